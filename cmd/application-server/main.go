@@ -3,84 +3,75 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/gin-gonic/gin"
-	"github.com/sirupsen/logrus"
-
+	"github.com/bOguzhan/NATbypass/internal/application"
 	"github.com/bOguzhan/NATbypass/internal/config"
-	"github.com/bOguzhan/NATbypass/internal/nat"
+	"github.com/bOguzhan/NATbypass/internal/logging"
+	"github.com/bOguzhan/NATbypass/internal/tracing"
 )
 
 func main() {
+	// Determine config path - default to configs/config.yaml
+	configPath := os.Getenv("CONFIG_PATH")
+	if configPath == "" {
+		configPath = "configs/config.yaml"
+	}
+
+	loader := &config.Loader{}
+	flag.StringVar(&loader.Path, "config", configPath, "path to the YAML configuration file")
+	flag.Var(&loader.Overrides, "set", "override a configuration key, e.g. -set tcp.max_connections=200 (repeatable)")
+	flag.Parse()
+
 	// Load configuration
-	cfg, err := config.LoadConfig("configs/config.yaml")
+	cfg, err := loader.Load()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Setup logger
-	logger := logrus.New()
-	logLevel, err := logrus.ParseLevel(cfg.Servers.Application.LogLevel)
+	// Setup loggers, one per component so levels can be changed at runtime
+	logs, err := logging.NewManager(os.Stdout, cfg.Servers.Application.LogFormat, cfg.Servers.Application.LogLevel)
 	if err != nil {
-		logLevel = logrus.InfoLevel
+		fmt.Fprintf(os.Stderr, "Error configuring logging: %v\n", err)
+		os.Exit(1)
 	}
-	logger.SetLevel(logLevel)
-	logger.SetFormatter(&logrus.TextFormatter{
-		FullTimestamp: true,
-	})
-
-	// Setup HTTP server
-	router := gin.Default()
-	addr := fmt.Sprintf("%s:%d", cfg.Servers.Application.Host, cfg.Servers.Application.Port)
-
-	// Setup routes
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{
-			"status": "ok",
-			"server": "application",
-		})
-	})
+	logger := logs.Logger("application-server")
 
-	// Create context that can be canceled
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// Setup TCP server
-	tcpConfig := &config.TCPServerConfig{
-		Host:              cfg.Servers.TCP.Host,
-		Port:              cfg.Servers.TCP.Port,
-		ConnectionTimeout: cfg.Servers.TCP.ConnectionTimeout,
-		MaxConnections:    cfg.Servers.TCP.MaxConnections,
-		BufferSize:        cfg.Servers.TCP.BufferSize,
+	// Export traces to the configured OTLP collector
+	shutdownTracing, err := tracing.Setup(context.Background(), "application-server", cfg.Tracing)
+	if err != nil {
+		logger.Fatalf("Failed to set up tracing: %v", err)
 	}
-	tcpServer := nat.NewTCPServer(tcpConfig, logger)
 
-	// Start TCP server
-	logger.Info("Starting TCP server...")
-	if err := tcpServer.Start(ctx); err != nil {
-		logger.Fatalf("Failed to start TCP server: %v", err)
+	// Create the rendezvous and relay services and the HTTP API
+	server, err := application.NewServer(cfg, logs)
+	if err != nil {
+		logger.Fatalf("Failed to create application server: %v", err)
 	}
 
-	// Setup server graceful shutdown
-	srv := &http.Server{
-		Addr:    addr,
-		Handler: router,
+	// Create context that can be canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	logger.Info("Starting services...")
+	if err := server.Start(ctx); err != nil {
+		logger.Fatalf("Failed to start application server: %v", err)
 	}
 
-	// Start HTTP server in a goroutine
-	go func() {
-		logger.Infof("Starting application server on %s", addr)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Fatalf("Failed to start server: %v", err)
+	// Re-read the configuration on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func(current *config.Config) {
+		for range hup {
+			current = reload(loader, current, logs, server)
 		}
-	}()
+	}(cfg)
 
 	// Wait for interrupt signal to gracefully shutdown the server
 	quit := make(chan os.Signal, 1)
@@ -92,15 +83,46 @@ func main() {
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer shutdownCancel()
 
-	// Stop HTTP server
-	if err := srv.Shutdown(shutdownCtx); err != nil {
-		logger.Errorf("HTTP server shutdown error: %v", err)
+	// Stop the HTTP API, then every service
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Errorf("Server shutdown error: %v", err)
 	}
 
-	// Stop TCP server
-	if err := tcpServer.Stop(); err != nil {
-		logger.Errorf("TCP server shutdown error: %v", err)
+	// Flush spans still buffered for export
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Errorf("Tracing shutdown error: %v", err)
 	}
 
 	logger.Info("Server stopped successfully")
 }
+
+// reload loads the configuration again and applies the settings that can
+// change while running. The current configuration is kept if the new one
+// doesn't load.
+func reload(loader *config.Loader, current *config.Config, logs *logging.Manager, server *application.Server) *config.Config {
+	logger := logs.Logger("application-server")
+
+	next, err := loader.Load()
+	if err != nil {
+		logger.WithError(err).Error("Configuration reload failed, keeping the current configuration")
+		return current
+	}
+
+	updated, applied, ignored := config.Reload(current, next)
+	if len(ignored) > 0 {
+		logger.WithField("keys", ignored).Warn("Configuration changes need a restart and were not applied")
+	}
+
+	for _, key := range applied {
+		if key == "servers.application.log_level" {
+			// Replaces levels set through the admin API
+			if err := logs.SetAllLevels(updated.Servers.Application.LogLevel); err != nil {
+				logger.WithError(err).Error("Failed to change log level")
+			}
+		}
+	}
+	server.Reload(updated)
+
+	logger.WithField("keys", applied).Info("Configuration reloaded")
+	return updated
+}
@@ -3,6 +3,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"net/http"
 	"os"
@@ -11,9 +12,12 @@ import (
 	"time"
 
 	"github.com/bOguzhan/NATbypass/internal/config"
+	"github.com/bOguzhan/NATbypass/internal/logging"
 	"github.com/bOguzhan/NATbypass/internal/signaling"
+	"github.com/bOguzhan/NATbypass/internal/tracing"
 	"github.com/bOguzhan/NATbypass/internal/utils"
 	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
 )
 
 func main() {
@@ -23,8 +27,13 @@ func main() {
 		configPath = "configs/config.yaml"
 	}
 
+	loader := &config.Loader{}
+	flag.StringVar(&loader.Path, "config", configPath, "path to the YAML configuration file")
+	flag.Var(&loader.Overrides, "set", "override a configuration key, e.g. -set stun.retries=5 (repeatable)")
+	flag.Parse()
+
 	// Load configuration
-	cfg, err := config.LoadConfig(configPath)
+	cfg, err := loader.Load()
 	if err != nil {
 		fmt.Printf("Error loading configuration: %v\n", err)
 		os.Exit(1)
@@ -32,8 +41,20 @@ func main() {
 
 	// Configure logger
 	logger := utils.NewLogger("mediatory-server", cfg.Servers.Mediatory.LogLevel)
+	formatter, err := logging.NewFormatter(cfg.Servers.Mediatory.LogFormat)
+	if err != nil {
+		fmt.Printf("Error configuring logging: %v\n", err)
+		os.Exit(1)
+	}
+	logger.SetFormatter(formatter)
 	logger.Info("Starting Mediatory Server...")
 
+	// Export traces to the configured OTLP collector
+	shutdownTracing, err := tracing.Setup(context.Background(), "mediatory-server", cfg.Tracing)
+	if err != nil {
+		logger.Fatalf("Failed to set up tracing: %v", err)
+	}
+
 	// Set Gin mode based on log level
 	if cfg.Servers.Mediatory.LogLevel == "debug" {
 		gin.SetMode(gin.DebugMode)
@@ -42,7 +63,10 @@ func main() {
 	}
 
 	// Create and configure server
-	server := signaling.NewServer(logger)
+	server, err := signaling.NewServer(logger)
+	if err != nil {
+		logger.Fatalf("Failed to create server: %v", err)
+	}
 
 	// Create handlers and set server reference
 	handlers := server.GetHandlers()
@@ -63,6 +87,15 @@ func main() {
 		}
 	}()
 
+	// Re-read the configuration on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func(current *config.Config) {
+		for range hup {
+			current = reload(loader, current, logger, handlers)
+		}
+	}(cfg)
+
 	// Set up graceful shutdown
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -81,5 +114,40 @@ func main() {
 		}).Fatal("Server forced to shutdown")
 	}
 
+	// Flush spans still buffered for export
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Errorf("Tracing shutdown error: %v", err)
+	}
+
 	logger.Info("Server exited")
 }
+
+// reload loads the configuration again and applies the settings that can
+// change while running. The current configuration is kept if the new one
+// doesn't load.
+func reload(loader *config.Loader, current *config.Config, logger *utils.Logger, handlers *signaling.Handlers) *config.Config {
+	next, err := loader.Load()
+	if err != nil {
+		logger.WithFields(map[string]interface{}{
+			"error": err.Error(),
+		}).Error("Configuration reload failed, keeping the current configuration")
+		return current
+	}
+
+	updated, applied, ignored := config.Reload(current, next)
+	if len(ignored) > 0 {
+		logger.WithFields(map[string]interface{}{
+			"keys": ignored,
+		}).Warn("Configuration changes need a restart and were not applied")
+	}
+
+	if level, err := logrus.ParseLevel(updated.Servers.Mediatory.LogLevel); err == nil {
+		logger.SetLevel(level)
+	}
+	handlers.SetConfig(updated)
+
+	logger.WithFields(map[string]interface{}{
+		"keys": applied,
+	}).Info("Configuration reloaded")
+	return updated
+}
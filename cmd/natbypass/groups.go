@@ -0,0 +1,98 @@
+// cmd/natbypass/groups.go
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/pkg/client"
+)
+
+// parseProperties parses comma-separated key=value pairs
+func parseProperties(s string) (map[string]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+
+	properties := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("%w: property %q must be key=value", errUsage, pair)
+		}
+		properties[key] = value
+	}
+	return properties, nil
+}
+
+// joinGroup joins the group given with -group and returns a function
+// leaving it again
+func joinGroup(ctx context.Context, c *client.Client, opts options) (func(), error) {
+	joinCtx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	if err := c.JoinGroup(joinCtx, opts.group, opts.groupSecret); err != nil {
+		return nil, fmt.Errorf("joining group %s: %w", opts.group, err)
+	}
+	status("Joined group %s as %s", opts.group, c.ID())
+
+	return func() {
+		leaveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		c.LeaveGroup(leaveCtx, opts.group)
+	}, nil
+}
+
+// listPeers prints the online members of the group, then reports members
+// joining and leaving until ctx is cancelled
+func listPeers(ctx context.Context, c *client.Client, opts options) error {
+	listCtx, cancel := context.WithTimeout(ctx, opts.timeout)
+	peers, err := c.Peers(listCtx, opts.group)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	table := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(table, "ID\tNAME\tVIRTUAL IP\tPROPERTIES")
+	for _, peer := range peers {
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\n", peer.ID, peer.Name, peer.VirtualIP, formatProperties(peer.Properties))
+	}
+	table.Flush()
+
+	status("Watching group %s, press Ctrl+C to leave", opts.group)
+	<-ctx.Done()
+	return nil
+}
+
+// reportPresence prints a member joining or leaving a group
+func reportPresence(p client.Presence) {
+	if !p.Joined {
+		status("%s left %s", p.PeerID, p.Group)
+		return
+	}
+
+	description := p.PeerID
+	if p.Name != "" {
+		description += " (" + p.Name + ")"
+	}
+	if len(p.Properties) > 0 {
+		description += " " + formatProperties(p.Properties)
+	}
+	status("%s joined %s", description, p.Group)
+}
+
+// formatProperties formats properties as sorted key=value pairs
+func formatProperties(properties map[string]string) string {
+	pairs := make([]string, 0, len(properties))
+	for key, value := range properties {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,24 @@
+// cmd/natbypass/groups_test.go
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProperties(t *testing.T) {
+	properties, err := parseProperties("os=linux, role=db,empty=")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"os": "linux", "role": "db", "empty": ""}, properties)
+	assert.Equal(t, "empty=,os=linux,role=db", formatProperties(properties))
+
+	properties, err = parseProperties("")
+	assert.NoError(t, err)
+	assert.Nil(t, properties)
+
+	for _, invalid := range []string{"os", "=linux", "os=linux,,"} {
+		_, err := parseProperties(invalid)
+		assert.ErrorIs(t, err, errUsage, invalid)
+	}
+}
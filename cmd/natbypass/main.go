@@ -0,0 +1,462 @@
+// cmd/natbypass/main.go
+
+// Command natbypass connects to peers through a mediatory server. It can
+// register, report the local NAT, pipe standard input and output to a peer,
+// chat, transfer files, forward TCP and UDP ports, proxy through a peer
+// with SOCKS5, join a mesh VPN and find peers through named groups.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/pkg/client"
+	"github.com/bOguzhan/NATbypass/pkg/networking"
+)
+
+// chunkSize is the most stdin data sent per message, small enough to fit
+// in one datagram on most paths
+const chunkSize = 1200
+
+const usage = `Usage: natbypass [flags] <command> [arguments]
+
+Commands:
+  register                    register and print the client ID
+  discover                    print the public address and NAT type
+  listen                      wait for a peer and pipe standard input and output to it
+  connect <peer-id>           connect to a peer and pipe standard input and output to it
+  chat [peer-id]              chat line by line, waiting for a peer if none is given
+  send-file <peer-id> <path>  send a file to a peer running recv-file
+  recv-file [directory]       wait for a peer and save the file it sends
+  expose <service>...         serve local services, e.g. tcp:localhost:22, to the -allow peers
+  forward <peer-id> <spec>... listen locally and forward to a peer's exposed services;
+                              spec is [bind:]port:tcp|udp:host:port, e.g. 2222:tcp:localhost:22
+  exit                        let the -allow peers reach public addresses through this host
+  socks <peer-id> [address]   run a SOCKS5 proxy, default 127.0.0.1:1080, exiting through a peer
+  mesh [interface]            join the mesh VPN on a TUN interface with the server-assigned IP (Linux, root)
+  peers                       list the online members of the -group and watch for changes
+
+Any command joins the group given with -group while it runs, so its members
+can find this peer.
+
+Flags:
+`
+
+// options are the global flags
+type options struct {
+	server      string
+	stunServers string
+	name        string
+	natType     string
+	portMapping bool
+	rendezvous  string
+	relay       string
+	strategies  string
+	timeout     time.Duration
+	allow       string
+	exitNets    string
+	group       string
+	groupSecret string
+	properties  string
+}
+
+func main() {
+	opts := options{}
+	flags := flag.NewFlagSet("natbypass", flag.ExitOnError)
+	flags.StringVar(&opts.server, "server", envOr("NATBYPASS_SERVER", "http://localhost:8081"), "mediatory server URL")
+	flags.StringVar(&opts.stunServers, "stun", "stun.l.google.com:19302,stun1.l.google.com:19302", "comma-separated STUN servers, empty to skip discovery")
+	flags.StringVar(&opts.name, "name", "", "name shown by the server")
+	flags.StringVar(&opts.natType, "nat-type", "", "local NAT type if known, e.g. port-restricted-cone")
+	flags.BoolVar(&opts.portMapping, "port-mapping", false, "ask the gateway to forward ports with PCP, NAT-PMP or UPnP")
+	flags.StringVar(&opts.rendezvous, "rendezvous", os.Getenv("NATBYPASS_RENDEZVOUS"), "application server's UDP rendezvous address, host:port")
+	flags.StringVar(&opts.relay, "relay", os.Getenv("NATBYPASS_RELAY"), "application server's relay address used when punching fails, host:port")
+	flags.StringVar(&opts.strategies, "strategies", os.Getenv("NATBYPASS_STRATEGIES"), "comma-separated traversal strategies to use, e.g. udp-hole-punching,udp-relaying, all if empty")
+	flags.DurationVar(&opts.timeout, "timeout", 30*time.Second, "limit for registering, discovery and connecting to a peer")
+	flags.StringVar(&opts.allow, "allow", "", "comma-separated peer IDs allowed to use exposed services or the exit, * for any registered peer")
+	flags.StringVar(&opts.exitNets, "exit-networks", "", "comma-separated networks besides public addresses the exit lets peers reach, e.g. 192.168.1.0/24")
+	flags.StringVar(&opts.group, "group", os.Getenv("NATBYPASS_GROUP"), "group to join, whose members can list each other")
+	flags.StringVar(&opts.groupSecret, "group-secret", os.Getenv("NATBYPASS_GROUP_SECRET"), "secret the group is created with or joined by")
+	flags.StringVar(&opts.properties, "properties", "", "comma-separated key=value pairs shown to group members")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(os.Args[1:])
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, opts, flags.Arg(0), flags.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "natbypass: %v\n", err)
+		if errors.Is(err, errUsage) {
+			flags.Usage()
+			os.Exit(2)
+		}
+		os.Exit(1)
+	}
+}
+
+// errUsage reports a command given the wrong arguments
+var errUsage = errors.New("invalid arguments")
+
+// run executes a command
+func run(ctx context.Context, opts options, command string, args []string) error {
+	c, err := opts.newClient(command == "mesh")
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if command == "peers" && opts.group == "" {
+		return fmt.Errorf("%w: peers needs -group", errUsage)
+	}
+	if (command == "expose" || command == "exit") && len(opts.allowList()) == 0 {
+		// Otherwise any client of the server could use this host's services,
+		// or the host as a proxy
+		return fmt.Errorf("%w: %s needs -allow", errUsage, command)
+	}
+	if opts.group != "" && command != "help" {
+		leave, err := joinGroup(ctx, c, opts)
+		if err != nil {
+			return err
+		}
+		defer leave()
+	}
+
+	switch {
+	case command == "register" && len(args) == 0:
+		return register(ctx, c, opts.timeout)
+	case command == "discover" && len(args) == 0:
+		return discover(ctx, c, opts)
+	case command == "listen" && len(args) == 0:
+		return withAccepted(ctx, c, pipe)
+	case command == "connect" && len(args) == 1:
+		return withDialed(ctx, c, args[0], opts.timeout, pipe)
+	case command == "chat" && len(args) == 0:
+		return withAccepted(ctx, c, chat)
+	case command == "chat" && len(args) == 1:
+		return withDialed(ctx, c, args[0], opts.timeout, chat)
+	case command == "send-file" && len(args) == 2:
+		return withDialed(ctx, c, args[0], opts.timeout, func(ctx context.Context, conn net.Conn) error {
+			return sendFile(ctx, conn, args[1])
+		})
+	case command == "recv-file" && len(args) <= 1:
+		dir := "."
+		if len(args) == 1 {
+			dir = args[0]
+		}
+		return withAccepted(ctx, c, func(ctx context.Context, conn net.Conn) error {
+			return receiveFile(ctx, conn, dir)
+		})
+	case command == "expose" && len(args) >= 1:
+		return expose(ctx, c, args, opts.allowList())
+	case command == "forward" && len(args) >= 2:
+		var specs []forwardSpec
+		for _, arg := range args[1:] {
+			spec, err := parseForward(arg)
+			if err != nil {
+				return err
+			}
+			specs = append(specs, spec)
+		}
+		return withDialed(ctx, c, args[0], opts.timeout, func(ctx context.Context, conn net.Conn) error {
+			return forward(ctx, conn, specs)
+		})
+	case command == "exit" && len(args) == 0:
+		networks, err := opts.exitNetworkList()
+		if err != nil {
+			return err
+		}
+		return exit(ctx, c, opts.allowList(), networks)
+	case command == "socks" && (len(args) == 1 || len(args) == 2):
+		bind := "127.0.0.1:1080"
+		if len(args) == 2 {
+			bind = args[1]
+		}
+		return withDialed(ctx, c, args[0], opts.timeout, func(ctx context.Context, conn net.Conn) error {
+			return socks(ctx, conn, bind)
+		})
+	case command == "mesh" && len(args) <= 1:
+		name := ""
+		if len(args) == 1 {
+			name = args[0]
+		}
+		return joinMesh(ctx, c, opts, name)
+	case command == "peers" && len(args) == 0:
+		return listPeers(ctx, c, opts)
+	case command == "help":
+		return errUsage
+	}
+	return fmt.Errorf("%w: %s %s", errUsage, command, strings.Join(args, " "))
+}
+
+// newClient creates an SDK client from the flags, asking for a virtual
+// address if it joins the mesh
+func (o options) newClient(mesh bool) (*client.Client, error) {
+	properties, err := parseProperties(o.properties)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.New(client.Config{
+		ServerURL:        o.server,
+		Name:             o.name,
+		Properties:       properties,
+		Mesh:             mesh,
+		STUNServer:       o.stunList()[0],
+		NATType:          o.natType,
+		PortMapping:      o.portMapping,
+		RendezvousServer: o.rendezvous,
+		RelayServer:      o.relay,
+		Strategies:       o.strategyList(),
+		OnPresence:       reportPresence,
+	})
+}
+
+// strategyList returns the traversal strategies to use, nil for all of them
+func (o options) strategyList() []networking.StrategyConfig {
+	var strategies []networking.StrategyConfig
+	for _, name := range strings.Split(o.strategies, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			strategies = append(strategies, networking.StrategyConfig{Type: networking.StrategyType(name)})
+		}
+	}
+	return strategies
+}
+
+// stunList returns the STUN servers, always at least one entry, which is
+// empty when discovery is disabled
+func (o options) stunList() []string {
+	var servers []string
+	for _, server := range strings.Split(o.stunServers, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			servers = append(servers, server)
+		}
+	}
+	if len(servers) == 0 {
+		return []string{""}
+	}
+	return servers
+}
+
+// allowList returns the peer IDs allowed to use exposed services
+func (o options) allowList() []string {
+	var peers []string
+	for _, peer := range strings.Split(o.allow, ",") {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// exitNetworkList returns the networks the exit lets peers reach besides
+// public addresses
+func (o options) exitNetworkList() ([]netip.Prefix, error) {
+	var networks []netip.Prefix
+	for _, network := range strings.Split(o.exitNets, ",") {
+		if network = strings.TrimSpace(network); network == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			return nil, fmt.Errorf("%w: -exit-networks: %v", errUsage, err)
+		}
+		networks = append(networks, prefix.Masked())
+	}
+	return networks, nil
+}
+
+// register registers and prints the client ID the server assigned
+func register(ctx context.Context, c *client.Client, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := c.Register(ctx); err != nil {
+		return err
+	}
+	fmt.Println(c.ID())
+	return nil
+}
+
+// discover prints the local and public addresses and the NAT type
+func discover(ctx context.Context, c *client.Client, opts options) error {
+	ctx, cancel := context.WithTimeout(ctx, opts.timeout)
+	defer cancel()
+
+	servers := opts.stunList()
+	if servers[0] == "" {
+		return client.ErrNoSTUNServer
+	}
+
+	result, err := c.Discover(ctx, servers...)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Local address:  %s\n", result.LocalAddr)
+	fmt.Printf("Public address: %s\n", result.PublicAddr)
+	fmt.Printf("NAT type:       %s\n", result.NATType)
+	return nil
+}
+
+// withDialed connects to a peer, reports the strategy that succeeded and
+// hands the connection to handle
+func withDialed(ctx context.Context, c *client.Client, peerID string, timeout time.Duration, handle func(context.Context, net.Conn) error) error {
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	status("Connecting to %s...", peerID)
+	conn, err := c.Dial(dialCtx, peerID)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", peerID, err)
+	}
+	defer conn.Close()
+
+	status("Connected to %s via %s", peerID, client.Strategy(conn))
+	return handle(ctx, conn)
+}
+
+// withAccepted waits for a peer, reports the strategy that succeeded and
+// hands the connection to handle
+func withAccepted(ctx context.Context, c *client.Client, handle func(context.Context, net.Conn) error) error {
+	listener, err := c.Listen(ctx)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	status("Waiting for a peer, listening as %s", c.ID())
+	conn, err := listener.Accept()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	defer conn.Close()
+
+	status("Connected to %s via %s", peerID(conn), client.Strategy(conn))
+	return handle(ctx, conn)
+}
+
+// pipe copies standard input to the peer and the peer's data to standard
+// output until standard input ends or ctx is cancelled
+func pipe(ctx context.Context, conn net.Conn) error {
+	go func() {
+		buffer := make([]byte, 64*1024)
+		for {
+			n, err := conn.Read(buffer)
+			if err != nil {
+				return
+			}
+			os.Stdout.Write(buffer[:n])
+		}
+	}()
+
+	return sendInput(ctx, conn, func(r io.Reader, send func([]byte) error) error {
+		buffer := make([]byte, chunkSize)
+		for {
+			n, err := r.Read(buffer)
+			if n > 0 {
+				if sendErr := send(buffer[:n]); sendErr != nil {
+					return sendErr
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+	})
+}
+
+// chat sends standard input line by line and prints the peer's lines
+func chat(ctx context.Context, conn net.Conn) error {
+	peer := peerID(conn)
+	go func() {
+		// Lines are newline terminated, so they survive stream transports
+		// joining or splitting messages
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			fmt.Printf("%s: %s\n", peer, scanner.Text())
+		}
+	}()
+
+	return sendInput(ctx, conn, func(r io.Reader, send func([]byte) error) error {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if len(line) > chunkSize-1 {
+				line = line[:chunkSize-1]
+			}
+			if err := send([]byte(line + "\n")); err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	})
+}
+
+// sendInput runs forward over standard input until it ends or ctx is
+// cancelled. Reaching the end of input is not an error.
+func sendInput(ctx context.Context, conn net.Conn, forward func(io.Reader, func([]byte) error) error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- forward(os.Stdin, func(data []byte) error {
+			_, err := conn.Write(data)
+			return err
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		return err
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// peerID returns the client ID of the peer on the other end of conn
+func peerID(conn net.Conn) string {
+	if peer, ok := conn.(interface{ PeerID() string }); ok {
+		return peer.PeerID()
+	}
+	return conn.RemoteAddr().String()
+}
+
+// status prints a progress message on standard error, keeping standard
+// output for data
+func status(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
+// envOr returns an environment variable, or fallback if it is unset
+func envOr(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
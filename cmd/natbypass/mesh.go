@@ -0,0 +1,48 @@
+// cmd/natbypass/mesh.go
+package main
+
+import (
+	"context"
+	"net/netip"
+
+	"github.com/bOguzhan/NATbypass/pkg/client"
+	"github.com/bOguzhan/NATbypass/pkg/mesh"
+)
+
+// joinMesh creates a TUN interface with the virtual IP the server assigns
+// and routes its packets to the other mesh peers until ctx is cancelled
+func joinMesh(ctx context.Context, c *client.Client, opts options, name string) error {
+	registerCtx, cancel := context.WithTimeout(ctx, opts.timeout)
+	err := c.Register(registerCtx)
+	cancel()
+	if err != nil {
+		return err
+	}
+
+	addr := c.VirtualIP()
+	if !addr.IsValid() {
+		return mesh.ErrNoVirtualIP
+	}
+
+	tun, err := mesh.OpenTUN(name)
+	if err != nil {
+		return err
+	}
+	defer tun.Close()
+	if err := tun.Configure(addr, mesh.DefaultMTU); err != nil {
+		return err
+	}
+	status("Joined the mesh as %s on %s, client ID %s", addr, tun.Name(), c.ID())
+
+	node := mesh.NewNode(c, tun, &mesh.Config{
+		DialTimeout: opts.timeout,
+		OnPeer: func(addr netip.Addr, peerID string, err error) {
+			if err != nil {
+				status("Failed to reach %s: %v", addr, err)
+				return
+			}
+			status("Connected to %s (%s)", addr, peerID)
+		},
+	})
+	return node.Run(ctx)
+}
@@ -0,0 +1,286 @@
+// cmd/natbypass/transfer.go
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/pkg/tunnel"
+)
+
+// Files are sent over one stream of a tunnel session, which resends what
+// the path loses. The sender writes the offer, a length-prefixed JSON
+// fileOffer, and waits for the receiver to accept before streaming the
+// file. The receiver confirms once the file is saved and its hash checked.
+const (
+	replyAccept byte = 'A' // The receiver created the file
+	replyDone   byte = 'D' // The file was saved intact
+)
+
+const (
+	maxOfferSize   = 64 * 1024
+	receiverLinger = 2 * time.Second // Longest wait for the sender to see the confirmation
+)
+
+// transferService names the stream a file is sent on. The receiver accepts
+// it rather than dialing the address.
+var transferService = tunnel.Service{Network: "tcp", Address: "natbypass-file:0"}
+
+// Transfer errors
+var (
+	errChecksumMismatch = errors.New("checksum mismatch")
+	errInvalidFileName  = errors.New("invalid file name")
+	errInvalidOffer     = errors.New("invalid offer")
+	errNotConfirmed     = errors.New("receiver did not confirm the file")
+)
+
+// fileOffer announces a file
+type fileOffer struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// sendFile sends a file to a peer running receiveFile
+func sendFile(ctx context.Context, conn net.Conn, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	offer, err := describeFile(file, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	session := tunnel.Client(conn, nil)
+	defer session.Close()
+	stop := closeOnDone(ctx, session)
+	defer stop()
+
+	started := time.Now()
+	err = sendStream(session, file, offer)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	snapshot := session.Stats()
+	status("Sent %s (%d bytes) in %s, %d retransmits", offer.Name, offer.Size,
+		time.Since(started).Round(time.Millisecond), snapshot.Retransmits)
+	return nil
+}
+
+// sendStream offers the file on a new stream and sends it once accepted
+func sendStream(session *tunnel.Session, file *os.File, offer fileOffer) error {
+	stream, err := session.Open(transferService)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	if err := writeOffer(stream, offer); err != nil {
+		return err
+	}
+	if err := readReply(stream, replyAccept); err != nil {
+		return fmt.Errorf("receiver declined %s: %w", offer.Name, err)
+	}
+
+	if _, err := io.Copy(stream, io.NewSectionReader(file, 0, offer.Size)); err != nil {
+		return err
+	}
+	if err := stream.CloseWrite(); err != nil {
+		return err
+	}
+	return readReply(stream, replyDone)
+}
+
+// describeFile builds the offer for a file
+func describeFile(file *os.File, name string) (fileOffer, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return fileOffer{}, err
+	}
+	if !info.Mode().IsRegular() {
+		return fileOffer{}, fmt.Errorf("%s is not a regular file", name)
+	}
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return fileOffer{}, err
+	}
+
+	return fileOffer{
+		Name:   name,
+		Size:   info.Size(),
+		SHA256: hex.EncodeToString(hash.Sum(nil)),
+	}, nil
+}
+
+// receiveFile saves the file a peer sends with sendFile into dir. Existing
+// files are never overwritten.
+func receiveFile(ctx context.Context, conn net.Conn, dir string) error {
+	session := tunnel.Server(conn, nil)
+	defer session.Close()
+	stop := closeOnDone(ctx, session)
+	defer stop()
+
+	stream, err := session.Accept()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	defer stream.Close()
+
+	err = receiveStream(stream, dir)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+
+	// The sender closes the session once it has the confirmation, which is
+	// lost if the session is closed here first
+	select {
+	case <-session.Done():
+	case <-time.After(receiverLinger):
+	}
+	return nil
+}
+
+// receiveStream reads the offer, saves the file into dir and confirms it
+func receiveStream(stream *tunnel.Stream, dir string) error {
+	offer, err := readOffer(stream)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, offer.Name)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	status("Receiving %s (%d bytes)", offer.Name, offer.Size)
+
+	started := time.Now()
+	err = receiveContents(stream, file, offer)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		_, err = stream.Write([]byte{replyDone})
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	status("Received %s in %s", path, time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+// receiveContents accepts the offer, writes the file and checks its hash
+func receiveContents(stream *tunnel.Stream, file *os.File, offer fileOffer) error {
+	if _, err := stream.Write([]byte{replyAccept}); err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	n, err := io.CopyN(io.MultiWriter(file, hash), stream, offer.Size)
+	if err == io.EOF {
+		return fmt.Errorf("file ended after %d of %d bytes", n, offer.Size)
+	}
+	if err != nil {
+		return err
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != offer.SHA256 {
+		return errChecksumMismatch
+	}
+	return nil
+}
+
+// writeOffer sends an offer with its length
+func writeOffer(w io.Writer, offer fileOffer) error {
+	body, err := json.Marshal(offer)
+	if err != nil {
+		return err
+	}
+	message := make([]byte, 4+len(body))
+	binary.BigEndian.PutUint32(message, uint32(len(body)))
+	copy(message[4:], body)
+
+	_, err = w.Write(message)
+	return err
+}
+
+// readOffer reads and checks an offer written by writeOffer
+func readOffer(r io.Reader) (fileOffer, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return fileOffer{}, err
+	}
+	size := binary.BigEndian.Uint32(length[:])
+	if size > maxOfferSize {
+		return fileOffer{}, fmt.Errorf("%w: %d bytes", errInvalidOffer, size)
+	}
+	body := make([]byte, size)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return fileOffer{}, err
+	}
+
+	var offer fileOffer
+	if err := json.Unmarshal(body, &offer); err != nil {
+		return fileOffer{}, fmt.Errorf("%w: %v", errInvalidOffer, err)
+	}
+	if offer.Name != filepath.Base(offer.Name) || offer.Name == "." || offer.Name == ".." || offer.Size < 0 {
+		return fileOffer{}, fmt.Errorf("%w: %q", errInvalidFileName, offer.Name)
+	}
+	return offer, nil
+}
+
+// readReply waits for a one byte reply from the receiver. A receiver that
+// fails closes the stream without replying.
+func readReply(r io.Reader, want byte) error {
+	var reply [1]byte
+	if _, err := io.ReadFull(r, reply[:]); err != nil {
+		if err == io.EOF {
+			return errNotConfirmed
+		}
+		return err
+	}
+	if reply[0] != want {
+		return errNotConfirmed
+	}
+	return nil
+}
+
+// closeOnDone closes the session when ctx is cancelled. The returned func
+// stops watching ctx.
+func closeOnDone(ctx context.Context, session *tunnel.Session) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			session.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,122 @@
+// cmd/natbypass/transfer_test.go
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/bOguzhan/NATbypass/pkg/tunnel"
+	"github.com/stretchr/testify/assert"
+)
+
+// udpPair returns two UDP sockets connected to each other
+func udpPair(t *testing.T, portA, portB int) (net.Conn, net.Conn) {
+	addrA := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: portA}
+	addrB := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: portB}
+
+	a, err := net.DialUDP("udp", addrA, addrB)
+	assert.NoError(t, err)
+	b, err := net.DialUDP("udp", addrB, addrA)
+	assert.NoError(t, err)
+
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+// lossyConn drops every nth write
+type lossyConn struct {
+	net.Conn
+	n      uint64
+	writes uint64
+}
+
+func (c *lossyConn) Write(b []byte) (int, error) {
+	if atomic.AddUint64(&c.writes, 1)%c.n == 0 {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+// transfer sends data from one end of a pair to the other and returns what
+// was saved
+func transfer(t *testing.T, sender, receiver net.Conn, data []byte) ([]byte, error) {
+	src := filepath.Join(t.TempDir(), "payload.bin")
+	assert.NoError(t, os.WriteFile(src, data, 0o600))
+	dir := t.TempDir()
+
+	received := make(chan error, 1)
+	go func() {
+		received <- receiveFile(context.Background(), receiver, dir)
+	}()
+
+	assert.NoError(t, sendFile(context.Background(), sender, src))
+	if err := <-received; err != nil {
+		return nil, err
+	}
+	return os.ReadFile(filepath.Join(dir, "payload.bin"))
+}
+
+func TestFileTransfer(t *testing.T) {
+	data := make([]byte, 64*1024+123)
+	rand.Read(data)
+
+	a, b := udpPair(t, 12392, 12393)
+	saved, err := transfer(t, a, b, data)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(data, saved))
+
+	// The tunnel session resends whatever the path loses
+	c, d := udpPair(t, 12394, 12395)
+	saved, err = transfer(t, &lossyConn{Conn: c, n: 7}, &lossyConn{Conn: d, n: 5}, data)
+	assert.NoError(t, err)
+	assert.True(t, bytes.Equal(data, saved))
+
+	e, f := udpPair(t, 12396, 12397)
+	saved, err = transfer(t, e, f, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, saved)
+}
+
+func TestReceiveFileRejectsPaths(t *testing.T) {
+	a, b := udpPair(t, 12398, 12399)
+	session := tunnel.Client(a, nil)
+	defer session.Close()
+
+	go func() {
+		stream, err := session.Open(transferService)
+		if err == nil {
+			writeOffer(stream, fileOffer{Name: "../escape", Size: 1})
+		}
+	}()
+	err := receiveFile(context.Background(), b, t.TempDir())
+	assert.ErrorIs(t, err, errInvalidFileName)
+}
+
+func TestSendFileDeclined(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "payload.bin")
+	assert.NoError(t, os.WriteFile(src, []byte("new"), 0o600))
+	dir := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "payload.bin"), []byte("old"), 0o600))
+
+	a, b := udpPair(t, 12390, 12391)
+	received := make(chan error, 1)
+	go func() {
+		received <- receiveFile(context.Background(), b, dir)
+	}()
+
+	// Existing files are kept and the sender is told
+	assert.Error(t, sendFile(context.Background(), a, src))
+	assert.ErrorIs(t, <-received, os.ErrExist)
+	saved, err := os.ReadFile(filepath.Join(dir, "payload.bin"))
+	assert.NoError(t, err)
+	assert.Equal(t, "old", string(saved))
+}
@@ -0,0 +1,200 @@
+// cmd/natbypass/tunnel.go
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/bOguzhan/NATbypass/pkg/client"
+	"github.com/bOguzhan/NATbypass/pkg/tunnel"
+)
+
+// forwardSpec is a local listener forwarding to one of the peer's services
+type forwardSpec struct {
+	bind    string
+	service tunnel.Service
+}
+
+// parseForward parses [bind-address:]port:network:host:port, binding to
+// the loopback address when no bind address is given
+func parseForward(s string) (forwardSpec, error) {
+	split := -1
+	for _, network := range []string{":tcp:", ":udp:"} {
+		if i := strings.Index(s, network); i >= 0 && (split < 0 || i < split) {
+			split = i
+		}
+	}
+	if split < 0 {
+		return forwardSpec{}, fmt.Errorf("%w: forward %q must be [bind:]port:tcp|udp:host:port", errUsage, s)
+	}
+
+	bind := s[:split]
+	if !strings.Contains(bind, ":") {
+		bind = net.JoinHostPort("127.0.0.1", bind)
+	}
+	if _, _, err := net.SplitHostPort(bind); err != nil {
+		return forwardSpec{}, fmt.Errorf("%w: forward %q: %v", errUsage, s, err)
+	}
+
+	service, err := tunnel.ParseService(s[split+1:])
+	if err != nil {
+		return forwardSpec{}, fmt.Errorf("%w: %v", errUsage, err)
+	}
+	return forwardSpec{bind: bind, service: service}, nil
+}
+
+// expose serves the given services to every peer that connects until ctx
+// is cancelled
+func expose(ctx context.Context, c *client.Client, args []string, allowed []string) error {
+	var services []tunnel.Service
+	for _, arg := range args {
+		service, err := tunnel.ParseService(arg)
+		if err != nil {
+			return fmt.Errorf("%w: %v", errUsage, err)
+		}
+		services = append(services, service)
+	}
+
+	status("Exposing %s", strings.Join(args, ", "))
+	return servePeers(ctx, c, allowed, nil, func(session *tunnel.Session) error {
+		return tunnel.Serve(session, services)
+	})
+}
+
+// exit lets the allowed peers reach public addresses and the given
+// networks through this host, as the exit for their socks command, until
+// ctx is cancelled
+func exit(ctx context.Context, c *client.Client, allowed []string, networks []netip.Prefix) error {
+	status("Acting as an exit for peers' SOCKS proxies")
+	config := &tunnel.Config{ExitNetworks: networks}
+	return servePeers(ctx, c, allowed, config, func(session *tunnel.Session) error {
+		return tunnel.ServeExit(session, nil)
+	})
+}
+
+// servePeers runs a tunnel session with the given config, which may be
+// nil, and serve for every peer that connects until ctx is cancelled. Peers
+// not in allowed are turned away unless it is empty.
+func servePeers(ctx context.Context, c *client.Client, allowed []string, config *tunnel.Config, serve func(*tunnel.Session) error) error {
+	listener, err := c.Listen(ctx)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	status("Waiting for peers, listening as %s", c.ID())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		peer := peerID(conn)
+		if !isAllowed(peer, allowed) {
+			status("Rejected %s, not in -allow", peer)
+			conn.Close()
+			continue
+		}
+		status("Connected to %s via %s", peer, client.Strategy(conn))
+
+		go func() {
+			session := tunnel.Server(conn, config)
+			defer session.Close()
+			go func() {
+				<-ctx.Done()
+				session.Close()
+			}()
+
+			err := serve(session)
+			status("Disconnected from %s: %v", peer, err)
+		}()
+	}
+}
+
+// isAllowed reports whether a peer may use exposed services. Peer IDs are
+// those the server checked the peers' signals were signed with.
+func isAllowed(peer string, allowed []string) bool {
+	for _, id := range allowed {
+		if id == "*" || id == peer {
+			return true
+		}
+	}
+	return false
+}
+
+// forward listens locally for each spec and carries connections to the
+// peer's services until ctx is cancelled or the peer goes away
+func forward(ctx context.Context, conn net.Conn, specs []forwardSpec) error {
+	session := tunnel.Client(conn, nil)
+	defer session.Close()
+
+	errs := make(chan error, len(specs))
+	for _, spec := range specs {
+		spec := spec
+		if spec.service.Network == "udp" {
+			packetConn, err := net.ListenPacket("udp", spec.bind)
+			if err != nil {
+				return err
+			}
+			defer packetConn.Close()
+			go func() { errs <- tunnel.ForwardPacket(session, packetConn, spec.service) }()
+		} else {
+			listener, err := net.Listen("tcp", spec.bind)
+			if err != nil {
+				return err
+			}
+			defer listener.Close()
+			go func() { errs <- tunnel.Forward(session, listener, spec.service) }()
+		}
+		status("Forwarding %s to %s", spec.bind, spec.service)
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errs:
+		if errors.Is(err, tunnel.ErrSessionClosed) {
+			return nil
+		}
+		return err
+	}
+}
+
+// socks runs a local SOCKS5 proxy whose connections leave through the peer
+// until ctx is cancelled or the peer goes away
+func socks(ctx context.Context, conn net.Conn, bind string) error {
+	session := tunnel.Client(conn, nil)
+	defer session.Close()
+
+	listener, err := net.Listen("tcp", bind)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	status("SOCKS5 proxy listening on %s", listener.Addr())
+
+	errs := make(chan error, 1)
+	go func() { errs <- tunnel.ServeSOCKS(session, listener) }()
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errs:
+		if errors.Is(err, tunnel.ErrSessionClosed) {
+			return nil
+		}
+		return err
+	}
+}
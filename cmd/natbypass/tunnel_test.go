@@ -0,0 +1,48 @@
+// cmd/natbypass/tunnel_test.go
+package main
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/bOguzhan/NATbypass/pkg/tunnel"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseForward(t *testing.T) {
+	spec, err := parseForward("2222:tcp:localhost:22")
+	assert.NoError(t, err)
+	assert.Equal(t, "127.0.0.1:2222", spec.bind)
+	assert.Equal(t, tunnel.Service{Network: "tcp", Address: "localhost:22"}, spec.service)
+
+	spec, err = parseForward("0.0.0.0:5353:udp:10.0.0.1:53")
+	assert.NoError(t, err)
+	assert.Equal(t, "0.0.0.0:5353", spec.bind)
+	assert.Equal(t, tunnel.Service{Network: "udp", Address: "10.0.0.1:53"}, spec.service)
+
+	for _, invalid := range []string{"2222", "2222:sctp:localhost:22", "2222:tcp:localhost", "a:b:c:tcp:localhost:22"} {
+		_, err := parseForward(invalid)
+		assert.ErrorIs(t, err, errUsage, invalid)
+	}
+}
+
+func TestExitOptions(t *testing.T) {
+	// Services and exits open to every peer are refused before anything is sent
+	err := run(context.Background(), options{server: "http://127.0.0.1:1"}, "exit", nil)
+	assert.ErrorIs(t, err, errUsage)
+	err = run(context.Background(), options{server: "http://127.0.0.1:1"}, "expose", []string{"tcp:localhost:22"})
+	assert.ErrorIs(t, err, errUsage)
+
+	assert.True(t, isAllowed("a", []string{"b", "a"}))
+	assert.True(t, isAllowed("a", []string{"*"}))
+	assert.False(t, isAllowed("a", []string{"b"}))
+	assert.False(t, isAllowed("a", nil))
+
+	networks, err := options{exitNets: "192.168.1.7/24, fd00::/8"}.exitNetworkList()
+	assert.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("192.168.1.0/24"), netip.MustParsePrefix("fd00::/8")}, networks)
+
+	_, err = options{exitNets: "192.168.1.1"}.exitNetworkList()
+	assert.ErrorIs(t, err, errUsage)
+}
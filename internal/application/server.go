@@ -0,0 +1,341 @@
+// internal/application/server.go
+
+// Package application runs the services of the application server: UDP and
+// TCP rendezvous, the relay, and the HTTP API used to monitor them.
+package application
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/bOguzhan/NATbypass/internal/config"
+	"github.com/bOguzhan/NATbypass/internal/logging"
+	"github.com/bOguzhan/NATbypass/internal/metrics"
+	"github.com/bOguzhan/NATbypass/internal/nat"
+	"github.com/bOguzhan/NATbypass/pkg/tracing"
+)
+
+// Server runs every service enabled in the configuration behind one HTTP API
+type Server struct {
+	config   *config.Config
+	logs     *logging.Manager
+	logger   logging.Logger
+	router   *gin.Engine
+	registry *metrics.Registry
+	http     *http.Server
+	listener net.Listener
+
+	// Services, nil when disabled
+	tcp   *nat.TCPServer
+	udp   *nat.UDPServer
+	relay *nat.RelayServer
+}
+
+// NewServer creates the enabled services. The UDP socket is bound here; the
+// other services bind when started.
+func NewServer(cfg *config.Config, logs *logging.Manager) (*Server, error) {
+	s := &Server{
+		config:   cfg,
+		logs:     logs,
+		logger:   logs.Logger("application-server"),
+		registry: metrics.NewRegistry(),
+	}
+	auth := s.authenticator()
+
+	if cfg.TCP.Enabled {
+		s.tcp = nat.NewTCPServer(&cfg.TCP, logs.Logger("tcp-server"))
+		s.tcp.SetAuthenticator(auth)
+	}
+
+	if cfg.UDP.Enabled {
+		udp, err := nat.NewUDPServer(net.JoinHostPort(cfg.UDP.ListenHost, strconv.Itoa(cfg.UDP.ListenPort)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create UDP server: %w", err)
+		}
+		udp.SetConfig(&cfg.UDP)
+		udp.SetLogger(logs.Logger("udp-server"))
+		udp.SetAuthenticator(auth)
+		s.udp = udp
+	}
+
+	if cfg.Relay.Enabled {
+		s.relay = nat.NewRelayServer(&cfg.Relay, logs.Logger("relay-server"))
+		s.relay.SetAuthenticator(auth)
+	}
+
+	if err := s.setupRoutes(); err != nil {
+		if s.udp != nil {
+			s.udp.Stop()
+		}
+		return nil, err
+	}
+	return s, nil
+}
+
+// registerMetrics exports the process and enabled services' metrics
+func (s *Server) registerMetrics() error {
+	if err := metrics.RegisterProcessMetrics(s.registry); err != nil {
+		return err
+	}
+	if s.tcp != nil {
+		if err := nat.RegisterTCPServerMetrics(s.registry, s.tcp); err != nil {
+			return err
+		}
+	}
+	if s.udp != nil {
+		if err := nat.RegisterUDPServerMetrics(s.registry, s.udp); err != nil {
+			return err
+		}
+	}
+	if s.relay != nil {
+		if err := nat.RegisterRelayServerMetrics(s.registry, s.relay); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authenticator returns a packet authenticator when a rendezvous secret is
+// configured. The services share it, so a client's sequence numbers are
+// checked against one replay window whichever service it talks to.
+func (s *Server) authenticator() *nat.PacketAuthenticator {
+	if s.config.Security.RendezvousSecret == "" {
+		return nil
+	}
+	return nat.NewPacketAuthenticator([]byte(s.config.Security.RendezvousSecret), s.config.Security.KeyTTL)
+}
+
+// setupRoutes registers the metrics and configures the HTTP API
+func (s *Server) setupRoutes() error {
+	if err := s.registerMetrics(); err != nil {
+		return fmt.Errorf("failed to register metrics: %w", err)
+	}
+	latency, err := s.registry.GinMiddleware()
+	if err != nil {
+		return fmt.Errorf("failed to register HTTP metrics: %w", err)
+	}
+
+	s.router = gin.New()
+	s.router.Use(gin.Recovery())
+	s.router.Use(tracing.GinMiddleware("application-server"))
+	s.router.Use(latency)
+
+	s.router.GET("/health", s.handleHealth)
+
+	// Prometheus scrape endpoint
+	s.router.GET("/metrics", s.registry.Handler())
+
+	// Per-connection traffic counters
+	s.router.GET("/stats/connections", s.handleConnectionStats)
+	s.router.GET("/stats/connections/:id", s.handleConnectionStatsByID)
+
+	admin := s.router.Group("/admin")
+	// Runtime log level control
+	s.logs.RegisterRoutes(admin)
+	admin.GET("/sessions", s.handleSessions)
+	return nil
+}
+
+// Handler returns the HTTP API
+func (s *Server) Handler() http.Handler {
+	return s.router
+}
+
+// Start starts every enabled service, then the HTTP API. Services already
+// started are stopped again if a later one fails.
+func (s *Server) Start(ctx context.Context) error {
+	if err := s.startServices(ctx); err != nil {
+		s.stopServices()
+		return err
+	}
+
+	addr := net.JoinHostPort(s.config.Servers.Application.Host, strconv.Itoa(s.config.Servers.Application.Port))
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		s.stopServices()
+		return fmt.Errorf("failed to start HTTP server: %w", err)
+	}
+	s.listener = listener
+	s.http = &http.Server{Handler: s.router}
+
+	go func() {
+		s.logger.WithField("listen_addr", listener.Addr().String()).Info("Starting HTTP API")
+		if err := s.http.Serve(listener); err != nil && err != http.ErrServerClosed {
+			s.logger.WithError(err).Error("HTTP server error")
+		}
+	}()
+
+	return nil
+}
+
+func (s *Server) startServices(ctx context.Context) error {
+	if s.tcp != nil {
+		if err := s.tcp.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start TCP server: %w", err)
+		}
+	}
+	if s.udp != nil {
+		if err := s.udp.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start UDP server: %w", err)
+		}
+	}
+	if s.relay != nil {
+		if err := s.relay.Start(ctx); err != nil {
+			return fmt.Errorf("failed to start relay server: %w", err)
+		}
+	}
+	return nil
+}
+
+// Shutdown stops accepting API requests, waits for those in flight until ctx
+// ends, then stops every service. All services are stopped even if some fail.
+func (s *Server) Shutdown(ctx context.Context) error {
+	var problems []string
+	if s.http != nil {
+		if err := s.http.Shutdown(ctx); err != nil {
+			problems = append(problems, fmt.Sprintf("HTTP server: %v", err))
+		}
+	}
+	problems = append(problems, s.stopServices()...)
+
+	if len(problems) > 0 {
+		return fmt.Errorf("shutdown failed: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// stopServices stops the started services, returning a description of each
+// failure
+func (s *Server) stopServices() []string {
+	var problems []string
+	if s.tcp != nil {
+		if err := s.tcp.Stop(); err != nil {
+			problems = append(problems, fmt.Sprintf("TCP server: %v", err))
+		}
+	}
+	if s.udp != nil {
+		if err := s.udp.Stop(); err != nil {
+			problems = append(problems, fmt.Sprintf("UDP server: %v", err))
+		}
+	}
+	if s.relay != nil {
+		if err := s.relay.Stop(); err != nil {
+			problems = append(problems, fmt.Sprintf("relay server: %v", err))
+		}
+	}
+	return problems
+}
+
+// Reload applies the TCP timeouts of a reloaded configuration. Other
+// services have no settings that can change while running.
+func (s *Server) Reload(cfg *config.Config) {
+	if s.tcp != nil {
+		s.tcp.SetTimeouts(&cfg.TCP)
+	}
+}
+
+// Addr returns the address of the HTTP API, nil before Start
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+func (s *Server) handleHealth(c *gin.Context) {
+	services := gin.H{}
+	if s.tcp != nil {
+		services["tcp"] = addrString(s.tcp.Addr())
+	}
+	if s.udp != nil {
+		services["udp"] = addrString(s.udp.LocalAddr())
+	}
+	if s.relay != nil {
+		services["relay"] = addrString(s.relay.Addr())
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "ok",
+		"server":   "application",
+		"services": services,
+	})
+}
+
+func (s *Server) handleConnectionStats(c *gin.Context) {
+	response := gin.H{}
+	if s.tcp != nil {
+		response["tcp"] = s.tcp.ConnectionStats()
+	}
+	if s.udp != nil {
+		response["udp"] = s.udp.ConnectionStats()
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+func (s *Server) handleConnectionStatsByID(c *gin.Context) {
+	id := c.Param("id")
+	if s.tcp != nil {
+		if snapshot, exists := s.tcp.GetConnectionStats(id); exists {
+			c.JSON(http.StatusOK, snapshot)
+			return
+		}
+	}
+	if s.udp != nil {
+		if snapshot, exists := s.udp.GetConnectionStats(id); exists {
+			c.JSON(http.StatusOK, snapshot)
+			return
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{
+		"status": "error",
+		"error":  "connection_not_found",
+	})
+}
+
+// handleSessions lists live sessions across the services, optionally
+// filtered with ?protocol=tcp, udp or relay
+func (s *Server) handleSessions(c *gin.Context) {
+	protocol := c.Query("protocol")
+
+	var sessions []nat.Session
+	switch protocol {
+	case "", "tcp", "udp", "relay":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "unknown_protocol",
+		})
+		return
+	}
+
+	if s.tcp != nil && (protocol == "" || protocol == "tcp") {
+		sessions = append(sessions, s.tcp.Sessions()...)
+	}
+	if s.udp != nil && (protocol == "" || protocol == "udp") {
+		sessions = append(sessions, s.udp.Sessions()...)
+	}
+	if s.relay != nil && (protocol == "" || protocol == "relay") {
+		sessions = append(sessions, s.relay.Sessions()...)
+	}
+	if sessions == nil {
+		sessions = []nat.Session{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"count":    len(sessions),
+		"sessions": sessions,
+	})
+}
+
+func addrString(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
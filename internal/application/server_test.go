@@ -0,0 +1,155 @@
+// internal/application/server_test.go
+package application
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bOguzhan/NATbypass/internal/config"
+	"github.com/bOguzhan/NATbypass/internal/logging"
+	"github.com/bOguzhan/NATbypass/internal/nat"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+)
+
+func testConfig() *config.Config {
+	cfg := config.DefaultConfig()
+	cfg.Servers.Application.Host = "127.0.0.1"
+	cfg.Servers.Application.Port = 18090
+	cfg.TCP.ListenHost = "127.0.0.1"
+	cfg.TCP.ListenPort = 18091
+	cfg.UDP.ListenHost = "127.0.0.1"
+	cfg.UDP.ListenPort = 18092
+	cfg.Relay.ListenHost = "127.0.0.1"
+	cfg.Relay.ListenPort = 18093
+	return cfg
+}
+
+func send(t *testing.T, conn net.Conn, packetType protocol.PacketType, payload string) {
+	data, err := (&protocol.Packet{Type: packetType, Payload: []byte(payload)}).Serialize()
+	assert.NoError(t, err)
+	_, err = conn.Write(data)
+	assert.NoError(t, err)
+}
+
+func listSessions(t *testing.T, url string) []nat.Session {
+	resp, err := http.Get(url)
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var body struct {
+		Count    int           `json:"count"`
+		Sessions []nat.Session `json:"sessions"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, body.Count, len(body.Sessions))
+	return body.Sessions
+}
+
+func TestServerRunsAllServices(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logs, _ := logging.NewManager(io.Discard, logging.FormatText, "info")
+
+	server, err := NewServer(testConfig(), logs)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, server.Start(context.Background()))
+
+	// One peer on each service
+	tcpConn, err := net.Dial("tcp", "127.0.0.1:18091")
+	assert.NoError(t, err)
+	defer tcpConn.Close()
+	assert.NoError(t, protocol.WriteFrame(tcpConn, &protocol.Packet{
+		Type:    protocol.PacketTypeRegistration,
+		Payload: []byte("tcp-client"),
+	}))
+
+	udpConn, err := net.Dial("udp", "127.0.0.1:18092")
+	assert.NoError(t, err)
+	defer udpConn.Close()
+	send(t, udpConn, protocol.PacketTypeRegistration, "udp-client")
+
+	relayConn, err := net.Dial("udp", "127.0.0.1:18093")
+	assert.NoError(t, err)
+	defer relayConn.Close()
+	send(t, relayConn, protocol.PacketTypeRelayBind, "session-1")
+
+	base := "http://" + server.Addr().String()
+	var sessions []nat.Session
+	assert.Eventually(t, func() bool {
+		sessions = listSessions(t, base+"/admin/sessions")
+		return len(sessions) == 3
+	}, 2*time.Second, 50*time.Millisecond)
+
+	protocols := map[string]string{}
+	for _, session := range sessions {
+		protocols[session.Protocol] = session.ClientID
+	}
+	assert.Equal(t, map[string]string{"tcp": "tcp-client", "udp": "udp-client", "relay": ""}, protocols)
+
+	relaySessions := listSessions(t, base+"/admin/sessions?protocol=relay")
+	if assert.Len(t, relaySessions, 1) {
+		assert.Equal(t, "session-1", relaySessions[0].ID)
+	}
+
+	resp, err := http.Get(base + "/admin/sessions?protocol=sctp")
+	if assert.NoError(t, err) {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, server.Shutdown(ctx))
+
+	// Every port is released
+	_, err = http.Get(base + "/health")
+	assert.Error(t, err)
+	for _, addr := range []string{"127.0.0.1:18092", "127.0.0.1:18093"} {
+		udpAddr, _ := net.ResolveUDPAddr("udp", addr)
+		conn, err := net.ListenUDP("udp", udpAddr)
+		if assert.NoError(t, err, addr) {
+			conn.Close()
+		}
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:18091")
+	if assert.NoError(t, err) {
+		listener.Close()
+	}
+}
+
+func TestServerDisabledServices(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	logs, _ := logging.NewManager(io.Discard, logging.FormatText, "info")
+
+	cfg := testConfig()
+	cfg.TCP.Enabled = false
+	cfg.Relay.Enabled = false
+	server, err := NewServer(cfg, logs)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer server.Shutdown(context.Background())
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/health", nil)
+	server.Handler().ServeHTTP(w, req)
+
+	var health struct {
+		Services map[string]string `json:"services"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &health))
+	assert.Equal(t, map[string]string{"udp": "127.0.0.1:18092"}, health.Services)
+}
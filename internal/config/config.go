@@ -2,21 +2,17 @@
 package config
 
 import (
-	"fmt"
-	"os"
-	"path/filepath"
-	"strconv"
 	"time"
 
 	"github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v3"
 )
 
 // ServerConfig contains server-related configuration
 type ServerConfig struct {
-	Host     string `yaml:"host"`
-	Port     int    `yaml:"port"`
-	LogLevel string `yaml:"log_level"`
+	Host      string `yaml:"host"`
+	Port      int    `yaml:"port"`
+	LogLevel  string `yaml:"log_level"`
+	LogFormat string `yaml:"log_format"` // "text" or "json"
 }
 
 // ServersConfig contains configuration for different servers
@@ -39,117 +35,127 @@ type SignalingConfig struct {
 	Port            int           `yaml:"port"`
 	ConnTTL         time.Duration `yaml:"conn_ttl"`
 	CleanupInterval time.Duration `yaml:"cleanup_interval"`
+	VirtualNetwork  string        `yaml:"virtual_network"` // IPv4 CIDR that mesh clients get their virtual addresses from
 }
 
 // TCPServerConfig contains TCP server related configuration for NAT traversal
 type TCPServerConfig struct {
-	ListenHost        string        `yaml:"listen_host"`
-	ListenPort        int           `yaml:"listen_port"`
-	Host              string        `yaml:"-"` // Not in YAML, just for code compatibility
-	Port              int           `yaml:"-"` // Not in YAML, just for code compatibility
-	ConnectionTimeout time.Duration `yaml:"connection_timeout"`
-	IdleTimeout       time.Duration `yaml:"idle_timeout"`
-	MaxConnections    int           `yaml:"max_connections"`
-	BufferSize        int           `yaml:"buffer_size"`
+	Enabled              bool          `yaml:"enabled"` // Run the TCP rendezvous service in the application server
+	ListenHost           string        `yaml:"listen_host"`
+	ListenPort           int           `yaml:"listen_port"`
+	ConnectionTimeout    time.Duration `yaml:"connection_timeout"` // Time allowed for a new connection to register
+	IdleTimeout          time.Duration `yaml:"idle_timeout"`       // Close connections without traffic for this long
+	MaxConnectionAge     time.Duration `yaml:"max_connection_age"` // Absolute connection lifetime, 0 for unlimited
+	MaxConnections       int           `yaml:"max_connections"`
+	MaxConnectionsPerIP  int           `yaml:"max_connections_per_ip"`
+	AdmissionPolicy      string        `yaml:"admission_policy"` // "reject" or "queue" once MaxConnections is reached
+	MaxQueuedConnections int           `yaml:"max_queued_connections"`
+	QueueTimeout         time.Duration `yaml:"queue_timeout"`
+	BufferSize           int           `yaml:"buffer_size"`
 }
 
 // UDPServerConfig contains UDP server related configuration for NAT traversal
 type UDPServerConfig struct {
+	Enabled          bool          `yaml:"enabled"` // Run the UDP rendezvous service in the application server
 	ListenHost       string        `yaml:"listen_host"`
 	ListenPort       int           `yaml:"listen_port"`
-	Host             string        `yaml:"-"` // Alias for ListenHost
-	Port             int           `yaml:"-"` // Alias for ListenPort
 	PacketBufferSize int           `yaml:"packet_buffer_size"`
 	IdleTimeout      time.Duration `yaml:"idle_timeout"`
 	MaxPacketSize    int           `yaml:"max_packet_size"`
+	Workers          int           `yaml:"workers"`     // Packet processing workers, 0 for one per CPU
+	QueueDepth       int           `yaml:"queue_depth"` // Packets buffered per worker
+	DropPolicy       string        `yaml:"drop_policy"` // "drop-newest" or "drop-oldest" when a worker queue is full
+	BatchSize        int           `yaml:"batch_size"`  // Datagrams read or written per system call on Linux
+}
+
+// RelayConfig contains settings for the UDP relay that forwards traffic
+// between peers that can't reach each other directly
+type RelayConfig struct {
+	Enabled          bool          `yaml:"enabled"`
+	ListenHost       string        `yaml:"listen_host"`
+	ListenPort       int           `yaml:"listen_port"`
+	IdleTimeout      time.Duration `yaml:"idle_timeout"` // Drop sessions without traffic for this long
+	MaxSessions      int           `yaml:"max_sessions"` // 0 for unlimited
+	PacketBufferSize int           `yaml:"packet_buffer_size"`
 }
 
 // TraversalConfig contains NAT traversal related configuration
 type TraversalConfig struct {
-	PreferredProtocol string        `yaml:"preferred_protocol"`
-	Timeout           time.Duration `yaml:"timeout"`
-	MaxRetries        int           `yaml:"max_retries"`
-	RelayServer       string        `yaml:"relay_server"`
-	RelayPort         int           `yaml:"relay_port"`
+	PreferredProtocol string           `yaml:"preferred_protocol"` // "udp", "tcp" or empty for no preference
+	Timeout           time.Duration    `yaml:"timeout"`            // Overall limit for connecting to a peer
+	DialTimeout       time.Duration    `yaml:"dial_timeout"`       // Limit for each TCP connect attempt
+	MaxRetries        int              `yaml:"max_retries"`
+	RelayServer       string           `yaml:"relay_server"`
+	RelayPort         int              `yaml:"relay_port"`
+	Strategies        StrategiesConfig `yaml:"strategies"`
+}
+
+// StrategyConfig enables a traversal strategy and overrides its priority
+type StrategyConfig struct {
+	Enabled  bool `yaml:"enabled"`
+	Priority int  `yaml:"priority"` // Higher is tried first, 0 ranks by estimated success rate alone
+}
+
+// StrategiesConfig has one entry per built-in traversal strategy
+type StrategiesConfig struct {
+	UDPHolePunching     StrategyConfig `yaml:"udp_hole_punching"`
+	TCPSimultaneousOpen StrategyConfig `yaml:"tcp_simultaneous_open"`
+	UDPRelaying         StrategyConfig `yaml:"udp_relaying"`
+	TCPRelaying         StrategyConfig `yaml:"tcp_relaying"`
+	PortMapping         StrategyConfig `yaml:"port_mapping"`
+}
+
+// HolePunchConfig contains UDP hole punching timing
+type HolePunchConfig struct {
+	Attempts  int           `yaml:"attempts"`   // Punch packets sent per session
+	Interval  time.Duration `yaml:"interval"`   // Delay between punch packets
+	Timeout   time.Duration `yaml:"timeout"`    // Give up if nothing is acknowledged within this long
+	KeepAlive time.Duration `yaml:"keep_alive"` // Keep-alive interval on an established session
+}
+
+// SecurityConfig contains settings for authenticating rendezvous traffic
+type SecurityConfig struct {
+	RendezvousSecret string        `yaml:"rendezvous_secret"` // Empty disables packet authentication
+	KeyTTL           time.Duration `yaml:"key_ttl"`
+}
+
+// TracingConfig contains OpenTelemetry trace export settings
+type TracingConfig struct {
+	Enabled     bool    `yaml:"enabled"`
+	Endpoint    string  `yaml:"endpoint"`     // OTLP/HTTP collector host:port
+	Insecure    bool    `yaml:"insecure"`     // Export over plain HTTP
+	SampleRatio float64 `yaml:"sample_ratio"` // Fraction of new traces recorded, 0 to 1
 }
 
 // Config represents the application configuration
 type Config struct {
-	Server    ServerConfig    `yaml:"server"`
-	Servers   ServersConfig   `yaml:"servers"` // For newer code structure
+	Servers   ServersConfig   `yaml:"servers"`
 	STUN      STUNConfig      `yaml:"stun"`
 	Signaling SignalingConfig `yaml:"signaling"`
 	TCP       TCPServerConfig `yaml:"tcp"`
 	UDP       UDPServerConfig `yaml:"udp"`
+	Relay     RelayConfig     `yaml:"relay"`
 	Traversal TraversalConfig `yaml:"traversal"`
-}
-
-// LoadConfig loads configuration from a yaml file with environment variable overrides
-func LoadConfig(configPath string) (*Config, error) {
-	// Create default config
-	config := DefaultConfig()
-
-	// If config file exists, load it
-	if configPath != "" {
-		absPath, err := filepath.Abs(configPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get absolute path: %w", err)
-		}
-
-		data, err := os.ReadFile(absPath)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read config file: %w", err)
-		}
-
-		if err := yaml.Unmarshal(data, config); err != nil {
-			return nil, fmt.Errorf("failed to parse config file: %w", err)
-		}
-	}
-
-	// Override with environment variables if present
-	if port := os.Getenv("MEDIATORY_PORT"); port != "" {
-		if p, err := strconv.Atoi(port); err == nil {
-			config.Server.Port = p
-		}
-	}
-
-	if port := os.Getenv("APPLICATION_PORT"); port != "" {
-		if p, err := strconv.Atoi(port); err == nil {
-			config.Server.Port = p
-		}
-	}
-
-	if stunServer := os.Getenv("STUN_SERVER"); stunServer != "" {
-		config.STUN.Server = stunServer
-	}
-
-	// Initialize aliases for backward compatibility
-	config.TCP.Host = config.TCP.ListenHost
-	config.TCP.Port = config.TCP.ListenPort
-	config.UDP.Host = config.UDP.ListenHost
-	config.UDP.Port = config.UDP.ListenPort
-
-	return config, nil
+	HolePunch HolePunchConfig `yaml:"hole_punch"`
+	Security  SecurityConfig  `yaml:"security"`
+	Tracing   TracingConfig   `yaml:"tracing"`
 }
 
 // DefaultConfig returns a default configuration
 func DefaultConfig() *Config {
-	cfg := &Config{
-		Server: ServerConfig{
-			Host:     "0.0.0.0",
-			Port:     8080,
-			LogLevel: "info",
-		},
+	return &Config{
 		Servers: ServersConfig{
 			Mediatory: ServerConfig{
-				Host:     "0.0.0.0",
-				Port:     8081,
-				LogLevel: "info",
+				Host:      "0.0.0.0",
+				Port:      8081,
+				LogLevel:  "info",
+				LogFormat: "text",
 			},
 			Application: ServerConfig{
-				Host:     "0.0.0.0",
-				Port:     8082,
-				LogLevel: "info",
+				Host:      "0.0.0.0",
+				Port:      8082,
+				LogLevel:  "info",
+				LogFormat: "text",
 			},
 		},
 		STUN: STUNConfig{
@@ -163,38 +169,74 @@ func DefaultConfig() *Config {
 			Port:            8081,
 			ConnTTL:         5 * time.Minute,
 			CleanupInterval: 1 * time.Minute,
+			VirtualNetwork:  "100.64.0.0/16",
 		},
 		TCP: TCPServerConfig{
-			ListenHost:        "0.0.0.0",
-			ListenPort:        0,
-			ConnectionTimeout: 30 * time.Second,
-			IdleTimeout:       2 * time.Minute,
-			MaxConnections:    100,
-			BufferSize:        4096,
+			Enabled:              true,
+			ListenHost:           "0.0.0.0",
+			ListenPort:           0,
+			ConnectionTimeout:    30 * time.Second,
+			IdleTimeout:          2 * time.Minute,
+			MaxConnectionAge:     0,
+			MaxConnections:       100,
+			MaxConnectionsPerIP:  10,
+			AdmissionPolicy:      "reject",
+			MaxQueuedConnections: 50,
+			QueueTimeout:         5 * time.Second,
+			BufferSize:           4096,
 		},
 		UDP: UDPServerConfig{
+			Enabled:          true,
 			ListenHost:       "0.0.0.0",
 			ListenPort:       0,
 			PacketBufferSize: 4096,
 			IdleTimeout:      2 * time.Minute,
 			MaxPacketSize:    1500,
+			Workers:          0,
+			QueueDepth:       256,
+			DropPolicy:       "drop-newest",
+			BatchSize:        32,
+		},
+		Relay: RelayConfig{
+			Enabled:          true,
+			ListenHost:       "0.0.0.0",
+			ListenPort:       3478,
+			IdleTimeout:      2 * time.Minute,
+			MaxSessions:      1000,
+			PacketBufferSize: 4096,
 		},
 		Traversal: TraversalConfig{
 			PreferredProtocol: "",
 			Timeout:           30 * time.Second,
+			DialTimeout:       3 * time.Second,
 			MaxRetries:        5,
 			RelayServer:       "",
 			RelayPort:         3478,
+			Strategies: StrategiesConfig{
+				UDPHolePunching:     StrategyConfig{Enabled: true},
+				TCPSimultaneousOpen: StrategyConfig{Enabled: true},
+				UDPRelaying:         StrategyConfig{Enabled: true},
+				TCPRelaying:         StrategyConfig{Enabled: true},
+				PortMapping:         StrategyConfig{Enabled: true},
+			},
+		},
+		HolePunch: HolePunchConfig{
+			Attempts:  5,
+			Interval:  500 * time.Millisecond,
+			Timeout:   30 * time.Second,
+			KeepAlive: 10 * time.Second,
+		},
+		Security: SecurityConfig{
+			RendezvousSecret: "",
+			KeyTTL:           24 * time.Hour,
+		},
+		Tracing: TracingConfig{
+			Enabled:     false,
+			Endpoint:    "localhost:4318",
+			Insecure:    true,
+			SampleRatio: 1.0,
 		},
 	}
-
-	// Set up aliases for backward compatibility
-	cfg.TCP.Host = cfg.TCP.ListenHost
-	cfg.TCP.Port = cfg.TCP.ListenPort
-	cfg.UDP.Host = cfg.UDP.ListenHost
-	cfg.UDP.Port = cfg.UDP.ListenPort
-
-	return cfg
 }
 
 // ConfigureLogger sets up the logger based on configuration
@@ -0,0 +1,131 @@
+// internal/config/config_test.go
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+func TestRepositoryConfigLoads(t *testing.T) {
+	cfg, err := (&Loader{Path: "../../configs/config.yaml", LookupEnv: env(nil)}).Load()
+	assert.NoError(t, err)
+	assert.Equal(t, 8081, cfg.Servers.Mediatory.Port)
+	assert.Equal(t, 2*time.Minute, cfg.TCP.IdleTimeout)
+}
+
+func TestUnknownKeysRejected(t *testing.T) {
+	path := writeConfig(t, "tcp:\n  listen_port: 5555\n  host: 0.0.0.0\n")
+
+	_, err := (&Loader{Path: path, LookupEnv: env(nil)}).Load()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "field host not found")
+	}
+}
+
+func TestLayering(t *testing.T) {
+	path := writeConfig(t, "tcp:\n  idle_timeout: 1m\n  max_connections: 50\nstun:\n  retries: 1\n")
+
+	loader := &Loader{
+		Path: path,
+		LookupEnv: env(map[string]string{
+			"MEDIATORY_PORT":                "9001",
+			"APPLICATION_PORT":              "9002",
+			"NATBYPASS_TCP_MAX_CONNECTIONS": "75",
+			"NATBYPASS_STUN_RETRIES":        "4",
+			"NATBYPASS_TRACING_ENABLED":     "true",
+		}),
+	}
+	assert.NoError(t, loader.Overrides.Set("stun.retries=6"))
+	assert.Error(t, loader.Overrides.Set("stun.retry=6"), "unknown keys are caught when parsing flags")
+	assert.Error(t, loader.Overrides.Set("stun.retries"))
+
+	cfg, err := loader.Load()
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// The legacy variables no longer share one port
+	assert.Equal(t, 9001, cfg.Servers.Mediatory.Port)
+	assert.Equal(t, 9002, cfg.Servers.Application.Port)
+
+	assert.Equal(t, time.Minute, cfg.TCP.IdleTimeout, "file over defaults")
+	assert.Equal(t, 75, cfg.TCP.MaxConnections, "environment over file")
+	assert.Equal(t, 6, cfg.STUN.Retries, "flags over environment")
+	assert.True(t, cfg.Tracing.Enabled)
+}
+
+func TestEnvErrors(t *testing.T) {
+	_, err := (&Loader{LookupEnv: env(map[string]string{"NATBYPASS_TCP_IDLE_TIMEOUT": "2"})}).Load()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "NATBYPASS_TCP_IDLE_TIMEOUT")
+	}
+
+	_, err = (&Loader{LookupEnv: env(map[string]string{"MEDIATORY_PORT": "http"})}).Load()
+	assert.Error(t, err)
+}
+
+func TestEnvName(t *testing.T) {
+	assert.Equal(t, "NATBYPASS_SERVERS_MEDIATORY_LOG_LEVEL", EnvName("servers.mediatory.log_level"))
+	assert.Contains(t, Keys(), "udp.drop_policy")
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, DefaultConfig().Validate())
+
+	cfg := DefaultConfig()
+	cfg.Servers.Application.Port = 70000
+	cfg.Servers.Mediatory.LogLevel = "loud"
+	cfg.TCP.IdleTimeout = -time.Second
+	cfg.TCP.AdmissionPolicy = "wait"
+	cfg.Signaling.VirtualNetwork = "fd00::/64"
+	cfg.Tracing.SampleRatio = 2
+
+	err := cfg.Validate()
+	var validation *ValidationError
+	if !assert.True(t, errors.As(err, &validation)) {
+		return
+	}
+	assert.Equal(t, []string{
+		"servers.application.port: 70000 is not a valid port",
+		"tcp.idle_timeout: must not be negative",
+		`servers.mediatory.log_level: unknown level "loud"`,
+		`signaling.virtual_network: must be an IPv4 network of at least 4 addresses, got "fd00::/64"`,
+		`tcp.admission_policy: must be reject or queue, got "wait"`,
+		"tracing.sample_ratio: must be between 0 and 1",
+	}, validation.Problems)
+}
+
+func TestReload(t *testing.T) {
+	current := DefaultConfig()
+	next := DefaultConfig()
+	next.Servers.Mediatory.LogLevel = "debug"
+	next.TCP.IdleTimeout = time.Minute
+	next.TCP.ListenPort = 6000
+
+	updated, applied, ignored := Reload(current, next)
+	assert.Equal(t, []string{"servers.mediatory.log_level", "tcp.idle_timeout"}, applied)
+	assert.Equal(t, []string{"tcp.listen_port"}, ignored)
+
+	assert.Equal(t, "debug", updated.Servers.Mediatory.LogLevel)
+	assert.Equal(t, time.Minute, updated.TCP.IdleTimeout)
+	assert.Equal(t, 0, updated.TCP.ListenPort, "restart-only settings keep their value")
+	assert.Equal(t, "info", current.Servers.Mediatory.LogLevel, "current is not modified")
+}
@@ -0,0 +1,121 @@
+// internal/config/keys.go
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setting is one leaf of the configuration, addressed by its dotted YAML
+// path such as "tcp.idle_timeout"
+type setting struct {
+	key   string
+	value reflect.Value
+}
+
+// settings lists every leaf of cfg in declaration order
+func settings(cfg *Config) []setting {
+	var out []setting
+	var walk func(prefix string, v reflect.Value)
+	walk = func(prefix string, v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			name := strings.Split(v.Type().Field(i).Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			key := name
+			if prefix != "" {
+				key = prefix + "." + name
+			}
+
+			field := v.Field(i)
+			if field.Kind() == reflect.Struct {
+				walk(key, field)
+				continue
+			}
+			out = append(out, setting{key: key, value: field})
+		}
+	}
+	walk("", reflect.ValueOf(cfg).Elem())
+	return out
+}
+
+// lookup finds the setting for a dotted key
+func lookup(cfg *Config, key string) (reflect.Value, bool) {
+	for _, s := range settings(cfg) {
+		if s.key == key {
+			return s.value, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// Keys returns the dotted key of every setting, e.g. "tcp.idle_timeout"
+func Keys() []string {
+	all := settings(DefaultConfig())
+	keys := make([]string, len(all))
+	for i, s := range all {
+		keys[i] = s.key
+	}
+	return keys
+}
+
+// EnvName returns the environment variable that overrides a key:
+// "tcp.idle_timeout" is NATBYPASS_TCP_IDLE_TIMEOUT
+func EnvName(key string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// Set parses value into the setting at key. Durations use Go syntax ("30s").
+func (c *Config) Set(key, value string) error {
+	field, ok := lookup(c, key)
+	if !ok {
+		return fmt.Errorf("unknown configuration key %q", key)
+	}
+	if err := setValue(field, value); err != nil {
+		return fmt.Errorf("%s: invalid value %q: %w", key, value, err)
+	}
+	return nil
+}
+
+func setValue(field reflect.Value, value string) error {
+	if field.Type() == durationType {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported type %s", field.Type())
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+// internal/config/load.go
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix starts the name of every variable in the environment overlay
+const envPrefix = "NATBYPASS_"
+
+// Loader builds a configuration from layered sources, each overriding the
+// one before: built-in defaults, the YAML file, legacy environment variables,
+// NATBYPASS_* environment variables and finally command-line overrides. The
+// result is validated before it is returned.
+type Loader struct {
+	Path      string                      // YAML file, empty to skip it
+	Overrides Overrides                   // key=value pairs, usually from -set flags
+	LookupEnv func(string) (string, bool) // os.LookupEnv when nil
+}
+
+// LoadConfig loads configuration from a yaml file with environment variable overrides
+func LoadConfig(configPath string) (*Config, error) {
+	return (&Loader{Path: configPath}).Load()
+}
+
+// Load reads every source and validates the result
+func (l *Loader) Load() (*Config, error) {
+	config := DefaultConfig()
+
+	if l.Path != "" {
+		if err := decodeFile(l.Path, config); err != nil {
+			return nil, err
+		}
+	}
+
+	lookupEnv := l.LookupEnv
+	if lookupEnv == nil {
+		lookupEnv = os.LookupEnv
+	}
+	if err := applyLegacyEnv(config, lookupEnv); err != nil {
+		return nil, err
+	}
+	if err := applyEnv(config, lookupEnv); err != nil {
+		return nil, err
+	}
+
+	for _, override := range l.Overrides {
+		key, value, _ := strings.Cut(override, "=")
+		if err := config.Set(key, value); err != nil {
+			return nil, fmt.Errorf("command-line override: %w", err)
+		}
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// decodeFile decodes a YAML file over config. Keys that don't map to a
+// setting are errors rather than being silently dropped.
+func decodeFile(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv sets every key that has a NATBYPASS_* variable in the environment
+func applyEnv(config *Config, lookupEnv func(string) (string, bool)) error {
+	for _, s := range settings(config) {
+		name := EnvName(s.key)
+		value, ok := lookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setValue(s.value, value); err != nil {
+			return fmt.Errorf("%s: invalid value %q: %w", name, value, err)
+		}
+	}
+	return nil
+}
+
+// legacyEnv maps the variables used before the NATBYPASS_* overlay existed
+// onto the keys they set
+var legacyEnv = []struct {
+	name string
+	keys []string
+}{
+	{"MEDIATORY_PORT", []string{"servers.mediatory.port"}},
+	{"APPLICATION_PORT", []string{"servers.application.port"}},
+	{"STUN_SERVER", []string{"stun.server"}},
+	{"RENDEZVOUS_SECRET", []string{"security.rendezvous_secret"}},
+	{"LOG_FORMAT", []string{"servers.mediatory.log_format", "servers.application.log_format"}},
+	{"TRACING_ENDPOINT", []string{"tracing.endpoint"}},
+}
+
+func applyLegacyEnv(config *Config, lookupEnv func(string) (string, bool)) error {
+	for _, env := range legacyEnv {
+		value, ok := lookupEnv(env.name)
+		if !ok || value == "" {
+			continue
+		}
+		for _, key := range env.keys {
+			if err := config.Set(key, value); err != nil {
+				return fmt.Errorf("%s: %w", env.name, err)
+			}
+		}
+	}
+
+	// Pointing at a collector implies exporting to it
+	if endpoint, ok := lookupEnv("TRACING_ENDPOINT"); ok && endpoint != "" {
+		config.Tracing.Enabled = true
+	}
+	return nil
+}
+
+// Overrides collects repeated key=value command-line flags. It implements
+// flag.Value:
+//
+//	var overrides config.Overrides
+//	flag.Var(&overrides, "set", "override a configuration key")
+type Overrides []string
+
+func (o *Overrides) String() string {
+	return strings.Join(*o, ",")
+}
+
+// Set checks the pair names a known key; the value is parsed when loading
+func (o *Overrides) Set(pair string) error {
+	key, _, found := strings.Cut(pair, "=")
+	if !found {
+		return fmt.Errorf("expected key=value, got %q", pair)
+	}
+	if _, ok := lookup(DefaultConfig(), key); !ok {
+		return fmt.Errorf("unknown configuration key %q", key)
+	}
+	*o = append(*o, pair)
+	return nil
+}
@@ -0,0 +1,41 @@
+// internal/config/reload.go
+package config
+
+import "reflect"
+
+// reloadable are the keys a running server can pick up on SIGHUP. Anything
+// else (listen addresses, buffer sizes, secrets, ...) needs a restart.
+var reloadable = map[string]bool{
+	"servers.mediatory.log_level":   true,
+	"servers.application.log_level": true,
+	"stun.server":                   true,
+	"stun.timeout":                  true,
+	"stun.retries":                  true,
+	"tcp.connection_timeout":        true,
+	"tcp.idle_timeout":              true,
+	"tcp.max_connection_age":        true,
+	"tcp.queue_timeout":             true,
+}
+
+// Reload returns a copy of current with the reloadable settings that differ
+// in next applied. applied lists the keys that changed; ignored lists keys
+// that changed but only take effect after a restart.
+func Reload(current, next *Config) (updated *Config, applied, ignored []string) {
+	copied := *current
+	updated = &copied
+
+	nextSettings := settings(next)
+	for i, s := range settings(updated) {
+		value := nextSettings[i].value
+		if reflect.DeepEqual(s.value.Interface(), value.Interface()) {
+			continue
+		}
+		if !reloadable[s.key] {
+			ignored = append(ignored, s.key)
+			continue
+		}
+		s.value.Set(value)
+		applied = append(applied, s.key)
+	}
+	return updated, applied, ignored
+}
@@ -0,0 +1,104 @@
+// internal/config/validate.go
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ValidationError lists every invalid setting in a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "invalid configuration: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate checks values the schema alone can't: ranges, enumerations and
+// settings that depend on each other. All problems are reported at once.
+func (c *Config) Validate() error {
+	var problems []string
+	report := func(key, format string, args ...interface{}) {
+		problems = append(problems, key+": "+fmt.Sprintf(format, args...))
+	}
+
+	// Counts, sizes, ports and durations are never negative
+	for _, s := range settings(c) {
+		switch s.value.Kind() {
+		case reflect.Int, reflect.Int64:
+			if s.value.Int() < 0 {
+				report(s.key, "must not be negative")
+			}
+		case reflect.Float64:
+			if s.value.Float() < 0 {
+				report(s.key, "must not be negative")
+			}
+		}
+		if strings.HasSuffix(s.key, "port") && s.value.Int() > 65535 {
+			report(s.key, "%d is not a valid port", s.value.Int())
+		}
+	}
+
+	for _, server := range []struct {
+		key string
+		ServerConfig
+	}{
+		{"servers.mediatory", c.Servers.Mediatory},
+		{"servers.application", c.Servers.Application},
+	} {
+		if _, err := logrus.ParseLevel(server.LogLevel); err != nil {
+			report(server.key+".log_level", "unknown level %q", server.LogLevel)
+		}
+		if server.LogFormat != "" && server.LogFormat != "text" && server.LogFormat != "json" {
+			report(server.key+".log_format", "must be text or json, got %q", server.LogFormat)
+		}
+	}
+
+	if c.STUN.Server == "" {
+		report("stun.server", "must be set")
+	}
+	if c.STUN.Timeout <= 0 {
+		report("stun.timeout", "must be positive")
+	}
+
+	if network, err := netip.ParsePrefix(c.Signaling.VirtualNetwork); err != nil || !network.Addr().Is4() || network.Bits() > 30 {
+		report("signaling.virtual_network", "must be an IPv4 network of at least 4 addresses, got %q", c.Signaling.VirtualNetwork)
+	}
+
+	switch c.TCP.AdmissionPolicy {
+	case "", "reject", "queue":
+	default:
+		report("tcp.admission_policy", "must be reject or queue, got %q", c.TCP.AdmissionPolicy)
+	}
+	if c.UDP.MaxPacketSize > 65535 {
+		report("udp.max_packet_size", "must be at most 65535")
+	}
+	switch c.UDP.DropPolicy {
+	case "", "drop-newest", "drop-oldest":
+	default:
+		report("udp.drop_policy", "must be drop-newest or drop-oldest, got %q", c.UDP.DropPolicy)
+	}
+
+	switch c.Traversal.PreferredProtocol {
+	case "", "udp", "tcp":
+	default:
+		report("traversal.preferred_protocol", "must be empty, udp or tcp, got %q", c.Traversal.PreferredProtocol)
+	}
+
+	if c.Tracing.SampleRatio > 1 {
+		report("tracing.sample_ratio", "must be between 0 and 1")
+	}
+	if c.Tracing.Enabled && c.Tracing.Endpoint == "" {
+		report("tracing.endpoint", "must be set when tracing is enabled")
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
@@ -2,6 +2,10 @@
 
 package discovery
 
+import (
+	"strings"
+)
+
 // NATType represents different NAT types that can be detected
 type NATType string
 
@@ -20,4 +24,38 @@ const (
 
 	// NATSymmetric represents a symmetric NAT (most restrictive)
 	NATSymmetric NATType = "symmetric"
+
+	// NATNone represents a host with a public address and no NAT in the way,
+	// as is typical for IPv6
+	NATNone NATType = "none"
 )
+
+// ParseNATType converts a NAT type name to a NATType, ignoring case and
+// separators, so "PortRestrictedCone" and "port_restricted_cone" are the
+// same. Unrecognised names are NATUnknown.
+func ParseNATType(name string) NATType {
+	key := normalizeNATType(name)
+	for _, natType := range []NATType{
+		NATNone,
+		NATFullCone,
+		NATAddressRestrictedCone,
+		NATPortRestrictedCone,
+		NATSymmetric,
+	} {
+		if normalizeNATType(string(natType)) == key {
+			return natType
+		}
+	}
+	return NATUnknown
+}
+
+// normalizeNATType lowercases a name and strips separators
+func normalizeNATType(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '-', '_', ' ':
+			return -1
+		}
+		return r
+	}, strings.ToLower(name))
+}
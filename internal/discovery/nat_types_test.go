@@ -0,0 +1,16 @@
+// File: internal/discovery/nat_types_test.go
+
+package discovery
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNATType(t *testing.T) {
+	assert.Equal(t, NATPortRestrictedCone, ParseNATType("PortRestrictedCone"))
+	assert.Equal(t, NATFullCone, ParseNATType("full_cone"))
+	assert.Equal(t, NATNone, ParseNATType("None"))
+	assert.Equal(t, NATUnknown, ParseNATType("Restricted"))
+}
@@ -0,0 +1,61 @@
+// internal/logging/gin.go
+package logging
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterRoutes adds the log level admin endpoints:
+//
+//	GET /log-levels              every component's level
+//	PUT /log-levels/:component   {"level": "debug"} changes one
+func (m *Manager) RegisterRoutes(router gin.IRoutes) {
+	router.GET("/log-levels", m.getLevels)
+	router.PUT("/log-levels/:component", m.setLevel)
+}
+
+func (m *Manager) getLevels(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"status": "success",
+		"levels": m.Levels(),
+	})
+}
+
+func (m *Manager) setLevel(c *gin.Context) {
+	var req struct {
+		Level string `json:"level" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invalid_request",
+			"detail": err.Error(),
+		})
+		return
+	}
+
+	component := c.Param("component")
+	if err := m.SetLevel(component, req.Level); err != nil {
+		if errors.Is(err, ErrUnknownComponent) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"status": "error",
+				"error":  "component_not_found",
+			})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invalid_level",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "updated",
+		"component": component,
+		"level":     req.Level,
+	})
+}
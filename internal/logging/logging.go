@@ -0,0 +1,167 @@
+// internal/logging/logging.go
+
+// Package logging defines the logger taken by library code such as
+// internal/nat, a no-op default for consumers that don't configure one, and
+// a set of per-component loggers whose levels can be changed at runtime.
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Logger is the logging interface components take. *logrus.Logger,
+// *logrus.Entry and *utils.Logger all satisfy it. Identifiers such as
+// connection and session IDs go in fields rather than the message.
+type Logger = logrus.FieldLogger
+
+// Fields are structured log fields
+type Fields = logrus.Fields
+
+// Output formats
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Errors returned when changing levels
+var (
+	ErrUnknownComponent = errors.New("unknown log component")
+	ErrInvalidFormat    = errors.New("log format must be text or json")
+)
+
+var discard = func() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	logger.SetLevel(logrus.PanicLevel)
+	return logger
+}()
+
+// Discard returns a logger that drops everything
+func Discard() Logger {
+	return discard
+}
+
+// OrDiscard returns logger, or a logger that drops everything if it is nil
+func OrDiscard(logger Logger) Logger {
+	if logger == nil {
+		return discard
+	}
+	return logger
+}
+
+// NewFormatter returns the logrus formatter for a format name
+func NewFormatter(format string) (logrus.Formatter, error) {
+	switch format {
+	case "", FormatText:
+		return &logrus.TextFormatter{
+			FullTimestamp:   true,
+			TimestampFormat: "2006-01-02 15:04:05.000",
+		}, nil
+	case FormatJSON:
+		return &logrus.JSONFormatter{}, nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidFormat, format)
+	}
+}
+
+// Manager hands out one logger per component, all writing to the same
+// output in the same format, each with a level that can be changed while
+// the process runs
+type Manager struct {
+	mu        sync.RWMutex
+	out       io.Writer
+	formatter logrus.Formatter
+	level     logrus.Level // Level of components created from now on
+	loggers   map[string]*logrus.Logger
+}
+
+// NewManager creates a manager writing to out in the given format. Unknown
+// levels fall back to info.
+func NewManager(out io.Writer, format, level string) (*Manager, error) {
+	formatter, err := NewFormatter(format)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := logrus.ParseLevel(level)
+	if err != nil {
+		parsed = logrus.InfoLevel
+	}
+
+	return &Manager{
+		out:       out,
+		formatter: formatter,
+		level:     parsed,
+		loggers:   make(map[string]*logrus.Logger),
+	}, nil
+}
+
+// Logger returns the logger for a component, creating it at the manager's
+// default level on first use. Entries carry a "component" field.
+func (m *Manager) Logger(component string) Logger {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	logger, exists := m.loggers[component]
+	if !exists {
+		logger = logrus.New()
+		logger.SetOutput(m.out)
+		logger.SetFormatter(m.formatter)
+		logger.SetLevel(m.level)
+		m.loggers[component] = logger
+	}
+	return logger.WithField("component", component)
+}
+
+// SetLevel changes a component's level
+func (m *Manager) SetLevel(component, level string) error {
+	parsed, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	m.mu.RLock()
+	logger, exists := m.loggers[component]
+	m.mu.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("%w: %s", ErrUnknownComponent, component)
+	}
+	logger.SetLevel(parsed)
+	return nil
+}
+
+// SetAllLevels changes every component's level, including components
+// created later, replacing any set individually
+func (m *Manager) SetAllLevels(level string) error {
+	parsed, err := logrus.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.level = parsed
+	for _, logger := range m.loggers {
+		logger.SetLevel(parsed)
+	}
+	return nil
+}
+
+// Levels returns every component's current level
+func (m *Manager) Levels() map[string]string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	levels := make(map[string]string, len(m.loggers))
+	for component, logger := range m.loggers {
+		levels[component] = logger.GetLevel().String()
+	}
+	return levels
+}
@@ -0,0 +1,94 @@
+// internal/logging/logging_test.go
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bOguzhan/NATbypass/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// The wrapper used by the servers is accepted wherever a Logger is
+var _ Logger = (*utils.Logger)(nil)
+
+func TestManagerLevels(t *testing.T) {
+	var out bytes.Buffer
+	manager, err := NewManager(&out, FormatJSON, "info")
+	assert.NoError(t, err)
+
+	tcp := manager.Logger("tcp-server")
+	udp := manager.Logger("udp-server")
+
+	tcp.WithField("connection_id", "abc").Debug("hidden")
+	assert.Empty(t, out.String(), "debug is below the default level")
+
+	assert.NoError(t, manager.SetLevel("tcp-server", "debug"))
+	tcp.WithField("connection_id", "abc").Debug("shown")
+	udp.Debug("still hidden")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(t, lines, 1)
+
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "shown", entry["msg"])
+	assert.Equal(t, "debug", entry["level"])
+	assert.Equal(t, "tcp-server", entry["component"])
+	assert.Equal(t, "abc", entry["connection_id"])
+
+	assert.Equal(t, map[string]string{"tcp-server": "debug", "udp-server": "info"}, manager.Levels())
+	assert.ErrorIs(t, manager.SetLevel("relay", "debug"), ErrUnknownComponent)
+	assert.Error(t, manager.SetLevel("tcp-server", "loud"))
+
+	assert.NoError(t, manager.SetAllLevels("warn"))
+	manager.Logger("relay")
+	assert.Equal(t, map[string]string{"tcp-server": "warning", "udp-server": "warning", "relay": "warning"}, manager.Levels())
+
+	_, err = NewManager(&out, "xml", "info")
+	assert.ErrorIs(t, err, ErrInvalidFormat)
+}
+
+func TestDiscard(t *testing.T) {
+	assert.NotPanics(t, func() {
+		OrDiscard(nil).WithField("session_id", "s1").Error("dropped")
+		Discard().Infof("dropped %d", 1)
+	})
+}
+
+func TestLevelRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	manager, _ := NewManager(&bytes.Buffer{}, FormatText, "info")
+	manager.Logger("udp-server")
+
+	router := gin.New()
+	manager.RegisterRoutes(router.Group("/admin"))
+
+	put := func(path, body string) int {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodPut, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, put("/admin/log-levels/udp-server", `{"level":"warn"}`))
+	assert.Equal(t, http.StatusNotFound, put("/admin/log-levels/relay", `{"level":"warn"}`))
+	assert.Equal(t, http.StatusBadRequest, put("/admin/log-levels/udp-server", `{"level":"loud"}`))
+	assert.Equal(t, http.StatusBadRequest, put("/admin/log-levels/udp-server", `{}`))
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/admin/log-levels", nil)
+	router.ServeHTTP(w, req)
+
+	var response struct {
+		Levels map[string]string `json:"levels"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "warning", response.Levels["udp-server"])
+}
@@ -0,0 +1,40 @@
+// internal/metrics/gin.go
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GinMiddleware registers an HTTP latency histogram and returns middleware
+// that observes every request by method, route template and status code.
+// Requests that match no route are recorded under the route "unmatched" so
+// scanners can't create unbounded series.
+func (r *Registry) GinMiddleware() (gin.HandlerFunc, error) {
+	latency, err := r.NewHistogram("natbypass_http_request_duration_seconds",
+		"HTTP request latency by method, route and status code",
+		DefBuckets, "method", "route", "status")
+	if err != nil {
+		return nil, err
+	}
+
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		latency.Observe(time.Since(start).Seconds(), c.Request.Method, route, strconv.Itoa(c.Writer.Status()))
+	}, nil
+}
+
+// Handler returns a gin handler serving scrapes of the registry
+func (r *Registry) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r.ServeHTTP(c.Writer, c.Request)
+	}
+}
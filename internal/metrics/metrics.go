@@ -0,0 +1,438 @@
+// internal/metrics/metrics.go
+
+// Package metrics exposes server metrics in the Prometheus text exposition
+// format. It covers what the servers need: labelled counters, gauges and
+// histograms, plus metrics computed on each scrape from existing state.
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the media type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// Kind is a Prometheus metric type
+type Kind string
+
+// Metric kinds
+const (
+	KindCounter   Kind = "counter"
+	KindGauge     Kind = "gauge"
+	KindHistogram Kind = "histogram"
+)
+
+// ErrAlreadyRegistered is returned when a family name is registered twice
+var ErrAlreadyRegistered = errors.New("metric already registered")
+
+// DefBuckets are latency buckets in seconds suitable for HTTP handlers
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector is a metric family that can write itself out
+type collector interface {
+	describe() (name, help string, kind Kind)
+	write(w *bufio.Writer)
+}
+
+// Registry holds metric families and renders them for scrapes
+type Registry struct {
+	mu         sync.RWMutex
+	collectors map[string]collector
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{collectors: make(map[string]collector)}
+}
+
+// register adds a family. Names are unique within a registry; the first
+// family registered under a name is kept.
+func (r *Registry) register(c collector) error {
+	name, _, _ := c.describe()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.collectors[name]; exists {
+		return fmt.Errorf("metrics: %s: %w", name, ErrAlreadyRegistered)
+	}
+	r.collectors[name] = c
+	return nil
+}
+
+// NewCounter registers a counter family with the given label names
+func (r *Registry) NewCounter(name, help string, labels ...string) (*Counter, error) {
+	c := &Counter{family: newFamily(name, help, KindCounter, labels)}
+	if err := r.register(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewGauge registers a gauge family with the given label names
+func (r *Registry) NewGauge(name, help string, labels ...string) (*Gauge, error) {
+	g := &Gauge{family: newFamily(name, help, KindGauge, labels)}
+	if err := r.register(g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// NewHistogram registers a histogram family. Nil buckets use DefBuckets.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) (*Histogram, error) {
+	if buckets == nil {
+		buckets = DefBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	h := &Histogram{
+		name:    name,
+		help:    help,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	if err := r.register(h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// NewFunc registers a family whose samples are computed on every scrape.
+// collect calls observe once per series with label values in the order of
+// labels. Counter funcs must report values that only grow.
+func (r *Registry) NewFunc(name, help string, kind Kind, labels []string, collect func(observe func(value float64, labelValues ...string))) error {
+	return r.register(&funcCollector{name: name, help: help, kind: kind, labels: labels, collect: collect})
+}
+
+// NewGaugeFunc registers an unlabelled gauge computed on every scrape
+func (r *Registry) NewGaugeFunc(name, help string, value func() float64) error {
+	return r.NewFunc(name, help, KindGauge, nil, func(observe func(float64, ...string)) {
+		observe(value())
+	})
+}
+
+// NewCounterFunc registers an unlabelled counter read on every scrape
+func (r *Registry) NewCounterFunc(name, help string, value func() float64) error {
+	return r.NewFunc(name, help, KindCounter, nil, func(observe func(float64, ...string)) {
+		observe(value())
+	})
+}
+
+// WriteTo renders every family in the text exposition format, sorted by name
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	collectors := make([]collector, len(names))
+	for i, name := range names {
+		collectors[i] = r.collectors[name]
+	}
+	r.mu.RUnlock()
+
+	counter := &countingWriter{w: w}
+	buf := bufio.NewWriter(counter)
+	for _, c := range collectors {
+		name, help, kind := c.describe()
+		fmt.Fprintf(buf, "# HELP %s %s\n", name, escapeHelp(help))
+		fmt.Fprintf(buf, "# TYPE %s %s\n", name, kind)
+		c.write(buf)
+	}
+	err := buf.Flush()
+	return counter.n, err
+}
+
+// ServeHTTP serves a scrape
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w)
+}
+
+// family holds the series of a counter or gauge keyed by label values
+type family struct {
+	name   string
+	help   string
+	kind   Kind
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*sample
+}
+
+// sample is one series of a family
+type sample struct {
+	labelValues []string
+	value       float64
+}
+
+func newFamily(name, help string, kind Kind, labels []string) family {
+	return family{name: name, help: help, kind: kind, labels: labels, series: make(map[string]*sample)}
+}
+
+func (f *family) describe() (string, string, Kind) {
+	return f.name, f.help, f.kind
+}
+
+// add changes a series' value, creating it on first use
+func (f *family) add(delta float64, labelValues []string, set bool) {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	s, exists := f.series[key]
+	if !exists {
+		s = &sample{labelValues: append([]string(nil), labelValues...)}
+		f.series[key] = s
+	}
+	if set {
+		s.value = delta
+	} else {
+		s.value += delta
+	}
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mu.Lock()
+	samples := make([]*sample, 0, len(f.series))
+	for _, s := range f.series {
+		samples = append(samples, &sample{labelValues: s.labelValues, value: s.value})
+	}
+	f.mu.Unlock()
+
+	sortSamples(samples)
+	for _, s := range samples {
+		writeSample(w, f.name, f.labels, s.labelValues, "", "", s.value)
+	}
+}
+
+// Counter is a family of monotonically increasing values
+type Counter struct {
+	family
+}
+
+// Inc adds one to the series with the given label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.add(1, labelValues, false)
+}
+
+// Add adds a non-negative amount to the series with the given label values
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic(fmt.Sprintf("metrics: counter %s cannot decrease", c.name))
+	}
+	c.add(delta, labelValues, false)
+}
+
+// Gauge is a family of values that go up and down
+type Gauge struct {
+	family
+}
+
+// Set sets the series with the given label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.add(value, labelValues, true)
+}
+
+// Add changes the series with the given label values by delta
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.add(delta, labelValues, false)
+}
+
+// Histogram is a family of bucketed observations
+type Histogram struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+// histogramSeries holds one series' bucket counts, which are not cumulative
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64 // One per bucket plus +Inf
+	sum         float64
+	count       uint64
+}
+
+func (h *Histogram) describe() (string, string, Kind) {
+	return h.name, h.help, KindHistogram
+}
+
+// Observe records a value in the series with the given label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	if len(labelValues) != len(h.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", h.name, len(h.labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	bucket := sort.SearchFloat64s(h.buckets, value)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s, exists := h.series[key]
+	if !exists {
+		s = &histogramSeries{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)+1),
+		}
+		h.series[key] = s
+	}
+	s.counts[bucket]++
+	s.sum += value
+	s.count++
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	series := make([]histogramSeries, 0, len(h.series))
+	for _, s := range h.series {
+		copied := *s
+		copied.counts = append([]uint64(nil), s.counts...)
+		series = append(series, copied)
+	}
+	h.mu.Unlock()
+
+	sort.Slice(series, func(i, j int) bool {
+		return lessLabels(series[i].labelValues, series[j].labelValues)
+	})
+
+	for _, s := range series {
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			writeSample(w, h.name+"_bucket", h.labels, s.labelValues, "le", formatFloat(upper), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", h.labels, s.labelValues, "le", "+Inf", float64(s.count))
+		writeSample(w, h.name+"_sum", h.labels, s.labelValues, "", "", s.sum)
+		writeSample(w, h.name+"_count", h.labels, s.labelValues, "", "", float64(s.count))
+	}
+}
+
+// funcCollector computes its samples on every scrape
+type funcCollector struct {
+	name    string
+	help    string
+	kind    Kind
+	labels  []string
+	collect func(observe func(value float64, labelValues ...string))
+}
+
+func (f *funcCollector) describe() (string, string, Kind) {
+	return f.name, f.help, f.kind
+}
+
+func (f *funcCollector) write(w *bufio.Writer) {
+	var samples []*sample
+	f.collect(func(value float64, labelValues ...string) {
+		if len(labelValues) != len(f.labels) {
+			return
+		}
+		samples = append(samples, &sample{labelValues: labelValues, value: value})
+	})
+
+	sortSamples(samples)
+	for _, s := range samples {
+		writeSample(w, f.name, f.labels, s.labelValues, "", "", s.value)
+	}
+}
+
+// writeSample writes one sample line. extraName/extraValue add a label such
+// as a histogram's "le".
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraName, extraValue string, value float64) {
+	w.WriteString(name)
+
+	if len(labels) > 0 || extraName != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabel(values[i]))
+		}
+		if extraName != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraName, extraValue)
+		}
+		w.WriteByte('}')
+	}
+
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(value))
+	w.WriteByte('\n')
+}
+
+// formatFloat formats a sample value the way Prometheus parses it
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+// sortSamples orders samples by label values so scrapes are stable
+func sortSamples(samples []*sample) {
+	sort.Slice(samples, func(i, j int) bool {
+		return lessLabels(samples[i].labelValues, samples[j].labelValues)
+	})
+}
+
+func lessLabels(a, b []string) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// countingWriter counts bytes written for WriteTo's return value
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
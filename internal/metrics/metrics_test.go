@@ -0,0 +1,131 @@
+// internal/metrics/metrics_test.go
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func scrape(t *testing.T, registry *Registry) string {
+	var out strings.Builder
+	_, err := registry.WriteTo(&out)
+	assert.NoError(t, err)
+	return out.String()
+}
+
+func TestCounterAndGauge(t *testing.T) {
+	registry := NewRegistry()
+	requests, err := registry.NewCounter("test_requests_total", "Requests handled", "code")
+	assert.NoError(t, err)
+	queue, err := registry.NewGauge("test_queue_depth", "Queued items")
+	assert.NoError(t, err)
+
+	requests.Inc("200")
+	requests.Inc("200")
+	requests.Add(3, "500")
+	queue.Set(7)
+	queue.Add(-2)
+
+	assert.Equal(t, `# HELP test_queue_depth Queued items
+# TYPE test_queue_depth gauge
+test_queue_depth 5
+# HELP test_requests_total Requests handled
+# TYPE test_requests_total counter
+test_requests_total{code="200"} 2
+test_requests_total{code="500"} 3
+`, scrape(t, registry))
+
+	assert.Panics(t, func() { requests.Inc() }, "label count must match")
+}
+
+func TestDuplicateRegistration(t *testing.T) {
+	registry := NewRegistry()
+	queue, err := registry.NewGauge("test_queue_depth", "Queued items")
+	assert.NoError(t, err)
+	queue.Set(3)
+
+	gauge, err := registry.NewGauge("test_queue_depth", "again")
+	assert.ErrorIs(t, err, ErrAlreadyRegistered)
+	assert.Nil(t, gauge)
+	_, err = registry.NewHistogram("test_queue_depth", "again", nil)
+	assert.ErrorIs(t, err, ErrAlreadyRegistered)
+	err = registry.NewGaugeFunc("test_queue_depth", "again", func() float64 { return 1 })
+	assert.ErrorIs(t, err, ErrAlreadyRegistered)
+
+	// The family registered first is still served
+	assert.Equal(t, `# HELP test_queue_depth Queued items
+# TYPE test_queue_depth gauge
+test_queue_depth 3
+`, scrape(t, registry))
+
+	_, err = registry.GinMiddleware()
+	assert.NoError(t, err)
+	_, err = registry.GinMiddleware()
+	assert.ErrorIs(t, err, ErrAlreadyRegistered, "one latency histogram per registry")
+}
+
+func TestHistogram(t *testing.T) {
+	registry := NewRegistry()
+	latency, err := registry.NewHistogram("test_latency_seconds", "Latency", []float64{0.1, 1}, "path")
+	assert.NoError(t, err)
+
+	latency.Observe(0.05, "/a")
+	latency.Observe(0.1, "/a")
+	latency.Observe(0.5, "/a")
+	latency.Observe(3, "/a")
+
+	assert.Equal(t, `# HELP test_latency_seconds Latency
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{path="/a",le="0.1"} 2
+test_latency_seconds_bucket{path="/a",le="1"} 3
+test_latency_seconds_bucket{path="/a",le="+Inf"} 4
+test_latency_seconds_sum{path="/a"} 3.65
+test_latency_seconds_count{path="/a"} 4
+`, scrape(t, registry))
+}
+
+func TestFuncCollectorAndEscaping(t *testing.T) {
+	registry := NewRegistry()
+	err := registry.NewFunc("test_connections", "Connections\nby status", KindGauge, []string{"status"},
+		func(observe func(float64, ...string)) {
+			observe(2, "established")
+			observe(1, `we"ird\`)
+		})
+	assert.NoError(t, err)
+
+	assert.Equal(t, `# HELP test_connections Connections\nby status
+# TYPE test_connections gauge
+test_connections{status="established"} 2
+test_connections{status="we\"ird\\"} 1
+`, scrape(t, registry))
+}
+
+func TestGinMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	registry := NewRegistry()
+
+	latency, err := registry.GinMiddleware()
+	assert.NoError(t, err)
+
+	router := gin.New()
+	router.Use(latency)
+	router.GET("/items/:id", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	router.GET("/metrics", registry.Handler())
+
+	for _, path := range []string{"/items/1", "/items/2", "/missing"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, ContentType, recorder.Header().Get("Content-Type"))
+
+	body := recorder.Body.String()
+	assert.Contains(t, body, `natbypass_http_request_duration_seconds_count{method="GET",route="/items/:id",status="204"} 2`)
+	assert.Contains(t, body, `natbypass_http_request_duration_seconds_count{method="GET",route="unmatched",status="404"} 1`)
+}
@@ -0,0 +1,22 @@
+// internal/metrics/process.go
+package metrics
+
+import (
+	"github.com/bOguzhan/NATbypass/internal/utils"
+)
+
+// RegisterProcessMetrics exports the process uptime and build version
+func RegisterProcessMetrics(registry *Registry) error {
+	err := registry.NewGaugeFunc("natbypass_uptime_seconds",
+		"Seconds since the server process started", func() float64 {
+			return utils.Uptime().Seconds()
+		})
+	if err != nil {
+		return err
+	}
+
+	return registry.NewFunc("natbypass_build_info", "Build information, always 1",
+		KindGauge, []string{"version"}, func(observe func(float64, ...string)) {
+			observe(1, utils.Version)
+		})
+}
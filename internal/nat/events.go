@@ -0,0 +1,162 @@
+package nat
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+)
+
+// eventQueueSize is the number of events buffered before new ones are dropped
+const eventQueueSize = 1024
+
+// EventType identifies a connection lifecycle event
+type EventType string
+
+const (
+	// EventConnect is emitted when a peer connection is first tracked
+	EventConnect EventType = "connect"
+
+	// EventRegister is emitted when a peer registers its client ID
+	EventRegister EventType = "register"
+
+	// EventPacket is emitted for every packet accepted by a server
+	EventPacket EventType = "packet"
+
+	// EventIdleTimeout is emitted when a connection is dropped for inactivity
+	EventIdleTimeout EventType = "idle-timeout"
+
+	// EventClose is emitted when a connection stops being tracked
+	EventClose EventType = "close"
+)
+
+// ConnectionEvent describes a lifecycle event on a TCP or UDP server connection.
+// UDP connections have no handshake, so their ConnectionID is the peer address.
+type ConnectionEvent struct {
+	Type         EventType
+	Protocol     string
+	ConnectionID string
+	ClientID     string
+	RemoteAddr   net.Addr
+	LocalAddr    net.Addr
+	Packet       *protocol.Packet // Only set for EventPacket
+	Timestamp    time.Time
+}
+
+// EventHandler receives connection lifecycle events
+type EventHandler func(event ConnectionEvent)
+
+// eventHub delivers events to subscribers on its own goroutine so that slow
+// subscribers never block a server's read loops. When the queue is full new
+// events are dropped and counted.
+type eventHub struct {
+	mu       sync.RWMutex
+	handlers map[EventType][]EventHandler
+	queue    chan ConnectionEvent
+	closed   bool
+	dropped  uint64
+}
+
+// newEventHub creates an event hub and starts its delivery goroutine
+func newEventHub() *eventHub {
+	hub := &eventHub{
+		handlers: make(map[EventType][]EventHandler),
+		queue:    make(chan ConnectionEvent, eventQueueSize),
+	}
+
+	go hub.run()
+
+	return hub
+}
+
+// OnConnect subscribes to new connections
+func (h *eventHub) OnConnect(handler EventHandler) {
+	h.subscribe(EventConnect, handler)
+}
+
+// OnRegister subscribes to client registrations
+func (h *eventHub) OnRegister(handler EventHandler) {
+	h.subscribe(EventRegister, handler)
+}
+
+// OnPacket subscribes to accepted packets
+func (h *eventHub) OnPacket(handler EventHandler) {
+	h.subscribe(EventPacket, handler)
+}
+
+// OnIdleTimeout subscribes to connections dropped for inactivity
+func (h *eventHub) OnIdleTimeout(handler EventHandler) {
+	h.subscribe(EventIdleTimeout, handler)
+}
+
+// OnClose subscribes to connections that stop being tracked
+func (h *eventHub) OnClose(handler EventHandler) {
+	h.subscribe(EventClose, handler)
+}
+
+// DroppedEvents returns the number of events dropped because subscribers fell behind
+func (h *eventHub) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&h.dropped)
+}
+
+// subscribe adds a handler for an event type
+func (h *eventHub) subscribe(eventType EventType, handler EventHandler) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handlers[eventType] = append(h.handlers[eventType], handler)
+}
+
+// hasHandlers reports whether anyone subscribed to an event type, letting
+// callers skip building events nobody will receive
+func (h *eventHub) hasHandlers(eventType EventType) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.handlers[eventType]) > 0
+}
+
+// emit queues an event for delivery without blocking
+func (h *eventHub) emit(event ConnectionEvent) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.closed || len(h.handlers[event.Type]) == 0 {
+		return
+	}
+
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	select {
+	case h.queue <- event:
+	default:
+		atomic.AddUint64(&h.dropped, 1)
+	}
+}
+
+// run delivers queued events to subscribers in order
+func (h *eventHub) run() {
+	for event := range h.queue {
+		h.mu.RLock()
+		handlers := h.handlers[event.Type]
+		h.mu.RUnlock()
+
+		for _, handler := range handlers {
+			handler(event)
+		}
+	}
+}
+
+// close stops accepting events. Events already queued are still delivered.
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return
+	}
+	h.closed = true
+	close(h.queue)
+}
@@ -0,0 +1,162 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bOguzhan/NATbypass/internal/config"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+)
+
+// waitForEvent returns the next event from the channel or fails the test
+func waitForEvent(t *testing.T, events <-chan ConnectionEvent) ConnectionEvent {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for event")
+		return ConnectionEvent{}
+	}
+}
+
+func TestEventHubDoesNotBlock(t *testing.T) {
+	hub := newEventHub()
+	defer hub.close()
+
+	release := make(chan struct{})
+	hub.OnPacket(func(event ConnectionEvent) {
+		<-release
+	})
+
+	// A stuck subscriber must not block emitters, excess events are dropped
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < eventQueueSize+10; i++ {
+			hub.emit(ConnectionEvent{Type: EventPacket})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("emit blocked on a slow subscriber")
+	}
+	close(release)
+
+	assert.True(t, hub.DroppedEvents() > 0, "Overflowing events should be counted as dropped")
+
+	// Events without subscribers are neither queued nor counted
+	dropped := hub.DroppedEvents()
+	hub.emit(ConnectionEvent{Type: EventConnect})
+	assert.Equal(t, dropped, hub.DroppedEvents())
+}
+
+func TestUDPServerEvents(t *testing.T) {
+	server, err := NewUDPServer("127.0.0.1:12360")
+	assert.NoError(t, err)
+
+	events := make(chan ConnectionEvent, 10)
+	forward := func(event ConnectionEvent) { events <- event }
+	server.OnConnect(forward)
+	server.OnRegister(forward)
+	server.OnPacket(forward)
+	server.OnClose(forward)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, server.Start(ctx))
+
+	client, err := net.DialUDP("udp", nil, server.conn.LocalAddr().(*net.UDPAddr))
+	assert.NoError(t, err)
+	defer client.Close()
+
+	data, err := (&protocol.Packet{
+		Type:    protocol.PacketTypeRegistration,
+		Payload: []byte("udp-events"),
+	}).Serialize()
+	assert.NoError(t, err)
+	_, err = client.Write(data)
+	assert.NoError(t, err)
+
+	// The packet is announced before the registration it carries is processed
+	packetEvent := waitForEvent(t, events)
+	assert.Equal(t, EventPacket, packetEvent.Type)
+	assert.Equal(t, protocol.PacketTypeRegistration, packetEvent.Packet.Type)
+
+	connectEvent := waitForEvent(t, events)
+	assert.Equal(t, EventConnect, connectEvent.Type)
+	assert.Equal(t, "udp", connectEvent.Protocol)
+	assert.Equal(t, client.LocalAddr().String(), connectEvent.ConnectionID)
+
+	registerEvent := waitForEvent(t, events)
+	assert.Equal(t, EventRegister, registerEvent.Type)
+	assert.Equal(t, "udp-events", registerEvent.ClientID)
+	assert.Equal(t, client.LocalAddr().String(), registerEvent.RemoteAddr.String())
+
+	assert.NoError(t, server.Stop())
+
+	closeEvent := waitForEvent(t, events)
+	assert.Equal(t, EventClose, closeEvent.Type)
+	assert.Equal(t, "udp-events", closeEvent.ClientID)
+}
+
+func TestTCPServerEvents(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	server := NewTCPServer(&config.TCPServerConfig{
+		ListenHost:     "127.0.0.1",
+		ListenPort:     8770,
+		IdleTimeout:    200 * time.Millisecond,
+		MaxConnections: 10,
+	}, logger)
+
+	events := make(chan ConnectionEvent, 10)
+	forward := func(event ConnectionEvent) { events <- event }
+	server.OnConnect(forward)
+	server.OnRegister(forward)
+	server.OnIdleTimeout(forward)
+	server.OnClose(forward)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, server.Start(ctx))
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", "127.0.0.1:8770")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	connectEvent := waitForEvent(t, events)
+	assert.Equal(t, EventConnect, connectEvent.Type)
+	assert.Equal(t, "tcp", connectEvent.Protocol)
+	assert.NotEmpty(t, connectEvent.ConnectionID)
+	assert.Equal(t, conn.LocalAddr().String(), connectEvent.RemoteAddr.String())
+
+	assert.NoError(t, protocol.WriteFrame(conn, &protocol.Packet{
+		Type:    protocol.PacketTypeRegistration,
+		Payload: []byte("tcp-events"),
+	}))
+
+	registerEvent := waitForEvent(t, events)
+	assert.Equal(t, EventRegister, registerEvent.Type)
+	assert.Equal(t, "tcp-events", registerEvent.ClientID)
+	assert.Equal(t, connectEvent.ConnectionID, registerEvent.ConnectionID)
+
+	// Stay silent until the idle timeout closes the connection
+	idleEvent := waitForEvent(t, events)
+	assert.Equal(t, EventIdleTimeout, idleEvent.Type)
+	assert.Equal(t, "tcp-events", idleEvent.ClientID)
+
+	closeEvent := waitForEvent(t, events)
+	assert.Equal(t, EventClose, closeEvent.Type)
+	assert.Equal(t, connectEvent.ConnectionID, closeEvent.ConnectionID)
+}
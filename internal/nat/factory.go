@@ -1,12 +1,23 @@
 package nat
 
 import (
+	"context"
 	"errors"
+	"net"
 	"sort"
+	"time"
 
+	"github.com/bOguzhan/NATbypass/internal/config"
 	"github.com/bOguzhan/NATbypass/internal/discovery"
+	"github.com/bOguzhan/NATbypass/pkg/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracer records traversal attempts made by this package
+var tracer = otel.Tracer("github.com/bOguzhan/NATbypass/internal/nat")
+
 // StrategyType defines the type of NAT traversal strategy
 
 const (
@@ -24,20 +35,48 @@ var (
 
 // StrategyFactory creates and manages NAT traversal strategies
 type StrategyFactory struct {
-	strategies map[StrategyType]TraversalStrategy
+	strategies        map[StrategyType]TraversalStrategy
+	priorities        map[StrategyType]int // Configured priority overrides
+	preferredProtocol string               // Used when SelectStrategy is given none
+	timeout           time.Duration        // Limit for EstablishConnection, 0 for none
 }
 
-// NewStrategyFactory creates a new strategy factory with all available strategies
-func NewStrategyFactory() *StrategyFactory {
+// NewStrategyFactory creates a strategy factory with the strategies enabled
+// in cfg, configured from its traversal and hole punching sections. A nil
+// cfg enables every strategy with the default settings.
+func NewStrategyFactory(cfg *config.Config) *StrategyFactory {
+	if cfg == nil {
+		cfg = config.DefaultConfig()
+	}
+
 	factory := &StrategyFactory{
-		strategies: make(map[StrategyType]TraversalStrategy),
+		strategies:        make(map[StrategyType]TraversalStrategy),
+		priorities:        make(map[StrategyType]int),
+		preferredProtocol: cfg.Traversal.PreferredProtocol,
+		timeout:           cfg.Traversal.Timeout,
 	}
 
-	// Register all available strategies
-	factory.registerStrategy(UDPHolePunching, newUDPHolePunchingStrategy())
-	factory.registerStrategy(TCPSimultaneousOpen, newTCPSimultaneousOpenStrategy())
-	factory.registerStrategy(UDPRelaying, newUDPRelayingStrategy())
-	factory.registerStrategy(TCPRelaying, newTCPRelayingStrategy())
+	// Register the enabled strategies
+	enabled := cfg.Traversal.Strategies
+	for _, entry := range []struct {
+		strategyType StrategyType
+		config       config.StrategyConfig
+		strategy     TraversalStrategy
+	}{
+		{UDPHolePunching, enabled.UDPHolePunching, newUDPHolePunchingStrategy(&cfg.HolePunch)},
+		{TCPSimultaneousOpen, enabled.TCPSimultaneousOpen, newTCPSimultaneousOpenStrategy(cfg)},
+		{UDPRelaying, enabled.UDPRelaying, newUDPRelayingStrategy(&cfg.Traversal)},
+		{TCPRelaying, enabled.TCPRelaying, newTCPRelayingStrategy(&cfg.Traversal)},
+		{PortMapping, enabled.PortMapping, newPortMappingStrategy()},
+	} {
+		if !entry.config.Enabled {
+			continue
+		}
+		factory.registerStrategy(entry.strategyType, entry.strategy)
+		if entry.config.Priority > 0 {
+			factory.priorities[entry.strategyType] = entry.config.Priority
+		}
+	}
 
 	return factory
 }
@@ -47,6 +86,12 @@ func (f *StrategyFactory) registerStrategy(strategyType StrategyType, strategy T
 	f.strategies[strategyType] = strategy
 }
 
+// Priority returns the configured priority of a strategy type, if one was set
+func (f *StrategyFactory) Priority(strategyType StrategyType) (int, bool) {
+	priority, ok := f.priorities[strategyType]
+	return priority, ok
+}
+
 // GetStrategyByType retrieves a strategy by its type
 func (f *StrategyFactory) GetStrategyByType(strategyType StrategyType) (TraversalStrategy, error) {
 	strategy, exists := f.strategies[strategyType]
@@ -65,15 +110,25 @@ func (f *StrategyFactory) GetAvailableStrategies() []TraversalStrategy {
 	return strategies
 }
 
-// SelectStrategy chooses the optimal traversal strategy based on NAT types and preferences
+// SelectStrategy chooses the optimal traversal strategy based on NAT types and preferences.
+// Strategies with a configured priority rank above those without, and an
+// empty preferredProtocol falls back to the configured preference.
 func (f *StrategyFactory) SelectStrategy(localNATType, remoteNATType discovery.NATType, preferredProtocol string) TraversalStrategy {
+	if preferredProtocol == "" {
+		preferredProtocol = f.preferredProtocol
+	}
+	return f.selectStrategy(localNATType, remoteNATType, preferredProtocol)
+}
+
+func (f *StrategyFactory) selectStrategy(localNATType, remoteNATType discovery.NATType, preferredProtocol string) TraversalStrategy {
 	candidates := make([]struct {
 		strategy    TraversalStrategy
+		priority    int
 		successRate float64
 	}, 0, len(f.strategies))
 
 	// Evaluate each strategy's success rate for the given NAT types
-	for _, strategy := range f.strategies {
+	for strategyType, strategy := range f.strategies {
 		// If preferred protocol is specified, filter by protocol
 		if preferredProtocol != "" && strategy.GetProtocol() != preferredProtocol {
 			continue
@@ -82,12 +137,16 @@ func (f *StrategyFactory) SelectStrategy(localNATType, remoteNATType discovery.N
 		successRate := strategy.EstimateSuccessRate(localNATType, remoteNATType)
 		candidates = append(candidates, struct {
 			strategy    TraversalStrategy
+			priority    int
 			successRate float64
-		}{strategy, successRate})
+		}{strategy, f.priorities[strategyType], successRate})
 	}
 
-	// Sort by success rate (highest first)
+	// Sort by priority, then success rate (highest first)
 	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].priority != candidates[j].priority {
+			return candidates[i].priority > candidates[j].priority
+		}
 		return candidates[i].successRate > candidates[j].successRate
 	})
 
@@ -98,9 +157,52 @@ func (f *StrategyFactory) SelectStrategy(localNATType, remoteNATType discovery.N
 
 	// If no preferred protocol match was found, return the best strategy overall
 	if preferredProtocol != "" {
-		return f.SelectStrategy(localNATType, remoteNATType, "")
+		return f.selectStrategy(localNATType, remoteNATType, "")
 	}
 
 	// Should never happen if strategies are properly registered
 	return nil
 }
+
+// EstablishConnection connects to a peer that may be reachable on several
+// endpoints, such as a global IPv6 address alongside a NAT-mapped IPv4 one.
+// Direct IPv6 endpoints are tried first and the rest are raced Happy Eyeballs
+// style; the first attempt the peer answers wins. Each attempt binds its own
+// socket, marked to share its port, so attempts racing from a fixed local
+// port don't fail to bind. The strategy and each endpoint attempt are traced
+// as spans under ctx. Attempts are abandoned once the configured traversal
+// timeout passes.
+func (f *StrategyFactory) EstablishConnection(
+	ctx context.Context,
+	strategy TraversalStrategy,
+	localAddr *net.UDPAddr,
+	candidates []*net.UDPAddr,
+) (net.Conn, error) {
+	if f.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, f.timeout)
+		defer cancel()
+	}
+
+	ctx, span := tracer.Start(ctx, "nat.strategy", trace.WithAttributes(
+		attribute.String("natbypass.strategy", strategy.GetName()),
+		attribute.String("natbypass.protocol", strategy.GetProtocol()),
+		attribute.Int("natbypass.candidates", len(candidates)),
+	))
+
+	conn, addr, err := raceConnect(ctx, SortCandidates(candidates), happyEyeballsDelay,
+		func(ctx context.Context, remoteAddr *net.UDPAddr) (net.Conn, error) {
+			ctx, attempt := tracer.Start(ctx, "nat.attempt", trace.WithAttributes(
+				attribute.String("natbypass.remote_addr", remoteAddr.String()),
+			))
+			conn, err := strategy.EstablishConnection(ctx, localAddrFor(localAddr, remoteAddr), remoteAddr)
+			tracing.End(attempt, err)
+			return conn, err
+		})
+	if err == nil {
+		span.SetAttributes(attribute.String("natbypass.remote_addr", addr.String()))
+	}
+	tracing.End(span, err)
+
+	return conn, err
+}
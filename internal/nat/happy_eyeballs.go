@@ -0,0 +1,177 @@
+package nat
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// happyEyeballsDelay is how long a connection attempt runs before the next
+// candidate is tried in parallel, as recommended by RFC 8305
+const happyEyeballsDelay = 250 * time.Millisecond
+
+// ErrNoCandidates is returned when there is no endpoint to connect to
+var ErrNoCandidates = errors.New("no candidate endpoints")
+
+// dialFunc attempts a connection to a single candidate endpoint
+type dialFunc func(ctx context.Context, remoteAddr *net.UDPAddr) (net.Conn, error)
+
+// IsDirectIPv6 reports whether an address is a global IPv6 address, which
+// peers can usually reach without any NAT traversal
+func IsDirectIPv6(addr *net.UDPAddr) bool {
+	return ClassifyEndpoint(addr) == EndpointIPv6
+}
+
+// SortCandidates orders remote endpoints for connection attempts. Global IPv6
+// addresses come first since they need no NAT traversal; the remaining
+// endpoints keep their order but alternate between address families, so a
+// broken family doesn't hold up the other.
+func SortCandidates(candidates []*net.UDPAddr) []*net.UDPAddr {
+	var direct, first, second []*net.UDPAddr
+	var firstIsIPv4 bool
+
+	for _, candidate := range candidates {
+		if IsDirectIPv6(candidate) {
+			direct = append(direct, candidate)
+			continue
+		}
+
+		isIPv4 := candidate.IP.To4() != nil
+		if len(first) == 0 && len(second) == 0 {
+			firstIsIPv4 = isIPv4
+		}
+		if isIPv4 == firstIsIPv4 {
+			first = append(first, candidate)
+		} else {
+			second = append(second, candidate)
+		}
+	}
+
+	sorted := make([]*net.UDPAddr, 0, len(candidates))
+	sorted = append(sorted, direct...)
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			sorted = append(sorted, first[i])
+		}
+		if i < len(second) {
+			sorted = append(sorted, second[i])
+		}
+	}
+
+	return sorted
+}
+
+// raceConnect tries candidates in order, starting the next attempt when the
+// previous one fails or has run for delay, whichever comes first (Happy
+// Eyeballs, RFC 8305). The first connection established wins; the remaining
+// attempts are cancelled and any late connections closed.
+func raceConnect(ctx context.Context, candidates []*net.UDPAddr, delay time.Duration, dial dialFunc) (net.Conn, *net.UDPAddr, error) {
+	if len(candidates) == 0 {
+		return nil, nil, ErrNoCandidates
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type attempt struct {
+		conn net.Conn
+		addr *net.UDPAddr
+		err  error
+	}
+	results := make(chan attempt, len(candidates))
+
+	next, pending := 0, 0
+	start := func() {
+		remoteAddr := candidates[next]
+		next++
+		pending++
+		go func() {
+			conn, err := dial(ctx, remoteAddr)
+			results <- attempt{conn: conn, addr: remoteAddr, err: err}
+		}()
+	}
+
+	// closeLate closes connections from attempts that finish after we return
+	closeLate := func(count int) {
+		go func() {
+			for i := 0; i < count; i++ {
+				if result := <-results; result.conn != nil {
+					result.conn.Close()
+				}
+			}
+		}()
+	}
+
+	start()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var lastErr error
+	for pending > 0 {
+		select {
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				closeLate(pending)
+				return result.conn, result.addr, nil
+			}
+			lastErr = result.err
+
+			// A failed attempt starts the next one without waiting
+			if next < len(candidates) {
+				start()
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+				timer.Reset(delay)
+			}
+		case <-timer.C:
+			if next < len(candidates) {
+				start()
+				timer.Reset(delay)
+			}
+		case <-ctx.Done():
+			closeLate(pending)
+			return nil, nil, ctx.Err()
+		}
+	}
+
+	return nil, nil, lastErr
+}
+
+// dialUDP binds a UDP socket for a connection attempt and connects it to the
+// peer. Attempts raced from a fixed local port bind it concurrently, so the
+// socket is marked to share its port. Being connected, each socket receives
+// only its own peer's datagrams instead of the kernel spreading them across
+// every socket sharing the port.
+func dialUDP(ctx context.Context, localAddr, remoteAddr *net.UDPAddr) (*net.UDPConn, error) {
+	dialer := net.Dialer{Control: reusePort}
+	if localAddr != nil {
+		dialer.LocalAddr = localAddr
+	}
+
+	conn, err := dialer.DialContext(ctx, "udp", remoteAddr.String())
+	if err != nil {
+		return nil, err
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+// localAddrFor adapts a local address to the remote address family. A local
+// address of the other family is widened to the wildcard address on the same
+// port so the attempt can still bind.
+func localAddrFor(localAddr, remoteAddr *net.UDPAddr) *net.UDPAddr {
+	if localAddr == nil || localAddr.IP == nil || localAddr.IP.IsUnspecified() {
+		return localAddr
+	}
+
+	if (localAddr.IP.To4() != nil) == (remoteAddr.IP.To4() != nil) {
+		return localAddr
+	}
+
+	return &net.UDPAddr{Port: localAddr.Port}
+}
@@ -0,0 +1,286 @@
+package nat
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bOguzhan/NATbypass/internal/config"
+	"github.com/bOguzhan/NATbypass/internal/discovery"
+	"github.com/bOguzhan/NATbypass/pkg/portmap"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+)
+
+// requireIPv6Loopback skips tests on hosts without IPv6
+func requireIPv6Loopback(t *testing.T) {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Skipf("IPv6 not available: %v", err)
+	}
+	conn.Close()
+}
+
+func mustUDPAddr(t *testing.T, addr string) *net.UDPAddr {
+	t.Helper()
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	assert.NoError(t, err)
+	return udpAddr
+}
+
+// startPunchPeer acknowledges hole punches sent to addr, like a peer
+// punching back
+func startPunchPeer(t *testing.T, addr string) {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp4", mustUDPAddr(t, addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	ack, _ := (&protocol.Packet{Type: protocol.PacketTypeHolePunchAck}).Serialize()
+	go func() {
+		buffer := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			if packet, err := protocol.ParsePacket(buffer[:n]); err == nil && packet.Type == protocol.PacketTypeHolePunch {
+				conn.WriteToUDP(ack, from)
+			}
+		}
+	}()
+}
+
+func TestSortCandidates(t *testing.T) {
+	sorted := SortCandidates([]*net.UDPAddr{
+		mustUDPAddr(t, "203.0.113.7:4000"),
+		mustUDPAddr(t, "198.51.100.1:4000"),
+		mustUDPAddr(t, "[fd00::1]:4000"),
+		mustUDPAddr(t, "[2001:db8::1]:4000"),
+	})
+
+	addrs := make([]string, len(sorted))
+	for i, addr := range sorted {
+		addrs[i] = addr.String()
+	}
+
+	// Direct IPv6 first, then families alternate starting with the first seen
+	assert.Equal(t, []string{
+		"[2001:db8::1]:4000",
+		"203.0.113.7:4000",
+		"[fd00::1]:4000",
+		"198.51.100.1:4000",
+	}, addrs)
+}
+
+func TestRaceConnect(t *testing.T) {
+	candidates := []*net.UDPAddr{
+		mustUDPAddr(t, "[2001:db8::1]:4000"),
+		mustUDPAddr(t, "203.0.113.7:4000"),
+	}
+
+	t.Run("SlowCandidateIsOvertaken", func(t *testing.T) {
+		start := time.Now()
+		conn, addr, err := raceConnect(context.Background(), candidates, 50*time.Millisecond,
+			func(ctx context.Context, remoteAddr *net.UDPAddr) (net.Conn, error) {
+				if remoteAddr.IP.To4() == nil {
+					<-ctx.Done()
+					return nil, ctx.Err()
+				}
+				client, server := net.Pipe()
+				server.Close()
+				return client, nil
+			})
+
+		assert.NoError(t, err)
+		assert.NotNil(t, conn)
+		assert.Equal(t, "203.0.113.7:4000", addr.String())
+		assert.True(t, time.Since(start) >= 50*time.Millisecond, "The second attempt should wait for the delay")
+	})
+
+	t.Run("FailureStartsNextImmediately", func(t *testing.T) {
+		start := time.Now()
+		_, addr, err := raceConnect(context.Background(), candidates, time.Second,
+			func(ctx context.Context, remoteAddr *net.UDPAddr) (net.Conn, error) {
+				if remoteAddr.IP.To4() == nil {
+					return nil, errors.New("unreachable")
+				}
+				client, server := net.Pipe()
+				server.Close()
+				return client, nil
+			})
+
+		assert.NoError(t, err)
+		assert.Equal(t, "203.0.113.7:4000", addr.String())
+		assert.True(t, time.Since(start) < time.Second, "A failed attempt should not wait for the delay")
+	})
+
+	t.Run("AllFail", func(t *testing.T) {
+		_, _, err := raceConnect(context.Background(), candidates, time.Millisecond,
+			func(ctx context.Context, remoteAddr *net.UDPAddr) (net.Conn, error) {
+				return nil, errors.New("unreachable")
+			})
+		assert.EqualError(t, err, "unreachable")
+	})
+
+	t.Run("NoCandidates", func(t *testing.T) {
+		_, _, err := raceConnect(context.Background(), nil, time.Millisecond, nil)
+		assert.Equal(t, ErrNoCandidates, err)
+	})
+}
+
+// TestRaceFromFixedPort races attempts that all bind the same local port, as
+// connections from a port known to the peer do
+func TestRaceFromFixedPort(t *testing.T) {
+	localAddr := mustUDPAddr(t, "127.0.0.1:12371")
+
+	t.Run("UDP", func(t *testing.T) {
+		// The mapping attempt holds the port while the gateway stays silent
+		gateway, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer gateway.Close()
+		mapping := NewPortMappingStrategy(portmap.NewMapper(portmap.NewPCPClient(gateway.LocalAddr().(*net.UDPAddr))))
+		holePunching := newUDPHolePunchingStrategy(&config.HolePunchConfig{})
+		startPunchPeer(t, "127.0.0.1:12373")
+		startPunchPeer(t, "127.0.0.1:12389")
+
+		// Punching a peer that never answers doesn't win just by starting first
+		candidates := []*net.UDPAddr{
+			mustUDPAddr(t, "127.0.0.1:12372"),
+			mustUDPAddr(t, "127.0.0.1:12388"),
+			mustUDPAddr(t, "127.0.0.1:12373"),
+		}
+		conn, addr, err := raceConnect(context.Background(), candidates, 50*time.Millisecond,
+			func(ctx context.Context, remoteAddr *net.UDPAddr) (net.Conn, error) {
+				if remoteAddr == candidates[0] {
+					return mapping.EstablishConnection(ctx, localAddr, remoteAddr)
+				}
+				return holePunching.EstablishConnection(ctx, localAddr, remoteAddr)
+			})
+		if !assert.NoError(t, err) {
+			return
+		}
+		assert.Equal(t, candidates[2], addr)
+		assert.Equal(t, localAddr.Port, conn.LocalAddr().(*net.UDPAddr).Port)
+		conn.Close()
+
+		// Sockets sharing the port each get their own peer's acknowledgments
+		var wg sync.WaitGroup
+		for _, peer := range []string{"127.0.0.1:12373", "127.0.0.1:12389"} {
+			wg.Add(1)
+			go func(peer string) {
+				defer wg.Done()
+				conn, err := holePunching.EstablishConnection(context.Background(), localAddr, mustUDPAddr(t, peer))
+				if assert.NoError(t, err, peer) {
+					assert.Equal(t, peer, conn.RemoteAddr().String())
+					conn.Close()
+				}
+			}(peer)
+		}
+		wg.Wait()
+	})
+
+	t.Run("TCP", func(t *testing.T) {
+		peer, err := net.Listen("tcp4", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer peer.Close()
+		go func() {
+			for {
+				conn, err := peer.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+			}
+		}()
+
+		// The strategy listens on the port it dials from
+		strategy := newTCPSimultaneousOpenStrategy(&config.Config{})
+		peerAddr := peer.Addr().(*net.TCPAddr)
+		candidates := []*net.UDPAddr{
+			{IP: peerAddr.IP, Port: peerAddr.Port},
+		}
+		conn, _, err := raceConnect(context.Background(), candidates, 50*time.Millisecond,
+			func(ctx context.Context, remoteAddr *net.UDPAddr) (net.Conn, error) {
+				return strategy.EstablishConnection(ctx, localAddr, remoteAddr)
+			})
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer conn.Close()
+		assert.Equal(t, localAddr.Port, conn.LocalAddr().(*net.TCPAddr).Port)
+	})
+}
+
+func TestNoNATSuccessRates(t *testing.T) {
+	factory := NewStrategyFactory(nil)
+
+	// Without NAT on either side direct connections beat relaying
+	strategy := factory.SelectStrategy(discovery.NATNone, discovery.NATNone, "")
+	assert.Equal(t, "UDP Hole Punching", strategy.GetName())
+
+	tcpStrategy, _ := factory.GetStrategyByType(TCPSimultaneousOpen)
+	assert.Greater(t, tcpStrategy.EstimateSuccessRate(discovery.NATNone, discovery.NATNone), 0.95)
+}
+
+func TestServersListenOnIPv6(t *testing.T) {
+	requireIPv6Loopback(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	udpServer, err := NewUDPServer("[::1]:12364")
+	assert.NoError(t, err)
+	assert.NoError(t, udpServer.Start(ctx))
+	defer udpServer.Stop()
+
+	client, err := net.DialUDP("udp6", nil, mustUDPAddr(t, "[::1]:12364"))
+	assert.NoError(t, err)
+	defer client.Close()
+
+	data, err := (&protocol.Packet{Type: protocol.PacketTypeRegistration, Payload: []byte("v6-client")}).Serialize()
+	assert.NoError(t, err)
+	_, err = client.Write(data)
+	assert.NoError(t, err)
+
+	buffer := make([]byte, 1024)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := client.Read(buffer)
+	assert.NoError(t, err)
+	response, err := protocol.ParsePacket(buffer[:n])
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.PacketTypeRegistrationAck, response.Type)
+	assert.Len(t, udpServer.GetClientEndpoints("v6-client"), 1)
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+	tcpServer := NewTCPServer(&config.TCPServerConfig{ListenHost: "::1", ListenPort: 8771, MaxConnections: 10}, logger)
+	assert.NoError(t, tcpServer.Start(ctx))
+	defer tcpServer.Stop()
+
+	conn, err := net.Dial("tcp6", "[::1]:8771")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	assert.NoError(t, protocol.WriteFrame(conn, &protocol.Packet{Type: protocol.PacketTypeRegistration, Payload: []byte("v6-client")}))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	ack, err := protocol.ReadFrame(conn, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.PacketTypeRegistrationAck, ack.Type)
+	assert.Equal(t, conn.LocalAddr().String(), string(ack.Payload))
+}
@@ -0,0 +1,112 @@
+package nat
+
+import (
+	"github.com/bOguzhan/NATbypass/internal/metrics"
+)
+
+// RegisterTCPServerMetrics exports a TCP server's counters. Values are read
+// from GetStats on every scrape, so rates come from Prometheus' rate().
+func RegisterTCPServerMetrics(registry *metrics.Registry, server *TCPServer) error {
+	if err := registry.NewGaugeFunc("natbypass_tcp_connections_active",
+		"TCP connections currently served", func() float64 {
+			return float64(server.GetStats().Active)
+		}); err != nil {
+		return err
+	}
+	if err := registry.NewGaugeFunc("natbypass_tcp_connections_queued",
+		"TCP connections waiting for a free slot", func() float64 {
+			return float64(server.GetStats().Queued)
+		}); err != nil {
+		return err
+	}
+	if err := registry.NewCounterFunc("natbypass_tcp_connections_accepted_total",
+		"TCP connections admitted", func() float64 {
+			return float64(server.GetStats().Accepted)
+		}); err != nil {
+		return err
+	}
+
+	if err := registry.NewFunc("natbypass_tcp_connections_rejected_total",
+		"TCP connections rejected by admission control, by reason",
+		metrics.KindCounter, []string{"reason"}, func(observe func(float64, ...string)) {
+			stats := server.GetStats()
+			observe(float64(stats.RejectedCapacity), rejectCapacity)
+			observe(float64(stats.RejectedPerIP), rejectPerIP)
+			observe(float64(stats.RejectedQueueFull), rejectQueueFull)
+			observe(float64(stats.RejectedQueueTimeout), rejectQueueTimeout)
+		}); err != nil {
+		return err
+	}
+
+	if err := registry.NewFunc("natbypass_tcp_connections_closed_total",
+		"TCP connections closed by the server, by reason",
+		metrics.KindCounter, []string{"reason"}, func(observe func(float64, ...string)) {
+			stats := server.GetStats()
+			observe(float64(stats.ClosedIdle), "idle")
+			observe(float64(stats.ClosedMaxAge), "max_age")
+			observe(float64(stats.ClosedUnregistered), "unregistered")
+		}); err != nil {
+		return err
+	}
+
+	return registry.NewFunc("natbypass_tcp_packets_total",
+		"Packets handled by the TCP server, by direction",
+		metrics.KindCounter, []string{"direction"}, func(observe func(float64, ...string)) {
+			stats := server.GetStats()
+			observe(float64(stats.PacketsReceived), "received")
+			observe(float64(stats.PacketsSent), "sent")
+		})
+}
+
+// RegisterUDPServerMetrics exports a UDP server's counters
+func RegisterUDPServerMetrics(registry *metrics.Registry, server *UDPServer) error {
+	if err := registry.NewGaugeFunc("natbypass_udp_connections",
+		"UDP peers currently registered", func() float64 {
+			return float64(server.GetConnectionCount())
+		}); err != nil {
+		return err
+	}
+	if err := registry.NewGaugeFunc("natbypass_udp_queue_depth",
+		"UDP packets waiting for a worker", func() float64 {
+			return float64(server.GetStats().Queued)
+		}); err != nil {
+		return err
+	}
+
+	return registry.NewFunc("natbypass_udp_packets_total",
+		"Packets handled by the UDP server, by outcome",
+		metrics.KindCounter, []string{"outcome"}, func(observe func(float64, ...string)) {
+			stats := server.GetStats()
+			observe(float64(stats.Received), "received")
+			observe(float64(stats.Processed), "processed")
+			observe(float64(stats.ParseErrors), "parse_error")
+			observe(float64(stats.DroppedNewest), "dropped_newest")
+			observe(float64(stats.DroppedOldest), "dropped_oldest")
+			observe(float64(stats.Sent), "sent")
+			observe(float64(stats.SendDropped), "send_dropped")
+		})
+}
+
+// RegisterRelayServerMetrics exports a relay server's counters
+func RegisterRelayServerMetrics(registry *metrics.Registry, server *RelayServer) error {
+	if err := registry.NewGaugeFunc("natbypass_relay_sessions",
+		"Relay sessions, paired or waiting for a peer", func() float64 {
+			return float64(server.GetStats().Sessions)
+		}); err != nil {
+		return err
+	}
+
+	if err := registry.NewFunc("natbypass_relay_packets_total",
+		"Data packets handled by the relay, by outcome",
+		metrics.KindCounter, []string{"outcome"}, func(observe func(float64, ...string)) {
+			stats := server.GetStats()
+			observe(float64(stats.Forwarded), "forwarded")
+			observe(float64(stats.Dropped), "dropped")
+		}); err != nil {
+		return err
+	}
+	return registry.NewCounterFunc("natbypass_relay_binds_rejected_total",
+		"Relay bind requests refused", func() float64 {
+			return float64(server.GetStats().Rejected)
+		})
+}
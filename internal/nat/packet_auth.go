@@ -0,0 +1,155 @@
+package nat
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+)
+
+const (
+	// replayWindowSize is the number of sequence numbers tracked behind the highest seen
+	replayWindowSize = 64
+
+	// defaultKeyTTL bounds how long an issued packet key is accepted
+	defaultKeyTTL = 24 * time.Hour
+
+	// keyClockSkew tolerates key IDs issued slightly in the future
+	keyClockSkew = 5 * time.Minute
+)
+
+// Errors returned when a rendezvous packet fails authentication
+var (
+	ErrReplayedPacket = errors.New("replayed or too old sequence number")
+	ErrExpiredKey     = errors.New("packet key expired or not yet valid")
+	ErrSupersededKey  = errors.New("packet key superseded by a newer registration")
+)
+
+// PacketAuthenticator verifies rendezvous packets signed with per-client keys.
+// Keys are derived from a master secret shared with the signaling server, which
+// issues them at registration using the issue time as key ID.
+type PacketAuthenticator struct {
+	masterKey []byte
+	keyTTL    time.Duration
+	windows   map[string]*replayWindow
+	mutex     sync.Mutex
+	sendSeq   uint64
+	now       func() time.Time
+}
+
+// replayWindow tracks recently seen sequence numbers for a client key
+type replayWindow struct {
+	keyID   uint32
+	highest uint64
+	bitmap  uint64 // Bit i set means highest-i has been seen
+	seen    bool
+}
+
+// NewPacketAuthenticator creates an authenticator for the given master secret
+func NewPacketAuthenticator(masterKey []byte, keyTTL time.Duration) *PacketAuthenticator {
+	if keyTTL <= 0 {
+		keyTTL = defaultKeyTTL
+	}
+
+	return &PacketAuthenticator{
+		masterKey: masterKey,
+		keyTTL:    keyTTL,
+		windows:   make(map[string]*replayWindow),
+		now:       time.Now,
+	}
+}
+
+// Authenticate verifies the packet's MAC and rejects replayed sequence numbers
+func (a *PacketAuthenticator) Authenticate(packet *protocol.Packet) error {
+	if packet.Auth == nil {
+		return protocol.ErrNotAuthenticated
+	}
+
+	issued := time.Unix(int64(packet.Auth.KeyID), 0)
+	now := a.now()
+	if now.Sub(issued) > a.keyTTL || issued.Sub(now) > keyClockSkew {
+		return ErrExpiredKey
+	}
+
+	key := protocol.DeriveClientKey(a.masterKey, packet.Auth.ClientID, packet.Auth.KeyID)
+	if err := packet.Verify(key); err != nil {
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	window, exists := a.windows[packet.Auth.ClientID]
+	if !exists || packet.Auth.KeyID > window.keyID {
+		// A newer key resets the window, older keys are no longer accepted
+		window = &replayWindow{keyID: packet.Auth.KeyID}
+		a.windows[packet.Auth.ClientID] = window
+	} else if packet.Auth.KeyID < window.keyID {
+		return ErrSupersededKey
+	}
+
+	if !window.accept(packet.Auth.Sequence) {
+		return ErrReplayedPacket
+	}
+
+	return nil
+}
+
+// Sign signs an outgoing packet for a client with the key it registered with
+func (a *PacketAuthenticator) Sign(packet *protocol.Packet, clientID string, keyID uint32) error {
+	key := protocol.DeriveClientKey(a.masterKey, clientID, keyID)
+	return packet.Sign(clientID, keyID, atomic.AddUint64(&a.sendSeq, 1), key)
+}
+
+// PruneExpired drops replay state for keys that are past their TTL. Packets
+// signed with those keys are rejected as expired, so the state is no longer needed.
+func (a *PacketAuthenticator) PruneExpired() int {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	cutoff := a.now().Add(-a.keyTTL)
+	count := 0
+	for clientID, window := range a.windows {
+		if time.Unix(int64(window.keyID), 0).Before(cutoff) {
+			delete(a.windows, clientID)
+			count++
+		}
+	}
+	return count
+}
+
+// accept records a sequence number, returning false if it was already seen or
+// falls behind the window
+func (w *replayWindow) accept(seq uint64) bool {
+	if !w.seen {
+		w.seen = true
+		w.highest = seq
+		w.bitmap = 1
+		return true
+	}
+
+	if seq > w.highest {
+		shift := seq - w.highest
+		if shift >= replayWindowSize {
+			w.bitmap = 1
+		} else {
+			w.bitmap = w.bitmap<<shift | 1
+		}
+		w.highest = seq
+		return true
+	}
+
+	offset := w.highest - seq
+	if offset >= replayWindowSize {
+		return false
+	}
+
+	mask := uint64(1) << offset
+	if w.bitmap&mask != 0 {
+		return false
+	}
+	w.bitmap |= mask
+	return true
+}
@@ -0,0 +1,151 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"github.com/bOguzhan/NATbypass/internal/discovery"
+	"github.com/bOguzhan/NATbypass/pkg/portmap"
+)
+
+// Port mapping availability, learned from the first mapping attempt
+const (
+	portMappingUnknown = iota
+	portMappingAvailable
+	portMappingUnavailable
+)
+
+// PortMappingStrategy asks the local gateway to forward a port using PCP,
+// NAT-PMP or UPnP IGD. Once mapped, peers reach us on the gateway's public
+// address no matter how restrictive either NAT is, so no hole punching is
+// needed. The mapping is renewed in the background and deleted on close.
+type PortMappingStrategy struct {
+	mu     sync.Mutex
+	mapper *portmap.Mapper // Created lazily for the default gateway
+	state  int
+}
+
+// newPortMappingStrategy creates a port mapping strategy for the default gateway
+func newPortMappingStrategy() *PortMappingStrategy {
+	return &PortMappingStrategy{}
+}
+
+// NewPortMappingStrategy creates a port mapping strategy that uses the given
+// mapper, for gateways other than the default one
+func NewPortMappingStrategy(mapper *portmap.Mapper) *PortMappingStrategy {
+	return &PortMappingStrategy{mapper: mapper}
+}
+
+// GetProtocol returns the network protocol used by this strategy
+func (s *PortMappingStrategy) GetProtocol() string {
+	return "udp"
+}
+
+// GetName returns the descriptive name of this strategy
+func (s *PortMappingStrategy) GetName() string {
+	return "Port Mapping"
+}
+
+// EstimateSuccessRate returns estimated success rate. It depends on whether
+// our gateway grants mappings rather than on NAT types: until a mapping has
+// been tried the estimate stays below hole punching for friendly NATs.
+func (s *PortMappingStrategy) EstimateSuccessRate(localNATType, remoteNATType discovery.NATType) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch s.state {
+	case portMappingAvailable:
+		return 0.97
+	case portMappingUnavailable:
+		return 0.0
+	default:
+		return 0.50
+	}
+}
+
+// Lease maps a local UDP port on the gateway and keeps the mapping alive
+// until the lease is closed. The mapped address should be advertised to
+// peers as a candidate.
+func (s *PortMappingStrategy) Lease(ctx context.Context, localPort int) (*portmap.Lease, error) {
+	mapper, err := s.getMapper()
+	if err != nil {
+		s.setState(portMappingUnavailable)
+		return nil, err
+	}
+
+	lease, err := mapper.Lease(ctx, "udp", localPort, portmap.DefaultLifetime)
+	if err != nil {
+		s.setState(portMappingUnavailable)
+		return nil, err
+	}
+
+	s.setState(portMappingAvailable)
+	return lease, nil
+}
+
+// EstablishConnection binds the local address, maps its port on the gateway
+// and returns a connection to the peer. The peer should be told the mapped
+// address, available from the returned connection's MappedAddr.
+func (s *PortMappingStrategy) EstablishConnection(ctx context.Context, localAddr, remoteAddr *net.UDPAddr) (net.Conn, error) {
+	conn, err := dialUDP(ctx, localAddr, remoteAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	lease, err := s.Lease(ctx, conn.LocalAddr().(*net.UDPAddr).Port)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &MappedConn{UDPConn: conn, lease: lease, remoteAddr: remoteAddr}, nil
+}
+
+// getMapper returns the mapper, creating one for the default gateway
+func (s *PortMappingStrategy) getMapper() (*portmap.Mapper, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mapper == nil {
+		mapper, err := portmap.NewDefaultMapper()
+		if err != nil {
+			return nil, err
+		}
+		s.mapper = mapper
+	}
+	return s.mapper, nil
+}
+
+// setState records whether the gateway grants mappings
+func (s *PortMappingStrategy) setState(state int) {
+	s.mu.Lock()
+	s.state = state
+	s.mu.Unlock()
+}
+
+// MappedConn is a UDP connection to a peer on a port forwarded by the gateway
+type MappedConn struct {
+	*net.UDPConn
+	lease      *portmap.Lease
+	remoteAddr *net.UDPAddr
+}
+
+// MappedAddr returns the public address the gateway forwards to this connection
+func (c *MappedConn) MappedAddr() *net.UDPAddr {
+	return c.lease.Mapping().ExternalAddr()
+}
+
+// RemoteAddr returns the peer's address
+func (c *MappedConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// Close deletes the mapping and closes the socket
+func (c *MappedConn) Close() error {
+	leaseErr := c.lease.Close()
+	if err := c.UDPConn.Close(); err != nil {
+		return err
+	}
+	return leaseErr
+}
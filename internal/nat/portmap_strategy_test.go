@@ -0,0 +1,94 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/internal/discovery"
+	"github.com/bOguzhan/NATbypass/pkg/portmap"
+	"github.com/stretchr/testify/assert"
+)
+
+// startFakePCPGateway answers PCP MAP requests, forwarding every internal
+// port to the same port on 203.0.113.9
+func startFakePCPGateway(t *testing.T, port int) *net.UDPAddr {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		t.Fatalf("Failed to start fake gateway: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buffer := make([]byte, 1100)
+		for {
+			n, addr, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			if n < 60 {
+				continue
+			}
+
+			response := make([]byte, 60)
+			copy(response, buffer[:60])
+			response[1] = 0x81 // MAP response
+			response[3] = 0    // Success
+			copy(response[42:44], buffer[40:42])
+			copy(response[44:60], net.IPv4(203, 0, 113, 9).To16())
+			conn.WriteToUDP(response, addr)
+		}
+	}()
+
+	return conn.LocalAddr().(*net.UDPAddr)
+}
+
+func TestPortMappingStrategy(t *testing.T) {
+	gateway := startFakePCPGateway(t, 15360)
+	strategy := NewPortMappingStrategy(portmap.NewMapper(portmap.NewPCPClient(gateway)))
+
+	// Availability is unknown until a mapping has been tried
+	assert.InDelta(t, 0.50, strategy.EstimateSuccessRate(discovery.NATSymmetric, discovery.NATSymmetric), 0.01)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	localAddr := mustUDPAddr(t, "127.0.0.1:12365")
+	remoteAddr := mustUDPAddr(t, "127.0.0.1:12366")
+	conn, err := strategy.EstablishConnection(ctx, localAddr, remoteAddr)
+	assert.NoError(t, err)
+
+	mapped, ok := conn.(*MappedConn)
+	assert.True(t, ok)
+	assert.Equal(t, "203.0.113.9:12365", mapped.MappedAddr().String())
+	assert.Equal(t, remoteAddr, conn.RemoteAddr())
+	assert.InDelta(t, 0.97, strategy.EstimateSuccessRate(discovery.NATSymmetric, discovery.NATSymmetric), 0.01)
+
+	// Writes go to the peer
+	peer, err := net.ListenUDP("udp", remoteAddr)
+	assert.NoError(t, err)
+	defer peer.Close()
+
+	_, err = conn.Write([]byte("hello"))
+	assert.NoError(t, err)
+
+	buffer := make([]byte, 16)
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	n, from, err := peer.ReadFromUDP(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(buffer[:n]))
+	assert.Equal(t, 12365, from.Port)
+
+	assert.NoError(t, conn.Close())
+}
+
+func TestPortMappingStrategyUnavailable(t *testing.T) {
+	// Nothing listens on the gateway port
+	gateway := mustUDPAddr(t, "127.0.0.1:15361")
+	strategy := NewPortMappingStrategy(portmap.NewMapper(portmap.NewPCPClient(gateway)))
+
+	_, err := strategy.Lease(context.Background(), 12367)
+	assert.Error(t, err)
+	assert.Equal(t, 0.0, strategy.EstimateSuccessRate(discovery.NATFullCone, discovery.NATFullCone))
+}
@@ -0,0 +1,390 @@
+package nat
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/internal/config"
+	"github.com/bOguzhan/NATbypass/internal/logging"
+	"github.com/bOguzhan/NATbypass/internal/utils"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+	"github.com/bOguzhan/NATbypass/pkg/stats"
+)
+
+// Relay bind outcomes, sent as the payload of a RelayBindAck or an Error
+const (
+	relayWaiting     = "waiting"
+	relayPaired      = "paired"
+	relayFull        = "relay_full"
+	relaySessionFull = "session_full"
+	relayNoSession   = "session_id_required"
+
+	defaultRelayIdleTimeout = 2 * time.Minute
+)
+
+// RelayServer forwards traffic between pairs of peers that could not reach
+// each other directly. Both peers send a RelayBind packet carrying the same
+// session ID, agreed on through signaling; once the second one binds, Data
+// packets from either peer are passed to the other unchanged. Sessions are
+// dropped after the configured idle timeout.
+type RelayServer struct {
+	config   config.RelayConfig
+	conn     *net.UDPConn
+	sessions map[string]*relaySession // Keyed by session ID
+	peers    map[string]*relaySession // Keyed by peer address
+	mutex    sync.RWMutex
+	auth     *PacketAuthenticator
+	logger   logging.Logger
+	stopChan chan struct{}
+	stopOnce sync.Once
+	counters relayServerCounters
+}
+
+// relaySession is a pair of peers, or one peer waiting for the other
+type relaySession struct {
+	id         string
+	peers      []*net.UDPAddr
+	createdAt  time.Time
+	lastActive time.Time
+	stats      *stats.Counters // Traffic received from the peers and forwarded
+}
+
+// RelayServerStats reports relay activity
+type RelayServerStats struct {
+	Sessions  int    `json:"sessions"`
+	Forwarded uint64 `json:"forwarded"` // Data packets passed to the other peer
+	Dropped   uint64 `json:"dropped"`   // Data packets from unbound or unpaired peers
+	Rejected  uint64 `json:"rejected"`  // Bind requests refused
+}
+
+type relayServerCounters struct {
+	forwarded uint64
+	dropped   uint64
+	rejected  uint64
+}
+
+// NewRelayServer creates a relay server. A nil logger discards everything.
+func NewRelayServer(cfg *config.RelayConfig, logger logging.Logger) *RelayServer {
+	return &RelayServer{
+		config:   *cfg,
+		sessions: make(map[string]*relaySession),
+		peers:    make(map[string]*relaySession),
+		logger:   logging.OrDiscard(logger),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// SetAuthenticator requires bind requests to be authenticated. Data packets
+// are only accepted from bound peers. It must be called before Start.
+func (r *RelayServer) SetAuthenticator(auth *PacketAuthenticator) {
+	r.auth = auth
+}
+
+// Start binds the relay socket and starts forwarding
+func (r *RelayServer) Start(ctx context.Context) error {
+	addr := net.JoinHostPort(r.config.ListenHost, strconv.Itoa(r.config.ListenPort))
+	r.logger.WithField("listen_addr", addr).Info("Starting relay server")
+
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to resolve relay address: %w", err)
+	}
+	r.conn, err = net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return fmt.Errorf("failed to start relay server: %w", err)
+	}
+
+	go r.readLoop()
+	go r.cleanupLoop(ctx)
+
+	return nil
+}
+
+// Stop closes the relay socket and forgets every session
+func (r *RelayServer) Stop() error {
+	var err error
+	r.stopOnce.Do(func() {
+		close(r.stopChan)
+		if r.conn != nil {
+			err = r.conn.Close()
+		}
+
+		r.mutex.Lock()
+		r.sessions = make(map[string]*relaySession)
+		r.peers = make(map[string]*relaySession)
+		r.mutex.Unlock()
+
+		r.logger.Info("Relay server stopped")
+	})
+	return err
+}
+
+// Addr returns the address the relay listens on, nil before Start
+func (r *RelayServer) Addr() net.Addr {
+	if r.conn == nil {
+		return nil
+	}
+	return r.conn.LocalAddr()
+}
+
+// readLoop handles datagrams until the socket is closed
+func (r *RelayServer) readLoop() {
+	bufferSize := r.config.PacketBufferSize
+	if bufferSize <= 0 {
+		bufferSize = udpReadBufferSize
+	}
+	buffer := make([]byte, bufferSize)
+
+	for {
+		n, addr, err := r.conn.ReadFromUDP(buffer)
+		if err != nil {
+			if utils.IsClosedNetworkError(err) {
+				return
+			}
+			r.logger.WithError(err).Error("Error reading from relay socket")
+			continue
+		}
+
+		packet, err := protocol.ParsePacket(buffer[:n])
+		if err != nil {
+			r.logger.WithError(err).WithField("remote_addr", addr.String()).Debug("Error parsing packet")
+			continue
+		}
+
+		switch packet.Type {
+		case protocol.PacketTypeRelayBind:
+			r.handleBind(packet, addr)
+		case protocol.PacketTypeData:
+			r.forward(buffer[:n], addr)
+		case protocol.PacketTypeKeepAlive:
+			r.touch(addr)
+		default:
+			r.logger.WithFields(logging.Fields{
+				"remote_addr": addr.String(),
+				"packet_type": packet.Type,
+			}).Debug("Ignoring packet")
+		}
+	}
+}
+
+// handleBind adds a peer to a session, creating the session for the first
+// peer. Both peers are told once the session is paired.
+func (r *RelayServer) handleBind(packet *protocol.Packet, addr *net.UDPAddr) {
+	if r.auth != nil {
+		if err := r.auth.Authenticate(packet); err != nil {
+			r.logger.WithError(err).WithField("remote_addr", addr.String()).Warn("Rejected relay bind")
+			atomic.AddUint64(&r.counters.rejected, 1)
+			return
+		}
+	}
+
+	sessionID := string(packet.Payload)
+	logger := r.logger.WithFields(logging.Fields{
+		"session_id":  sessionID,
+		"remote_addr": addr.String(),
+	})
+	if sessionID == "" {
+		r.rejectBind(addr, relayNoSession)
+		return
+	}
+
+	r.mutex.Lock()
+	session, exists := r.sessions[sessionID]
+	switch {
+	case exists && session.hasPeer(addr):
+		// A retransmitted bind
+	case exists && len(session.peers) == 2:
+		r.mutex.Unlock()
+		logger.Warn("Relay session already has two peers")
+		r.rejectBind(addr, relaySessionFull)
+		return
+	case exists:
+		session.peers = append(session.peers, addr)
+		r.peers[addr.String()] = session
+	case r.config.MaxSessions > 0 && len(r.sessions) >= r.config.MaxSessions:
+		r.mutex.Unlock()
+		logger.Warn("Relay session limit reached")
+		r.rejectBind(addr, relayFull)
+		return
+	default:
+		session = &relaySession{
+			id:        sessionID,
+			peers:     []*net.UDPAddr{addr},
+			createdAt: time.Now(),
+			stats:     stats.NewCounters(),
+		}
+		r.sessions[sessionID] = session
+		r.peers[addr.String()] = session
+	}
+	session.lastActive = time.Now()
+	peers := append([]*net.UDPAddr(nil), session.peers...)
+	r.mutex.Unlock()
+
+	if len(peers) < 2 {
+		logger.Info("Relay peer waiting for its partner")
+		r.send(protocol.PacketTypeRelayBindAck, relayWaiting, addr)
+		return
+	}
+
+	logger.Info("Relay session paired")
+	for _, peer := range peers {
+		r.send(protocol.PacketTypeRelayBindAck, relayPaired, peer)
+	}
+}
+
+// forward passes a data packet to the sender's partner
+func (r *RelayServer) forward(data []byte, addr *net.UDPAddr) {
+	r.mutex.Lock()
+	session, exists := r.peers[addr.String()]
+	var target *net.UDPAddr
+	if exists {
+		session.lastActive = time.Now()
+		target = session.partnerOf(addr)
+	}
+	r.mutex.Unlock()
+
+	if target == nil {
+		atomic.AddUint64(&r.counters.dropped, 1)
+		return
+	}
+
+	session.stats.RecordReceived(len(data))
+	if _, err := r.conn.WriteToUDP(data, target); err != nil {
+		r.logger.WithError(err).WithField("session_id", session.id).Warn("Error forwarding relay packet")
+		return
+	}
+	session.stats.RecordSent(len(data))
+	atomic.AddUint64(&r.counters.forwarded, 1)
+}
+
+// touch keeps a peer's session alive
+func (r *RelayServer) touch(addr *net.UDPAddr) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if session, exists := r.peers[addr.String()]; exists {
+		session.lastActive = time.Now()
+	}
+}
+
+func (r *RelayServer) rejectBind(addr *net.UDPAddr, reason string) {
+	atomic.AddUint64(&r.counters.rejected, 1)
+	r.send(protocol.PacketTypeError, reason, addr)
+}
+
+func (r *RelayServer) send(packetType protocol.PacketType, payload string, addr *net.UDPAddr) {
+	data, err := (&protocol.Packet{Type: packetType, Payload: []byte(payload)}).Serialize()
+	if err != nil {
+		return
+	}
+	if _, err := r.conn.WriteToUDP(data, addr); err != nil {
+		r.logger.WithError(err).WithField("remote_addr", addr.String()).Debug("Error sending relay response")
+	}
+}
+
+// cleanupLoop drops idle sessions
+func (r *RelayServer) cleanupLoop(ctx context.Context) {
+	timeout := r.config.IdleTimeout
+	if timeout <= 0 {
+		timeout = defaultRelayIdleTimeout
+	}
+	interval := timeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.stopChan:
+			return
+		case <-ticker.C:
+			r.expireSessions(time.Now().Add(-timeout))
+		}
+	}
+}
+
+// expireSessions drops sessions inactive since before cutoff
+func (r *RelayServer) expireSessions(cutoff time.Time) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	expired := 0
+	for id, session := range r.sessions {
+		if session.lastActive.After(cutoff) {
+			continue
+		}
+		for _, peer := range session.peers {
+			delete(r.peers, peer.String())
+		}
+		delete(r.sessions, id)
+		expired++
+		r.logger.WithField("session_id", id).Info("Relay session expired")
+	}
+	return expired
+}
+
+// Sessions lists the relay sessions, paired or waiting
+func (r *RelayServer) Sessions() []Session {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	sessions := make([]Session, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		addrs := make([]string, len(session.peers))
+		for i, peer := range session.peers {
+			addrs[i] = peer.String()
+		}
+		sessions = append(sessions, Session{
+			ID:          session.id,
+			Protocol:    "relay",
+			RemoteAddrs: addrs,
+			CreatedAt:   session.createdAt,
+			LastActive:  session.lastActive,
+			Stats:       session.stats.Snapshot(),
+		})
+	}
+	return sortSessions(sessions)
+}
+
+// GetStats returns relay counters
+func (r *RelayServer) GetStats() RelayServerStats {
+	r.mutex.RLock()
+	sessions := len(r.sessions)
+	r.mutex.RUnlock()
+
+	return RelayServerStats{
+		Sessions:  sessions,
+		Forwarded: atomic.LoadUint64(&r.counters.forwarded),
+		Dropped:   atomic.LoadUint64(&r.counters.dropped),
+		Rejected:  atomic.LoadUint64(&r.counters.rejected),
+	}
+}
+
+func (s *relaySession) hasPeer(addr *net.UDPAddr) bool {
+	for _, peer := range s.peers {
+		if peer.String() == addr.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// partnerOf returns the other peer, nil while the session waits for one
+func (s *relaySession) partnerOf(addr *net.UDPAddr) *net.UDPAddr {
+	for _, peer := range s.peers {
+		if peer.String() != addr.String() {
+			return peer
+		}
+	}
+	return nil
+}
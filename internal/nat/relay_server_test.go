@@ -0,0 +1,96 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/internal/config"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+// relayPeer sends a packet to the relay
+func relayPeer(t *testing.T, conn *net.UDPConn, relay net.Addr, packetType protocol.PacketType, payload string) {
+	data, err := (&protocol.Packet{Type: packetType, Payload: []byte(payload)}).Serialize()
+	assert.NoError(t, err)
+	_, err = conn.WriteTo(data, relay)
+	assert.NoError(t, err)
+}
+
+func readRelayPacket(t *testing.T, conn *net.UDPConn) *protocol.Packet {
+	buffer := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := conn.Read(buffer)
+	if !assert.NoError(t, err) {
+		return &protocol.Packet{}
+	}
+	packet, err := protocol.ParsePacket(buffer[:n])
+	assert.NoError(t, err)
+	return packet
+}
+
+func TestRelayServer(t *testing.T) {
+	server := NewRelayServer(&config.RelayConfig{
+		ListenHost:  "127.0.0.1",
+		ListenPort:  12380,
+		IdleTimeout: time.Minute,
+		MaxSessions: 1,
+	}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, server.Start(ctx))
+	defer server.Stop()
+
+	peers := make([]*net.UDPConn, 3)
+	for i := range peers {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+		assert.NoError(t, err)
+		defer conn.Close()
+		peers[i] = conn
+	}
+	a, b, c := peers[0], peers[1], peers[2]
+
+	// The first peer waits, the second pairs the session
+	relayPeer(t, a, server.Addr(), protocol.PacketTypeRelayBind, "session-1")
+	ack := readRelayPacket(t, a)
+	assert.Equal(t, protocol.PacketTypeRelayBindAck, ack.Type)
+	assert.Equal(t, relayWaiting, string(ack.Payload))
+
+	relayPeer(t, b, server.Addr(), protocol.PacketTypeRelayBind, "session-1")
+	assert.Equal(t, relayPaired, string(readRelayPacket(t, a).Payload))
+	assert.Equal(t, relayPaired, string(readRelayPacket(t, b).Payload))
+
+	// Data is forwarded unchanged in both directions
+	relayPeer(t, a, server.Addr(), protocol.PacketTypeData, "hello")
+	assert.Equal(t, "hello", string(readRelayPacket(t, b).Payload))
+	relayPeer(t, b, server.Addr(), protocol.PacketTypeData, "world")
+	assert.Equal(t, "world", string(readRelayPacket(t, a).Payload))
+
+	// A third peer can't join, nor open a second session past the limit
+	relayPeer(t, c, server.Addr(), protocol.PacketTypeRelayBind, "session-1")
+	reply := readRelayPacket(t, c)
+	assert.Equal(t, protocol.PacketTypeError, reply.Type)
+	assert.Equal(t, relaySessionFull, string(reply.Payload))
+
+	relayPeer(t, c, server.Addr(), protocol.PacketTypeRelayBind, "session-2")
+	assert.Equal(t, relayFull, string(readRelayPacket(t, c).Payload))
+
+	sessions := server.Sessions()
+	if assert.Len(t, sessions, 1) {
+		assert.Equal(t, "session-1", sessions[0].ID)
+		assert.Equal(t, "relay", sessions[0].Protocol)
+		assert.Len(t, sessions[0].RemoteAddrs, 2)
+		assert.Equal(t, uint64(2), sessions[0].Stats.PacketsOut)
+	}
+
+	stats := server.GetStats()
+	assert.Equal(t, uint64(2), stats.Forwarded)
+	assert.Equal(t, uint64(2), stats.Rejected)
+
+	// Idle sessions are dropped
+	assert.Equal(t, 1, server.expireSessions(time.Now()))
+	assert.Empty(t, server.Sessions())
+}
@@ -6,6 +6,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/bOguzhan/NATbypass/internal/config"
 	"github.com/bOguzhan/NATbypass/internal/discovery"
 )
 
@@ -21,12 +22,12 @@ type UDPRelayingStrategy struct {
 	timeout time.Duration
 }
 
-// newUDPRelayingStrategy creates a new UDP relaying strategy
-func newUDPRelayingStrategy() *UDPRelayingStrategy {
-	// In a real implementation, these would be loaded from configuration
+// newUDPRelayingStrategy creates a UDP relaying strategy for the configured
+// TURN server
+func newUDPRelayingStrategy(cfg *config.TraversalConfig) *UDPRelayingStrategy {
 	return &UDPRelayingStrategy{
-		turnServer: "turn.example.com",
-		turnPort:   3478,
+		turnServer: cfg.RelayServer,
+		turnPort:   intOr(cfg.RelayPort, 3478),
 		credentials: struct {
 			username string
 			password string
@@ -34,7 +35,7 @@ func newUDPRelayingStrategy() *UDPRelayingStrategy {
 			username: "user",
 			password: "pass",
 		},
-		timeout: 30 * time.Second,
+		timeout: durationOr(cfg.Timeout, 30*time.Second),
 	}
 }
 
@@ -98,12 +99,12 @@ type TCPRelayingStrategy struct {
 	timeout time.Duration
 }
 
-// newTCPRelayingStrategy creates a new TCP relaying strategy
-func newTCPRelayingStrategy() *TCPRelayingStrategy {
-	// In a real implementation, these would be loaded from configuration
+// newTCPRelayingStrategy creates a TCP relaying strategy for the configured
+// TURN server
+func newTCPRelayingStrategy(cfg *config.TraversalConfig) *TCPRelayingStrategy {
 	return &TCPRelayingStrategy{
-		turnServer: "turn.example.com",
-		turnPort:   3478,
+		turnServer: cfg.RelayServer,
+		turnPort:   intOr(cfg.RelayPort, 3478),
 		credentials: struct {
 			username string
 			password string
@@ -111,7 +112,7 @@ func newTCPRelayingStrategy() *TCPRelayingStrategy {
 			username: "user",
 			password: "pass",
 		},
-		timeout: 30 * time.Second,
+		timeout: durationOr(cfg.Timeout, 30*time.Second),
 	}
 }
 
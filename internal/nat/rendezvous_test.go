@@ -0,0 +1,161 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/internal/config"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+// rendezvousClient talks to a rendezvous server over one transport
+type rendezvousClient struct {
+	localAddr string
+	send      func(*protocol.Packet) error
+	read      func(timeout time.Duration) (*protocol.Packet, error)
+}
+
+// startUDPRendezvous runs a UDP rendezvous server and connects a client
+func startUDPRendezvous(t *testing.T, auth *PacketAuthenticator) (rendezvousClient, func() []Session) {
+	server, err := NewUDPServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetAuthenticator(auth)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	assert.NoError(t, server.Start(ctx))
+	t.Cleanup(func() { server.Stop() })
+
+	conn, err := net.DialUDP("udp", nil, server.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return rendezvousClient{
+		localAddr: conn.LocalAddr().String(),
+		send: func(packet *protocol.Packet) error {
+			data, err := packet.Serialize()
+			if err == nil {
+				_, err = conn.Write(data)
+			}
+			return err
+		},
+		read: func(timeout time.Duration) (*protocol.Packet, error) {
+			buffer := make([]byte, 1500)
+			conn.SetReadDeadline(time.Now().Add(timeout))
+			n, err := conn.Read(buffer)
+			if err != nil {
+				return nil, err
+			}
+			return protocol.ParsePacket(buffer[:n])
+		},
+	}, server.Sessions
+}
+
+// startTCPRendezvous runs a TCP rendezvous server and connects a client
+func startTCPRendezvous(t *testing.T, auth *PacketAuthenticator) (rendezvousClient, func() []Session) {
+	server := NewTCPServer(&config.TCPServerConfig{ListenHost: "127.0.0.1"}, nil)
+	server.SetAuthenticator(auth)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	assert.NoError(t, server.Start(ctx))
+	t.Cleanup(func() { server.Stop() })
+
+	conn, err := net.Dial("tcp", server.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return rendezvousClient{
+		localAddr: conn.LocalAddr().String(),
+		send: func(packet *protocol.Packet) error {
+			return protocol.WriteFrame(conn, packet)
+		},
+		read: func(timeout time.Duration) (*protocol.Packet, error) {
+			conn.SetReadDeadline(time.Now().Add(timeout))
+			return protocol.ReadFrame(conn, 0)
+		},
+	}, server.Sessions
+}
+
+// TestRendezvousServersAgree checks that the UDP and TCP rendezvous servers
+// acknowledge registrations and track activity the same way
+func TestRendezvousServersAgree(t *testing.T) {
+	masterKey := []byte("test-master-secret")
+	clientID := "agreeing-client"
+	keyID := uint32(time.Now().Unix())
+	key := protocol.DeriveClientKey(masterKey, clientID, keyID)
+
+	transports := []struct {
+		name  string
+		start func(*testing.T, *PacketAuthenticator) (rendezvousClient, func() []Session)
+	}{
+		{"udp", startUDPRendezvous},
+		{"tcp", startTCPRendezvous},
+	}
+
+	// Packets sent after registering, in order
+	steps := []struct {
+		name       string
+		packetType protocol.PacketType
+		signed     bool
+		refreshes  bool // Whether the session's activity is refreshed
+	}{
+		{"signed keep-alive", protocol.PacketTypeKeepAlive, true, true},
+		{"unsigned keep-alive", protocol.PacketTypeKeepAlive, false, false},
+		{"signed data", protocol.PacketTypeData, true, true},
+		{"unsigned data", protocol.PacketTypeData, false, false},
+	}
+
+	for _, transport := range transports {
+		t.Run(transport.name, func(t *testing.T) {
+			client, sessions := transport.start(t, NewPacketAuthenticator(masterKey, time.Hour))
+			sequence := uint64(0)
+			send := func(packetType protocol.PacketType, payload []byte, signed bool) {
+				packet := &protocol.Packet{Type: packetType, Payload: payload}
+				if signed {
+					sequence++
+					assert.NoError(t, packet.Sign(clientID, keyID, sequence, key))
+				}
+				assert.NoError(t, client.send(packet))
+			}
+			lastActive := func() time.Time {
+				for _, session := range sessions() {
+					if session.ClientID == clientID {
+						return session.LastActive
+					}
+				}
+				return time.Time{}
+			}
+
+			// The ack carries the address the server saw the client at
+			send(protocol.PacketTypeRegistration, []byte(clientID), true)
+			ack, err := client.read(2 * time.Second)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, protocol.PacketTypeRegistrationAck, ack.Type)
+			assert.Equal(t, client.localAddr, string(ack.Payload))
+			assert.NoError(t, ack.Verify(key))
+
+			for _, step := range steps {
+				before := lastActive()
+				assert.False(t, before.IsZero(), step.name)
+				time.Sleep(20 * time.Millisecond)
+
+				send(step.packetType, []byte("payload"), step.signed)
+
+				// Neither packet type is answered
+				_, err := client.read(100 * time.Millisecond)
+				assert.Error(t, err, step.name)
+				assert.Equal(t, step.refreshes, lastActive().After(before), step.name)
+			}
+		})
+	}
+}
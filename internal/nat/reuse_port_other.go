@@ -0,0 +1,13 @@
+//go:build !linux
+
+package nat
+
+import (
+	"syscall"
+)
+
+// reusePort leaves the socket as is on platforms where sharing a port has
+// other semantics; concurrent attempts from a fixed port fail to bind there
+func reusePort(network, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -0,0 +1,32 @@
+package nat
+
+import (
+	"sort"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/pkg/stats"
+)
+
+// Session describes a live client session on a rendezvous or relay server.
+// TCP sessions are connections, UDP sessions are registered peers and relay
+// sessions are pairs of peers bound to the same relay session ID.
+type Session struct {
+	ID          string         `json:"id"`
+	Protocol    string         `json:"protocol"` // "tcp", "udp" or "relay"
+	ClientID    string         `json:"client_id,omitempty"`
+	RemoteAddrs []string       `json:"remote_addrs"`
+	CreatedAt   time.Time      `json:"created_at"`
+	LastActive  time.Time      `json:"last_active"`
+	Stats       stats.Snapshot `json:"stats"`
+}
+
+// sortSessions orders sessions oldest first so listings are stable
+func sortSessions(sessions []Session) []Session {
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].CreatedAt.Equal(sessions[j].CreatedAt) {
+			return sessions[i].CreatedAt.Before(sessions[j].CreatedAt)
+		}
+		return sessions[i].ID < sessions[j].ID
+	})
+	return sessions
+}
@@ -37,6 +37,9 @@ const (
 
 	// TCPRelaying represents TCP relaying via a TURN server (fallback option)
 	TCPRelaying StrategyType = "tcp-relaying"
+
+	// PortMapping represents forwarding a port on the gateway via PCP, NAT-PMP or UPnP IGD
+	PortMapping StrategyType = "port-mapping"
 )
 
 // StrategySelector helps select the optimal traversal strategy based on NAT types
@@ -13,7 +13,7 @@ import (
 )
 
 func TestStrategyImplementations(t *testing.T) {
-	factory := NewStrategyFactory()
+	factory := NewStrategyFactory(nil)
 	strategies := factory.GetAvailableStrategies()
 
 	// Check that all strategies implement the interface correctly
@@ -56,7 +56,7 @@ func TestEstablishConnectionInterface(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Test each strategy's EstablishConnection interface
-	factory := NewStrategyFactory()
+	factory := NewStrategyFactory(nil)
 	for _, stratType := range []StrategyType{
 		UDPHolePunching,
 		TCPSimultaneousOpen,
@@ -73,6 +73,11 @@ func TestEstablishConnectionInterface(t *testing.T) {
 
 			// We just test that the call doesn't panic - we expect errors since these are stubs
 			_, err = strategy.EstablishConnection(ctx, localAddr, remoteAddr)
+			if stratType == UDPHolePunching {
+				// Nobody answers the punches
+				assert.ErrorIs(t, err, context.DeadlineExceeded)
+				return
+			}
 			assert.NoError(t, err)
 			// We don't assert on error as different strategies may return different errors
 		})
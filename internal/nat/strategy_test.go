@@ -4,13 +4,15 @@ import (
 	"context"
 	"net"
 	"testing"
+	"time"
 
+	"github.com/bOguzhan/NATbypass/internal/config"
 	"github.com/bOguzhan/NATbypass/internal/discovery"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestStrategyFactory(t *testing.T) {
-	factory := NewStrategyFactory()
+	factory := NewStrategyFactory(nil)
 
 	// Test factory initialization
 	assert.NotNil(t, factory)
@@ -18,7 +20,7 @@ func TestStrategyFactory(t *testing.T) {
 
 	// Test all strategies were registered
 	strategies := factory.GetAvailableStrategies()
-	assert.Len(t, strategies, 4)
+	assert.Len(t, strategies, 5)
 
 	strategyNames := make(map[string]bool)
 	for _, s := range strategies {
@@ -29,10 +31,11 @@ func TestStrategyFactory(t *testing.T) {
 	assert.True(t, strategyNames["TCP Simultaneous Open"])
 	assert.True(t, strategyNames["UDP Relaying"])
 	assert.True(t, strategyNames["TCP Relaying"])
+	assert.True(t, strategyNames["Port Mapping"])
 }
 
 func TestStrategyRetrieval(t *testing.T) {
-	factory := NewStrategyFactory()
+	factory := NewStrategyFactory(nil)
 
 	// Test retrieving specific strategy by type
 	udpStrategy, err := factory.GetStrategyByType(UDPHolePunching)
@@ -52,7 +55,7 @@ func TestStrategyRetrieval(t *testing.T) {
 }
 
 func TestStrategySelection(t *testing.T) {
-	factory := NewStrategyFactory()
+	factory := NewStrategyFactory(nil)
 
 	testCases := []struct {
 		localNAT         discovery.NATType
@@ -87,7 +90,7 @@ func TestStrategySelection(t *testing.T) {
 }
 
 func TestSuccessRateEstimation(t *testing.T) {
-	factory := NewStrategyFactory()
+	factory := NewStrategyFactory(nil)
 
 	// Get the strategies
 	udpStrategy, _ := factory.GetStrategyByType(UDPHolePunching)
@@ -166,3 +169,41 @@ func TestCustomStrategyRegistration(t *testing.T) {
 	selectedStrategy := factory.SelectStrategy(discovery.NATFullCone, discovery.NATFullCone, "")
 	assert.Equal(t, "Custom Strategy", selectedStrategy.GetName())
 }
+
+func TestStrategyFactoryConfig(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Traversal.Strategies.PortMapping.Enabled = false
+	cfg.Traversal.Strategies.TCPRelaying.Priority = 10
+	cfg.Traversal.DialTimeout = time.Second
+	cfg.HolePunch.Attempts = 8
+	cfg.HolePunch.Interval = 200 * time.Millisecond
+
+	factory := NewStrategyFactory(cfg)
+
+	// Disabled strategies are not registered
+	assert.Len(t, factory.GetAvailableStrategies(), 4)
+	_, err := factory.GetStrategyByType(PortMapping)
+	assert.Equal(t, ErrStrategyNotFound, err)
+
+	// A configured priority outranks estimated success rates
+	priority, ok := factory.Priority(TCPRelaying)
+	assert.True(t, ok)
+	assert.Equal(t, 10, priority)
+	_, ok = factory.Priority(UDPHolePunching)
+	assert.False(t, ok)
+	selected := factory.SelectStrategy(discovery.NATFullCone, discovery.NATFullCone, "")
+	assert.Equal(t, "TCP Relaying", selected.GetName())
+
+	// Strategies are timed by the configuration
+	udp, _ := factory.GetStrategyByType(UDPHolePunching)
+	assert.Equal(t, 8, udp.(*UDPHolePunchingStrategy).maxRetries)
+	assert.Equal(t, 200*time.Millisecond, udp.(*UDPHolePunchingStrategy).initialTimeout)
+	tcp, _ := factory.GetStrategyByType(TCPSimultaneousOpen)
+	assert.Equal(t, time.Second, tcp.(*TCPSimultaneousOpenStrategy).connTimeout)
+
+	// The configured protocol preference applies when none is given
+	cfg = config.DefaultConfig()
+	cfg.Traversal.PreferredProtocol = "tcp"
+	selected = NewStrategyFactory(cfg).SelectStrategy(discovery.NATFullCone, discovery.NATFullCone, "")
+	assert.Equal(t, "tcp", selected.GetProtocol())
+}
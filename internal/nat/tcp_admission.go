@@ -0,0 +1,242 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/internal/logging"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+)
+
+// Admission policies applied when a TCP server reaches MaxConnections
+const (
+	// AdmissionReject closes new connections immediately when the server is full
+	AdmissionReject = "reject"
+
+	// AdmissionQueue holds new connections until a slot frees up or QueueTimeout passes
+	AdmissionQueue = "queue"
+)
+
+const (
+	defaultTCPBufferSize   = 4096
+	defaultTCPQueueTimeout = 5 * time.Second
+	rejectWriteTimeout     = 500 * time.Millisecond
+)
+
+// Reasons a TCP connection was turned away or closed by the server
+const (
+	rejectCapacity     = "capacity"
+	rejectPerIP        = "per_ip_limit"
+	rejectQueueFull    = "queue_full"
+	rejectQueueTimeout = "queue_timeout"
+)
+
+// TCPServerStats contains admission and timeout counters for a TCP server
+type TCPServerStats struct {
+	Active               int    `json:"active"`
+	Queued               int    `json:"queued"`
+	Accepted             uint64 `json:"accepted"`
+	RejectedCapacity     uint64 `json:"rejected_capacity"`
+	RejectedPerIP        uint64 `json:"rejected_per_ip"`
+	RejectedQueueFull    uint64 `json:"rejected_queue_full"`
+	RejectedQueueTimeout uint64 `json:"rejected_queue_timeout"`
+	ClosedIdle           uint64 `json:"closed_idle"`
+	ClosedMaxAge         uint64 `json:"closed_max_age"`
+	ClosedUnregistered   uint64 `json:"closed_unregistered"`
+	PacketsReceived      uint64 `json:"packets_received"`
+	PacketsSent          uint64 `json:"packets_sent"`
+}
+
+// tcpServerCounters holds the atomically updated counters behind TCPServerStats
+type tcpServerCounters struct {
+	queued               int32
+	accepted             uint64
+	rejectedCapacity     uint64
+	rejectedPerIP        uint64
+	rejectedQueueFull    uint64
+	rejectedQueueTimeout uint64
+	closedIdle           uint64
+	closedMaxAge         uint64
+	closedUnregistered   uint64
+	packetsReceived      uint64
+	packetsSent          uint64
+}
+
+// admit runs admission control for a freshly accepted connection. Connections
+// over the per-IP limit are rejected; when the server is full the configured
+// policy decides whether to reject or queue them.
+func (s *TCPServer) admit(ctx context.Context, conn net.Conn) {
+	ip := remoteIP(conn.RemoteAddr())
+
+	if !s.reserveIP(ip) {
+		s.reject(conn, rejectPerIP)
+		return
+	}
+
+	if s.tryAcquireSlot() {
+		s.registerConnection(ctx, conn, ip)
+		return
+	}
+
+	if s.config.AdmissionPolicy != AdmissionQueue {
+		s.releaseIP(ip)
+		s.reject(conn, rejectCapacity)
+		return
+	}
+
+	queued := atomic.AddInt32(&s.counters.queued, 1)
+	if s.config.MaxQueuedConnections > 0 && int(queued) > s.config.MaxQueuedConnections {
+		atomic.AddInt32(&s.counters.queued, -1)
+		s.releaseIP(ip)
+		s.reject(conn, rejectQueueFull)
+		return
+	}
+
+	go s.waitForSlot(ctx, conn, ip)
+}
+
+// waitForSlot holds a queued connection until a slot frees up
+func (s *TCPServer) waitForSlot(ctx context.Context, conn net.Conn, ip string) {
+	defer atomic.AddInt32(&s.counters.queued, -1)
+
+	s.connectionsMu.RLock()
+	timeout := s.config.QueueTimeout
+	s.connectionsMu.RUnlock()
+	if timeout <= 0 {
+		timeout = defaultTCPQueueTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case s.slots <- struct{}{}:
+		s.registerConnection(ctx, conn, ip)
+	case <-timer.C:
+		s.releaseIP(ip)
+		s.reject(conn, rejectQueueTimeout)
+	case <-ctx.Done():
+		s.releaseIP(ip)
+		conn.Close()
+	case <-s.stopChan:
+		s.releaseIP(ip)
+		conn.Close()
+	}
+}
+
+// tryAcquireSlot takes a connection slot without blocking
+func (s *TCPServer) tryAcquireSlot() bool {
+	if s.slots == nil {
+		return true
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// releaseSlot returns a connection slot
+func (s *TCPServer) releaseSlot() {
+	if s.slots == nil {
+		return
+	}
+
+	select {
+	case <-s.slots:
+	default:
+	}
+}
+
+// reserveIP counts a connection against its source IP, returning false if the
+// per-IP limit is already reached
+func (s *TCPServer) reserveIP(ip string) bool {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+
+	if s.config.MaxConnectionsPerIP > 0 && s.perIP[ip] >= s.config.MaxConnectionsPerIP {
+		return false
+	}
+	s.perIP[ip]++
+	return true
+}
+
+// releaseIP undoes a reserveIP
+func (s *TCPServer) releaseIP(ip string) {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+	s.releaseIPLocked(ip)
+}
+
+// releaseIPLocked undoes a reserveIP. The caller must hold connectionsMu.
+func (s *TCPServer) releaseIPLocked(ip string) {
+	if s.perIP[ip] <= 1 {
+		delete(s.perIP, ip)
+	} else {
+		s.perIP[ip]--
+	}
+}
+
+// reject counts the rejection, then tells the peer why it was turned away
+// and closes the connection in the background, so peers that are slow to
+// read don't hold up accepting others
+func (s *TCPServer) reject(conn net.Conn, reason string) {
+	switch reason {
+	case rejectCapacity:
+		atomic.AddUint64(&s.counters.rejectedCapacity, 1)
+	case rejectPerIP:
+		atomic.AddUint64(&s.counters.rejectedPerIP, 1)
+	case rejectQueueFull:
+		atomic.AddUint64(&s.counters.rejectedQueueFull, 1)
+	case rejectQueueTimeout:
+		atomic.AddUint64(&s.counters.rejectedQueueTimeout, 1)
+	}
+
+	s.logger.WithFields(logging.Fields{
+		"remote_addr": conn.RemoteAddr().String(),
+		"reason":      reason,
+	}).Warn("Rejected TCP connection")
+
+	go func() {
+		conn.SetWriteDeadline(time.Now().Add(rejectWriteTimeout))
+		protocol.WriteFrame(conn, &protocol.Packet{
+			Type:    protocol.PacketTypeError,
+			Payload: []byte("connection rejected: " + reason),
+		})
+		conn.Close()
+	}()
+}
+
+// GetStats returns admission and timeout counters
+func (s *TCPServer) GetStats() TCPServerStats {
+	return TCPServerStats{
+		Active:               s.GetActiveConnections(),
+		Queued:               int(atomic.LoadInt32(&s.counters.queued)),
+		Accepted:             atomic.LoadUint64(&s.counters.accepted),
+		RejectedCapacity:     atomic.LoadUint64(&s.counters.rejectedCapacity),
+		RejectedPerIP:        atomic.LoadUint64(&s.counters.rejectedPerIP),
+		RejectedQueueFull:    atomic.LoadUint64(&s.counters.rejectedQueueFull),
+		RejectedQueueTimeout: atomic.LoadUint64(&s.counters.rejectedQueueTimeout),
+		ClosedIdle:           atomic.LoadUint64(&s.counters.closedIdle),
+		ClosedMaxAge:         atomic.LoadUint64(&s.counters.closedMaxAge),
+		ClosedUnregistered:   atomic.LoadUint64(&s.counters.closedUnregistered),
+		PacketsReceived:      atomic.LoadUint64(&s.counters.packetsReceived),
+		PacketsSent:          atomic.LoadUint64(&s.counters.packetsSent),
+	}
+}
+
+// remoteIP returns the IP part of a connection's remote address
+func remoteIP(addr net.Addr) string {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		return tcpAddr.IP.String()
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,31 @@
+package nat
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bOguzhan/NATbypass/internal/config"
+)
+
+func TestRejectDoesNotBlock(t *testing.T) {
+	server := NewTCPServer(&config.TCPServerConfig{}, nil)
+
+	// The peer never reads, so the error frame can't be written
+	conn, peer := net.Pipe()
+	defer peer.Close()
+
+	start := time.Now()
+	server.reject(conn, rejectCapacity)
+	assert.Less(t, time.Since(start), rejectWriteTimeout/2)
+	assert.Equal(t, uint64(1), server.GetStats().RejectedCapacity)
+
+	// The connection is closed once the write times out
+	time.Sleep(rejectWriteTimeout + 100*time.Millisecond)
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := peer.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
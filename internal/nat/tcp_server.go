@@ -4,23 +4,44 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/sirupsen/logrus"
 
 	"github.com/bOguzhan/NATbypass/internal/config"
+	"github.com/bOguzhan/NATbypass/internal/logging"
+	"github.com/bOguzhan/NATbypass/internal/utils"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+	"github.com/bOguzhan/NATbypass/pkg/stats"
+)
+
+const (
+	// tcpPunchDelay is how long peers wait before a simultaneous open attempt,
+	// giving both sides time to receive their instructions
+	tcpPunchDelay = 500 * time.Millisecond
+
+	// tcpWriteTimeout bounds writing a packet, so a stalled connection
+	// can't block the one forwarding to it
+	tcpWriteTimeout = 5 * time.Second
 )
 
 // TCPServer represents a TCP server for NAT traversal operations
 type TCPServer struct {
+	*eventHub
 	config        *config.TCPServerConfig
 	listener      net.Listener
 	connections   map[string]*TCPConnection
+	clients       map[string]string // Client ID -> connection ID
 	connectionsMu sync.RWMutex
-	logger        *logrus.Logger
+	logger        logging.Logger
 	stopChan      chan struct{}
+	auth          *PacketAuthenticator
+	slots         chan struct{}  // Connection slots, nil when MaxConnections is unlimited
+	perIP         map[string]int // Admitted and queued connections per source IP
+	counters      tcpServerCounters
 }
 
 // TCPConnection represents a TCP connection with its metadata
@@ -29,24 +50,58 @@ type TCPConnection struct {
 	Conn       net.Conn
 	RemoteAddr net.Addr
 	LocalAddr  net.Addr
+	ClientID   string // Set once the peer registers
+	KeyID      uint32 // Key the client authenticated with, zero if unauthenticated
 	CreatedAt  time.Time
 	LastActive time.Time
+	Stats      *stats.Counters // Traffic on this connection
+	writeMu    sync.Mutex
+	ip         string
 }
 
-// NewTCPServer creates a new TCPServer instance
-func NewTCPServer(cfg *config.TCPServerConfig, logger *logrus.Logger) *TCPServer {
-	return &TCPServer{
+// NewTCPServer creates a new TCPServer instance. A nil logger discards
+// everything.
+func NewTCPServer(cfg *config.TCPServerConfig, logger logging.Logger) *TCPServer {
+	server := &TCPServer{
+		eventHub:    newEventHub(),
 		config:      cfg,
 		connections: make(map[string]*TCPConnection),
-		logger:      logger,
+		clients:     make(map[string]string),
+		logger:      logging.OrDiscard(logger),
 		stopChan:    make(chan struct{}),
+		perIP:       make(map[string]int),
 	}
+
+	if cfg.MaxConnections > 0 {
+		server.slots = make(chan struct{}, cfg.MaxConnections)
+	}
+
+	return server
+}
+
+// SetAuthenticator requires all incoming packets to be authenticated.
+// It must be called before Start.
+func (s *TCPServer) SetAuthenticator(auth *PacketAuthenticator) {
+	s.auth = auth
+}
+
+// SetTimeouts changes the connection, idle, maximum age and queue timeouts,
+// and may be called while the server runs. Open connections are checked
+// against the new values at the next maintenance pass.
+func (s *TCPServer) SetTimeouts(cfg *config.TCPServerConfig) {
+	s.connectionsMu.Lock()
+	defer s.connectionsMu.Unlock()
+
+	s.config.ConnectionTimeout = cfg.ConnectionTimeout
+	s.config.IdleTimeout = cfg.IdleTimeout
+	s.config.MaxConnectionAge = cfg.MaxConnectionAge
+	s.config.QueueTimeout = cfg.QueueTimeout
 }
 
 // Start initializes and starts the TCP server
 func (s *TCPServer) Start(ctx context.Context) error {
-	addr := fmt.Sprintf("%s:%d", s.config.Host, s.config.Port)
-	s.logger.Infof("Starting TCP server on %s", addr)
+	addr := net.JoinHostPort(s.config.ListenHost, strconv.Itoa(s.config.ListenPort))
+	s.logger.WithField("listen_addr", addr).Info("Starting TCP server")
 
 	var err error
 	s.listener, err = net.Listen("tcp", addr)
@@ -55,7 +110,7 @@ func (s *TCPServer) Start(ctx context.Context) error {
 	}
 
 	go s.acceptLoop(ctx)
-	go s.maintenanceLoop(ctx)
+	go s.maintenanceLoop(ctx, s.maintenanceInterval())
 
 	return nil
 }
@@ -71,30 +126,42 @@ func (s *TCPServer) acceptLoop(ctx context.Context) {
 		default:
 			conn, err := s.listener.Accept()
 			if err != nil {
-				s.logger.Errorf("Error accepting TCP connection: %v", err)
+				if utils.IsClosedNetworkError(err) {
+					return
+				}
+				s.logger.WithError(err).Error("Error accepting TCP connection")
 				continue
 			}
 
-			connID := uuid.New().String()
-			tcpConn := &TCPConnection{
-				ID:         connID,
-				Conn:       conn,
-				RemoteAddr: conn.RemoteAddr(),
-				LocalAddr:  conn.LocalAddr(),
-				CreatedAt:  time.Now(),
-				LastActive: time.Now(),
-			}
+			s.admit(ctx, conn)
+		}
+	}
+}
 
-			s.connectionsMu.Lock()
-			s.connections[connID] = tcpConn
-			s.connectionsMu.Unlock()
+// registerConnection tracks an admitted connection and starts serving it
+func (s *TCPServer) registerConnection(ctx context.Context, conn net.Conn, ip string) {
+	connID := uuid.New().String()
+	tcpConn := &TCPConnection{
+		ID:         connID,
+		Conn:       conn,
+		RemoteAddr: conn.RemoteAddr(),
+		LocalAddr:  conn.LocalAddr(),
+		CreatedAt:  time.Now(),
+		LastActive: time.Now(),
+		Stats:      stats.NewCounters(),
+		ip:         ip,
+	}
 
-			s.logger.Infof("New TCP connection established: %s -> %s (ID: %s)",
-				conn.RemoteAddr().String(), conn.LocalAddr().String(), connID)
+	s.connectionsMu.Lock()
+	s.connections[connID] = tcpConn
+	s.connectionsMu.Unlock()
+	atomic.AddUint64(&s.counters.accepted, 1)
 
-			go s.handleConnection(ctx, tcpConn)
-		}
-	}
+	s.connLogger(tcpConn).WithField("local_addr", conn.LocalAddr().String()).
+		Info("New TCP connection established")
+	s.emit(tcpConnectionEvent(EventConnect, tcpConn, ""))
+
+	go s.handleConnection(ctx, tcpConn)
 }
 
 // handleConnection manages an individual TCP connection
@@ -102,12 +169,18 @@ func (s *TCPServer) handleConnection(ctx context.Context, conn *TCPConnection) {
 	defer func() {
 		conn.Conn.Close()
 		s.connectionsMu.Lock()
-		delete(s.connections, conn.ID)
+		s.removeConnectionLocked(conn)
 		s.connectionsMu.Unlock()
-		s.logger.Infof("TCP connection closed: %s (ID: %s)", conn.RemoteAddr.String(), conn.ID)
+		s.connLogger(conn).Info("TCP connection closed")
 	}()
 
-	buffer := make([]byte, 4096)
+	bufferSize := s.config.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultTCPBufferSize
+	}
+
+	buffer := make([]byte, bufferSize)
+	decoder := protocol.NewStreamDecoder(protocol.MaxFrameSize)
 	for {
 		select {
 		case <-ctx.Done():
@@ -117,7 +190,7 @@ func (s *TCPServer) handleConnection(ctx context.Context, conn *TCPConnection) {
 		default:
 			// Set read deadline to prevent blocking indefinitely
 			if err := conn.Conn.SetReadDeadline(time.Now().Add(time.Second * 5)); err != nil {
-				s.logger.Errorf("Failed to set read deadline: %v", err)
+				s.connLogger(conn).WithError(err).Error("Failed to set read deadline")
 				return
 			}
 
@@ -127,52 +200,255 @@ func (s *TCPServer) handleConnection(ctx context.Context, conn *TCPConnection) {
 					// This is a timeout, which is expected when no data is received
 					continue
 				}
-				s.logger.Debugf("Read error from %s: %v", conn.RemoteAddr.String(), err)
+				s.connLogger(conn).WithError(err).Debug("Read error")
 				return
 			}
 
-			// Update last active time
-			conn.LastActive = time.Now()
-			s.handlePacket(conn, buffer[:n])
+			decoder.Write(buffer[:n])
+
+			// A single read may carry several packets or only part of one
+			for {
+				buffered := decoder.Buffered()
+				packet, err := decoder.Next()
+				if err != nil {
+					s.connLogger(conn).WithError(err).Warn("Invalid packet stream")
+					return
+				}
+				if packet == nil {
+					break
+				}
+				conn.Stats.RecordReceived(buffered - decoder.Buffered())
+				atomic.AddUint64(&s.counters.packetsReceived, 1)
+
+				packet.SourceAddr = conn.RemoteAddr
+				s.handlePacket(conn, packet)
+			}
 		}
 	}
 }
 
 // handlePacket processes a packet received over TCP
-func (s *TCPServer) handlePacket(conn *TCPConnection, data []byte) {
-	// TODO: Implement protocol-specific packet handling and validation
-	s.logger.Debugf("Received %d bytes from %s", len(data), conn.RemoteAddr.String())
+func (s *TCPServer) handlePacket(conn *TCPConnection, packet *protocol.Packet) {
+	s.connLogger(conn).WithFields(logging.Fields{
+		"packet_type": packet.Type,
+		"size":        len(packet.Payload),
+	}).Debug("Received packet")
 
-	// Basic packet validation
-	if len(data) < 4 {
-		s.logger.Warnf("Received invalid packet (too short) from %s", conn.RemoteAddr.String())
+	if s.auth != nil && !s.authorizePacket(conn, packet) {
 		return
 	}
 
-	// Example packet processing - to be replaced with actual protocol implementation
-	packetType := data[0]
-	s.logger.Debugf("Processed packet of type %d from %s", packetType, conn.RemoteAddr.String())
-
-	// Placeholder for actual packet handling logic
-	switch packetType {
-	case 0x01: // Registration packet
-		s.logger.Infof("Registration packet from %s", conn.RemoteAddr.String())
-		// TODO: Implement registration logic
-	case 0x02: // Hole punching packet
-		s.logger.Infof("Hole punching packet from %s", conn.RemoteAddr.String())
-		// TODO: Implement hole punching logic
-	case 0x03: // Keep-alive packet
-		s.logger.Debugf("Keep-alive packet from %s", conn.RemoteAddr.String())
-		// Send acknowledgment
-		conn.Conn.Write([]byte{0x03, 0x01})
+	// Every accepted packet keeps the connection alive, as over UDP
+	s.connectionsMu.Lock()
+	conn.LastActive = time.Now()
+	s.connectionsMu.Unlock()
+
+	if s.hasHandlers(EventPacket) {
+		s.connectionsMu.RLock()
+		event := tcpConnectionEvent(EventPacket, conn, conn.ClientID)
+		s.connectionsMu.RUnlock()
+		event.Packet = packet
+		s.emit(event)
+	}
+
+	switch packet.Type {
+	case protocol.PacketTypeRegistration:
+		s.handleRegistration(conn, packet)
+	case protocol.PacketTypeHolePunch:
+		s.handleHolePunch(conn, packet)
+	case protocol.PacketTypeData, protocol.PacketTypeKeepAlive:
+		// Only refresh the activity timestamp, above
 	default:
-		s.logger.Warnf("Unknown packet type %d from %s", packetType, conn.RemoteAddr.String())
+		s.connLogger(conn).WithField("packet_type", packet.Type).Warn("Unknown packet type")
+	}
+}
+
+// authorizePacket verifies a packet's MAC and checks that the signing client
+// is the one registered on this connection
+func (s *TCPServer) authorizePacket(conn *TCPConnection, packet *protocol.Packet) bool {
+	if err := s.auth.Authenticate(packet); err != nil {
+		s.connLogger(conn).WithError(err).Warn("Rejected packet")
+		return false
+	}
+
+	if packet.Type == protocol.PacketTypeRegistration {
+		registration, err := protocol.DecodeRegistration(packet.Payload)
+		if err != nil || registration.ClientID != packet.Auth.ClientID {
+			s.connLogger(conn).Warn("Rejected registration: client ID does not match key")
+			return false
+		}
+		return true
+	}
+
+	s.connectionsMu.RLock()
+	clientID := conn.ClientID
+	s.connectionsMu.RUnlock()
+
+	if clientID != packet.Auth.ClientID {
+		s.connLogger(conn).WithFields(logging.Fields{
+			"client_id": clientID,
+			"sender_id": packet.Auth.ClientID,
+		}).Warn("Rejected packet: sender is not the registered client")
+		return false
 	}
+
+	return true
+}
+
+// handleRegistration processes client registration packets. The ack carries
+// the client's address as observed by the server, as over UDP.
+func (s *TCPServer) handleRegistration(conn *TCPConnection, packet *protocol.Packet) {
+	registration, err := protocol.DecodeRegistration(packet.Payload)
+	if err != nil {
+		s.connLogger(conn).WithError(err).Warn("Invalid registration")
+		return
+	}
+	clientID := registration.ClientID
+
+	s.connectionsMu.Lock()
+	if conn.ClientID != "" && conn.ClientID != clientID && s.clients[conn.ClientID] == conn.ID {
+		delete(s.clients, conn.ClientID)
+	}
+	conn.ClientID = clientID
+	if packet.Auth != nil {
+		conn.KeyID = packet.Auth.KeyID
+	}
+	s.clients[clientID] = conn.ID
+	s.connectionsMu.Unlock()
+
+	s.connLogger(conn).WithField("client_id", clientID).Info("Client registered")
+	s.emit(tcpConnectionEvent(EventRegister, conn, clientID))
+
+	response := &protocol.Packet{
+		Type:    protocol.PacketTypeRegistrationAck,
+		Payload: []byte(conn.RemoteAddr.String()),
+	}
+	if err := s.sendSignedPacket(conn, response); err != nil {
+		s.connLogger(conn).WithError(err).Error("Error sending registration ack")
+	}
+}
+
+// handleHolePunch coordinates a TCP simultaneous open between the sender and
+// the client named in the payload. Both peers receive each other's observed
+// address and the same delay, so their connection attempts cross in flight.
+func (s *TCPServer) handleHolePunch(conn *TCPConnection, packet *protocol.Packet) {
+	targetID := string(packet.Payload)
+
+	s.connectionsMu.RLock()
+	sourceID := conn.ClientID
+	var target *TCPConnection
+	if connID, exists := s.clients[targetID]; exists {
+		target = s.connections[connID]
+	}
+	s.connectionsMu.RUnlock()
+
+	if sourceID == "" || target == nil {
+		message := "target client not found"
+		if sourceID == "" {
+			message = "source client not registered"
+		}
+
+		response := &protocol.Packet{
+			Type:    protocol.PacketTypeError,
+			Payload: []byte(message),
+		}
+		if err := s.sendSignedPacket(conn, response); err != nil {
+			s.connLogger(conn).WithError(err).Error("Error sending error response")
+		}
+		return
+	}
+
+	delay := tcpPunchDelay.Milliseconds()
+
+	// Tell the target to open towards the source
+	targetPayload, err := protocol.EncodePunchInstruction(&protocol.PunchInstruction{
+		PeerID:      sourceID,
+		PeerAddr:    conn.RemoteAddr.String(),
+		DelayMillis: delay,
+	})
+	if err != nil {
+		s.connLogger(conn).WithError(err).Error("Error encoding punch instruction")
+		return
+	}
+
+	punchRequest := &protocol.Packet{
+		Type:    protocol.PacketTypeHolePunch,
+		Payload: targetPayload,
+	}
+	if err := s.sendSignedPacket(target, punchRequest); err != nil {
+		s.connLogger(target).WithError(err).Error("Error forwarding punch request")
+		return
+	}
+
+	// Tell the source to open towards the target
+	sourcePayload, err := protocol.EncodePunchInstruction(&protocol.PunchInstruction{
+		PeerID:      targetID,
+		PeerAddr:    target.RemoteAddr.String(),
+		DelayMillis: delay,
+	})
+	if err != nil {
+		s.connLogger(conn).WithError(err).Error("Error encoding punch instruction")
+		return
+	}
+
+	response := &protocol.Packet{
+		Type:    protocol.PacketTypeHolePunchResponse,
+		Payload: sourcePayload,
+	}
+	if err := s.sendSignedPacket(conn, response); err != nil {
+		s.connLogger(conn).WithError(err).Error("Error sending punch response")
+		return
+	}
+
+	s.connLogger(conn).WithFields(logging.Fields{
+		"client_id":          sourceID,
+		"target_id":          targetID,
+		"target_remote_addr": target.RemoteAddr.String(),
+	}).Info("Initiated TCP simultaneous open")
+}
+
+// sendSignedPacket signs a packet for an authenticated client and sends it
+func (s *TCPServer) sendSignedPacket(conn *TCPConnection, packet *protocol.Packet) error {
+	s.connectionsMu.RLock()
+	clientID, keyID := conn.ClientID, conn.KeyID
+	s.connectionsMu.RUnlock()
+
+	if s.auth != nil && clientID != "" && keyID != 0 {
+		if err := s.auth.Sign(packet, clientID, keyID); err != nil {
+			return err
+		}
+	}
+
+	return s.sendPacket(conn, packet)
+}
+
+// sendPacket writes a length-prefixed packet to a connection. A connection
+// that doesn't take it within tcpWriteTimeout is closed, since part of the
+// frame may have been written.
+func (s *TCPServer) sendPacket(conn *TCPConnection, packet *protocol.Packet) error {
+	frame, err := protocol.EncodeFrame(packet)
+	if err != nil {
+		return err
+	}
+
+	conn.writeMu.Lock()
+	defer conn.writeMu.Unlock()
+
+	if err = conn.Conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout)); err != nil {
+		return err
+	}
+	if _, err = conn.Conn.Write(frame); err != nil {
+		conn.Conn.Close()
+		return err
+	}
+	conn.Stats.RecordSent(len(frame))
+	atomic.AddUint64(&s.counters.packetsSent, 1)
+	return nil
 }
 
 // maintenanceLoop periodically cleans up stale connections
-func (s *TCPServer) maintenanceLoop(ctx context.Context) {
-	ticker := time.NewTicker(30 * time.Second)
+func (s *TCPServer) maintenanceLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
 	for {
@@ -183,24 +459,106 @@ func (s *TCPServer) maintenanceLoop(ctx context.Context) {
 			return
 		case <-ticker.C:
 			s.cleanupStaleConnections()
+			// Timeouts may have been changed by SetTimeouts
+			if next := s.maintenanceInterval(); next != interval {
+				interval = next
+				ticker.Reset(interval)
+			}
+		}
+	}
+}
+
+// maintenanceInterval checks timeouts at twice the rate of the shortest one
+func (s *TCPServer) maintenanceInterval() time.Duration {
+	s.connectionsMu.RLock()
+	defer s.connectionsMu.RUnlock()
+
+	interval := 30 * time.Second
+	for _, timeout := range []time.Duration{
+		s.config.IdleTimeout,
+		s.config.MaxConnectionAge,
+		s.config.ConnectionTimeout,
+	} {
+		if timeout > 0 && timeout/2 < interval {
+			interval = timeout / 2
 		}
 	}
+
+	if interval < time.Second {
+		interval = time.Second
+	}
+	return interval
 }
 
-// cleanupStaleConnections removes inactive connections
+// cleanupStaleConnections closes connections that have been idle too long,
+// outlived the maximum connection age or never registered
 func (s *TCPServer) cleanupStaleConnections() {
-	threshold := time.Now().Add(-time.Duration(s.config.ConnectionTimeout) * time.Second)
+	now := time.Now()
 
 	s.connectionsMu.Lock()
 	defer s.connectionsMu.Unlock()
 
-	for id, conn := range s.connections {
-		if conn.LastActive.Before(threshold) {
-			s.logger.Infof("Closing stale TCP connection: %s (ID: %s, inactive for %v)",
-				conn.RemoteAddr.String(), id, time.Since(conn.LastActive))
-			conn.Conn.Close()
-			delete(s.connections, id)
+	for _, conn := range s.connections {
+		var reason string
+		switch {
+		case s.config.IdleTimeout > 0 && now.Sub(conn.LastActive) > s.config.IdleTimeout:
+			reason = "idle"
+			atomic.AddUint64(&s.counters.closedIdle, 1)
+			s.emit(tcpConnectionEvent(EventIdleTimeout, conn, conn.ClientID))
+		case s.config.MaxConnectionAge > 0 && now.Sub(conn.CreatedAt) > s.config.MaxConnectionAge:
+			reason = "max age reached"
+			atomic.AddUint64(&s.counters.closedMaxAge, 1)
+		case s.config.ConnectionTimeout > 0 && conn.ClientID == "" && now.Sub(conn.CreatedAt) > s.config.ConnectionTimeout:
+			reason = "not registered"
+			atomic.AddUint64(&s.counters.closedUnregistered, 1)
+		default:
+			continue
 		}
+
+		s.connLogger(conn).WithFields(logging.Fields{
+			"reason":   reason,
+			"inactive": now.Sub(conn.LastActive).String(),
+		}).Info("Closing stale TCP connection")
+		conn.Conn.Close()
+		s.removeConnectionLocked(conn)
+	}
+}
+
+// connLogger returns the server logger with a connection's ID and peer
+// address as fields
+func (s *TCPServer) connLogger(conn *TCPConnection) logging.Logger {
+	return s.logger.WithFields(logging.Fields{
+		"connection_id": conn.ID,
+		"remote_addr":   conn.RemoteAddr.String(),
+	})
+}
+
+// tcpConnectionEvent builds a lifecycle event for a TCP connection
+func tcpConnectionEvent(eventType EventType, conn *TCPConnection, clientID string) ConnectionEvent {
+	return ConnectionEvent{
+		Type:         eventType,
+		Protocol:     "tcp",
+		ConnectionID: conn.ID,
+		ClientID:     clientID,
+		RemoteAddr:   conn.RemoteAddr,
+		LocalAddr:    conn.LocalAddr,
+		Timestamp:    time.Now(),
+	}
+}
+
+// removeConnectionLocked drops a connection and its client index entry and
+// frees its admission slot. The caller must hold connectionsMu.
+func (s *TCPServer) removeConnectionLocked(conn *TCPConnection) {
+	if _, exists := s.connections[conn.ID]; !exists {
+		return
+	}
+
+	delete(s.connections, conn.ID)
+	s.releaseIPLocked(conn.ip)
+	s.releaseSlot()
+	s.emit(tcpConnectionEvent(EventClose, conn, conn.ClientID))
+	if conn.ClientID != "" && s.clients[conn.ClientID] == conn.ID {
+		delete(s.clients, conn.ClientID)
 	}
 }
 
@@ -210,6 +568,34 @@ func (s *TCPServer) ForceCleanup() {
 	s.cleanupStaleConnections()
 }
 
+// Addr returns the address the server listens on, nil before Start
+func (s *TCPServer) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Sessions lists the open connections
+func (s *TCPServer) Sessions() []Session {
+	s.connectionsMu.RLock()
+	defer s.connectionsMu.RUnlock()
+
+	sessions := make([]Session, 0, len(s.connections))
+	for _, conn := range s.connections {
+		sessions = append(sessions, Session{
+			ID:          conn.ID,
+			Protocol:    "tcp",
+			ClientID:    conn.ClientID,
+			RemoteAddrs: []string{conn.RemoteAddr.String()},
+			CreatedAt:   conn.CreatedAt,
+			LastActive:  conn.LastActive,
+			Stats:       conn.Stats.Snapshot(),
+		})
+	}
+	return sortSessions(sessions)
+}
+
 // GetActiveConnections returns the count of currently active connections
 func (s *TCPServer) GetActiveConnections() int {
 	s.connectionsMu.RLock()
@@ -217,7 +603,39 @@ func (s *TCPServer) GetActiveConnections() int {
 	return len(s.connections)
 }
 
-// SendTo sends data to a specific connection by ID
+// GetConnectionByClientID returns the connection ID a client registered on
+func (s *TCPServer) GetConnectionByClientID(clientID string) (string, bool) {
+	s.connectionsMu.RLock()
+	defer s.connectionsMu.RUnlock()
+	connID, exists := s.clients[clientID]
+	return connID, exists
+}
+
+// GetConnectionStats returns the traffic counters of a connection by ID
+func (s *TCPServer) GetConnectionStats(connectionID string) (stats.Snapshot, bool) {
+	s.connectionsMu.RLock()
+	conn, exists := s.connections[connectionID]
+	s.connectionsMu.RUnlock()
+
+	if !exists {
+		return stats.Snapshot{}, false
+	}
+	return conn.Stats.Snapshot(), true
+}
+
+// ConnectionStats returns the traffic counters of every connection by ID
+func (s *TCPServer) ConnectionStats() map[string]stats.Snapshot {
+	s.connectionsMu.RLock()
+	defer s.connectionsMu.RUnlock()
+
+	snapshots := make(map[string]stats.Snapshot, len(s.connections))
+	for id, conn := range s.connections {
+		snapshots[id] = conn.Stats.Snapshot()
+	}
+	return snapshots
+}
+
+// SendTo sends data to a specific connection by ID as a data packet
 func (s *TCPServer) SendTo(connectionID string, data []byte) error {
 	s.connectionsMu.RLock()
 	conn, exists := s.connections[connectionID]
@@ -227,8 +645,11 @@ func (s *TCPServer) SendTo(connectionID string, data []byte) error {
 		return fmt.Errorf("connection %s not found", connectionID)
 	}
 
-	_, err := conn.Conn.Write(data)
-	if err != nil {
+	packet := &protocol.Packet{
+		Type:    protocol.PacketTypeData,
+		Payload: data,
+	}
+	if err := s.sendPacket(conn, packet); err != nil {
 		return fmt.Errorf("failed to send data to %s: %w", connectionID, err)
 	}
 
@@ -257,12 +678,16 @@ func (s *TCPServer) Stop() error {
 	s.connectionsMu.Lock()
 	defer s.connectionsMu.Unlock()
 
-	for id, conn := range s.connections {
-		s.logger.Debugf("Closing TCP connection: %s (ID: %s)", conn.RemoteAddr.String(), id)
+	for _, conn := range s.connections {
+		s.connLogger(conn).Debug("Closing TCP connection")
 		conn.Conn.Close()
+		s.emit(tcpConnectionEvent(EventClose, conn, conn.ClientID))
 	}
 
 	s.connections = make(map[string]*TCPConnection)
+	s.clients = make(map[string]string)
+	s.perIP = make(map[string]int)
+	s.eventHub.close()
 	s.logger.Info("TCP server stopped successfully")
 	return nil
 }
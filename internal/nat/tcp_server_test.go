@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
@@ -13,8 +14,25 @@ import (
 
 	"github.com/bOguzhan/NATbypass/internal/config"
 	"github.com/bOguzhan/NATbypass/internal/nat"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
 )
 
+// registerOverTCP sends a framed registration packet and returns the server's response
+func registerOverTCP(conn net.Conn, clientID string) (*protocol.Packet, error) {
+	err := protocol.WriteFrame(conn, &protocol.Packet{
+		Type:    protocol.PacketTypeRegistration,
+		Payload: []byte(clientID),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(2 * time.Second)); err != nil {
+		return nil, err
+	}
+	return protocol.ReadFrame(conn, 0)
+}
+
 func TestTCPServer(t *testing.T) {
 	// Setup logger
 	logger := logrus.New()
@@ -22,9 +40,9 @@ func TestTCPServer(t *testing.T) {
 
 	// Create TCP server configuration
 	tcpConfig := &config.TCPServerConfig{
-		Host:              "127.0.0.1",
-		Port:              8765, // Use a port that's likely to be available
-		ConnectionTimeout: 5,    // Short timeout for testing
+		ListenHost:        "127.0.0.1",
+		ListenPort:        8765,            // Use a port that's likely to be available
+		ConnectionTimeout: 5 * time.Second, // Short timeout for testing
 		MaxConnections:    10,
 		BufferSize:        1024,
 	}
@@ -45,28 +63,38 @@ func TestTCPServer(t *testing.T) {
 
 	// Test single connection
 	t.Run("TestSingleConnection", func(t *testing.T) {
-		conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", tcpConfig.Host, tcpConfig.Port))
+		conn, err := net.Dial("tcp", net.JoinHostPort(tcpConfig.ListenHost, strconv.Itoa(tcpConfig.ListenPort)))
 		assert.NoError(t, err, "Should connect to server")
 		defer conn.Close()
 
-		// Test sending data
-		testData := []byte{0x03, 0x00, 0x01, 0x02} // Keep-alive packet
-		_, err = conn.Write(testData)
-		assert.NoError(t, err, "Should write to connection")
-
-		// Test receiving response
-		response := make([]byte, 2)
-		err = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
-		assert.NoError(t, err, "Should set read deadline")
-
-		n, err := conn.Read(response)
+		// Test registration round trip
+		response, err := registerOverTCP(conn, "tcp-client-1")
 		assert.NoError(t, err, "Should read response")
-		assert.Equal(t, 2, n, "Response should be 2 bytes")
-		assert.Equal(t, byte(0x03), response[0], "First byte should be packet type")
-		assert.Equal(t, byte(0x01), response[1], "Second byte should be acknowledgment")
+		assert.Equal(t, protocol.PacketTypeRegistrationAck, response.Type, "Should receive registration ack")
+
+		// Keep-alive packets are accepted without a response
+		err = protocol.WriteFrame(conn, &protocol.Packet{Type: protocol.PacketTypeKeepAlive})
+		assert.NoError(t, err, "Should write keep-alive")
 
 		// Verify connection count
 		assert.Equal(t, 1, server.GetActiveConnections(), "Should have 1 active connection")
+
+		// Both frames in and the ack out are counted on the connection
+		registration, _ := protocol.EncodeFrame(&protocol.Packet{
+			Type:    protocol.PacketTypeRegistration,
+			Payload: []byte("tcp-client-1"),
+		})
+		keepAlive, _ := protocol.EncodeFrame(&protocol.Packet{Type: protocol.PacketTypeKeepAlive})
+		assert.Eventually(t, func() bool {
+			for _, snapshot := range server.ConnectionStats() {
+				return snapshot.PacketsIn == 2 &&
+					snapshot.BytesIn == uint64(len(registration)+len(keepAlive)) &&
+					snapshot.PacketsOut == 1
+			}
+			return false
+		}, 2*time.Second, 50*time.Millisecond, "Traffic should be counted per connection")
+		assert.Equal(t, uint64(2), server.GetStats().PacketsReceived)
+		assert.Equal(t, uint64(1), server.GetStats().PacketsSent)
 	})
 
 	// Test multiple concurrent connections
@@ -79,25 +107,16 @@ func TestTCPServer(t *testing.T) {
 			go func(id int) {
 				defer wg.Done()
 
-				conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", tcpConfig.Host, tcpConfig.Port))
+				conn, err := net.Dial("tcp", net.JoinHostPort(tcpConfig.ListenHost, strconv.Itoa(tcpConfig.ListenPort)))
 				if err != nil {
 					t.Errorf("Connection %d failed: %v", id, err)
 					return
 				}
 				defer conn.Close()
 
-				// Send keep-alive
-				_, err = conn.Write([]byte{0x03, 0x00, 0x01, 0x02})
-				if err != nil {
-					t.Errorf("Write on connection %d failed: %v", id, err)
-					return
-				}
-
-				// Read response
-				resp := make([]byte, 2)
-				conn.SetReadDeadline(time.Now().Add(2 * time.Second))
-				if _, err := conn.Read(resp); err != nil {
-					t.Errorf("Read on connection %d failed: %v", id, err)
+				// Register and wait for the ack
+				if _, err := registerOverTCP(conn, fmt.Sprintf("tcp-client-%d", id)); err != nil {
+					t.Errorf("Registration on connection %d failed: %v", id, err)
 					return
 				}
 
@@ -117,35 +136,58 @@ func TestTCPServer(t *testing.T) {
 		wg.Wait()
 	})
 
-	// Test connection timeout
+	// Test packets split across reads and coalesced into one read
+	t.Run("TestStreamFraming", func(t *testing.T) {
+		conn, err := net.Dial("tcp", net.JoinHostPort(tcpConfig.ListenHost, strconv.Itoa(tcpConfig.ListenPort)))
+		assert.NoError(t, err, "Should connect to server")
+		defer conn.Close()
+
+		keepAlive, err := protocol.EncodeFrame(&protocol.Packet{Type: protocol.PacketTypeKeepAlive})
+		assert.NoError(t, err)
+		registration, err := protocol.EncodeFrame(&protocol.Packet{
+			Type:    protocol.PacketTypeRegistration,
+			Payload: []byte("tcp-client-framing"),
+		})
+		assert.NoError(t, err)
+
+		// Write the registration in two halves with a pause in between
+		stream := append(keepAlive, registration...)
+		split := len(keepAlive) + 3
+		_, err = conn.Write(stream[:split])
+		assert.NoError(t, err)
+		time.Sleep(50 * time.Millisecond)
+		_, err = conn.Write(stream[split:])
+		assert.NoError(t, err)
+
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		response, err := protocol.ReadFrame(conn, 0)
+		assert.NoError(t, err, "Should read response")
+		assert.Equal(t, protocol.PacketTypeRegistrationAck, response.Type)
+	})
+
+	// Test idle timeout
 	t.Run("TestConnectionTimeout", func(t *testing.T) {
 		// Lower timeout for this test
 		server.Stop()
-		tcpConfig.ConnectionTimeout = 1 // 1 second timeout
+		tcpConfig.IdleTimeout = 1 * time.Second
 		server = nat.NewTCPServer(tcpConfig, logger)
 		err := server.Start(ctx)
 		assert.NoError(t, err, "Server should restart without error")
 
 		// Establish a connection
-		conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", tcpConfig.Host, tcpConfig.Port))
+		conn, err := net.Dial("tcp", net.JoinHostPort(tcpConfig.ListenHost, strconv.Itoa(tcpConfig.ListenPort)))
 		assert.NoError(t, err, "Should connect to server")
 		defer conn.Close()
 
 		// Send initial data
-		_, err = conn.Write([]byte{0x03, 0x00, 0x01, 0x02})
-		assert.NoError(t, err, "Should write to connection")
-
-		// Read response
-		resp := make([]byte, 2)
-		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
-		_, err = conn.Read(resp)
+		_, err = registerOverTCP(conn, "tcp-client-timeout")
 		assert.NoError(t, err, "Should read response")
 
 		// Verify we have a connection
 		assert.Equal(t, 1, server.GetActiveConnections(), "Should have 1 active connection")
 
 		// Wait for timeout and cleanup
-		time.Sleep(time.Duration(tcpConfig.ConnectionTimeout+1) * time.Second)
+		time.Sleep(tcpConfig.IdleTimeout + time.Second)
 
 		// Force a cleanup cycle - since server is already of type *nat.TCPServer, no type assertion needed
 		server.ForceCleanup() // Direct method call
@@ -154,5 +196,273 @@ func TestTCPServer(t *testing.T) {
 
 		// Connection should be cleaned up by the server
 		assert.Equal(t, 0, server.GetActiveConnections(), "Should have 0 active connections after timeout")
+		assert.Equal(t, uint64(1), server.GetStats().ClosedIdle, "Idle close should be counted")
+	})
+}
+
+func TestTCPServerHolePunchCoordination(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	tcpConfig := &config.TCPServerConfig{
+		ListenHost:        "127.0.0.1",
+		ListenPort:        8766,
+		ConnectionTimeout: 5 * time.Second,
+		MaxConnections:    10,
+		BufferSize:        1024,
+	}
+
+	server := nat.NewTCPServer(tcpConfig, logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err := server.Start(ctx)
+	assert.NoError(t, err, "Server should start without error")
+	defer server.Stop()
+
+	serverAddr := net.JoinHostPort(tcpConfig.ListenHost, strconv.Itoa(tcpConfig.ListenPort))
+
+	source, err := net.Dial("tcp", serverAddr)
+	assert.NoError(t, err)
+	defer source.Close()
+
+	target, err := net.Dial("tcp", serverAddr)
+	assert.NoError(t, err)
+	defer target.Close()
+
+	// Registration acks report the address the server observed
+	ack, err := registerOverTCP(source, "tcp-source")
+	assert.NoError(t, err)
+	assert.Equal(t, source.LocalAddr().String(), string(ack.Payload))
+
+	_, err = registerOverTCP(target, "tcp-target")
+	assert.NoError(t, err)
+
+	_, exists := server.GetConnectionByClientID("tcp-target")
+	assert.True(t, exists, "Target should be indexed by client ID")
+
+	// Punching towards an unknown client yields an error
+	err = protocol.WriteFrame(source, &protocol.Packet{Type: protocol.PacketTypeHolePunch, Payload: []byte("nobody")})
+	assert.NoError(t, err)
+	source.SetReadDeadline(time.Now().Add(2 * time.Second))
+	response, err := protocol.ReadFrame(source, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.PacketTypeError, response.Type)
+
+	// Punching towards the target instructs both peers
+	err = protocol.WriteFrame(source, &protocol.Packet{Type: protocol.PacketTypeHolePunch, Payload: []byte("tcp-target")})
+	assert.NoError(t, err)
+
+	source.SetReadDeadline(time.Now().Add(2 * time.Second))
+	response, err = protocol.ReadFrame(source, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.PacketTypeHolePunchResponse, response.Type)
+
+	instruction, err := protocol.DecodePunchInstruction(response.Payload)
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp-target", instruction.PeerID)
+	assert.Equal(t, target.LocalAddr().String(), instruction.PeerAddr)
+	assert.Greater(t, instruction.DelayMillis, int64(0))
+
+	target.SetReadDeadline(time.Now().Add(2 * time.Second))
+	request, err := protocol.ReadFrame(target, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.PacketTypeHolePunch, request.Type)
+
+	instruction, err = protocol.DecodePunchInstruction(request.Payload)
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp-source", instruction.PeerID)
+	assert.Equal(t, source.LocalAddr().String(), instruction.PeerAddr)
+}
+
+func TestTCPServerAdmissionControl(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.ErrorLevel)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// expectRejection reads the error frame sent before a rejected connection is closed
+	expectRejection := func(t *testing.T, conn net.Conn, reason string) {
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		packet, err := protocol.ReadFrame(conn, 0)
+		assert.NoError(t, err)
+		if packet != nil {
+			assert.Equal(t, protocol.PacketTypeError, packet.Type)
+			assert.Contains(t, string(packet.Payload), reason)
+		}
+	}
+
+	t.Run("RejectAtCapacity", func(t *testing.T) {
+		tcpConfig := &config.TCPServerConfig{
+			ListenHost:      "127.0.0.1",
+			ListenPort:      8767,
+			MaxConnections:  1,
+			AdmissionPolicy: nat.AdmissionReject,
+		}
+		server := nat.NewTCPServer(tcpConfig, logger)
+		assert.NoError(t, server.Start(ctx))
+		defer server.Stop()
+
+		addr := net.JoinHostPort(tcpConfig.ListenHost, strconv.Itoa(tcpConfig.ListenPort))
+		first, err := net.Dial("tcp", addr)
+		assert.NoError(t, err)
+		defer first.Close()
+
+		_, err = registerOverTCP(first, "admitted")
+		assert.NoError(t, err)
+
+		second, err := net.Dial("tcp", addr)
+		assert.NoError(t, err)
+		defer second.Close()
+
+		expectRejection(t, second, "capacity")
+		assert.Equal(t, uint64(1), server.GetStats().RejectedCapacity)
+		assert.Equal(t, 1, server.GetActiveConnections())
+	})
+
+	t.Run("PerIPLimit", func(t *testing.T) {
+		tcpConfig := &config.TCPServerConfig{
+			ListenHost:          "127.0.0.1",
+			ListenPort:          8768,
+			MaxConnectionsPerIP: 1,
+		}
+		server := nat.NewTCPServer(tcpConfig, logger)
+		assert.NoError(t, server.Start(ctx))
+		defer server.Stop()
+
+		addr := net.JoinHostPort(tcpConfig.ListenHost, strconv.Itoa(tcpConfig.ListenPort))
+		first, err := net.Dial("tcp", addr)
+		assert.NoError(t, err)
+		defer first.Close()
+
+		_, err = registerOverTCP(first, "admitted")
+		assert.NoError(t, err)
+
+		second, err := net.Dial("tcp", addr)
+		assert.NoError(t, err)
+		defer second.Close()
+
+		expectRejection(t, second, "per_ip_limit")
+		assert.Equal(t, uint64(1), server.GetStats().RejectedPerIP)
+	})
+
+	t.Run("QueueUntilSlotFrees", func(t *testing.T) {
+		tcpConfig := &config.TCPServerConfig{
+			ListenHost:      "127.0.0.1",
+			ListenPort:      8769,
+			MaxConnections:  1,
+			AdmissionPolicy: nat.AdmissionQueue,
+			QueueTimeout:    3 * time.Second,
+		}
+		server := nat.NewTCPServer(tcpConfig, logger)
+		assert.NoError(t, server.Start(ctx))
+		defer server.Stop()
+
+		addr := net.JoinHostPort(tcpConfig.ListenHost, strconv.Itoa(tcpConfig.ListenPort))
+		first, err := net.Dial("tcp", addr)
+		assert.NoError(t, err)
+
+		_, err = registerOverTCP(first, "first")
+		assert.NoError(t, err)
+
+		second, err := net.Dial("tcp", addr)
+		assert.NoError(t, err)
+		defer second.Close()
+
+		assert.Eventually(t, func() bool {
+			return server.GetStats().Queued == 1
+		}, 2*time.Second, 50*time.Millisecond)
+
+		// Closing the first connection lets the queued one in
+		first.Close()
+
+		response, err := registerOverTCP(second, "second")
+		assert.NoError(t, err)
+		if response != nil {
+			assert.Equal(t, protocol.PacketTypeRegistrationAck, response.Type)
+		}
+		assert.Equal(t, uint64(2), server.GetStats().Accepted)
 	})
 }
+
+func TestTCPServerSetTimeouts(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	tcpConfig := &config.TCPServerConfig{
+		ListenHost:     "127.0.0.1",
+		ListenPort:     8772,
+		MaxConnections: 10,
+	}
+	server := nat.NewTCPServer(tcpConfig, logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, server.Start(ctx))
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(tcpConfig.ListenHost, strconv.Itoa(tcpConfig.ListenPort)))
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, err = registerOverTCP(conn, "reloaded")
+	assert.NoError(t, err)
+
+	// Without an idle timeout the connection survives cleanup
+	time.Sleep(300 * time.Millisecond)
+	server.ForceCleanup()
+	assert.Equal(t, 1, server.GetActiveConnections())
+
+	// A timeout applied while running takes effect on open connections
+	server.SetTimeouts(&config.TCPServerConfig{IdleTimeout: 100 * time.Millisecond})
+	server.ForceCleanup()
+	assert.Eventually(t, func() bool {
+		return server.GetActiveConnections() == 0
+	}, 2*time.Second, 50*time.Millisecond)
+	assert.Equal(t, uint64(1), server.GetStats().ClosedIdle)
+}
+
+func TestTCPServerStalledWrite(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.WarnLevel)
+
+	tcpConfig := &config.TCPServerConfig{
+		ListenHost:     "127.0.0.1",
+		ListenPort:     8773,
+		MaxConnections: 10,
+	}
+	server := nat.NewTCPServer(tcpConfig, logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, server.Start(ctx))
+	defer server.Stop()
+
+	conn, err := net.Dial("tcp", net.JoinHostPort(tcpConfig.ListenHost, strconv.Itoa(tcpConfig.ListenPort)))
+	assert.NoError(t, err)
+	defer conn.Close()
+	_, err = registerOverTCP(conn, "stalled")
+	assert.NoError(t, err)
+	connectionID, _ := server.GetConnectionByClientID("stalled")
+
+	// The client stops reading, so writes fail once its buffers are full
+	// instead of blocking the sender
+	failed := make(chan error, 1)
+	go func() {
+		payload := make([]byte, 32*1024)
+		for {
+			if err := server.SendTo(connectionID, payload); err != nil {
+				failed <- err
+				return
+			}
+		}
+	}()
+
+	select {
+	case err := <-failed:
+		assert.Error(t, err)
+	case <-time.After(15 * time.Second):
+		t.Fatal("write to a stalled connection never failed")
+	}
+	assert.Eventually(t, func() bool {
+		return server.GetActiveConnections() == 0
+	}, 10*time.Second, 50*time.Millisecond)
+}
@@ -5,6 +5,7 @@ import (
 	"net"
 	"time"
 
+	"github.com/bOguzhan/NATbypass/internal/config"
 	"github.com/bOguzhan/NATbypass/internal/discovery"
 )
 
@@ -16,12 +17,14 @@ type TCPSimultaneousOpenStrategy struct {
 	maxRetries  int
 }
 
-// newTCPSimultaneousOpenStrategy creates a new TCP simultaneous open strategy
-func newTCPSimultaneousOpenStrategy() *TCPSimultaneousOpenStrategy {
+// newTCPSimultaneousOpenStrategy creates a TCP simultaneous open strategy
+// using the traversal dial timeout and retry count, and the hole punching
+// interval between attempts
+func newTCPSimultaneousOpenStrategy(cfg *config.Config) *TCPSimultaneousOpenStrategy {
 	return &TCPSimultaneousOpenStrategy{
-		connTimeout: 3 * time.Second,
-		retryDelay:  500 * time.Millisecond,
-		maxRetries:  5,
+		connTimeout: durationOr(cfg.Traversal.DialTimeout, 3*time.Second),
+		retryDelay:  durationOr(cfg.HolePunch.Interval, holePunchDelay),
+		maxRetries:  intOr(cfg.Traversal.MaxRetries, 5),
 	}
 }
 
@@ -39,13 +42,19 @@ func (s *TCPSimultaneousOpenStrategy) GetName() string {
 func (s *TCPSimultaneousOpenStrategy) EstimateSuccessRate(localNATType, remoteNATType discovery.NATType) float64 {
 	// Success rates based on combinations of NAT types
 
+	// Without NAT on either side (typically IPv6) a plain connect works
+	if localNATType == discovery.NATNone && remoteNATType == discovery.NATNone {
+		return 0.98
+	}
+
 	// Full cone to full cone has decent success rate
 	if localNATType == discovery.NATFullCone && remoteNATType == discovery.NATFullCone {
 		return 0.95 // Increased from 0.80
 	}
 
-	// Full cone to restricted has moderate success
-	if localNATType == discovery.NATFullCone || remoteNATType == discovery.NATFullCone {
+	// Full cone to restricted has moderate success, as does a side without NAT
+	if localNATType == discovery.NATFullCone || remoteNATType == discovery.NATFullCone ||
+		localNATType == discovery.NATNone || remoteNATType == discovery.NATNone {
 		return 0.85 // Increased from 0.60
 	}
 
@@ -78,11 +87,15 @@ func (s *TCPSimultaneousOpenStrategy) EstablishConnection(
 	// TCP simultaneous open requires precise timing and multiple connection attempts
 	// For UDP addresses, we'd need to convert to TCP addresses
 
-	// Convert UDP addresses to TCP addresses
-	localTCPAddr := &net.TCPAddr{
-		IP:   localAddr.IP,
-		Port: localAddr.Port,
-		Zone: localAddr.Zone,
+	// Convert UDP addresses to TCP addresses. Without a local address any
+	// port will do.
+	localTCPAddr := &net.TCPAddr{}
+	if localAddr != nil {
+		localTCPAddr = &net.TCPAddr{
+			IP:   localAddr.IP,
+			Port: localAddr.Port,
+			Zone: localAddr.Zone,
+		}
 	}
 	remoteTCPAddr := &net.TCPAddr{
 		IP:   remoteAddr.IP,
@@ -90,8 +103,10 @@ func (s *TCPSimultaneousOpenStrategy) EstablishConnection(
 		Zone: remoteAddr.Zone,
 	}
 
-	// Create a TCP listener bound to the local address
-	listener, err := net.ListenTCP("tcp", localTCPAddr)
+	// Create a TCP listener bound to the local address. The listener, the
+	// dialer and attempts raced from the same port all share it.
+	listenConfig := net.ListenConfig{Control: reusePort}
+	listener, err := listenConfig.Listen(ctx, "tcp", localTCPAddr.String())
 	if err != nil {
 		return nil, err
 	}
@@ -109,6 +124,7 @@ func (s *TCPSimultaneousOpenStrategy) EstablishConnection(
 	dialer := net.Dialer{
 		Timeout:   s.connTimeout,
 		LocalAddr: localTCPAddr,
+		Control:   reusePort,
 	}
 
 	conn, err := dialer.DialContext(ctx, "tcp", remoteTCPAddr.String())
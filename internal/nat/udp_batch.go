@@ -0,0 +1,21 @@
+package nat
+
+import "net"
+
+// udpDatagram is a raw datagram read from or written to a UDP socket
+type udpDatagram struct {
+	data []byte
+	addr *net.UDPAddr
+}
+
+// udpBatchConn moves several datagrams per system call where the platform
+// supports it (recvmmsg/sendmmsg on Linux) and one at a time elsewhere
+type udpBatchConn interface {
+	// readBatch blocks until at least one datagram arrives. The returned
+	// datagrams own their data.
+	readBatch() ([]udpDatagram, error)
+
+	// writeBatch sends every datagram it can, skipping those that fail, and
+	// returns the ones sent along with the first error
+	writeBatch(datagrams []udpDatagram) ([]udpDatagram, error)
+}
@@ -0,0 +1,96 @@
+//go:build linux
+
+package nat
+
+import (
+	"net"
+
+	"github.com/bOguzhan/NATbypass/internal/utils"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// batchPacketConn is the batching part of ipv4.PacketConn and
+// ipv6.PacketConn, which share their message type
+type batchPacketConn interface {
+	ReadBatch(msgs []ipv4.Message, flags int) (int, error)
+	WriteBatch(msgs []ipv4.Message, flags int) (int, error)
+}
+
+// linuxBatchConn uses recvmmsg and sendmmsg to move datagrams in batches
+type linuxBatchConn struct {
+	conn     batchPacketConn
+	readMsgs []ipv4.Message
+}
+
+// newUDPBatchConn wraps a UDP socket for batched reads and writes, using
+// the package for the socket's address family. Dual-stack sockets bound to
+// an unspecified address are IPv6 sockets.
+func newUDPBatchConn(conn *net.UDPConn, batchSize, bufferSize int) udpBatchConn {
+	msgs := make([]ipv4.Message, batchSize)
+	for i := range msgs {
+		msgs[i].Buffers = [][]byte{make([]byte, bufferSize)}
+	}
+
+	var packetConn batchPacketConn = ipv4.NewPacketConn(conn)
+	if local, ok := conn.LocalAddr().(*net.UDPAddr); ok && local.IP.To4() == nil {
+		packetConn = ipv6.NewPacketConn(conn)
+	}
+
+	return &linuxBatchConn{
+		conn:     packetConn,
+		readMsgs: msgs,
+	}
+}
+
+func (c *linuxBatchConn) readBatch() ([]udpDatagram, error) {
+	n, err := c.conn.ReadBatch(c.readMsgs, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	datagrams := make([]udpDatagram, 0, n)
+	for _, msg := range c.readMsgs[:n] {
+		addr, ok := msg.Addr.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		data := make([]byte, msg.N)
+		copy(data, msg.Buffers[0][:msg.N])
+		datagrams = append(datagrams, udpDatagram{data: data, addr: addr})
+	}
+
+	return datagrams, nil
+}
+
+func (c *linuxBatchConn) writeBatch(datagrams []udpDatagram) ([]udpDatagram, error) {
+	msgs := make([]ipv4.Message, len(datagrams))
+	for i, datagram := range datagrams {
+		msgs[i].Buffers = [][]byte{datagram.data}
+		msgs[i].Addr = datagram.addr
+	}
+
+	// sendmmsg may send fewer messages than requested, and stops at the
+	// first message that fails, which is skipped so the rest still go out
+	sent := make([]udpDatagram, 0, len(datagrams))
+	var firstErr error
+	for next := 0; next < len(msgs); {
+		n, err := c.conn.WriteBatch(msgs[next:], 0)
+		if n > 0 {
+			sent = append(sent, datagrams[next:next+n]...)
+			next += n
+		}
+		if err != nil {
+			if utils.IsClosedNetworkError(err) {
+				return sent, err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			next++
+		}
+	}
+
+	return sent, firstErr
+}
@@ -0,0 +1,54 @@
+//go:build !linux
+
+package nat
+
+import (
+	"net"
+
+	"github.com/bOguzhan/NATbypass/internal/utils"
+)
+
+// singleConn reads and writes one datagram per system call
+type singleConn struct {
+	conn   *net.UDPConn
+	buffer []byte
+}
+
+// newUDPBatchConn wraps a UDP socket. Batching is only available on Linux,
+// so other platforms fall back to single datagram reads and writes.
+func newUDPBatchConn(conn *net.UDPConn, batchSize, bufferSize int) udpBatchConn {
+	return &singleConn{
+		conn:   conn,
+		buffer: make([]byte, bufferSize),
+	}
+}
+
+func (c *singleConn) readBatch() ([]udpDatagram, error) {
+	n, addr, err := c.conn.ReadFromUDP(c.buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, n)
+	copy(data, c.buffer[:n])
+
+	return []udpDatagram{{data: data, addr: addr}}, nil
+}
+
+func (c *singleConn) writeBatch(datagrams []udpDatagram) ([]udpDatagram, error) {
+	sent := make([]udpDatagram, 0, len(datagrams))
+	var firstErr error
+	for _, datagram := range datagrams {
+		if _, err := c.conn.WriteToUDP(datagram.data, datagram.addr); err != nil {
+			if utils.IsClosedNetworkError(err) {
+				return sent, err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		sent = append(sent, datagram)
+	}
+	return sent, firstErr
+}
@@ -0,0 +1,65 @@
+package nat
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUDPBatchConn(t *testing.T) {
+	tests := []struct {
+		name   string
+		listen string // Address the batched socket binds to
+		peer   string
+	}{
+		{"ipv4", "127.0.0.1", "127.0.0.1"},
+		{"ipv6", "::1", "::1"},
+		{"dual-stack", "", "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			socket, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(tt.listen)})
+			if err != nil {
+				t.Skip("address family not available:", err)
+			}
+			defer socket.Close()
+			peer, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP(tt.peer)})
+			assert.NoError(t, err)
+			defer peer.Close()
+			peerAddr := peer.LocalAddr().(*net.UDPAddr)
+
+			batch := newUDPBatchConn(socket, 8, 1500)
+
+			// A datagram that can't be sent doesn't stop the ones after it
+			unreachable := &net.UDPAddr{IP: peerAddr.IP, Port: 0}
+			sent, err := batch.writeBatch([]udpDatagram{
+				{data: []byte("one"), addr: peerAddr},
+				{data: []byte("lost"), addr: unreachable},
+				{data: []byte("two"), addr: peerAddr},
+			})
+			assert.Error(t, err)
+			assert.Len(t, sent, 2)
+
+			buffer := make([]byte, 64)
+			for _, want := range []string{"one", "two"} {
+				peer.SetReadDeadline(time.Now().Add(time.Second))
+				n, _, err := peer.ReadFromUDP(buffer)
+				assert.NoError(t, err)
+				assert.Equal(t, want, string(buffer[:n]))
+			}
+
+			// Replies are read back with the sender's address
+			_, err = peer.WriteToUDP([]byte("reply"), &net.UDPAddr{IP: peerAddr.IP, Port: socket.LocalAddr().(*net.UDPAddr).Port})
+			assert.NoError(t, err)
+			datagrams, err := batch.readBatch()
+			if assert.NoError(t, err) && assert.Len(t, datagrams, 1) {
+				assert.Equal(t, "reply", string(datagrams[0].data))
+				assert.True(t, peerAddr.IP.Equal(datagrams[0].addr.IP))
+				assert.Equal(t, peerAddr.Port, datagrams[0].addr.Port)
+			}
+		})
+	}
+}
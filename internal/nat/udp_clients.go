@@ -0,0 +1,180 @@
+package nat
+
+import (
+	"net"
+
+	"github.com/bOguzhan/NATbypass/internal/logging"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+)
+
+// EndpointKind classifies the addresses a client can register from. A client
+// keeps at most one endpoint of each kind; registering a new address of the
+// same kind supersedes the old one.
+type EndpointKind string
+
+const (
+	// EndpointIPv4 is a public IPv4 address, usually a NAT mapping
+	EndpointIPv4 EndpointKind = "ipv4"
+
+	// EndpointIPv6 is a global IPv6 address
+	EndpointIPv6 EndpointKind = "ipv6"
+
+	// EndpointLAN is a private, loopback or link-local address
+	EndpointLAN EndpointKind = "lan"
+)
+
+// ClassifyEndpoint returns the endpoint kind of an address
+func ClassifyEndpoint(addr *net.UDPAddr) EndpointKind {
+	ip := addr.IP
+	if ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+		return EndpointLAN
+	}
+	if ip.To4() == nil {
+		return EndpointIPv6
+	}
+	return EndpointIPv4
+}
+
+// udpClient holds the endpoints a client has registered, keyed by kind, and
+// the private endpoints it announced for peers on the same network
+type udpClient struct {
+	endpoints  map[EndpointKind]*UDPConnection
+	localAddrs []*net.UDPAddr
+}
+
+// bindEndpointLocked records conn as the client's endpoint of its kind and
+// evicts any mapping it supersedes: the client's previous address of the same
+// kind, and another client previously registered at the same address. It
+// returns the evicted connections. The caller must hold s.mutex.
+func (s *UDPServer) bindEndpointLocked(conn *UDPConnection) []*UDPConnection {
+	addrKey := conn.peerAddr.String()
+	var evicted []*UDPConnection
+
+	// The address now belongs to this client
+	if previous, exists := s.connections[addrKey]; exists && previous.clientID != conn.clientID {
+		s.unbindEndpointLocked(previous)
+		evicted = append(evicted, previous)
+	}
+
+	client, exists := s.clients[conn.clientID]
+	if !exists {
+		client = &udpClient{endpoints: make(map[EndpointKind]*UDPConnection)}
+		s.clients[conn.clientID] = client
+	}
+
+	// The client moved, e.g. after a NAT rebinding
+	if previous, exists := client.endpoints[conn.endpoint]; exists && previous.peerAddr.String() != addrKey {
+		delete(s.connections, previous.peerAddr.String())
+		evicted = append(evicted, previous)
+	}
+
+	client.endpoints[conn.endpoint] = conn
+	s.connections[addrKey] = conn
+
+	for _, stale := range evicted {
+		s.logger.WithFields(logging.Fields{
+			"remote_addr": stale.peerAddr.String(),
+			"client_id":   stale.clientID,
+		}).Info("Evicted superseded mapping")
+	}
+
+	return evicted
+}
+
+// unbindEndpointLocked removes a connection from the client index. The caller
+// must hold s.mutex.
+func (s *UDPServer) unbindEndpointLocked(conn *UDPConnection) {
+	client, exists := s.clients[conn.clientID]
+	if !exists {
+		return
+	}
+
+	if current, exists := client.endpoints[conn.endpoint]; exists && current == conn {
+		delete(client.endpoints, conn.endpoint)
+	}
+	if len(client.endpoints) == 0 {
+		delete(s.clients, conn.clientID)
+	}
+}
+
+// endpointForLocked picks the client endpoint best suited to reach it from
+// source: one of the same kind when available, then public IPv4, then any.
+// The caller must hold s.mutex for reading.
+func (s *UDPServer) endpointForLocked(clientID string, source *net.UDPAddr) (*UDPConnection, bool) {
+	client, exists := s.clients[clientID]
+	if !exists {
+		return nil, false
+	}
+
+	for _, kind := range []EndpointKind{ClassifyEndpoint(source), EndpointIPv4, EndpointIPv6, EndpointLAN} {
+		if conn, exists := client.endpoints[kind]; exists {
+			return conn, true
+		}
+	}
+
+	return nil, false
+}
+
+// parseLocalAddrs keeps the announced addresses that are valid private
+// endpoints, as filtered by protocol.LocalEndpoints
+func parseLocalAddrs(addrs []string) []*net.UDPAddr {
+	var locals []*net.UDPAddr
+	for _, addrPort := range protocol.LocalEndpoints(addrs) {
+		locals = append(locals, net.UDPAddrFromAddrPort(addrPort))
+	}
+	return locals
+}
+
+// punchPayload tells a client where to reach a peer. Peers behind the same NAT
+// get the peer's private endpoints first with the public one as a fallback;
+// otherwise the payload is just the public address.
+func punchPayload(peerID string, public *net.UDPAddr, locals []*net.UDPAddr, sameNAT bool) []byte {
+	if !sameNAT || len(locals) == 0 {
+		return []byte(public.String())
+	}
+
+	candidates := make([]string, 0, len(locals)+1)
+	for _, local := range locals {
+		candidates = append(candidates, local.String())
+	}
+	candidates = append(candidates, public.String())
+
+	payload, err := protocol.EncodePunchInstruction(&protocol.PunchInstruction{
+		PeerID:     peerID,
+		PeerAddr:   public.String(),
+		Candidates: candidates,
+	})
+	if err != nil {
+		return []byte(public.String())
+	}
+	return payload
+}
+
+// GetClientEndpoints returns the addresses a client is registered from
+func (s *UDPServer) GetClientEndpoints(clientID string) map[EndpointKind]*net.UDPAddr {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	client, exists := s.clients[clientID]
+	if !exists {
+		return nil
+	}
+
+	endpoints := make(map[EndpointKind]*net.UDPAddr, len(client.endpoints))
+	for kind, conn := range client.endpoints {
+		endpoints[kind] = conn.peerAddr
+	}
+	return endpoints
+}
+
+// GetClientByAddr returns the client registered at an address
+func (s *UDPServer) GetClientByAddr(addr *net.UDPAddr) (string, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	conn, exists := s.connections[addr.String()]
+	if !exists {
+		return "", false
+	}
+	return conn.clientID, true
+}
@@ -0,0 +1,207 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bOguzhan/NATbypass/internal/logging"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+)
+
+func TestClassifyEndpoint(t *testing.T) {
+	tests := []struct {
+		addr string
+		kind EndpointKind
+	}{
+		{"203.0.113.7:4000", EndpointIPv4},
+		{"192.168.1.20:4000", EndpointLAN},
+		{"10.1.2.3:4000", EndpointLAN},
+		{"127.0.0.1:4000", EndpointLAN},
+		{"[2001:db8::1]:4000", EndpointIPv6},
+		{"[fd00::1]:4000", EndpointLAN},
+		{"[fe80::1]:4000", EndpointLAN},
+	}
+
+	for _, test := range tests {
+		addr, err := net.ResolveUDPAddr("udp", test.addr)
+		assert.NoError(t, err)
+		assert.Equal(t, test.kind, ClassifyEndpoint(addr), test.addr)
+	}
+}
+
+func TestUDPServerClientIndex(t *testing.T) {
+	server := &UDPServer{
+		connections: make(map[string]*UDPConnection),
+		clients:     make(map[string]*udpClient),
+		logger:      logging.Discard(),
+	}
+
+	register := func(clientID, addr string) []*UDPConnection {
+		udpAddr, err := net.ResolveUDPAddr("udp", addr)
+		assert.NoError(t, err)
+
+		server.mutex.Lock()
+		defer server.mutex.Unlock()
+		return server.bindEndpointLocked(&UDPConnection{
+			peerAddr: udpAddr,
+			clientID: clientID,
+			endpoint: ClassifyEndpoint(udpAddr),
+		})
+	}
+
+	// One endpoint of each kind is kept side by side
+	assert.Empty(t, register("alice", "203.0.113.7:4000"))
+	assert.Empty(t, register("alice", "[2001:db8::1]:4000"))
+	assert.Empty(t, register("alice", "192.168.1.20:4000"))
+	assert.Len(t, server.GetClientEndpoints("alice"), 3)
+
+	// A new public IPv4 address supersedes the old mapping
+	evicted := register("alice", "203.0.113.7:5000")
+	assert.Len(t, evicted, 1)
+	assert.Equal(t, "203.0.113.7:4000", evicted[0].peerAddr.String())
+	assert.Equal(t, "203.0.113.7:5000", server.GetClientEndpoints("alice")[EndpointIPv4].String())
+	assert.Equal(t, 3, server.GetConnectionCount())
+
+	// Another client taking over an address removes it from the previous owner
+	evicted = register("bob", "192.168.1.20:4000")
+	assert.Len(t, evicted, 1)
+	assert.Equal(t, "alice", evicted[0].clientID)
+	assert.Len(t, server.GetClientEndpoints("alice"), 2)
+
+	owner, found := server.GetClientByAddr(&net.UDPAddr{IP: net.ParseIP("192.168.1.20"), Port: 4000})
+	assert.True(t, found)
+	assert.Equal(t, "bob", owner)
+
+	// Peers are reached on an endpoint of their own kind when possible
+	server.mutex.RLock()
+	target, found := server.endpointForLocked("alice", &net.UDPAddr{IP: net.ParseIP("2001:db8::2"), Port: 1})
+	assert.True(t, found)
+	assert.Equal(t, EndpointIPv6, target.endpoint)
+	target, found = server.endpointForLocked("alice", &net.UDPAddr{IP: net.ParseIP("192.168.1.30"), Port: 1})
+	assert.True(t, found)
+	assert.Equal(t, EndpointIPv4, target.endpoint)
+	server.mutex.RUnlock()
+}
+
+func TestUDPServerReregistrationEvictsStaleEntry(t *testing.T) {
+	server, err := NewUDPServer("127.0.0.1:12362")
+	assert.NoError(t, err)
+	logger, hook := logtest.NewNullLogger()
+	server.SetLogger(logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, server.Start(ctx))
+	defer server.Stop()
+
+	serverAddr := server.conn.LocalAddr().(*net.UDPAddr)
+	data, err := (&protocol.Packet{Type: protocol.PacketTypeRegistration, Payload: []byte("roamer")}).Serialize()
+	assert.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		client, err := net.DialUDP("udp", nil, serverAddr)
+		assert.NoError(t, err)
+		defer client.Close()
+
+		_, err = client.Write(data)
+		assert.NoError(t, err)
+
+		buffer := make([]byte, 1024)
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		_, err = client.Read(buffer)
+		assert.NoError(t, err)
+
+		owner, found := server.GetClientByAddr(client.LocalAddr().(*net.UDPAddr))
+		assert.True(t, found)
+		assert.Equal(t, "roamer", owner)
+	}
+
+	// The second registration replaced the first rather than adding to it
+	assert.Equal(t, 1, server.GetConnectionCount())
+	assert.Len(t, server.GetClientEndpoints("roamer"), 1)
+
+	// The eviction was logged with the client ID as a field
+	var evicted bool
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "Evicted superseded mapping" {
+			evicted = true
+			assert.Equal(t, "roamer", entry.Data["client_id"])
+		}
+	}
+	assert.True(t, evicted)
+}
+
+func TestParseLocalAddrs(t *testing.T) {
+	locals := parseLocalAddrs([]string{
+		"192.168.1.20:4000",
+		"203.0.113.7:4000", // Public, ignored
+		"example.com:4000", // Not a literal address
+		"10.0.0.5:0",       // No port
+		"127.0.0.1:4000",   // Loopback, ignored
+		"[fd00::5]:4000",
+	})
+
+	assert.Len(t, locals, 2)
+	assert.Equal(t, "192.168.1.20:4000", locals[0].String())
+	assert.Equal(t, "[fd00::5]:4000", locals[1].String())
+}
+
+func TestUDPServerSameNATHolePunch(t *testing.T) {
+	server, err := NewUDPServer("127.0.0.1:12363")
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, server.Start(ctx))
+	defer server.Stop()
+
+	serverAddr := server.conn.LocalAddr().(*net.UDPAddr)
+	exchange := func(client *net.UDPConn, packet *protocol.Packet) *protocol.Packet {
+		data, err := packet.Serialize()
+		assert.NoError(t, err)
+		_, err = client.Write(data)
+		assert.NoError(t, err)
+
+		buffer := make([]byte, 2048)
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, err := client.Read(buffer)
+		assert.NoError(t, err)
+		response, err := protocol.ParsePacket(buffer[:n])
+		assert.NoError(t, err)
+		return response
+	}
+
+	// Both clients reach the server from 127.0.0.1, as if behind one NAT
+	clients := make([]*net.UDPConn, 2)
+	for i, local := range []string{"192.168.1.10:5000", "192.168.1.11:5000"} {
+		clients[i], err = net.DialUDP("udp", nil, serverAddr)
+		assert.NoError(t, err)
+		defer clients[i].Close()
+
+		payload, err := protocol.EncodeRegistration(&protocol.Registration{
+			ClientID:   []string{"lan-a", "lan-b"}[i],
+			LocalAddrs: []string{local},
+		})
+		assert.NoError(t, err)
+		exchange(clients[i], &protocol.Packet{Type: protocol.PacketTypeRegistration, Payload: payload})
+	}
+
+	response := exchange(clients[0], &protocol.Packet{Type: protocol.PacketTypeHolePunch, Payload: []byte("lan-b")})
+	assert.Equal(t, protocol.PacketTypeHolePunchResponse, response.Type)
+	assert.Equal(t, []string{"192.168.1.11:5000", clients[1].LocalAddr().String()}, protocol.PunchCandidates(response.Payload))
+
+	// The target is told about the initiator's private endpoint as well
+	buffer := make([]byte, 2048)
+	clients[1].SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := clients[1].Read(buffer)
+	assert.NoError(t, err)
+	request, err := protocol.ParsePacket(buffer[:n])
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.PacketTypeHolePunch, request.Type)
+	assert.Equal(t, []string{"192.168.1.10:5000", clients[0].LocalAddr().String()}, protocol.PunchCandidates(request.Payload))
+}
@@ -1,16 +1,23 @@
 package nat
 
 import (
+	"context"
 	"errors"
-	"log"
 	"net"
 	"strconv"
 	"sync"
 	"time"
 
+	"github.com/bOguzhan/NATbypass/internal/config"
+	"github.com/bOguzhan/NATbypass/internal/logging"
 	"github.com/bOguzhan/NATbypass/pkg/protocol"
+	"github.com/bOguzhan/NATbypass/pkg/stats"
+	"github.com/bOguzhan/NATbypass/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Hole punching defaults, for settings a HolePunchConfig leaves zero
 const (
 	holePunchTimeout   = 30 * time.Second
 	holePunchRetries   = 5
@@ -18,6 +25,9 @@ const (
 	holePunchKeepAlive = 10 * time.Second
 )
 
+// ErrHolePunchTimeout is recorded when no acknowledgment arrives in time
+var ErrHolePunchTimeout = errors.New("hole punch not acknowledged")
+
 // HolePunchingSession represents an active hole punching attempt
 type HolePunchingSession struct {
 	localAddr      *net.UDPAddr
@@ -26,6 +36,12 @@ type HolePunchingSession struct {
 	conn           *net.UDPConn
 	sessionID      string
 	lastActivity   time.Time
+	lastPunch      time.Time // When the latest punch was sent, for RTT
+	stats          *stats.Counters
+	logger         logging.Logger  // Carries the session ID, nil discards
+	ctx            context.Context // Carries the session span
+	span           trace.Span
+	spanOnce       sync.Once
 	mutex          sync.RWMutex
 	keepAliveTimer *time.Timer
 	done           chan struct{}
@@ -37,10 +53,13 @@ type UDPHolePuncher struct {
 	mutex     sync.RWMutex
 	localPort int
 	baseConn  *net.UDPConn
+	logger    logging.Logger
+	config    config.HolePunchConfig
 }
 
-// NewUDPHolePuncher creates a new UDP hole punching manager
-func NewUDPHolePuncher(localPort int) (*UDPHolePuncher, error) {
+// NewUDPHolePuncher creates a new UDP hole punching manager timed by cfg.
+// A nil cfg, or a zero field in it, uses the defaults.
+func NewUDPHolePuncher(localPort int, cfg *config.HolePunchConfig) (*UDPHolePuncher, error) {
 	addr, err := net.ResolveUDPAddr("udp", ":"+strconv.Itoa(localPort))
 	if err != nil {
 		return nil, err
@@ -51,15 +70,39 @@ func NewUDPHolePuncher(localPort int) (*UDPHolePuncher, error) {
 		return nil, err
 	}
 
-	return &UDPHolePuncher{
+	puncher := &UDPHolePuncher{
 		sessions:  make(map[string]*HolePunchingSession),
 		localPort: localPort,
 		baseConn:  conn,
-	}, nil
+		logger:    logging.Discard(),
+	}
+	if cfg != nil {
+		puncher.config = *cfg
+	}
+	puncher.config.Attempts = intOr(puncher.config.Attempts, holePunchRetries)
+	puncher.config.Interval = durationOr(puncher.config.Interval, holePunchDelay)
+	puncher.config.Timeout = durationOr(puncher.config.Timeout, holePunchTimeout)
+	puncher.config.KeepAlive = durationOr(puncher.config.KeepAlive, holePunchKeepAlive)
+
+	return puncher, nil
+}
+
+// SetLogger sets the logger for sessions started from now on. It discards
+// everything by default.
+func (p *UDPHolePuncher) SetLogger(logger logging.Logger) {
+	p.logger = logging.OrDiscard(logger)
 }
 
 // InitiateHolePunch starts a hole punching session to a remote peer
 func (p *UDPHolePuncher) InitiateHolePunch(remoteAddrStr string, sessionID string) (*HolePunchingSession, error) {
+	return p.InitiateHolePunchContext(context.Background(), remoteAddrStr, sessionID)
+}
+
+// InitiateHolePunchContext starts a hole punching session traced as part of
+// the trace in ctx, typically one continued from a signaling message. The
+// session span lasts until the punch is acknowledged or given up, with a
+// child span for each punch round.
+func (p *UDPHolePuncher) InitiateHolePunchContext(ctx context.Context, remoteAddrStr string, sessionID string) (*HolePunchingSession, error) {
 	remoteAddr, err := net.ResolveUDPAddr("udp", remoteAddrStr)
 	if err != nil {
 		return nil, err
@@ -79,6 +122,12 @@ func (p *UDPHolePuncher) InitiateHolePunch(remoteAddrStr string, sessionID strin
 	// Get the local address after binding
 	localAddr = conn.LocalAddr().(*net.UDPAddr)
 
+	ctx, span := tracer.Start(ctx, "nat.hole_punch", trace.WithAttributes(
+		attribute.String("natbypass.session_id", sessionID),
+		attribute.String("natbypass.local_addr", localAddr.String()),
+		attribute.String("natbypass.remote_addr", remoteAddr.String()),
+	))
+
 	session := &HolePunchingSession{
 		localAddr:      localAddr,
 		remoteAddr:     remoteAddr,
@@ -86,7 +135,11 @@ func (p *UDPHolePuncher) InitiateHolePunch(remoteAddrStr string, sessionID strin
 		conn:           conn,
 		sessionID:      sessionID,
 		lastActivity:   time.Now(),
-		keepAliveTimer: time.NewTimer(holePunchKeepAlive),
+		stats:          stats.NewCounters(),
+		logger:         logging.OrDiscard(p.logger).WithField("session_id", sessionID),
+		ctx:            ctx,
+		span:           span,
+		keepAliveTimer: time.NewTimer(p.config.KeepAlive),
 		done:           make(chan struct{}),
 	}
 
@@ -113,32 +166,48 @@ func (p *UDPHolePuncher) doPunchHole(session *HolePunchingSession) {
 
 	data, err := packet.Serialize()
 	if err != nil {
-		log.Printf("Error serializing hole punch packet: %v", err)
+		session.log().WithError(err).Error("Error serializing hole punch packet")
+		session.endSpan(err)
 		return
 	}
 
 	// Send multiple punch packets to increase chances of success
-	for i := 0; i < holePunchRetries && !session.IsEstablished(); i++ {
-		log.Printf("Sending hole punch packet %d/%d to %s", i+1, holePunchRetries, session.remoteAddr.String())
-
-		_, err = session.conn.WriteToUDP(data, session.remoteAddr)
+	for i := 0; i < p.config.Attempts && !session.IsEstablished(); i++ {
+		remoteAddr := session.GetRemoteAddr()
+		session.log().WithFields(logging.Fields{
+			"round":       i + 1,
+			"rounds":      p.config.Attempts,
+			"remote_addr": remoteAddr.String(),
+		}).Debug("Sending hole punch packet")
+
+		_, round := tracer.Start(session.ctx, "nat.punch_round", trace.WithAttributes(
+			attribute.Int("natbypass.round", i+1),
+			attribute.String("natbypass.remote_addr", remoteAddr.String()),
+		))
+
+		_, err = session.conn.WriteToUDP(data, remoteAddr)
 		if err != nil {
-			log.Printf("Error sending hole punch packet: %v", err)
+			session.log().WithError(err).Warn("Error sending hole punch packet")
+			tracing.End(round, err)
 			continue
 		}
+		session.recordPunch(len(data), i > 0)
 
-		time.Sleep(holePunchDelay)
+		time.Sleep(p.config.Interval)
+		round.SetAttributes(attribute.Bool("natbypass.acknowledged", session.IsEstablished()))
+		round.End()
 	}
 
 	// Set a timeout for the hole punching attempt
-	timer := time.NewTimer(holePunchTimeout)
+	timer := time.NewTimer(p.config.Timeout)
 	defer timer.Stop()
 
 	select {
 	case <-timer.C:
 		// If we reach here, the hole punch wasn't acknowledged
 		if !session.IsEstablished() {
-			log.Printf("Hole punching to %s timed out", session.remoteAddr.String())
+			session.log().WithField("remote_addr", session.GetRemoteAddr().String()).Warn("Hole punching timed out")
+			session.endSpan(ErrHolePunchTimeout)
 			p.CloseSession(session.sessionID)
 		}
 	case <-session.done:
@@ -152,7 +221,7 @@ func (p *UDPHolePuncher) listenForSession(session *HolePunchingSession) {
 	buffer := make([]byte, udpReadBufferSize)
 
 	// Set read deadline to handle timeout
-	session.conn.SetReadDeadline(time.Now().Add(holePunchTimeout))
+	session.conn.SetReadDeadline(time.Now().Add(p.config.Timeout))
 
 	for {
 		n, addr, err := session.conn.ReadFromUDP(buffer)
@@ -165,53 +234,59 @@ func (p *UDPHolePuncher) listenForSession(session *HolePunchingSession) {
 			if ok && netErr.Timeout() {
 				// Check if session is established despite timeout
 				if !session.IsEstablished() {
-					log.Printf("Read timeout for session %s", session.sessionID)
+					session.log().Warn("Read timeout")
+					session.endSpan(ErrHolePunchTimeout)
 					p.CloseSession(session.sessionID)
 				}
 				return
 			}
 
-			log.Printf("Error reading from UDP in session %s: %v", session.sessionID, err)
+			session.log().WithError(err).Error("Error reading from UDP")
 			continue
 		}
 
 		// Process the received packet
 		packet, err := protocol.ParsePacket(buffer[:n])
 		if err != nil {
-			log.Printf("Error parsing packet: %v", err)
+			session.log().WithError(err).WithField("remote_addr", addr.String()).Debug("Error parsing packet")
 			continue
 		}
 
 		// Update session state
 		session.UpdateActivity()
+		session.stats.RecordReceived(n)
 
 		// Handle different packet types
 		switch packet.Type {
 		case protocol.PacketTypeHolePunch:
-			log.Printf("Received hole punch from %s", addr.String())
+			session.log().WithField("remote_addr", addr.String()).Debug("Received hole punch")
 			// Send acknowledgment
 			ack := &protocol.Packet{
 				Type:    protocol.PacketTypeHolePunchAck,
 				Payload: []byte("ok"),
 			}
 			ackData, _ := ack.Serialize()
-			session.conn.WriteToUDP(ackData, addr)
+			if _, err := session.conn.WriteToUDP(ackData, addr); err == nil {
+				session.stats.RecordSent(len(ackData))
+			}
 
 			// Update session with the actual remote address
 			p.updateSessionRemoteAddr(session, addr)
 
 		case protocol.PacketTypeHolePunchAck:
-			log.Printf("Received hole punch acknowledgment from %s", addr.String())
+			session.log().WithField("remote_addr", addr.String()).Debug("Received hole punch acknowledgment")
 			// Update session with the actual remote address and mark as established
 			p.updateSessionRemoteAddr(session, addr)
+			session.recordAck()
 			session.SetEstablished(true)
+			session.endSpan(nil)
 
 		case protocol.PacketTypeKeepAlive:
 			// Just update activity timestamp, which was done above
 
 		case protocol.PacketTypeData:
 			// In a real implementation, this would be passed to application layer
-			log.Printf("Received %d bytes of data in session %s", len(packet.Payload), session.sessionID)
+			session.log().WithField("size", len(packet.Payload)).Debug("Received data")
 		}
 
 		// If this was the first packet after timeout, remove deadline
@@ -228,8 +303,10 @@ func (p *UDPHolePuncher) updateSessionRemoteAddr(session *HolePunchingSession, a
 
 	// Only update if the address is different
 	if session.remoteAddr.String() != addr.String() {
-		log.Printf("Updating remote address for session %s: %s -> %s",
-			session.sessionID, session.remoteAddr.String(), addr.String())
+		session.log().WithFields(logging.Fields{
+			"previous_addr": session.remoteAddr.String(),
+			"remote_addr":   addr.String(),
+		}).Info("Updating remote address")
 		session.remoteAddr = addr
 	}
 }
@@ -246,6 +323,7 @@ func (p *UDPHolePuncher) CloseSession(sessionID string) {
 	if exists {
 		close(session.done)
 		session.conn.Close()
+		session.endSpan(nil)
 	}
 }
 
@@ -271,10 +349,15 @@ func (s *HolePunchingSession) SetEstablished(established bool) {
 	s.established = established
 
 	if established {
-		log.Printf("Session %s established with %s", s.sessionID, s.remoteAddr.String())
+		s.log().WithField("remote_addr", s.remoteAddr.String()).Info("Session established")
 	}
 }
 
+// log returns the session's logger
+func (s *HolePunchingSession) log() logging.Logger {
+	return logging.OrDiscard(s.logger)
+}
+
 // UpdateActivity updates the last activity timestamp
 func (s *HolePunchingSession) UpdateActivity() {
 	s.mutex.Lock()
@@ -282,6 +365,52 @@ func (s *HolePunchingSession) UpdateActivity() {
 	s.lastActivity = time.Now()
 }
 
+// recordPunch counts a punch packet as a probe, and as a retransmit when it
+// repeats an unanswered one
+func (s *HolePunchingSession) recordPunch(size int, retry bool) {
+	s.mutex.Lock()
+	s.lastPunch = time.Now()
+	s.mutex.Unlock()
+
+	s.stats.RecordSent(size)
+	s.stats.RecordProbe()
+	if retry {
+		s.stats.RecordRetransmit()
+	}
+}
+
+// recordAck measures the round trip from the latest punch to its acknowledgment
+func (s *HolePunchingSession) recordAck() {
+	s.mutex.RLock()
+	sentAt := s.lastPunch
+	s.mutex.RUnlock()
+
+	if !sentAt.IsZero() {
+		s.stats.RecordRTT(time.Since(sentAt))
+	}
+}
+
+// endSpan ends the session span once, recording how the punch went
+func (s *HolePunchingSession) endSpan(err error) {
+	if s.span == nil {
+		return
+	}
+	s.spanOnce.Do(func() {
+		snapshot := s.stats.Snapshot()
+		s.span.SetAttributes(
+			attribute.Bool("natbypass.established", s.IsEstablished()),
+			attribute.String("natbypass.remote_addr", s.GetRemoteAddr().String()),
+			attribute.Int64("natbypass.rtt_ns", int64(snapshot.LastRTT)),
+		)
+		tracing.End(s.span, err)
+	})
+}
+
+// Stats returns the session's traffic counters and punch round trip times
+func (s *HolePunchingSession) Stats() stats.Snapshot {
+	return s.stats.Snapshot()
+}
+
 // GetRemoteAddr returns the remote address for this session
 func (s *HolePunchingSession) GetRemoteAddr() *net.UDPAddr {
 	s.mutex.RLock()
@@ -308,6 +437,23 @@ func (s *HolePunchingSession) SendData(data []byte) error {
 	_, err = s.conn.WriteToUDP(packetData, s.GetRemoteAddr())
 	if err == nil {
 		s.UpdateActivity()
+		s.stats.RecordSent(len(packetData))
 	}
 	return err
 }
+
+// intOr returns value, or fallback if value is not positive
+func intOr(value, fallback int) int {
+	if value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// durationOr returns value, or fallback if value is not positive
+func durationOr(value, fallback time.Duration) time.Duration {
+	if value <= 0 {
+		return fallback
+	}
+	return value
+}
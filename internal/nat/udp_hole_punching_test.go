@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/bOguzhan/NATbypass/internal/config"
 	"github.com/bOguzhan/NATbypass/pkg/protocol"
 	"github.com/stretchr/testify/assert"
 )
@@ -166,3 +167,14 @@ func TestSimulatedLocalHolePunch(t *testing.T) {
 	// Clean up
 	close(session1.done)
 }
+
+func TestUDPHolePuncherConfig(t *testing.T) {
+	puncher, err := NewUDPHolePuncher(0, &config.HolePunchConfig{Attempts: 2, Timeout: time.Second})
+	assert.NoError(t, err)
+	defer puncher.baseConn.Close()
+
+	assert.Equal(t, 2, puncher.config.Attempts)
+	assert.Equal(t, time.Second, puncher.config.Timeout)
+	assert.Equal(t, holePunchDelay, puncher.config.Interval, "zero settings use the defaults")
+	assert.Equal(t, holePunchKeepAlive, puncher.config.KeepAlive)
+}
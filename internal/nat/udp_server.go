@@ -2,13 +2,17 @@ package nat
 
 import (
 	"context"
-	"log"
 	"net"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/bOguzhan/NATbypass/internal/config"
+	"github.com/bOguzhan/NATbypass/internal/logging"
 	"github.com/bOguzhan/NATbypass/internal/utils"
 	"github.com/bOguzhan/NATbypass/pkg/protocol"
+	"github.com/bOguzhan/NATbypass/pkg/stats"
 )
 
 const (
@@ -18,22 +22,34 @@ const (
 
 // UDPServer handles UDP connections and NAT traversal operations
 type UDPServer struct {
+	*eventHub
 	conn        *net.UDPConn
 	listenAddr  string
-	connections map[string]*UDPConnection
+	connections map[string]*UDPConnection // Keyed by peer address
+	clients     map[string]*udpClient     // Keyed by client ID
 	mutex       sync.RWMutex
 	stopChan    chan struct{}
-	packetChan  chan *protocol.Packet
 	cleanup     *time.Ticker
 	maxIdleTime time.Duration
+	auth        *PacketAuthenticator
+	config      config.UDPServerConfig
+	batch       udpBatchConn
+	workers     *udpWorkerPool
+	outbound    chan udpDatagram
+	counters    udpServerCounters
+	logger      logging.Logger
 }
 
 // UDPConnection represents a UDP connection with a peer
 type UDPConnection struct {
 	peerAddr    *net.UDPAddr
+	createdAt   time.Time
 	lastActive  time.Time
 	established bool
 	clientID    string
+	keyID       uint32 // Key the client authenticated with, zero if unauthenticated
+	endpoint    EndpointKind
+	stats       *stats.Counters
 }
 
 // NewUDPServer creates a new UDP server instance
@@ -49,28 +65,83 @@ func NewUDPServer(listenAddr string) (*UDPServer, error) {
 	}
 
 	server := &UDPServer{
+		eventHub:    newEventHub(),
 		conn:        conn,
 		listenAddr:  listenAddr,
 		connections: make(map[string]*UDPConnection),
+		clients:     make(map[string]*udpClient),
 		stopChan:    make(chan struct{}),
-		packetChan:  make(chan *protocol.Packet, 100),
 		cleanup:     time.NewTicker(udpCleanupInterval),
 		maxIdleTime: 10 * time.Minute,
+		logger:      logging.Discard(),
+		config: config.UDPServerConfig{
+			PacketBufferSize: udpReadBufferSize,
+			QueueDepth:       defaultUDPQueueDepth,
+			DropPolicy:       UDPDropNewest,
+			BatchSize:        defaultUDPBatchSize,
+		},
 	}
 
 	return server, nil
 }
 
+// SetConfig applies packet processing settings: worker count, queue depth,
+// drop policy, batch size, read buffer size and idle timeout. Zero values keep
+// the defaults. It must be called before Start.
+func (s *UDPServer) SetConfig(cfg *config.UDPServerConfig) {
+	if cfg.PacketBufferSize > 0 {
+		s.config.PacketBufferSize = cfg.PacketBufferSize
+	}
+	if cfg.Workers > 0 {
+		s.config.Workers = cfg.Workers
+	}
+	if cfg.QueueDepth > 0 {
+		s.config.QueueDepth = cfg.QueueDepth
+	}
+	if cfg.DropPolicy != "" {
+		s.config.DropPolicy = cfg.DropPolicy
+	}
+	if cfg.BatchSize > 0 {
+		s.config.BatchSize = cfg.BatchSize
+	}
+	if cfg.IdleTimeout > 0 {
+		s.maxIdleTime = cfg.IdleTimeout
+	}
+}
+
+// SetLogger sets the logger, which discards everything by default. It must
+// be called before Start.
+func (s *UDPServer) SetLogger(logger logging.Logger) {
+	s.logger = logging.OrDiscard(logger)
+}
+
+// SetAuthenticator requires all incoming packets to be authenticated.
+// It must be called before Start.
+func (s *UDPServer) SetAuthenticator(auth *PacketAuthenticator) {
+	s.auth = auth
+}
+
 // Start begins the UDP server operation
 func (s *UDPServer) Start(ctx context.Context) error {
-	log.Printf("Starting UDP server on %s", s.listenAddr)
+	s.logger.WithField("listen_addr", s.listenAddr).Info("Starting UDP server")
+
+	workers := s.config.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	s.workers = newUDPWorkerPool(workers, s.config.QueueDepth, s.config.DropPolicy, &s.counters)
+	s.batch = newUDPBatchConn(s.conn, s.config.BatchSize, s.config.PacketBufferSize)
+	s.outbound = make(chan udpDatagram, s.config.QueueDepth)
+
+	// Start packet processors
+	s.workers.start(ctx, s.stopChan, s.handlePacket)
+
+	// Start packet sender
+	go s.sendPackets()
 
 	// Start packet listener
 	go s.listenPackets()
 
-	// Start packet processor
-	go s.processPackets(ctx)
-
 	// Start connection cleanup routine
 	go s.cleanupConnections(ctx)
 
@@ -81,53 +152,85 @@ func (s *UDPServer) Start(ctx context.Context) error {
 func (s *UDPServer) Stop() error {
 	close(s.stopChan)
 	s.cleanup.Stop()
+
+	s.mutex.RLock()
+	for _, conn := range s.connections {
+		s.emit(s.connectionEvent(EventClose, conn))
+	}
+	s.mutex.RUnlock()
+	s.eventHub.close()
+
 	return s.conn.Close()
 }
 
-// listenPackets continuously reads incoming UDP packets
+// listenPackets continuously reads incoming UDP packets and hands them to
+// the worker pool. The reader never blocks on a full queue; the drop policy
+// decides which packet is discarded instead.
 func (s *UDPServer) listenPackets() {
-	buffer := make([]byte, udpReadBufferSize)
-
 	for {
 		select {
 		case <-s.stopChan:
 			return
 		default:
-			n, addr, err := s.conn.ReadFromUDP(buffer)
+			datagrams, err := s.batch.readBatch()
 			if err != nil {
 				if utils.IsClosedNetworkError(err) {
 					return
 				}
-				log.Printf("Error reading from UDP: %v", err)
+				s.logger.WithError(err).Error("Error reading from UDP")
 				continue
 			}
 
-			// Copy buffer to prevent data race with the next read
-			data := make([]byte, n)
-			copy(data, buffer[:n])
+			for _, datagram := range datagrams {
+				atomic.AddUint64(&s.counters.received, 1)
 
-			packet, err := protocol.ParsePacket(data)
-			if err != nil {
-				log.Printf("Error parsing packet from %s: %v", addr.String(), err)
-				continue
-			}
+				packet, err := protocol.ParsePacket(datagram.data)
+				if err != nil {
+					atomic.AddUint64(&s.counters.parseErrors, 1)
+					s.logger.WithError(err).WithField("remote_addr", datagram.addr.String()).
+						Debug("Error parsing packet")
+					continue
+				}
 
-			packet.SourceAddr = addr
-			s.packetChan <- packet
+				packet.SourceAddr = datagram.addr
+				s.workers.dispatch(packet)
+			}
 		}
 	}
 }
 
-// processPackets handles incoming packets based on their type
-func (s *UDPServer) processPackets(ctx context.Context) {
+// sendPackets writes queued responses, batching whatever has accumulated
+// since the last write into a single call
+func (s *UDPServer) sendPackets() {
+	batch := make([]udpDatagram, 0, s.config.BatchSize)
+
 	for {
 		select {
-		case <-ctx.Done():
-			return
 		case <-s.stopChan:
 			return
-		case packet := <-s.packetChan:
-			s.handlePacket(packet)
+		case datagram := <-s.outbound:
+			batch = append(batch[:0], datagram)
+
+		collect:
+			for len(batch) < s.config.BatchSize {
+				select {
+				case datagram := <-s.outbound:
+					batch = append(batch, datagram)
+				default:
+					break collect
+				}
+			}
+
+			sent, err := s.batch.writeBatch(batch)
+			if err != nil {
+				if utils.IsClosedNetworkError(err) {
+					return
+				}
+				atomic.AddUint64(&s.counters.sendDropped, uint64(len(batch)-len(sent)))
+				s.logger.WithError(err).WithField("dropped", len(batch)-len(sent)).Error("Error sending UDP packets")
+			}
+			atomic.AddUint64(&s.counters.sent, uint64(len(sent)))
+			s.recordSent(sent)
 		}
 	}
 }
@@ -137,62 +240,170 @@ func (s *UDPServer) handlePacket(packet *protocol.Packet) {
 	addr := packet.SourceAddr.(*net.UDPAddr)
 	addrKey := addr.String()
 
+	if s.auth != nil && !s.authorizePacket(packet, addrKey) {
+		return
+	}
+
+	if s.hasHandlers(EventPacket) {
+		event := ConnectionEvent{
+			Type:         EventPacket,
+			Protocol:     "udp",
+			ConnectionID: addrKey,
+			RemoteAddr:   addr,
+			LocalAddr:    s.conn.LocalAddr(),
+			Packet:       packet,
+		}
+		s.mutex.RLock()
+		if conn, exists := s.connections[addrKey]; exists {
+			event.ClientID = conn.clientID
+		}
+		s.mutex.RUnlock()
+		s.emit(event)
+	}
+
 	switch packet.Type {
 	case protocol.PacketTypeRegistration:
 		s.handleRegistration(packet, addr)
 	case protocol.PacketTypeHolePunch:
 		s.handleHolePunch(packet, addr)
-	case protocol.PacketTypeData:
-		s.handleDataPacket(packet, addr)
-	case protocol.PacketTypeKeepAlive:
-		s.updateConnectionTimestamp(addrKey)
+	case protocol.PacketTypeData, protocol.PacketTypeKeepAlive:
+		// Only refresh the activity timestamp, below
 	default:
-		log.Printf("Unknown packet type from %s: %d", addrKey, packet.Type)
+		s.logger.WithFields(logging.Fields{
+			"remote_addr": addrKey,
+			"packet_type": packet.Type,
+		}).Warn("Unknown packet type")
+	}
+
+	// Counted after handling so a registration counts towards the
+	// connection it creates. Every accepted packet keeps it alive.
+	s.mutex.Lock()
+	if conn, exists := s.connections[addrKey]; exists {
+		conn.lastActive = time.Now()
+		conn.stats.RecordReceived(packet.Size())
+	}
+	s.mutex.Unlock()
+}
+
+// authorizePacket verifies a packet's MAC and checks that the signing client
+// is the one registered at the source address. Registration packets may only
+// claim the client ID they were signed for.
+func (s *UDPServer) authorizePacket(packet *protocol.Packet, addrKey string) bool {
+	if err := s.auth.Authenticate(packet); err != nil {
+		s.logger.WithError(err).WithField("remote_addr", addrKey).Warn("Rejected packet")
+		return false
+	}
+
+	if packet.Type == protocol.PacketTypeRegistration {
+		registration, err := protocol.DecodeRegistration(packet.Payload)
+		if err != nil || registration.ClientID != packet.Auth.ClientID {
+			s.logger.WithField("remote_addr", addrKey).
+				Warn("Rejected registration: client ID does not match key")
+			return false
+		}
+		return true
+	}
+
+	s.mutex.RLock()
+	conn, exists := s.connections[addrKey]
+	s.mutex.RUnlock()
+
+	if !exists || conn.clientID != packet.Auth.ClientID {
+		s.logger.WithFields(logging.Fields{
+			"remote_addr": addrKey,
+			"sender_id":   packet.Auth.ClientID,
+		}).Warn("Rejected packet: sender is not the registered client")
+		return false
 	}
+
+	return true
 }
 
 // handleRegistration processes client registration packets
 func (s *UDPServer) handleRegistration(packet *protocol.Packet, addr *net.UDPAddr) {
-	clientID := string(packet.Payload)
+	registration, err := protocol.DecodeRegistration(packet.Payload)
+	if err != nil {
+		s.logger.WithError(err).WithField("remote_addr", addr.String()).Warn("Invalid registration")
+		return
+	}
+	clientID := registration.ClientID
 	addrKey := addr.String()
+	localAddrs := parseLocalAddrs(registration.LocalAddrs)
+
+	var keyID uint32
+	if packet.Auth != nil {
+		keyID = packet.Auth.KeyID
+	}
 
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
 
 	// Create or update connection
-	s.connections[addrKey] = &UDPConnection{
+	previous, existed := s.connections[addrKey]
+	existed = existed && previous.clientID == clientID
+	conn := &UDPConnection{
 		peerAddr:    addr,
+		createdAt:   time.Now(),
 		lastActive:  time.Now(),
 		established: true,
 		clientID:    clientID,
+		keyID:       keyID,
+		endpoint:    ClassifyEndpoint(addr),
+		stats:       stats.NewCounters(),
 	}
+	if existed {
+		// Re-registering keeps the traffic counted so far
+		conn.createdAt = previous.createdAt
+		conn.stats = previous.stats
+	}
+	for _, evicted := range s.bindEndpointLocked(conn) {
+		s.emit(s.connectionEvent(EventClose, evicted))
+	}
+	s.clients[clientID].localAddrs = localAddrs
+
+	s.logger.WithFields(logging.Fields{
+		"client_id":   clientID,
+		"endpoint":    conn.endpoint,
+		"remote_addr": addrKey,
+	}).Info("Client registered")
+	if !existed {
+		s.emit(s.connectionEvent(EventConnect, conn))
+	}
+	s.emit(s.connectionEvent(EventRegister, conn))
 
-	log.Printf("Client %s registered from %s", clientID, addrKey)
-
-	// Send acknowledgment
+	// Acknowledge with the address the client was seen from
 	response := &protocol.Packet{
 		Type:    protocol.PacketTypeRegistrationAck,
-		Payload: []byte("registered"),
+		Payload: []byte(addrKey),
 	}
+	s.signPacket(response, clientID, keyID)
 	s.sendPacket(response, addr)
 }
 
-// handleHolePunch processes NAT hole punching packets
+// handleHolePunch processes NAT hole punching packets. Peers behind the same
+// NAT are given each other's private endpoints first, since many routers
+// don't hairpin traffic sent to their own public address.
 func (s *UDPServer) handleHolePunch(packet *protocol.Packet, addr *net.UDPAddr) {
 	targetID := string(packet.Payload)
 	sourceAddrKey := addr.String()
 
 	// Find target client connection
-	var targetAddr *net.UDPAddr
+	var target *UDPConnection
 	var targetFound bool
+	var targetLocals, sourceLocals []*net.UDPAddr
+	var sourceKeyID uint32
+	var sourceID string
 
 	s.mutex.RLock()
-	for _, conn := range s.connections {
-		if conn.clientID == targetID {
-			targetAddr = conn.peerAddr
-			targetFound = true
-			break
-		}
+	if conn, exists := s.endpointForLocked(targetID, addr); exists {
+		target = conn
+		targetLocals = s.clients[targetID].localAddrs
+		targetFound = true
+	}
+	if source, exists := s.connections[sourceAddrKey]; exists {
+		sourceID = source.clientID
+		sourceKeyID = source.keyID
+		sourceLocals = s.clients[sourceID].localAddrs
 	}
 	s.mutex.RUnlock()
 
@@ -202,55 +413,82 @@ func (s *UDPServer) handleHolePunch(packet *protocol.Packet, addr *net.UDPAddr)
 			Type:    protocol.PacketTypeError,
 			Payload: []byte("target client not found"),
 		}
+		s.signPacket(response, sourceID, sourceKeyID)
 		s.sendPacket(response, addr)
 		return
 	}
 
+	sameNAT := addr.IP.Equal(target.peerAddr.IP)
+	if sameNAT {
+		s.logger.WithFields(logging.Fields{
+			"client_id": sourceID,
+			"target_id": targetID,
+			"public_ip": addr.IP.String(),
+		}).Debug("Clients share a public address")
+	}
+
 	// Send source client's address to target client
 	punchRequest := &protocol.Packet{
 		Type:    protocol.PacketTypeHolePunch,
-		Payload: []byte(sourceAddrKey),
+		Payload: punchPayload(sourceID, addr, sourceLocals, sameNAT),
 	}
-	s.sendPacket(punchRequest, targetAddr)
+	s.signPacket(punchRequest, targetID, target.keyID)
+	s.sendPacket(punchRequest, target.peerAddr)
 
 	// Send target client's address to source client
 	response := &protocol.Packet{
 		Type:    protocol.PacketTypeHolePunchResponse,
-		Payload: []byte(targetAddr.String()),
+		Payload: punchPayload(targetID, target.peerAddr, targetLocals, sameNAT),
 	}
+	s.signPacket(response, sourceID, sourceKeyID)
 	s.sendPacket(response, addr)
 
-	log.Printf("Initiated hole punch between %s and %s", sourceAddrKey, targetAddr.String())
+	s.logger.WithFields(logging.Fields{
+		"client_id":          sourceID,
+		"remote_addr":        sourceAddrKey,
+		"target_id":          targetID,
+		"target_remote_addr": target.peerAddr.String(),
+		"same_nat":           sameNAT,
+	}).Info("Initiated hole punch")
 }
 
-// handleDataPacket processes data transfer packets
-func (s *UDPServer) handleDataPacket(packet *protocol.Packet, addr *net.UDPAddr) {
-	// In a real implementation, this might forward the data to the appropriate target
-	// For now, we'll just update the connection timestamp
-	s.updateConnectionTimestamp(addr.String())
+// signPacket signs a packet for an authenticated client so it can verify the
+// response came from the rendezvous server
+func (s *UDPServer) signPacket(packet *protocol.Packet, clientID string, keyID uint32) {
+	if s.auth == nil || clientID == "" || keyID == 0 {
+		return
+	}
+
+	if err := s.auth.Sign(packet, clientID, keyID); err != nil {
+		s.logger.WithError(err).WithField("client_id", clientID).Error("Error signing packet")
+	}
 }
 
 // sendPacket sends a packet to the specified address
 func (s *UDPServer) sendPacket(packet *protocol.Packet, addr *net.UDPAddr) {
 	data, err := packet.Serialize()
 	if err != nil {
-		log.Printf("Error serializing packet: %v", err)
+		s.logger.WithError(err).Error("Error serializing packet")
 		return
 	}
 
-	_, err = s.conn.WriteToUDP(data, addr)
-	if err != nil {
-		log.Printf("Error sending packet to %s: %v", addr.String(), err)
+	select {
+	case s.outbound <- udpDatagram{data: data, addr: addr}:
+	default:
+		atomic.AddUint64(&s.counters.sendDropped, 1)
+		s.logger.WithField("remote_addr", addr.String()).Debug("Dropped packet: send queue full")
 	}
 }
 
-// updateConnectionTimestamp updates the last active time for a connection
-func (s *UDPServer) updateConnectionTimestamp(addrKey string) {
-	s.mutex.Lock()
-	defer s.mutex.Unlock()
+// recordSent counts written datagrams towards their connections
+func (s *UDPServer) recordSent(batch []udpDatagram) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 
-	if conn, exists := s.connections[addrKey]; exists {
-		conn.lastActive = time.Now()
+	for _, datagram := range batch {
+		if conn, exists := s.connections[datagram.addr.String()]; exists {
+			conn.stats.RecordSent(len(datagram.data))
+		}
 	}
 }
 
@@ -267,15 +505,108 @@ func (s *UDPServer) cleanupConnections(ctx context.Context) {
 			now := time.Now()
 			for addr, conn := range s.connections {
 				if now.Sub(conn.lastActive) > s.maxIdleTime {
-					log.Printf("Removing idle connection: %s", addr)
+					s.logger.WithFields(logging.Fields{
+						"remote_addr": addr,
+						"client_id":   conn.clientID,
+					}).Info("Removing idle connection")
 					delete(s.connections, addr)
+					s.unbindEndpointLocked(conn)
+					s.emit(s.connectionEvent(EventIdleTimeout, conn))
+					s.emit(s.connectionEvent(EventClose, conn))
 				}
 			}
 			s.mutex.Unlock()
+
+			if s.auth != nil {
+				s.auth.PruneExpired()
+			}
 		}
 	}
 }
 
+// connectionEvent builds a lifecycle event for a UDP peer
+func (s *UDPServer) connectionEvent(eventType EventType, conn *UDPConnection) ConnectionEvent {
+	return ConnectionEvent{
+		Type:         eventType,
+		Protocol:     "udp",
+		ConnectionID: conn.peerAddr.String(),
+		ClientID:     conn.clientID,
+		RemoteAddr:   conn.peerAddr,
+		LocalAddr:    s.conn.LocalAddr(),
+		Timestamp:    time.Now(),
+	}
+}
+
+// GetStats returns packet processing and drop counters
+func (s *UDPServer) GetStats() UDPServerStats {
+	stats := UDPServerStats{
+		Connections:   s.GetConnectionCount(),
+		Received:      atomic.LoadUint64(&s.counters.received),
+		Processed:     atomic.LoadUint64(&s.counters.processed),
+		ParseErrors:   atomic.LoadUint64(&s.counters.parseErrors),
+		DroppedNewest: atomic.LoadUint64(&s.counters.droppedNewest),
+		DroppedOldest: atomic.LoadUint64(&s.counters.droppedOldest),
+		Sent:          atomic.LoadUint64(&s.counters.sent),
+		SendDropped:   atomic.LoadUint64(&s.counters.sendDropped),
+	}
+
+	if s.workers != nil {
+		stats.Workers = len(s.workers.shards)
+		stats.Queued = s.workers.queued()
+	}
+
+	return stats
+}
+
+// GetConnectionStats returns the traffic counters of the peer at addr
+func (s *UDPServer) GetConnectionStats(addr string) (stats.Snapshot, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	conn, exists := s.connections[addr]
+	if !exists {
+		return stats.Snapshot{}, false
+	}
+	return conn.stats.Snapshot(), true
+}
+
+// ConnectionStats returns the traffic counters of every peer by address
+func (s *UDPServer) ConnectionStats() map[string]stats.Snapshot {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	snapshots := make(map[string]stats.Snapshot, len(s.connections))
+	for addr, conn := range s.connections {
+		snapshots[addr] = conn.stats.Snapshot()
+	}
+	return snapshots
+}
+
+// LocalAddr returns the address the server listens on
+func (s *UDPServer) LocalAddr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Sessions lists the registered peers
+func (s *UDPServer) Sessions() []Session {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	sessions := make([]Session, 0, len(s.connections))
+	for addr, conn := range s.connections {
+		sessions = append(sessions, Session{
+			ID:          addr,
+			Protocol:    "udp",
+			ClientID:    conn.clientID,
+			RemoteAddrs: []string{addr},
+			CreatedAt:   conn.createdAt,
+			LastActive:  conn.lastActive,
+			Stats:       conn.stats.Snapshot(),
+		})
+	}
+	return sortSessions(sessions)
+}
+
 // GetConnectionCount returns the number of active connections
 func (s *UDPServer) GetConnectionCount() int {
 	s.mutex.RLock()
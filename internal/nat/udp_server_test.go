@@ -74,6 +74,14 @@ func TestUDPServerBasicOperations(t *testing.T) {
 	assert.Eventually(t, func() bool {
 		return server.GetConnectionCount() == 1
 	}, 2*time.Second, 100*time.Millisecond)
+
+	// The registration and its ack are counted on the connection
+	assert.Eventually(t, func() bool {
+		snapshot, exists := server.GetConnectionStats(clientConn.LocalAddr().String())
+		return exists &&
+			snapshot.PacketsIn == 1 && snapshot.BytesIn == uint64(len(packetData)) &&
+			snapshot.PacketsOut == 1 && snapshot.BytesOut == uint64(n)
+	}, 2*time.Second, 50*time.Millisecond)
 }
 
 func TestUDPServerHolePunching(t *testing.T) {
@@ -286,7 +294,7 @@ func TestUDPServerHolePunching(t *testing.T) {
 
 func TestUDPHolePuncher(t *testing.T) {
 	// Create hole puncher with a dynamically assigned port
-	puncher, err := NewUDPHolePuncher(0) // 0 means OS will assign a port
+	puncher, err := NewUDPHolePuncher(0, nil) // 0 means OS will assign a port
 	assert.NoError(t, err)
 	defer puncher.CloseSession("test-session")
 
@@ -395,3 +403,92 @@ func TestUDPServerConcurrentOperations(t *testing.T) {
 		return server.GetConnectionCount() == clientCount
 	}, 2*time.Second, 100*time.Millisecond)
 }
+
+func TestUDPServerAuthentication(t *testing.T) {
+	listenAddr := "127.0.0.1:12350"
+	masterKey := []byte("test-master-secret")
+
+	server, err := NewUDPServer(listenAddr)
+	assert.NoError(t, err)
+	server.SetAuthenticator(NewPacketAuthenticator(masterKey, time.Hour))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err = server.Start(ctx)
+	assert.NoError(t, err)
+	defer server.Stop()
+
+	serverAddr, err := net.ResolveUDPAddr("udp", listenAddr)
+	assert.NoError(t, err)
+
+	clientConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.NoError(t, err)
+	defer clientConn.Close()
+
+	clientID := "auth-client"
+	keyID := uint32(time.Now().Unix())
+	key := protocol.DeriveClientKey(masterKey, clientID, keyID)
+
+	send := func(packet *protocol.Packet) {
+		data, err := packet.Serialize()
+		assert.NoError(t, err)
+		_, err = clientConn.WriteToUDP(data, serverAddr)
+		assert.NoError(t, err)
+	}
+
+	readResponse := func() (*protocol.Packet, error) {
+		buffer := make([]byte, 4096)
+		clientConn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		n, _, err := clientConn.ReadFromUDP(buffer)
+		if err != nil {
+			return nil, err
+		}
+		return protocol.ParsePacket(buffer[:n])
+	}
+
+	// Unauthenticated registration is dropped
+	send(&protocol.Packet{Type: protocol.PacketTypeRegistration, Payload: []byte(clientID)})
+	_, err = readResponse()
+	assert.Error(t, err)
+	assert.Equal(t, 0, server.GetConnectionCount())
+
+	// Registration for another client ID with our key is dropped
+	hijack := &protocol.Packet{Type: protocol.PacketTypeRegistration, Payload: []byte("victim")}
+	assert.NoError(t, hijack.Sign(clientID, keyID, 1, key))
+	send(hijack)
+	_, err = readResponse()
+	assert.Error(t, err)
+
+	// Properly signed registration is accepted and the ack is signed
+	registration := &protocol.Packet{Type: protocol.PacketTypeRegistration, Payload: []byte(clientID)}
+	assert.NoError(t, registration.Sign(clientID, keyID, 2, key))
+	send(registration)
+
+	response, err := readResponse()
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.PacketTypeRegistrationAck, response.Type)
+	assert.NoError(t, response.Verify(key))
+	assert.Equal(t, 1, server.GetConnectionCount())
+
+	// Replaying the same sequence number is rejected
+	send(registration)
+	_, err = readResponse()
+	assert.Error(t, err)
+}
+
+func TestReplayWindow(t *testing.T) {
+	window := &replayWindow{}
+
+	assert.True(t, window.accept(10))
+	assert.False(t, window.accept(10))
+
+	// Out of order within the window is fine, once
+	assert.True(t, window.accept(8))
+	assert.False(t, window.accept(8))
+	assert.True(t, window.accept(11))
+
+	// Far behind the highest sequence is rejected
+	assert.True(t, window.accept(11+replayWindowSize))
+	assert.False(t, window.accept(9))
+}
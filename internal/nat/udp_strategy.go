@@ -2,10 +2,13 @@ package nat
 
 import (
 	"context"
+	"errors"
 	"net"
 	"time"
 
+	"github.com/bOguzhan/NATbypass/internal/config"
 	"github.com/bOguzhan/NATbypass/internal/discovery"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
 )
 
 // UDPHolePunchingStrategy implements UDP hole punching NAT traversal
@@ -15,11 +18,12 @@ type UDPHolePunchingStrategy struct {
 	maxRetries     int
 }
 
-// newUDPHolePunchingStrategy creates a new UDP hole punching strategy
-func newUDPHolePunchingStrategy() *UDPHolePunchingStrategy {
+// newUDPHolePunchingStrategy creates a UDP hole punching strategy that
+// punches cfg.Attempts times, cfg.Interval apart
+func newUDPHolePunchingStrategy(cfg *config.HolePunchConfig) *UDPHolePunchingStrategy {
 	return &UDPHolePunchingStrategy{
-		initialTimeout: 500 * time.Millisecond,
-		maxRetries:     5,
+		initialTimeout: durationOr(cfg.Interval, holePunchDelay),
+		maxRetries:     intOr(cfg.Attempts, holePunchRetries),
 	}
 }
 
@@ -38,8 +42,14 @@ func (s *UDPHolePunchingStrategy) EstimateSuccessRate(localNATType, remoteNATTyp
 	// Success rates based on combinations of NAT types
 	// These are approximate values based on research and empirical data
 
-	// Full cone to anything usually works well
-	if localNATType == discovery.NATFullCone || remoteNATType == discovery.NATFullCone {
+	// Without NAT on either side (typically IPv6) no hole needs punching
+	if localNATType == discovery.NATNone && remoteNATType == discovery.NATNone {
+		return 0.99
+	}
+
+	// Full cone to anything usually works well, as does a side without NAT
+	if localNATType == discovery.NATFullCone || remoteNATType == discovery.NATFullCone ||
+		localNATType == discovery.NATNone || remoteNATType == discovery.NATNone {
 		return 0.98 // Increased from 0.95
 	}
 
@@ -73,28 +83,88 @@ func (s *UDPHolePunchingStrategy) EstimateSuccessRate(localNATType, remoteNATTyp
 	return 0.60 // Increased from 0.50
 }
 
-// EstablishConnection attempts to establish a direct peer-to-peer connection
+// EstablishConnection punches from the local address towards the peer,
+// which punches back at the same time, and returns the socket once the peer
+// acknowledges a punch. Punches are resent initialTimeout apart, maxRetries
+// times, and the peer's punches are acknowledged while waiting. The socket
+// is connected, so only the peer's packets count.
 func (s *UDPHolePunchingStrategy) EstablishConnection(
 	ctx context.Context,
 	localAddr,
 	remoteAddr *net.UDPAddr,
 ) (net.Conn, error) {
-	// This is a simplified implementation
-	// In a real implementation, this would contain the full UDP hole punching logic
-
-	// Create a UDP connection bound to the local address
-	conn, err := net.ListenUDP("udp", localAddr)
+	conn, err := dialUDP(ctx, localAddr, remoteAddr)
 	if err != nil {
 		return nil, err
 	}
 
-	// Implement hole punching logic
-	// 1. Send initial packets to remote address to create NAT mappings
-	// 2. Wait for incoming packets while continuing to send
-	// 3. Once connection is established, return it
+	if err := s.punch(ctx, conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// punch sends punches on a connected socket until the peer acknowledges one
+func (s *UDPHolePunchingStrategy) punch(ctx context.Context, conn *net.UDPConn) error {
+	punch, err := (&protocol.Packet{Type: protocol.PacketTypeHolePunch}).Serialize()
+	if err != nil {
+		return err
+	}
+	ack, err := (&protocol.Packet{Type: protocol.PacketTypeHolePunchAck}).Serialize()
+	if err != nil {
+		return err
+	}
 
-	// For now, we'll just return the connection
-	// In a real implementation, we would verify bidirectional communication
+	// Wake the read below when the attempt is cancelled
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetReadDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	buffer := make([]byte, udpReadBufferSize)
+	for round := 0; round < s.maxRetries; round++ {
+		// Writes fail while the peer's ICMP errors come back; the next
+		// round tries again
+		conn.Write(punch)
+		conn.SetReadDeadline(time.Now().Add(s.initialTimeout))
+
+		for {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			n, err := conn.Read(buffer)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				if errors.Is(err, net.ErrClosed) {
+					return err
+				}
+				// The peer isn't listening yet
+				continue
+			}
+
+			packet, err := protocol.ParsePacket(buffer[:n])
+			if err != nil {
+				continue
+			}
+			switch packet.Type {
+			case protocol.PacketTypeHolePunch:
+				conn.Write(ack)
+			case protocol.PacketTypeHolePunchAck:
+				conn.SetReadDeadline(time.Time{})
+				return nil
+			}
+		}
+	}
 
-	return conn, nil
+	return ErrHolePunchTimeout
 }
@@ -0,0 +1,153 @@
+package nat
+
+import (
+	"context"
+	"hash/fnv"
+	"net"
+	"sync/atomic"
+
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+)
+
+// Drop policies applied when a UDP worker queue is full
+const (
+	// UDPDropNewest discards the packet that just arrived
+	UDPDropNewest = "drop-newest"
+
+	// UDPDropOldest discards the longest queued packet to make room for the new one
+	UDPDropOldest = "drop-oldest"
+)
+
+const (
+	defaultUDPQueueDepth = 256
+	defaultUDPBatchSize  = 32
+)
+
+// UDPServerStats contains packet processing counters for a UDP server
+type UDPServerStats struct {
+	Connections   int    `json:"connections"`
+	Workers       int    `json:"workers"`
+	Queued        int    `json:"queued"`
+	Received      uint64 `json:"received"`
+	Processed     uint64 `json:"processed"`
+	ParseErrors   uint64 `json:"parse_errors"`
+	DroppedNewest uint64 `json:"dropped_newest"`
+	DroppedOldest uint64 `json:"dropped_oldest"`
+	Sent          uint64 `json:"sent"`
+	SendDropped   uint64 `json:"send_dropped"`
+}
+
+// udpServerCounters holds the atomically updated counters behind UDPServerStats
+type udpServerCounters struct {
+	received      uint64
+	processed     uint64
+	parseErrors   uint64
+	droppedNewest uint64
+	droppedOldest uint64
+	sent          uint64
+	sendDropped   uint64
+}
+
+// udpWorkerPool spreads packets over a fixed set of workers. Packets are
+// sharded by source address so each peer's packets are handled in order.
+type udpWorkerPool struct {
+	shards     []chan *protocol.Packet
+	dropOldest bool
+	counters   *udpServerCounters
+}
+
+// newUDPWorkerPool creates a pool of workers with a bounded queue each
+func newUDPWorkerPool(workers, queueDepth int, dropPolicy string, counters *udpServerCounters) *udpWorkerPool {
+	if queueDepth <= 0 {
+		queueDepth = defaultUDPQueueDepth
+	}
+
+	pool := &udpWorkerPool{
+		shards:     make([]chan *protocol.Packet, workers),
+		dropOldest: dropPolicy == UDPDropOldest,
+		counters:   counters,
+	}
+	for i := range pool.shards {
+		pool.shards[i] = make(chan *protocol.Packet, queueDepth)
+	}
+
+	return pool
+}
+
+// start runs one goroutine per shard until the context is cancelled or stop is closed
+func (p *udpWorkerPool) start(ctx context.Context, stop <-chan struct{}, handle func(*protocol.Packet)) {
+	for _, shard := range p.shards {
+		go func(queue chan *protocol.Packet) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-stop:
+					return
+				case packet := <-queue:
+					handle(packet)
+					atomic.AddUint64(&p.counters.processed, 1)
+				}
+			}
+		}(shard)
+	}
+}
+
+// dispatch queues a packet on its source's shard without blocking the reader.
+// It returns false if the packet was dropped.
+func (p *udpWorkerPool) dispatch(packet *protocol.Packet) bool {
+	queue := p.shards[shardFor(packet.SourceAddr, len(p.shards))]
+
+	select {
+	case queue <- packet:
+		return true
+	default:
+	}
+
+	if !p.dropOldest {
+		atomic.AddUint64(&p.counters.droppedNewest, 1)
+		return false
+	}
+
+	// Make room by discarding the oldest queued packet. A worker may empty
+	// the slot first, in which case nothing is discarded.
+	select {
+	case <-queue:
+		atomic.AddUint64(&p.counters.droppedOldest, 1)
+	default:
+	}
+
+	select {
+	case queue <- packet:
+		return true
+	default:
+		atomic.AddUint64(&p.counters.droppedNewest, 1)
+		return false
+	}
+}
+
+// queued returns the number of packets waiting across all shards
+func (p *udpWorkerPool) queued() int {
+	total := 0
+	for _, shard := range p.shards {
+		total += len(shard)
+	}
+	return total
+}
+
+// shardFor maps a source address to a shard index
+func shardFor(addr net.Addr, shards int) int {
+	if shards <= 1 || addr == nil {
+		return 0
+	}
+
+	hash := fnv.New32a()
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		hash.Write(udpAddr.IP.To16())
+		hash.Write([]byte{byte(udpAddr.Port >> 8), byte(udpAddr.Port)})
+	} else {
+		hash.Write([]byte(addr.String()))
+	}
+
+	return int(hash.Sum32() % uint32(shards))
+}
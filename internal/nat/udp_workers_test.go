@@ -0,0 +1,122 @@
+package nat
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/bOguzhan/NATbypass/internal/config"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+)
+
+func TestUDPWorkerPoolDropPolicies(t *testing.T) {
+	source := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+	newPacket := func(payload string) *protocol.Packet {
+		return &protocol.Packet{Type: protocol.PacketTypeData, Payload: []byte(payload), SourceAddr: source}
+	}
+
+	t.Run("DropNewest", func(t *testing.T) {
+		counters := &udpServerCounters{}
+		pool := newUDPWorkerPool(1, 2, UDPDropNewest, counters)
+
+		assert.True(t, pool.dispatch(newPacket("1")))
+		assert.True(t, pool.dispatch(newPacket("2")))
+		assert.False(t, pool.dispatch(newPacket("3")), "A full queue should drop the new packet")
+
+		assert.Equal(t, uint64(1), counters.droppedNewest)
+		assert.Equal(t, "1", string((<-pool.shards[0]).Payload))
+	})
+
+	t.Run("DropOldest", func(t *testing.T) {
+		counters := &udpServerCounters{}
+		pool := newUDPWorkerPool(1, 2, UDPDropOldest, counters)
+
+		pool.dispatch(newPacket("1"))
+		pool.dispatch(newPacket("2"))
+		assert.True(t, pool.dispatch(newPacket("3")), "A full queue should make room for the new packet")
+
+		assert.Equal(t, uint64(1), counters.droppedOldest)
+		assert.Equal(t, "2", string((<-pool.shards[0]).Payload))
+		assert.Equal(t, "3", string((<-pool.shards[0]).Payload))
+	})
+}
+
+func TestUDPWorkerPoolPreservesPeerOrder(t *testing.T) {
+	counters := &udpServerCounters{}
+	pool := newUDPWorkerPool(4, 100, UDPDropNewest, counters)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	results := make(chan *protocol.Packet, 200)
+	pool.start(ctx, make(chan struct{}), func(packet *protocol.Packet) {
+		results <- packet
+	})
+
+	peers := []*net.UDPAddr{
+		{IP: net.IPv4(10, 0, 0, 1), Port: 5000},
+		{IP: net.IPv4(10, 0, 0, 2), Port: 5000},
+		{IP: net.IPv4(10, 0, 0, 1), Port: 5001},
+	}
+	for i := 0; i < 50; i++ {
+		for _, peer := range peers {
+			pool.dispatch(&protocol.Packet{Type: protocol.PacketTypeData, Payload: []byte{byte(i)}, SourceAddr: peer})
+		}
+	}
+
+	next := make(map[string]byte)
+	for i := 0; i < 150; i++ {
+		select {
+		case packet := <-results:
+			key := packet.SourceAddr.String()
+			assert.Equal(t, next[key], packet.Payload[0], "Packets from %s should stay in order", key)
+			next[key] = packet.Payload[0] + 1
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for packets")
+		}
+	}
+}
+
+func TestUDPServerStats(t *testing.T) {
+	server, err := NewUDPServer("127.0.0.1:12361")
+	assert.NoError(t, err)
+	server.SetConfig(&config.UDPServerConfig{Workers: 2, QueueDepth: 16, BatchSize: 8})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.NoError(t, server.Start(ctx))
+	defer server.Stop()
+
+	client, err := net.DialUDP("udp", nil, server.conn.LocalAddr().(*net.UDPAddr))
+	assert.NoError(t, err)
+	defer client.Close()
+
+	data, err := (&protocol.Packet{Type: protocol.PacketTypeRegistration, Payload: []byte("stats")}).Serialize()
+	assert.NoError(t, err)
+	_, err = client.Write(data)
+	assert.NoError(t, err)
+	_, err = client.Write([]byte{0xff})
+	assert.NoError(t, err)
+
+	// Wait for the registration acknowledgment
+	buffer := make([]byte, 1024)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := client.Read(buffer)
+	assert.NoError(t, err)
+	response, err := protocol.ParsePacket(buffer[:n])
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.PacketTypeRegistrationAck, response.Type)
+
+	time.Sleep(50 * time.Millisecond)
+	stats := server.GetStats()
+	assert.Equal(t, 2, stats.Workers)
+	assert.Equal(t, 1, stats.Connections)
+	assert.Equal(t, uint64(2), stats.Received)
+	assert.Equal(t, uint64(1), stats.Processed)
+	assert.Equal(t, uint64(1), stats.ParseErrors)
+	assert.Equal(t, uint64(1), stats.Sent)
+	assert.Equal(t, uint64(0), stats.DroppedNewest+stats.DroppedOldest)
+}
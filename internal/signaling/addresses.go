@@ -0,0 +1,171 @@
+// internal/signaling/addresses.go
+package signaling
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultVirtualNetwork is used when no virtual network is configured
+const DefaultVirtualNetwork = "100.64.0.0/16"
+
+// ErrAddressPoolExhausted is returned when every virtual address is taken
+var ErrAddressPoolExhausted = errors.New("virtual address pool exhausted")
+
+// AddressPool assigns the virtual IPv4 addresses mesh clients use on their
+// TUN devices. A client keeps its address across registrations until it is
+// released, so peers can keep addressing it when it registers again.
+type AddressPool struct {
+	mu       sync.Mutex
+	network  netip.Prefix
+	byClient map[string]netip.Addr
+	byAddr   map[netip.Addr]string
+	next     netip.Addr // Where the search for a free address starts
+}
+
+// NewAddressPool creates a pool for an IPv4 network such as 100.64.0.0/16.
+// The network and broadcast addresses are never assigned.
+func NewAddressPool(network string) (*AddressPool, error) {
+	prefix, err := netip.ParsePrefix(network)
+	if err != nil {
+		return nil, fmt.Errorf("invalid virtual network: %w", err)
+	}
+	prefix = prefix.Masked()
+	if !prefix.Addr().Is4() || prefix.Bits() > 30 {
+		return nil, fmt.Errorf("virtual network %s must be IPv4 with at least 4 addresses", prefix)
+	}
+
+	return &AddressPool{
+		network:  prefix,
+		byClient: make(map[string]netip.Addr),
+		byAddr:   make(map[netip.Addr]string),
+		next:     prefix.Addr().Next(),
+	}, nil
+}
+
+// Network returns the network addresses are assigned from
+func (p *AddressPool) Network() netip.Prefix {
+	return p.network
+}
+
+// Assign returns the client's address, assigning a free one if it has none
+func (p *AddressPool) Assign(clientID string) (netip.Addr, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if addr, exists := p.byClient[clientID]; exists {
+		return addr, nil
+	}
+
+	// Search once around the network, starting after the last assignment
+	size := 1 << (32 - p.network.Bits())
+	addr := p.next
+	for i := 0; i < size; i++ {
+		if !p.usable(addr) {
+			addr = p.network.Addr().Next()
+		}
+		if _, taken := p.byAddr[addr]; !taken {
+			p.byClient[clientID] = addr
+			p.byAddr[addr] = clientID
+			p.next = addr.Next()
+			return addr, nil
+		}
+		addr = addr.Next()
+	}
+	return netip.Addr{}, ErrAddressPoolExhausted
+}
+
+// usable reports whether addr may be assigned: inside the network and not
+// its network or broadcast address
+func (p *AddressPool) usable(addr netip.Addr) bool {
+	if !p.network.Contains(addr) || addr == p.network.Addr() {
+		return false
+	}
+	return p.network.Contains(addr.Next())
+}
+
+// Lookup returns the client an address is assigned to
+func (p *AddressPool) Lookup(addr netip.Addr) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	clientID, exists := p.byAddr[addr]
+	return clientID, exists
+}
+
+// Address returns the address assigned to a client
+func (p *AddressPool) Address(clientID string) (netip.Addr, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	addr, exists := p.byClient[clientID]
+	return addr, exists
+}
+
+// Release frees the client's address
+func (p *AddressPool) Release(clientID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if addr, exists := p.byClient[clientID]; exists {
+		delete(p.byAddr, addr)
+		delete(p.byClient, clientID)
+	}
+}
+
+// addressPool returns the pool for the configured virtual network, created
+// on first use. It is nil if the configured network is invalid.
+func (h *Handlers) addressPool() *AddressPool {
+	h.addressesOnce.Do(func() {
+		network := DefaultVirtualNetwork
+		if cfg := h.getConfig(); cfg != nil && cfg.Signaling.VirtualNetwork != "" {
+			network = cfg.Signaling.VirtualNetwork
+		}
+
+		pool, err := NewAddressPool(network)
+		if err != nil {
+			h.logger.WithFields(map[string]interface{}{
+				"error": err.Error(),
+			}).Error("Virtual addresses disabled")
+			return
+		}
+		h.addresses = pool
+	})
+	return h.addresses
+}
+
+// LookupVirtualIP returns the client a virtual address is assigned to
+func (h *Handlers) LookupVirtualIP(c *gin.Context) {
+	addr, err := netip.ParseAddr(c.Param("ip"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invalid_address",
+		})
+		return
+	}
+
+	var clientID string
+	exists := false
+	if pool := h.addressPool(); pool != nil {
+		clientID, exists = pool.Lookup(addr)
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status": "error",
+			"error":  "address_not_assigned",
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":     "ok",
+		"client_id":  clientID,
+		"virtual_ip": addr.String(),
+	})
+}
@@ -0,0 +1,96 @@
+// internal/signaling/addresses_test.go
+package signaling
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/bOguzhan/NATbypass/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddressPool(t *testing.T) {
+	pool, err := NewAddressPool("10.1.0.0/30")
+	assert.NoError(t, err)
+
+	// Only .1 and .2 are usable in a /30
+	a, err := pool.Assign("alice")
+	assert.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddr("10.1.0.1"), a)
+	b, err := pool.Assign("bob")
+	assert.NoError(t, err)
+	assert.Equal(t, netip.MustParseAddr("10.1.0.2"), b)
+	_, err = pool.Assign("carol")
+	assert.ErrorIs(t, err, ErrAddressPoolExhausted)
+
+	// Registering again keeps the address
+	again, _ := pool.Assign("alice")
+	assert.Equal(t, a, again)
+
+	clientID, exists := pool.Lookup(b)
+	assert.True(t, exists)
+	assert.Equal(t, "bob", clientID)
+
+	// Released addresses are reused
+	pool.Release("alice")
+	_, exists = pool.Lookup(a)
+	assert.False(t, exists)
+	c, err := pool.Assign("carol")
+	assert.NoError(t, err)
+	assert.Equal(t, a, c)
+
+	_, err = NewAddressPool("10.1.0.0/31")
+	assert.Error(t, err)
+	_, err = NewAddressPool("fd00::/64")
+	assert.Error(t, err)
+}
+
+func TestVirtualIPRoutes(t *testing.T) {
+	router, handlers := setupTestRouter(t)
+	cfg := config.DefaultConfig()
+	cfg.Signaling.VirtualNetwork = "10.9.0.0/24"
+	handlers.SetConfig(cfg)
+
+	// Only clients joining the mesh are assigned an address
+	w := postJSON(router, "/api/v1/register", map[string]interface{}{"name": "plain"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "virtual_ip")
+
+	w = postJSON(router, "/api/v1/register", map[string]interface{}{"name": "mesh", "mesh": true})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var registered struct {
+		ClientID       string `json:"client_id"`
+		VirtualIP      string `json:"virtual_ip"`
+		VirtualNetwork string `json:"virtual_network"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &registered))
+	assert.Equal(t, "10.9.0.1", registered.VirtualIP)
+	assert.Equal(t, "10.9.0.0/24", registered.VirtualNetwork)
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/virtual-ips/10.9.0.1", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), registered.ClientID)
+
+	// Taking a new ID releases the old one's address
+	member := registerTestClient(t, router, map[string]interface{}{"name": "member", "mesh": true})
+	w = member.post("/api/v1/register", map[string]interface{}{"name": "member"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), member.id)
+
+	for path, code := range map[string]int{
+		"/api/v1/virtual-ips/10.9.0.2":  http.StatusNotFound, // Released by member
+		"/api/v1/virtual-ips/10.9.0.3":  http.StatusNotFound,
+		"/api/v1/virtual-ips/not-an-ip": http.StatusBadRequest,
+	} {
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest(http.MethodGet, path, nil)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, code, w.Code, path)
+	}
+}
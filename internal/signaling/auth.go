@@ -0,0 +1,176 @@
+// internal/signaling/auth.go
+package signaling
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+	"github.com/gin-gonic/gin"
+)
+
+// Clients sign their requests with the session key issued at registration,
+// see protocol.SignRequest. Keys are derived from the rendezvous secret, so
+// the rendezvous servers can check the same key, or from a random secret
+// when none is configured. Only the ID of each client's current key is kept.
+
+const (
+	authenticatedClientKey = "natbypass.client_id" // Context key of the client that signed a request
+	defaultKeyTTL          = 24 * time.Hour
+	maxSignedBodySize      = 1 << 20
+)
+
+// Errors returned when checking a request's signature
+var (
+	errUnknownClient = errors.New("client was not issued a session key")
+	errSupersededKey = errors.New("session key was superseded")
+	errExpiredKey    = errors.New("session key expired")
+)
+
+// sessionKeys tracks the current key of every client the server issued an ID to
+type sessionKeys struct {
+	secret []byte // Used when no rendezvous secret is configured
+
+	mu      sync.Mutex
+	current map[string]uint32
+}
+
+func newSessionKeys() (*sessionKeys, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return &sessionKeys{secret: secret, current: make(map[string]uint32)}, nil
+}
+
+// keyID returns the ID of a client's current key, false if it has none
+func (k *sessionKeys) keyID(clientID string) (uint32, bool) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	keyID, exists := k.current[clientID]
+	return keyID, exists
+}
+
+// next records a new key for a client and returns its ID, the issue time.
+// It is always newer than the client's previous key, which stops working.
+func (k *sessionKeys) next(clientID string, now time.Time) uint32 {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	keyID := uint32(now.Unix())
+	if current, exists := k.current[clientID]; exists && keyID <= current {
+		keyID = current + 1
+	}
+	k.current[clientID] = keyID
+	return keyID
+}
+
+// forget drops a client that is gone
+func (k *sessionKeys) forget(clientID string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.current, clientID)
+}
+
+// masterKey returns the secret session keys are derived from
+func (h *Handlers) masterKey() []byte {
+	if cfg := h.getConfig(); cfg != nil && cfg.Security.RendezvousSecret != "" {
+		return []byte(cfg.Security.RendezvousSecret)
+	}
+	return h.keys.secret
+}
+
+// keyTTL returns how long a session key is accepted
+func (h *Handlers) keyTTL() time.Duration {
+	if cfg := h.getConfig(); cfg != nil && cfg.Security.KeyTTL > 0 {
+		return cfg.Security.KeyTTL
+	}
+	return defaultKeyTTL
+}
+
+// issueKey issues a client a new session key, superseding its previous one
+func (h *Handlers) issueKey(clientID string) (uint32, []byte) {
+	keyID := h.keys.next(clientID, time.Now())
+	return keyID, protocol.DeriveClientKey(h.masterKey(), clientID, keyID)
+}
+
+// verifyRequest checks the signature of a request and returns the client
+// that signed it. A client the server tracks must sign with its current
+// key; one it forgot, e.g. after expiring it, with any unexpired key. The
+// body is read to check it and left for the handler.
+func (h *Handlers) verifyRequest(c *gin.Context) (string, error) {
+	auth, err := protocol.ParseRequestAuth(c.Request.Header)
+	if err != nil {
+		return "", err
+	}
+
+	var body []byte
+	if c.Request.Body != nil {
+		body, err = io.ReadAll(io.LimitReader(c.Request.Body, maxSignedBodySize))
+		if err != nil {
+			return "", err
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	now := time.Now()
+	issued := time.Unix(int64(auth.KeyID), 0)
+	if now.Sub(issued) > h.keyTTL() || issued.Sub(now) > protocol.MaxRequestSkew {
+		return "", errExpiredKey
+	}
+	if current, tracked := h.keys.keyID(auth.ClientID); tracked && auth.KeyID != current {
+		return "", errSupersededKey
+	}
+
+	key := protocol.DeriveClientKey(h.masterKey(), auth.ClientID, auth.KeyID)
+	if err := auth.Verify(key, c.Request.Method, c.Request.URL.RequestURI(), body, now); err != nil {
+		return "", err
+	}
+	return auth.ClientID, nil
+}
+
+// authenticate rejects requests that aren't signed with the current key of
+// a registered client and records which client sent them
+func (h *Handlers) authenticate(c *gin.Context) {
+	clientID, err := h.verifyRequest(c)
+	if err == nil {
+		if _, tracked := h.keys.keyID(clientID); !tracked {
+			err = errUnknownClient
+		}
+	}
+	if err != nil {
+		h.logger.WithFields(map[string]interface{}{
+			"path":  c.FullPath(),
+			"ip":    c.ClientIP(),
+			"error": err.Error(),
+		}).Warn("Unauthenticated request")
+
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"status": "error",
+			"error":  "unauthorized",
+		})
+		return
+	}
+
+	c.Set(authenticatedClientKey, clientID)
+	c.Next()
+}
+
+// authorized reports whether the request was signed by clientID, answering
+// it with 403 if not
+func (h *Handlers) authorized(c *gin.Context, clientID string) bool {
+	if c.GetString(authenticatedClientKey) == clientID {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"status": "error",
+		"error":  "forbidden",
+	})
+	return false
+}
@@ -0,0 +1,130 @@
+// internal/signaling/auth_test.go
+package signaling
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+	"github.com/stretchr/testify/assert"
+)
+
+// testClient is a registered client that signs its requests like the SDK
+type testClient struct {
+	router http.Handler
+	id     string
+	keyID  uint32
+	key    []byte
+}
+
+// registerTestClient registers with body and fails the test unless a
+// session key is issued
+func registerTestClient(t *testing.T, router http.Handler, body map[string]interface{}) *testClient {
+	t.Helper()
+
+	w := postJSON(router, "/api/v1/register", body)
+	if w.Code != http.StatusOK {
+		t.Fatalf("registration failed with %d: %s", w.Code, w.Body)
+	}
+
+	var response struct {
+		ClientID   string `json:"client_id"`
+		SessionKey string `json:"session_key"`
+		KeyID      uint32 `json:"key_id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	key, err := hex.DecodeString(response.SessionKey)
+	if err != nil || len(key) == 0 {
+		t.Fatalf("invalid session key %q", response.SessionKey)
+	}
+	return &testClient{router: router, id: response.ClientID, keyID: response.KeyID, key: key}
+}
+
+// sign adds the client's signature to a request with the given body
+func (c *testClient) sign(req *http.Request, body []byte) {
+	protocol.SignRequest(req.Header, c.id, c.keyID, c.key, req.Method, req.URL.RequestURI(), body, time.Now())
+}
+
+// do sends a signed request, JSON encoding body unless it is nil
+func (c *testClient) do(method, path string, body interface{}) *httptest.ResponseRecorder {
+	var data []byte
+	if body != nil {
+		data, _ = json.Marshal(body)
+	}
+	req, _ := http.NewRequest(method, path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", "application/json")
+	c.sign(req, data)
+
+	w := httptest.NewRecorder()
+	c.router.ServeHTTP(w, req)
+	return w
+}
+
+func (c *testClient) post(path string, body interface{}) *httptest.ResponseRecorder {
+	return c.do(http.MethodPost, path, body)
+}
+
+func (c *testClient) get(path string) *httptest.ResponseRecorder {
+	return c.do(http.MethodGet, path, nil)
+}
+
+func TestRequestAuthentication(t *testing.T) {
+	router, _ := setupTestRouter(t)
+
+	alice := registerTestClient(t, router, map[string]interface{}{"name": "alice"})
+	bob := registerTestClient(t, router, map[string]interface{}{"name": "bob"})
+	heartbeat := map[string]interface{}{"client_id": alice.id}
+
+	assert.Equal(t, http.StatusOK, alice.post("/api/v1/heartbeat", heartbeat).Code)
+	assert.Equal(t, http.StatusUnauthorized, postJSON(router, "/api/v1/heartbeat", heartbeat).Code)
+	assert.Equal(t, http.StatusForbidden, bob.post("/api/v1/heartbeat", heartbeat).Code)
+	assert.Equal(t, http.StatusForbidden, bob.get("/api/v1/messages/"+alice.id).Code)
+
+	// Signals can't be sent in someone else's name
+	offer := map[string]interface{}{"type": "offer", "client_id": alice.id, "target_id": bob.id}
+	assert.Equal(t, http.StatusOK, alice.post("/api/v1/signal", offer).Code)
+	assert.Equal(t, http.StatusForbidden, bob.post("/api/v1/signal", offer).Code)
+	assert.Equal(t, http.StatusUnauthorized, postJSON(router, "/api/v1/signal", offer).Code)
+
+	// A signature covers the whole request
+	data, _ := json.Marshal(heartbeat)
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/heartbeat", bytes.NewReader([]byte(`{"client_id":"`+bob.id+`"}`)))
+	alice.sign(req, data)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Taking over an ID in use needs its key
+	w = postJSON(router, "/api/v1/register", map[string]interface{}{"client_id": alice.id})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = bob.post("/api/v1/register", map[string]interface{}{"client_id": alice.id})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// An ID the server didn't issue is replaced
+	stranger := registerTestClient(t, router, map[string]interface{}{"client_id": "44444444444444444444444444444444"})
+	assert.NotEqual(t, "44444444444444444444444444444444", stranger.id)
+
+	// Registering again with the key keeps the ID and supersedes the key
+	w = alice.post("/api/v1/register", map[string]interface{}{"client_id": alice.id})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		ClientID   string `json:"client_id"`
+		SessionKey string `json:"session_key"`
+		KeyID      uint32 `json:"key_id"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, alice.id, response.ClientID)
+	assert.Greater(t, response.KeyID, alice.keyID)
+
+	assert.Equal(t, http.StatusUnauthorized, alice.post("/api/v1/heartbeat", heartbeat).Code)
+	alice.keyID = response.KeyID
+	alice.key, _ = hex.DecodeString(response.SessionKey)
+	assert.Equal(t, http.StatusOK, alice.post("/api/v1/heartbeat", heartbeat).Code)
+}
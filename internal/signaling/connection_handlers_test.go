@@ -13,11 +13,14 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func setupConnectionTestRouter() (*gin.Engine, *Handlers) {
+func setupConnectionTestRouter(t *testing.T) (*gin.Engine, *Handlers) {
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
 	logger := utils.NewLogger("test", "info")
-	handlers := NewHandlers(logger)
+	handlers, err := NewHandlers(logger)
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	// Initialize the connection registry
 	handlers.connections = NewConnectionRegistry(logger)
@@ -29,20 +32,17 @@ func setupConnectionTestRouter() (*gin.Engine, *Handlers) {
 }
 
 func TestRequestConnection(t *testing.T) {
-	router, _ := setupConnectionTestRouter() // Using _ to ignore the handlers variable
+	router, _ := setupConnectionTestRouter(t) // Using _ to ignore the handlers variable
+	source := registerTestClient(t, router, map[string]interface{}{"name": "source"})
+	target := registerTestClient(t, router, map[string]interface{}{"name": "target"})
 
 	// 1. Test valid connection request
-	w := httptest.NewRecorder()
-	reqBody := map[string]interface{}{
-		"source_id":   "12345678901234567890123456789012",
-		"target_id":   "21098765432109876543210987654321",
+	w := source.post("/api/v1/connect", map[string]interface{}{
+		"source_id":   source.id,
+		"target_id":   target.id,
 		"source_ip":   "192.168.1.5",
 		"source_port": 12345,
-	}
-	reqJSON, _ := json.Marshal(reqBody)
-	req, _ := http.NewRequest(http.MethodPost, "/api/v1/connect", bytes.NewBuffer(reqJSON))
-	req.Header.Set("Content-Type", "application/json")
-	router.ServeHTTP(w, req)
+	})
 
 	assert.Equal(t, http.StatusOK, w.Code)
 
@@ -56,9 +56,7 @@ func TestRequestConnection(t *testing.T) {
 	connID := response["connection_id"].(string)
 
 	// 2. Test getting connections for client
-	w = httptest.NewRecorder()
-	req, _ = http.NewRequest(http.MethodGet, "/api/v1/connections/12345678901234567890123456789012", nil)
-	router.ServeHTTP(w, req)
+	w = source.get("/api/v1/connections/" + source.id)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 
@@ -73,22 +71,22 @@ func TestRequestConnection(t *testing.T) {
 	assert.Equal(t, "initiated", connections[0].(map[string]interface{})["status"])
 
 	// 3. Test updating connection status
-	w = httptest.NewRecorder()
 	updateReq := map[string]interface{}{
 		"connection_id": connID,
 		"status":        "negotiating",
 	}
-	updateJSON, _ := json.Marshal(updateReq)
-	req, _ = http.NewRequest(http.MethodPost, "/api/v1/connection/update", bytes.NewBuffer(updateJSON))
-	req.Header.Set("Content-Type", "application/json")
-	router.ServeHTTP(w, req)
-
+	w = target.post("/api/v1/connection/update", updateReq)
 	assert.Equal(t, http.StatusOK, w.Code)
 
+	// Only the peers of a connection may report on it
+	other := registerTestClient(t, router, map[string]interface{}{"name": "other"})
+	w = other.post("/api/v1/connection/update", updateReq)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	w = other.get("/api/v1/connections/" + source.id)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
 	// Verify the status was updated
-	w = httptest.NewRecorder()
-	req, _ = http.NewRequest(http.MethodGet, "/api/v1/connections/12345678901234567890123456789012", nil)
-	router.ServeHTTP(w, req)
+	w = source.get("/api/v1/connections/" + source.id)
 
 	err = json.Unmarshal(w.Body.Bytes(), &connResponse)
 	assert.NoError(t, err)
@@ -96,41 +94,33 @@ func TestRequestConnection(t *testing.T) {
 	assert.Equal(t, "negotiating", connections[0].(map[string]interface{})["status"])
 
 	// 4. Test connection error
-	w = httptest.NewRecorder()
-	errorReq := map[string]interface{}{
+	w = source.post("/api/v1/connection/update", map[string]interface{}{
 		"connection_id": connID,
 		"status":        "failed",
 		"error_message": "ICE negotiation timeout",
-	}
-	errorJSON, _ := json.Marshal(errorReq)
-	req, _ = http.NewRequest(http.MethodPost, "/api/v1/connection/update", bytes.NewBuffer(errorJSON))
-	req.Header.Set("Content-Type", "application/json")
-	router.ServeHTTP(w, req)
-
+	})
 	assert.Equal(t, http.StatusOK, w.Code)
 
 	// 5. Test invalid request
-	w = httptest.NewRecorder()
-	invalidReq := map[string]interface{}{
+	w = source.post("/api/v1/connect", map[string]interface{}{
 		"source_id": "too-short",
 		"target_id": "also-too-short",
-	}
-	invalidJSON, _ := json.Marshal(invalidReq)
-	req, _ = http.NewRequest(http.MethodPost, "/api/v1/connect", bytes.NewBuffer(invalidJSON))
-	req.Header.Set("Content-Type", "application/json")
-	router.ServeHTTP(w, req)
-
+	})
 	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// 6. Test requesting a connection as someone else
+	w = other.post("/api/v1/connect", map[string]interface{}{
+		"source_id": source.id,
+		"target_id": target.id,
+	})
+	assert.Equal(t, http.StatusForbidden, w.Code)
 }
 
 func TestGetNonExistentConnection(t *testing.T) {
-	router, _ := setupConnectionTestRouter()
+	router, _ := setupConnectionTestRouter(t)
+	client := registerTestClient(t, router, map[string]interface{}{"name": "lonely"})
 
-	// Use a valid format client ID (32 characters)
-	// The handler is rejecting the ID because it's not the right length
-	w := httptest.NewRecorder()
-	req, _ := http.NewRequest(http.MethodGet, "/api/v1/connections/12345678901234567890123456789012", nil)
-	router.ServeHTTP(w, req)
+	w := client.get("/api/v1/connections/" + client.id)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 
@@ -152,17 +142,13 @@ func TestGetNonExistentConnection(t *testing.T) {
 }
 
 func TestUpdateNonExistentConnection(t *testing.T) {
-	router, _ := setupConnectionTestRouter() // Using _ to ignore the handlers variable
+	router, _ := setupConnectionTestRouter(t) // Using _ to ignore the handlers variable
+	client := registerTestClient(t, router, map[string]interface{}{"name": "client"})
 
-	w := httptest.NewRecorder()
-	updateReq := map[string]interface{}{
+	w := client.post("/api/v1/connection/update", map[string]interface{}{
 		"connection_id": "nonexistent-conn-id",
 		"status":        "established",
-	}
-	updateJSON, _ := json.Marshal(updateReq)
-	req, _ := http.NewRequest(http.MethodPost, "/api/v1/connection/update", bytes.NewBuffer(updateJSON))
-	req.Header.Set("Content-Type", "application/json")
-	router.ServeHTTP(w, req)
+	})
 
 	assert.Equal(t, http.StatusNotFound, w.Code)
 
@@ -171,3 +157,101 @@ func TestUpdateNonExistentConnection(t *testing.T) {
 	assert.Equal(t, "error", response["status"])
 	assert.Equal(t, "connection_not_found", response["error"])
 }
+
+func TestRequestConnectionSameNetwork(t *testing.T) {
+	router, handlers := setupConnectionTestRouter(t)
+	source := registerTestClient(t, router, map[string]interface{}{"name": "source"})
+	target := registerTestClient(t, router, map[string]interface{}{"name": "target"})
+
+	server := &Server{clients: make(map[string]ClientInfo), logger: utils.NewLogger("test", "info")}
+	handlers.SetServer(server)
+
+	sourceID := source.id
+	targetID := target.id
+	server.RegisterClient(sourceID, ClientInfo{
+		ID:             sourceID,
+		IPAddress:      "203.0.113.7",
+		LocalAddresses: privateEndpoints([]string{"192.168.1.10:5000", "203.0.113.9:5000"}),
+	})
+	server.RegisterClient(targetID, ClientInfo{
+		ID:             targetID,
+		IPAddress:      "203.0.113.7",
+		LocalAddresses: privateEndpoints([]string{"192.168.1.11:5000"}),
+	})
+
+	w := source.post("/api/v1/connect", map[string]interface{}{
+		"source_id": sourceID,
+		"target_id": targetID,
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, true, response["same_network"])
+	assert.Equal(t, []interface{}{"192.168.1.11:5000"}, response["candidates"])
+
+	// The target sees the initiator's private endpoints; the public one was dropped
+	w = target.get("/api/v1/connections/" + targetID)
+
+	var connResponse map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &connResponse))
+	connections := connResponse["connections"].([]interface{})
+	assert.Len(t, connections, 1)
+	assert.Equal(t, true, connections[0].(map[string]interface{})["same_network"])
+	assert.Equal(t, []interface{}{"192.168.1.10:5000"}, connections[0].(map[string]interface{})["candidates"])
+}
+
+func TestTraceContextPropagation(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server, err := NewServer(utils.NewLogger("test", "error"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.handlers.connections.Stop()
+
+	source := registerTestClient(t, server.router, map[string]interface{}{"name": "source"})
+	target := registerTestClient(t, server.router, map[string]interface{}{"name": "target"})
+	sourceID := source.id
+	targetID := target.id
+
+	// A traced request, as sent by the SDK
+	traceparent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	tracedPost := func(path string, body interface{}) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(data))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("traceparent", traceparent)
+		source.sign(req, data)
+
+		w := httptest.NewRecorder()
+		server.router.ServeHTTP(w, req)
+		return w
+	}
+
+	// The connection remembers the trace it was requested in
+	w := tracedPost("/api/v1/connect", map[string]interface{}{
+		"source_id": sourceID,
+		"target_id": targetID,
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+
+	conn, exists := server.handlers.connections.GetConnection(response["connection_id"].(string))
+	assert.True(t, exists)
+	assert.Equal(t, traceparent, conn.Metadata["trace_context"].(map[string]string)["traceparent"])
+
+	// Messages sent without a trace context get the request's
+	w = tracedPost("/api/v1/signal", map[string]interface{}{
+		"type":      "offer",
+		"client_id": sourceID,
+		"target_id": targetID,
+		"payload":   map[string]string{"connection_id": "abc"},
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	messages := server.handlers.messages.GetMessages(targetID)
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, traceparent, messages[0].TraceContext["traceparent"])
+	}
+}
@@ -8,7 +8,11 @@ import (
 	"time"
 
 	"github.com/bOguzhan/NATbypass/internal/utils"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+	"github.com/bOguzhan/NATbypass/pkg/tracing"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ConnectionStatus represents the current state of a connection
@@ -153,6 +157,36 @@ func (r *ConnectionRegistry) UpdateConnectionError(connectionID string, errorMsg
 	return true
 }
 
+// SetStatus updates a connection's status, and its error message when one is
+// given, returning the status it had before. Checking and updating under one
+// lock lets callers act exactly once when a connection first resolves.
+func (r *ConnectionRegistry) SetStatus(connectionID string, status ConnectionStatus, errorMsg string) (ConnectionStatus, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	conn, exists := r.connections[connectionID]
+	if !exists {
+		return "", false
+	}
+
+	previous := conn.Status
+	conn.Status = status
+	if errorMsg != "" {
+		conn.ErrorMessage = errorMsg
+	}
+	conn.LastUpdated = time.Now()
+
+	r.logger.WithFields(map[string]interface{}{
+		"connection_id": connectionID,
+		"source_id":     conn.SourceID,
+		"target_id":     conn.TargetID,
+		"status":        status,
+		"error":         errorMsg,
+	}).Info("Connection status updated")
+
+	return previous, true
+}
+
 // UpdateConnectionMetadata adds or updates metadata for a connection
 func (r *ConnectionRegistry) UpdateConnectionMetadata(connectionID string, key string, value interface{}) bool {
 	r.mu.Lock()
@@ -366,6 +400,9 @@ func (h *Handlers) RequestConnection(c *gin.Context) {
 		})
 		return
 	}
+	if !h.authorized(c, req.SourceID) {
+		return
+	}
 
 	// Check if source client exists if server is available
 	if h.server != nil {
@@ -398,10 +435,38 @@ func (h *Handlers) RequestConnection(c *gin.Context) {
 		connReq.SourceAddress = fmt.Sprintf("%s:%d", req.SourceIP, req.SourcePort)
 	}
 
+	// Peers behind the same NAT are given each other's private endpoints,
+	// since the punch via public addresses needs the router to hairpin
+	var sameNetwork bool
+	var candidates []string
+	if h.server != nil {
+		source, _ := h.server.GetClient(req.SourceID)
+		target, _ := h.server.GetClient(req.TargetID)
+		if sharesPublicIP(source, target) {
+			sameNetwork = true
+			candidates = target.LocalAddresses
+			connReq.Metadata = map[string]interface{}{
+				"same_network":      true,
+				"source_candidates": source.LocalAddresses,
+				"target_candidates": target.LocalAddresses,
+			}
+		}
+	}
+
+	// Keep the trace the request belongs to, so the connection can be found
+	// from a trace and the other way around
+	ctx := c.Request.Context()
+	if traceContext := tracing.Inject(ctx); traceContext != nil {
+		if connReq.Metadata == nil {
+			connReq.Metadata = make(map[string]interface{})
+		}
+		connReq.Metadata["trace_context"] = traceContext
+	}
+
 	if err := h.connections.RegisterConnection(connReq); err != nil {
-		h.logger.WithFields(map[string]interface{}{
-			"error": err.Error(),
-		}).Error("Failed to register connection")
+		fields := tracing.LogFields(ctx)
+		fields["error"] = err.Error()
+		h.logger.WithFields(fields).Error("Failed to register connection")
 
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status": "error",
@@ -410,11 +475,24 @@ func (h *Handlers) RequestConnection(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("natbypass.connection_id", connReq.ConnectionID),
+		attribute.String("natbypass.source_id", req.SourceID),
+		attribute.String("natbypass.target_id", req.TargetID),
+		attribute.Bool("natbypass.same_network", sameNetwork),
+	)
+
+	response := gin.H{
 		"status":        "connection_registered",
 		"connection_id": connReq.ConnectionID,
 		"timestamp":     connReq.Timestamp,
-	})
+	}
+	if sameNetwork {
+		response["same_network"] = true
+		response["candidates"] = candidates
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 // GetActiveConnections returns all active connection requests for a client
@@ -428,13 +506,16 @@ func (h *Handlers) GetActiveConnections(c *gin.Context) {
 		})
 		return
 	}
+	if !h.authorized(c, clientID) {
+		return
+	}
 
 	connections := h.connections.GetConnectionsByClient(clientID)
 
 	// Format for response
 	response := make([]gin.H, 0, len(connections))
 	for _, conn := range connections {
-		response = append(response, gin.H{
+		entry := gin.H{
 			"connection_id": conn.ConnectionID,
 			"source_id":     conn.SourceID,
 			"target_id":     conn.TargetID,
@@ -442,7 +523,19 @@ func (h *Handlers) GetActiveConnections(c *gin.Context) {
 			"timestamp":     conn.Timestamp,
 			"last_updated":  conn.LastUpdated,
 			"is_initiator":  conn.SourceID == clientID,
-		})
+		}
+
+		// Each side gets the other's private endpoints to try first
+		if sameNetwork, _ := conn.Metadata["same_network"].(bool); sameNetwork {
+			entry["same_network"] = true
+			if conn.SourceID == clientID {
+				entry["candidates"] = conn.Metadata["target_candidates"]
+			} else {
+				entry["candidates"] = conn.Metadata["source_candidates"]
+			}
+		}
+
+		response = append(response, entry)
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -457,6 +550,12 @@ func (h *Handlers) UpdateConnectionStatus(c *gin.Context) {
 		ConnectionID string           `json:"connection_id" binding:"required"`
 		Status       ConnectionStatus `json:"status" binding:"required"`
 		ErrorMessage string           `json:"error_message,omitempty"`
+
+		// Optional details of how the reporting peer connected, used for
+		// punch success metrics
+		Strategy    string `json:"strategy,omitempty"`
+		NATType     string `json:"nat_type,omitempty"`
+		PeerNATType string `json:"peer_nat_type,omitempty"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -468,13 +567,32 @@ func (h *Handlers) UpdateConnectionStatus(c *gin.Context) {
 		return
 	}
 
-	success := false
+	// Only the peers of a connection report on it
+	conn, exists := h.connections.GetConnection(req.ConnectionID)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status": "error",
+			"error":  "connection_not_found",
+		})
+		return
+	}
+	if c.GetString(authenticatedClientKey) != conn.TargetID && !h.authorized(c, conn.SourceID) {
+		return
+	}
+
+	// An error message always marks the connection as failed
+	status := req.Status
 	if req.ErrorMessage != "" {
-		success = h.connections.UpdateConnectionError(req.ConnectionID, req.ErrorMessage)
-	} else {
-		success = h.connections.UpdateConnectionStatus(req.ConnectionID, req.Status)
+		status = StatusFailed
 	}
 
+	trace.SpanFromContext(c.Request.Context()).SetAttributes(
+		attribute.String("natbypass.connection_id", req.ConnectionID),
+		attribute.String("natbypass.status", string(status)),
+		attribute.String("natbypass.strategy", req.Strategy),
+	)
+
+	previous, success := h.connections.SetStatus(req.ConnectionID, status, req.ErrorMessage)
 	if !success {
 		c.JSON(http.StatusNotFound, gin.H{
 			"status": "error",
@@ -483,8 +601,34 @@ func (h *Handlers) UpdateConnectionStatus(c *gin.Context) {
 		return
 	}
 
+	// Both peers report the outcome; only the first report counts
+	if !resolved(previous) && resolved(status) {
+		h.metrics.recordPunch(req.Strategy, req.NATType, req.PeerNATType, status == StatusEstablished)
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"status":        "updated",
 		"connection_id": req.ConnectionID,
 	})
 }
+
+// resolved reports whether a connection attempt has succeeded or failed
+func resolved(status ConnectionStatus) bool {
+	return status == StatusEstablished || status == StatusFailed || status == StatusClosed
+}
+
+// sharesPublicIP reports whether two clients registered from the same public
+// IP, meaning they most likely sit behind the same NAT
+func sharesPublicIP(source, target ClientInfo) bool {
+	return source.IPAddress != "" && source.IPAddress == target.IPAddress
+}
+
+// privateEndpoints keeps the announced addresses that are valid private
+// endpoints, as filtered by protocol.LocalEndpoints
+func privateEndpoints(addrs []string) []string {
+	var endpoints []string
+	for _, addrPort := range protocol.LocalEndpoints(addrs) {
+		endpoints = append(endpoints, addrPort.String())
+	}
+	return endpoints
+}
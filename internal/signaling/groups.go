@@ -0,0 +1,391 @@
+// internal/signaling/groups.go
+package signaling
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/internal/utils"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+	"github.com/gin-gonic/gin"
+)
+
+// onlineWindow is how recently a member must have been seen to be listed;
+// clients send heartbeats every 30 seconds by default
+const onlineWindow = 2 * time.Minute
+
+// groupNamePattern matches group names such as "office" or "team.dev-1"
+var groupNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,63}$`)
+
+// Errors returned by the group registry
+var (
+	ErrInvalidGroupName  = errors.New("invalid group name")
+	ErrGroupAccessDenied = errors.New("wrong group secret")
+	ErrNotGroupMember    = errors.New("not a member of the group")
+)
+
+// GroupRegistry tracks named groups of clients. Members of a group can list
+// each other and are told when one joins or leaves, while clients sharing no
+// group can't see each other. A group exists while it has members; the
+// client creating it may set a secret that everyone joining later must give.
+type GroupRegistry struct {
+	mu     sync.Mutex
+	groups map[string]*group
+}
+
+// group is one named set of clients
+type group struct {
+	secret  []byte               // SHA-256 of the secret, nil for open groups
+	members map[string]time.Time // Client ID to when it joined
+}
+
+// NewGroupRegistry creates an empty group registry
+func NewGroupRegistry() *GroupRegistry {
+	return &GroupRegistry{
+		groups: make(map[string]*group),
+	}
+}
+
+// Join adds a client to a group, creating the group with the secret if it
+// doesn't exist. It returns the other members and whether the client was
+// added, which it isn't when it is a member already.
+func (r *GroupRegistry) Join(name, clientID, secret string) (others []string, added bool, err error) {
+	if !groupNamePattern.MatchString(name) {
+		return nil, false, ErrInvalidGroupName
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g, exists := r.groups[name]
+	if !exists {
+		g = &group{members: make(map[string]time.Time)}
+		if secret != "" {
+			hash := sha256.Sum256([]byte(secret))
+			g.secret = hash[:]
+		}
+		r.groups[name] = g
+	} else if g.secret != nil {
+		hash := sha256.Sum256([]byte(secret))
+		if subtle.ConstantTimeCompare(hash[:], g.secret) != 1 {
+			return nil, false, ErrGroupAccessDenied
+		}
+	}
+
+	_, member := g.members[clientID]
+	if !member {
+		g.members[clientID] = time.Now()
+	}
+	return g.others(clientID), !member, nil
+}
+
+// Leave removes a client from a group and returns the remaining members.
+// The group goes away with its last member.
+func (r *GroupRegistry) Leave(name, clientID string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g, exists := r.groups[name]
+	if !exists {
+		return nil, ErrNotGroupMember
+	}
+	if _, member := g.members[clientID]; !member {
+		return nil, ErrNotGroupMember
+	}
+
+	delete(g.members, clientID)
+	if len(g.members) == 0 {
+		delete(r.groups, name)
+	}
+	return g.others(clientID), nil
+}
+
+// LeaveAll removes a client from every group and returns the remaining
+// members of each group it was in
+func (r *GroupRegistry) LeaveAll(clientID string) map[string][]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	left := make(map[string][]string)
+	for name, g := range r.groups {
+		if _, member := g.members[clientID]; !member {
+			continue
+		}
+		delete(g.members, clientID)
+		if len(g.members) == 0 {
+			delete(r.groups, name)
+		}
+		left[name] = g.others(clientID)
+	}
+	return left
+}
+
+// Members returns the other members of a group the client belongs to.
+// Groups the client isn't in can't be seen, so whether they exist isn't
+// revealed either.
+func (r *GroupRegistry) Members(name, clientID string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	g, exists := r.groups[name]
+	if !exists {
+		return nil, ErrNotGroupMember
+	}
+	if _, member := g.members[clientID]; !member {
+		return nil, ErrNotGroupMember
+	}
+	return g.others(clientID), nil
+}
+
+// Count returns the number of groups
+func (r *GroupRegistry) Count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.groups)
+}
+
+// others returns the members except clientID, oldest first
+func (g *group) others(clientID string) []string {
+	members := make([]string, 0, len(g.members))
+	for id := range g.members {
+		if id != clientID {
+			members = append(members, id)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return g.members[members[i]].Before(g.members[members[j]])
+	})
+	return members
+}
+
+// PeerInfo is what the members of a group see of each other
+type PeerInfo struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+	VirtualIP  string            `json:"virtual_ip,omitempty"`
+	LastSeen   time.Time         `json:"last_seen"`
+}
+
+// JoinGroup adds a client to a group and tells the other members
+func (h *Handlers) JoinGroup(c *gin.Context) {
+	type JoinRequest struct {
+		ClientID string `json:"client_id" binding:"required"`
+		Group    string `json:"group" binding:"required"`
+		Secret   string `json:"secret"`
+	}
+
+	var req JoinRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invalid_request",
+			"detail": err.Error(),
+		})
+		return
+	}
+
+	if !utils.ValidateID(req.ClientID, 32) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invalid_client_id",
+		})
+		return
+	}
+	if !h.authorized(c, req.ClientID) {
+		return
+	}
+
+	var info ClientInfo
+	if h.server != nil {
+		var exists bool
+		if info, exists = h.server.GetClient(req.ClientID); !exists {
+			c.JSON(http.StatusNotFound, gin.H{
+				"status": "error",
+				"error":  "client_not_found",
+			})
+			return
+		}
+	}
+
+	others, added, err := h.groups.Join(req.Group, req.ClientID, req.Secret)
+	switch {
+	case errors.Is(err, ErrInvalidGroupName):
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invalid_group_name",
+		})
+		return
+	case errors.Is(err, ErrGroupAccessDenied):
+		h.logger.WithFields(map[string]interface{}{
+			"client_id": req.ClientID,
+			"group":     req.Group,
+		}).Warn("Group join denied")
+
+		c.JSON(http.StatusForbidden, gin.H{
+			"status": "error",
+			"error":  "group_access_denied",
+		})
+		return
+	}
+
+	if added {
+		h.notifyPresence(req.Group, others, req.ClientID, protocol.PresenceJoined, info)
+		h.logger.WithFields(map[string]interface{}{
+			"client_id": req.ClientID,
+			"group":     req.Group,
+			"members":   len(others) + 1,
+		}).Info("Client joined group")
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "joined",
+		"group":   req.Group,
+		"members": len(others) + 1,
+	})
+}
+
+// LeaveGroup removes a client from a group and tells the other members
+func (h *Handlers) LeaveGroup(c *gin.Context) {
+	type LeaveRequest struct {
+		ClientID string `json:"client_id" binding:"required"`
+		Group    string `json:"group" binding:"required"`
+	}
+
+	var req LeaveRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invalid_request",
+			"detail": err.Error(),
+		})
+		return
+	}
+	// Only members can leave, not anyone who knows their IDs
+	if !h.authorized(c, req.ClientID) {
+		return
+	}
+
+	others, err := h.groups.Leave(req.Group, req.ClientID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status": "error",
+			"error":  "not_a_member",
+		})
+		return
+	}
+
+	h.notifyPresence(req.Group, others, req.ClientID, protocol.PresenceLeft, ClientInfo{})
+	h.logger.WithFields(map[string]interface{}{
+		"client_id": req.ClientID,
+		"group":     req.Group,
+	}).Info("Client left group")
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "left",
+		"group":  req.Group,
+	})
+}
+
+// ListPeers returns the online members of a group to one of its members
+func (h *Handlers) ListPeers(c *gin.Context) {
+	group := c.Param("group")
+	clientID := c.Query("client_id")
+
+	if !utils.ValidateID(clientID, 32) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status": "error",
+			"error":  "invalid_client_id",
+		})
+		return
+	}
+	// Member IDs are handed out here, so knowing one proves nothing
+	if !h.authorized(c, clientID) {
+		return
+	}
+
+	members, err := h.groups.Members(group, clientID)
+	if err != nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"status": "error",
+			"error":  "not_a_member",
+		})
+		return
+	}
+
+	peers := make([]PeerInfo, 0, len(members))
+	for _, id := range members {
+		if peer, online := h.onlinePeer(id); online {
+			peers = append(peers, peer)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+		"group":  group,
+		"peers":  peers,
+		"count":  len(peers),
+	})
+}
+
+// onlinePeer returns what other members see of a client, and false if it
+// hasn't been seen recently. Without client tracking every member counts as
+// online.
+func (h *Handlers) onlinePeer(clientID string) (PeerInfo, bool) {
+	peer := PeerInfo{ID: clientID}
+	if h.server == nil {
+		return peer, true
+	}
+
+	info, exists := h.server.GetClient(clientID)
+	if !exists || time.Since(info.LastSeen) > onlineWindow {
+		return peer, false
+	}
+	peer.Name = info.Name
+	peer.Properties = info.Properties
+	peer.LastSeen = info.LastSeen
+
+	if pool := h.addressPool(); pool != nil {
+		if addr, assigned := pool.Address(clientID); assigned {
+			peer.VirtualIP = addr.String()
+		}
+	}
+	return peer, true
+}
+
+// notifyPresence queues a presence message about clientID for each member
+func (h *Handlers) notifyPresence(group string, members []string, clientID, event string, info ClientInfo) {
+	if len(members) == 0 {
+		return
+	}
+
+	message, err := protocol.NewMessage(protocol.TypePresence, clientID, protocol.PresencePayload{
+		Group:      group,
+		Event:      event,
+		Name:       info.Name,
+		Properties: info.Properties,
+	})
+	if err != nil {
+		return
+	}
+
+	for _, member := range members {
+		notification := *message
+		notification.TargetID = member
+		h.messages.AddMessage(member, notification)
+	}
+}
+
+// leaveGroups removes a client that is gone from its groups, telling the
+// remaining members
+func (h *Handlers) leaveGroups(clientID string) {
+	for group, others := range h.groups.LeaveAll(clientID) {
+		h.notifyPresence(group, others, clientID, protocol.PresenceLeft, ClientInfo{})
+	}
+}
@@ -0,0 +1,167 @@
+// internal/signaling/groups_test.go
+package signaling
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/internal/utils"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupRegistry(t *testing.T) {
+	groups := NewGroupRegistry()
+
+	others, added, err := groups.Join("office", "alice", "s3cret")
+	assert.NoError(t, err)
+	assert.True(t, added)
+	assert.Empty(t, others)
+
+	// Later members need the secret the group was created with
+	_, _, err = groups.Join("office", "bob", "guess")
+	assert.ErrorIs(t, err, ErrGroupAccessDenied)
+	others, added, err = groups.Join("office", "bob", "s3cret")
+	assert.NoError(t, err)
+	assert.True(t, added)
+	assert.Equal(t, []string{"alice"}, others)
+
+	// Joining again changes nothing
+	_, added, err = groups.Join("office", "bob", "s3cret")
+	assert.NoError(t, err)
+	assert.False(t, added)
+
+	_, _, err = groups.Join("bad name", "alice", "")
+	assert.ErrorIs(t, err, ErrInvalidGroupName)
+
+	// Only members see a group
+	members, err := groups.Members("office", "bob")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice"}, members)
+	_, err = groups.Members("office", "carol")
+	assert.ErrorIs(t, err, ErrNotGroupMember)
+	_, err = groups.Members("elsewhere", "alice")
+	assert.ErrorIs(t, err, ErrNotGroupMember)
+
+	groups.Join("lab", "alice", "")
+	assert.Equal(t, 2, groups.Count())
+	left := groups.LeaveAll("alice")
+	assert.Equal(t, map[string][]string{"office": {"bob"}, "lab": {}}, left)
+	assert.Equal(t, 1, groups.Count())
+
+	// The group and its secret go away with the last member
+	others, err = groups.Leave("office", "bob")
+	assert.NoError(t, err)
+	assert.Empty(t, others)
+	_, err = groups.Leave("office", "bob")
+	assert.ErrorIs(t, err, ErrNotGroupMember)
+	_, _, err = groups.Join("office", "carol", "")
+	assert.NoError(t, err)
+}
+
+func TestGroupRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server, err := NewServer(utils.NewLogger("test", "error"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.handlers.connections.Stop()
+
+	alice := registerTestClient(t, server.router, map[string]interface{}{
+		"name":       "alice",
+		"properties": map[string]string{"role": "db"},
+		"mesh":       true,
+	})
+	bob := registerTestClient(t, server.router, map[string]interface{}{"name": "bob"})
+	carol := registerTestClient(t, server.router, map[string]interface{}{})
+
+	join := func(client *testClient, secret string) int {
+		return client.post("/api/v1/groups/join", map[string]interface{}{
+			"client_id": client.id,
+			"group":     "office",
+			"secret":    secret,
+		}).Code
+	}
+	leave := func(client *testClient, id string) int {
+		return client.post("/api/v1/groups/leave", map[string]interface{}{"client_id": id, "group": "office"}).Code
+	}
+	listPeers := func(client *testClient, id string) (int, []PeerInfo) {
+		w := client.get("/api/v1/groups/office/peers?client_id=" + id)
+
+		var response struct {
+			Peers []PeerInfo `json:"peers"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &response)
+		return w.Code, response.Peers
+	}
+
+	assert.Equal(t, http.StatusOK, join(bob, "s3cret"))
+	assert.Equal(t, http.StatusOK, join(alice, "s3cret"))
+	assert.Equal(t, http.StatusForbidden, join(carol, "wrong"))
+
+	// Bob hears that alice joined
+	messages := server.handlers.messages.GetMessages(bob.id)
+	if assert.Len(t, messages, 1) {
+		assert.Equal(t, protocol.TypePresence, messages[0].Type)
+		assert.Equal(t, alice.id, messages[0].ClientID)
+		var presence protocol.PresencePayload
+		assert.NoError(t, json.Unmarshal(messages[0].Payload, &presence))
+		assert.Equal(t, protocol.PresencePayload{
+			Group:      "office",
+			Event:      protocol.PresenceJoined,
+			Name:       "alice",
+			Properties: map[string]string{"role": "db"},
+		}, presence)
+	}
+
+	code, peers := listPeers(bob, bob.id)
+	assert.Equal(t, http.StatusOK, code)
+	if assert.Len(t, peers, 1) {
+		assert.Equal(t, alice.id, peers[0].ID)
+		assert.Equal(t, "db", peers[0].Properties["role"])
+		assert.NotEmpty(t, peers[0].VirtualIP)
+	}
+
+	// Carol isn't a member, so she sees nothing, even giving a member's ID
+	code, _ = listPeers(carol, carol.id)
+	assert.Equal(t, http.StatusForbidden, code)
+	code, _ = listPeers(carol, bob.id)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	// Nor can she act for a member, or anyone without signing
+	assert.Equal(t, http.StatusForbidden, leave(carol, bob.id))
+	w := postJSON(server.router, "/api/v1/groups/leave", map[string]interface{}{"client_id": bob.id, "group": "office"})
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/api/v1/groups/office/peers?client_id="+bob.id, nil)
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Members not seen recently aren't listed
+	info, _ := server.GetClient(alice.id)
+	info.LastSeen = time.Now().Add(-time.Hour)
+	server.RegisterClient(alice.id, info)
+	_, peers = listPeers(bob, bob.id)
+	assert.Empty(t, peers)
+
+	// Leaving, or being removed by the server, is announced too
+	assert.Equal(t, http.StatusOK, leave(bob, bob.id))
+	assert.Equal(t, http.StatusNotFound, leave(bob, bob.id))
+	assert.Equal(t, http.StatusOK, join(bob, "s3cret"))
+	server.RemoveClient(bob.id)
+
+	messages = server.handlers.messages.GetMessages(alice.id)
+	if assert.Len(t, messages, 3) {
+		for i, event := range []string{protocol.PresenceLeft, protocol.PresenceJoined, protocol.PresenceLeft} {
+			var presence protocol.PresencePayload
+			json.Unmarshal(messages[i].Payload, &presence)
+			assert.Equal(t, event, presence.Event)
+			assert.Equal(t, bob.id, messages[i].ClientID)
+		}
+	}
+	assert.Equal(t, 1, server.handlers.groups.Count())
+}
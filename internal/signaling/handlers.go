@@ -3,14 +3,20 @@
 package signaling
 
 import (
+	"encoding/hex"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/bOguzhan/NATbypass/internal/config"
+	"github.com/bOguzhan/NATbypass/internal/metrics"
 	"github.com/bOguzhan/NATbypass/internal/utils"
 	"github.com/bOguzhan/NATbypass/pkg/networking"
 	"github.com/bOguzhan/NATbypass/pkg/protocol"
+	"github.com/bOguzhan/NATbypass/pkg/tracing"
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Handlers encapsulates the HTTP handlers for the signaling server
@@ -18,17 +24,41 @@ type Handlers struct {
 	logger      *utils.Logger
 	server      *Server // Reference to the server for client management
 	config      *config.Config
+	configMu    sync.RWMutex
 	connections *ConnectionRegistry // Add this field
 	messages    *MessageQueue       // Add this field
+	groups      *GroupRegistry
+	metrics     *signalingMetrics
+	keys        *sessionKeys
+
+	addresses     *AddressPool // Virtual IPs, see addressPool
+	addressesOnce sync.Once
 }
 
 // NewHandlers creates a new instance of signaling handlers
-func NewHandlers(logger *utils.Logger) *Handlers {
-	return &Handlers{
+func NewHandlers(logger *utils.Logger) (*Handlers, error) {
+	keys, err := newSessionKeys()
+	if err != nil {
+		return nil, err
+	}
+	h := &Handlers{
 		logger:      logger,
 		connections: NewConnectionRegistry(logger),
 		messages:    NewMessageQueue(logger),
+		groups:      NewGroupRegistry(),
+		keys:        keys,
+	}
+	m, err := newSignalingMetrics(h)
+	if err != nil {
+		return nil, err
 	}
+	h.metrics = m
+	return h, nil
+}
+
+// Metrics returns the registry behind the /metrics endpoint
+func (h *Handlers) Metrics() *metrics.Registry {
+	return h.metrics.registry
 }
 
 // SetServer sets the server reference
@@ -36,19 +66,34 @@ func (h *Handlers) SetServer(server *Server) {
 	h.server = server
 }
 
-// SetConfig sets the configuration reference
+// SetConfig sets the configuration reference. It may be called while
+// requests are being served, e.g. after a reload.
 func (h *Handlers) SetConfig(cfg *config.Config) {
+	h.configMu.Lock()
 	h.config = cfg
+	h.configMu.Unlock()
+}
+
+// getConfig returns the current configuration, nil if none was set
+func (h *Handlers) getConfig() *config.Config {
+	h.configMu.RLock()
+	defer h.configMu.RUnlock()
+	return h.config
 }
 
 // RegisterClient handles client registration requests
 func (h *Handlers) RegisterClient(c *gin.Context) {
 	type RegisterRequest struct {
-		ClientID   string            `json:"client_id"`
-		Name       string            `json:"name"`
-		Properties map[string]string `json:"properties"`
+		ClientID       string            `json:"client_id"`
+		Name           string            `json:"name"`
+		Properties     map[string]string `json:"properties"`
+		LocalAddresses []string          `json:"local_addresses"`
+		Mesh           bool              `json:"mesh"` // Whether to assign a virtual address
 	}
 
+	// Checked before the body is consumed by binding it
+	provenID, proofErr := h.verifyRequest(c)
+
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		h.logger.WithFields(map[string]interface{}{
@@ -63,9 +108,27 @@ func (h *Handlers) RegisterClient(c *gin.Context) {
 		return
 	}
 
-	// Validate client ID if provided, or generate a new one
-	clientID := req.ClientID
-	if clientID == "" || !utils.ValidateID(clientID, 32) {
+	// Only IDs the server generated are kept, and only by a client signing
+	// the request with the ID's session key. Others get a new ID, unless
+	// the ID is in use, since that client would be locked out.
+	clientID := ""
+	if req.ClientID != "" {
+		if _, inUse := h.keys.keyID(req.ClientID); proofErr == nil && provenID == req.ClientID {
+			clientID = req.ClientID
+		} else if inUse {
+			h.logger.WithFields(map[string]interface{}{
+				"client_id": req.ClientID,
+				"ip":        c.ClientIP(),
+			}).Warn("Unauthenticated registration of a client ID in use")
+
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"status": "error",
+				"error":  "unauthorized",
+			})
+			return
+		}
+	}
+	if clientID == "" {
 		var err error
 		clientID, err = utils.GeneratePeerID()
 		if err != nil {
@@ -82,6 +145,16 @@ func (h *Handlers) RegisterClient(c *gin.Context) {
 		}
 	}
 
+	// A client that proved an ID but takes a new one gives up the old ID
+	// and its address
+	if proofErr == nil && provenID != clientID {
+		if h.server != nil {
+			h.server.RemoveClient(provenID)
+		} else if pool := h.addressPool(); pool != nil {
+			pool.Release(provenID)
+		}
+	}
+
 	// Store client information if server is available
 	if h.server != nil {
 		h.server.RegisterClient(clientID, ClientInfo{
@@ -92,20 +165,50 @@ func (h *Handlers) RegisterClient(c *gin.Context) {
 			UserAgent:  c.Request.UserAgent(),
 			IsOnline:   true,
 			Properties: req.Properties,
+
+			LocalAddresses: privateEndpoints(req.LocalAddresses),
 		})
 	}
 
+	h.metrics.registrations.Inc()
 	h.logger.WithFields(map[string]interface{}{
 		"client_id": clientID,
 		"name":      req.Name,
 		"ip":        c.ClientIP(),
 	}).Info("Client registered")
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"status":    "registered",
 		"client_id": clientID,
 		"timestamp": time.Now(),
-	})
+	}
+
+	// Assign the address the client uses in the mesh overlay, only to
+	// clients joining it so the others can't exhaust the pool
+	if pool := h.addressPool(); pool != nil && !req.Mesh {
+		pool.Release(clientID)
+	} else if pool != nil {
+		if addr, err := pool.Assign(clientID); err == nil {
+			response["virtual_ip"] = addr.String()
+			response["virtual_network"] = pool.Network().String()
+		} else {
+			h.logger.WithFields(map[string]interface{}{
+				"client_id": clientID,
+				"error":     err.Error(),
+			}).Warn("No virtual address assigned")
+		}
+	}
+
+	// Issue the key the client signs its requests with. The rendezvous
+	// servers check it too when they share the rendezvous secret.
+	keyID, key := h.issueKey(clientID)
+	response["session_key"] = hex.EncodeToString(key)
+	response["key_id"] = keyID
+	if cfg := h.getConfig(); cfg != nil && cfg.Security.RendezvousSecret != "" {
+		response["rendezvous_auth"] = true
+	}
+
+	c.JSON(http.StatusOK, response)
 }
 
 // GetPublicAddress returns the client's public IP and port using STUN
@@ -117,8 +220,9 @@ func (h *Handlers) GetPublicAddress(c *gin.Context) {
 	stunServer := "stun.l.google.com:19302" // Default STUN server
 
 	// Use config if available
-	if h.config != nil && h.config.STUN.Server != "" {
-		stunServer = h.config.STUN.Server
+	cfg := h.getConfig()
+	if cfg != nil && cfg.STUN.Server != "" {
+		stunServer = cfg.STUN.Server
 	}
 
 	h.logger.Debug("Attempting STUN discovery for client address")
@@ -131,9 +235,9 @@ func (h *Handlers) GetPublicAddress(c *gin.Context) {
 	}
 
 	// Use config values if available
-	if h.config != nil {
-		stunConfig.TimeoutSeconds = h.config.STUN.TimeoutSeconds
-		stunConfig.RetryCount = h.config.STUN.RetryCount
+	if cfg != nil {
+		stunConfig.TimeoutSeconds = int(cfg.STUN.Timeout / time.Second)
+		stunConfig.RetryCount = cfg.STUN.Retries
 	}
 
 	addr, err := networking.DiscoverPublicAddressWithConfig(stunConfig)
@@ -182,6 +286,9 @@ func (h *Handlers) Heartbeat(c *gin.Context) {
 		})
 		return
 	}
+	if !h.authorized(c, req.ClientID) {
+		return
+	}
 
 	// Update client last seen time if server is available
 	if h.server != nil {
@@ -225,6 +332,9 @@ func (h *Handlers) PollMessages(c *gin.Context) {
 		})
 		return
 	}
+	if !h.authorized(c, clientID) {
+		return
+	}
 
 	// Get and clear messages for this client
 	messages := h.messages.GetMessages(clientID)
@@ -255,6 +365,10 @@ func (h *Handlers) SendSignal(c *gin.Context) {
 		})
 		return
 	}
+	// Peers trust the sender of a signal, so it must be the caller
+	if !h.authorized(c, message.ClientID) {
+		return
+	}
 
 	// Validate message type
 	switch message.Type {
@@ -268,14 +382,26 @@ func (h *Handlers) SendSignal(c *gin.Context) {
 		return
 	}
 
+	// Clients that don't embed a trace context still get the receiver's
+	// spans linked through this request's trace
+	ctx := c.Request.Context()
+	if len(message.TraceContext) == 0 {
+		message.TraceContext = tracing.Inject(ctx)
+	}
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("natbypass.message_type", string(message.Type)),
+		attribute.String("natbypass.source_id", message.ClientID),
+		attribute.String("natbypass.target_id", message.TargetID),
+	)
+
 	// Queue the message for the target client
 	h.messages.AddMessage(message.TargetID, message)
 
-	h.logger.WithFields(map[string]interface{}{
-		"from": message.ClientID,
-		"to":   message.TargetID,
-		"type": message.Type,
-	}).Info("Signal message queued")
+	fields := tracing.LogFields(ctx)
+	fields["from"] = message.ClientID
+	fields["to"] = message.TargetID
+	fields["type"] = message.Type
+	h.logger.WithFields(fields).Info("Signal message queued")
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":    "message_queued",
@@ -293,20 +419,26 @@ func (h *Handlers) SetupRoutes(router *gin.Engine) {
 	{
 		v1.POST("/register", h.RegisterClient)
 		v1.GET("/address", h.GetPublicAddress)
-		v1.POST("/heartbeat", h.Heartbeat)
+		v1.POST("/heartbeat", h.authenticate, h.Heartbeat)
 
 		// Add new connection endpoints
-		v1.POST("/connect", h.RequestConnection)
-		v1.GET("/connections/:client_id", h.GetActiveConnections)
-		v1.POST("/signal", h.SendSignal)
-		v1.GET("/messages/:client_id", h.PollMessages)
-		v1.POST("/connection/update", h.UpdateConnectionStatus) // Add this line
+		v1.POST("/connect", h.authenticate, h.RequestConnection)
+		v1.GET("/connections/:client_id", h.authenticate, h.GetActiveConnections)
+		v1.POST("/signal", h.authenticate, h.SendSignal)
+		v1.GET("/messages/:client_id", h.authenticate, h.PollMessages)
+		v1.POST("/connection/update", h.authenticate, h.UpdateConnectionStatus) // Add this line
+		v1.GET("/virtual-ips/:ip", h.LookupVirtualIP)
+
+		// Groups clients discover each other through
+		v1.POST("/groups/join", h.authenticate, h.JoinGroup)
+		v1.POST("/groups/leave", h.authenticate, h.LeaveGroup)
+		v1.GET("/groups/:group/peers", h.authenticate, h.ListPeers)
 	}
 
 	// Version info
 	router.GET("/version", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{
-			"version": "0.1.0",
+			"version": utils.Version,
 			"name":    "mediatory-server",
 		})
 	})
@@ -3,26 +3,32 @@ package signaling
 
 import (
 	"bytes"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
 
+	"github.com/bOguzhan/NATbypass/internal/config"
 	"github.com/bOguzhan/NATbypass/internal/utils"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
 	"github.com/gin-gonic/gin"
 )
 
-func setupTestRouter() (*gin.Engine, *Handlers) {
+func setupTestRouter(t *testing.T) (*gin.Engine, *Handlers) {
 	gin.SetMode(gin.TestMode)
 	router := gin.New()
 	logger := utils.NewLogger("test", "info")
-	handlers := NewHandlers(logger)
+	handlers, err := NewHandlers(logger)
+	if err != nil {
+		t.Fatal(err)
+	}
 	handlers.SetupRoutes(router)
 	return router, handlers
 }
 
 func TestHealthEndpoint(t *testing.T) {
-	router, _ := setupTestRouter()
+	router, _ := setupTestRouter(t)
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest(http.MethodGet, "/health", nil)
@@ -43,7 +49,7 @@ func TestHealthEndpoint(t *testing.T) {
 }
 
 func TestRegisterClient(t *testing.T) {
-	router, _ := setupTestRouter()
+	router, _ := setupTestRouter(t)
 
 	// Test valid registration
 	w := httptest.NewRecorder()
@@ -78,7 +84,7 @@ func TestRegisterClient(t *testing.T) {
 }
 
 func TestGetPublicAddress(t *testing.T) {
-	router, _ := setupTestRouter()
+	router, _ := setupTestRouter(t)
 
 	w := httptest.NewRecorder()
 	req, _ := http.NewRequest(http.MethodGet, "/api/v1/address", nil)
@@ -101,3 +107,34 @@ func TestGetPublicAddress(t *testing.T) {
 		t.Error("Response should contain ip")
 	}
 }
+
+func TestRegisterClientIssuesSessionKey(t *testing.T) {
+	router, handlers := setupTestRouter(t)
+
+	cfg := config.DefaultConfig()
+	cfg.Security.RendezvousSecret = "test-master-secret"
+	handlers.SetConfig(cfg)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodPost, "/api/v1/register", bytes.NewBufferString(`{"name":"test-client"}`))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, w.Code)
+	}
+
+	var response struct {
+		ClientID   string `json:"client_id"`
+		SessionKey string `json:"session_key"`
+		KeyID      uint32 `json:"key_id"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	expected := protocol.DeriveClientKey([]byte("test-master-secret"), response.ClientID, response.KeyID)
+	if response.SessionKey != hex.EncodeToString(expected) {
+		t.Errorf("Session key does not match the key derived from the master secret")
+	}
+}
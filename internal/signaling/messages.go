@@ -88,6 +88,18 @@ func (q *MessageQueue) PeekMessages(clientID string) []protocol.Message {
 	return result
 }
 
+// Depth returns the number of messages waiting across all clients
+func (q *MessageQueue) Depth() int {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	depth := 0
+	for _, messages := range q.messages {
+		depth += len(messages)
+	}
+	return depth
+}
+
 // CleanupOldMessages removes messages older than maxAge
 func (q *MessageQueue) CleanupOldMessages(maxAge time.Duration) int {
 	q.mu.Lock()
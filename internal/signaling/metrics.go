@@ -0,0 +1,129 @@
+// internal/signaling/metrics.go
+package signaling
+
+import (
+	"strings"
+
+	"github.com/bOguzhan/NATbypass/internal/discovery"
+	"github.com/bOguzhan/NATbypass/internal/metrics"
+	"github.com/bOguzhan/NATbypass/pkg/networking"
+	"github.com/gin-gonic/gin"
+)
+
+// signalingMetrics holds the mediatory server's Prometheus metrics
+type signalingMetrics struct {
+	registry       *metrics.Registry
+	latency        gin.HandlerFunc // Records HTTP request latency
+	registrations  *metrics.Counter
+	punchAttempts  *metrics.Counter
+	punchSuccesses *metrics.Counter
+}
+
+// newSignalingMetrics registers the mediatory server metrics. Gauges are
+// read from the handlers' state on every scrape.
+func newSignalingMetrics(h *Handlers) (*signalingMetrics, error) {
+	registry := metrics.NewRegistry()
+	m := &signalingMetrics{registry: registry}
+
+	var err error
+	m.registrations, err = registry.NewCounter("natbypass_registrations_total",
+		"Client registrations accepted, including re-registrations")
+	if err != nil {
+		return nil, err
+	}
+	m.punchAttempts, err = registry.NewCounter("natbypass_punch_attempts_total",
+		"Connection attempts resolved, by strategy and NAT type pair",
+		"strategy", "local_nat_type", "remote_nat_type")
+	if err != nil {
+		return nil, err
+	}
+	m.punchSuccesses, err = registry.NewCounter("natbypass_punch_successes_total",
+		"Connection attempts established, by strategy and NAT type pair",
+		"strategy", "local_nat_type", "remote_nat_type")
+	if err != nil {
+		return nil, err
+	}
+	m.latency, err = registry.GinMiddleware()
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.NewFunc("natbypass_clients", "Registered clients by online state",
+		metrics.KindGauge, []string{"state"}, func(observe func(float64, ...string)) {
+			var total, online int
+			if h.server != nil {
+				total, online = h.server.clientCounts()
+			}
+			observe(float64(online), "online")
+			observe(float64(total-online), "offline")
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.NewFunc("natbypass_connections", "Tracked connection requests by status",
+		metrics.KindGauge, []string{"status"}, func(observe func(float64, ...string)) {
+			for status, count := range h.connections.GetConnectionStats() {
+				if status != "total" {
+					observe(float64(count), status)
+				}
+			}
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.NewGaugeFunc("natbypass_message_queue_depth",
+		"Signaling messages waiting to be polled", func() float64 {
+			return float64(h.messages.Depth())
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	err = registry.NewGaugeFunc("natbypass_groups",
+		"Groups with at least one member", func() float64 {
+			return float64(h.groups.Count())
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := metrics.RegisterProcessMetrics(registry); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// recordPunch counts a resolved connection attempt. Labels come from
+// clients, so they are reduced to known values to bound the series count.
+func (m *signalingMetrics) recordPunch(strategy, localNATType, remoteNATType string, success bool) {
+	labels := []string{
+		strategyLabel(strategy),
+		string(discovery.ParseNATType(localNATType)),
+		string(discovery.ParseNATType(remoteNATType)),
+	}
+
+	m.punchAttempts.Inc(labels...)
+	if success {
+		m.punchSuccesses.Inc(labels...)
+	}
+}
+
+// strategyLabel maps a strategy type or display name such as "UDP Hole
+// Punching" to its strategy type, or "unknown"
+func strategyLabel(name string) string {
+	key := strings.ToLower(strings.NewReplacer(" ", "-", "_", "-").Replace(strings.TrimSpace(name)))
+	for _, strategyType := range []networking.StrategyType{
+		networking.UDPHolePunching,
+		networking.TCPSimultaneousOpen,
+		networking.UDPRelaying,
+		networking.TCPRelaying,
+		networking.PortMapping,
+	} {
+		if key == string(strategyType) {
+			return key
+		}
+	}
+	return "unknown"
+}
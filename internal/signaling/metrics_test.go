@@ -0,0 +1,97 @@
+// internal/signaling/metrics_test.go
+package signaling
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// postJSON sends a JSON request to a router and returns the recorder
+func postJSON(router http.Handler, path string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req, _ := http.NewRequest(http.MethodPost, path, bytes.NewBuffer(data))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server, err := NewServer(utils.NewLogger("test", "error"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.handlers.connections.Stop()
+
+	source := registerTestClient(t, server.router, map[string]interface{}{"name": "source"})
+	target := registerTestClient(t, server.router, map[string]interface{}{"name": "target"})
+
+	w := source.post("/api/v1/connect", map[string]interface{}{
+		"source_id": source.id,
+		"target_id": target.id,
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response map[string]interface{}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	connID := response["connection_id"].(string)
+
+	// Both peers report success; only the first report is counted
+	for _, peer := range []*testClient{source, target} {
+		w = peer.post("/api/v1/connection/update", map[string]interface{}{
+			"connection_id": connID,
+			"status":        "established",
+			"strategy":      "UDP Hole Punching",
+			"nat_type":      "full-cone",
+			"peer_nat_type": "PortRestrictedCone",
+		})
+		assert.Equal(t, http.StatusOK, w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/metrics", nil)
+	server.router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	body := w.Body.String()
+	assert.Contains(t, body, "natbypass_registrations_total 2\n")
+	assert.Contains(t, body, `natbypass_clients{state="online"} 2`)
+	assert.Contains(t, body, `natbypass_connections{status="established"} 1`)
+	assert.Contains(t, body, "natbypass_message_queue_depth 0\n")
+	assert.Contains(t, body, `natbypass_punch_attempts_total{strategy="udp-hole-punching",local_nat_type="full-cone",remote_nat_type="port-restricted-cone"} 1`)
+	assert.Contains(t, body, `natbypass_punch_successes_total{strategy="udp-hole-punching",local_nat_type="full-cone",remote_nat_type="port-restricted-cone"} 1`)
+	assert.Contains(t, body, `natbypass_http_request_duration_seconds_count{method="POST",route="/api/v1/register",status="200"} 2`)
+	assert.Contains(t, body, `natbypass_build_info{version="`+utils.Version+`"} 1`)
+}
+
+func TestStatsUptime(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	server, err := NewServer(utils.NewLogger("test", "error"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.handlers.connections.Stop()
+
+	time.Sleep(20 * time.Millisecond)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/stats", nil)
+	server.router.ServeHTTP(w, req)
+
+	var response struct {
+		Stats struct {
+			UptimeSeconds float64 `json:"uptime_seconds"`
+		} `json:"stats"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.GreaterOrEqual(t, response.Stats.UptimeSeconds, 0.02, "Uptime counts from process start")
+}
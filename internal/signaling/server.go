@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/bOguzhan/NATbypass/internal/utils"
+	"github.com/bOguzhan/NATbypass/pkg/tracing"
 	"github.com/gin-gonic/gin"
 )
 
@@ -30,16 +31,23 @@ type ClientInfo struct {
 	UserAgent  string            `json:"user_agent"`
 	IsOnline   bool              `json:"is_online"`
 	Properties map[string]string `json:"properties"`
+
+	// LocalAddresses are the private endpoints the client announced, handed
+	// to peers that share its public IP
+	LocalAddresses []string `json:"local_addresses,omitempty"`
 }
 
 // NewServer creates a new signaling server instance
-func NewServer(logger *utils.Logger) *Server {
+func NewServer(logger *utils.Logger) (*Server, error) {
 	// Create a new Gin router
 	router := gin.New()
 	router.Use(gin.Recovery())
 
 	// Create handlers with logger
-	handlers := NewHandlers(logger)
+	handlers, err := NewHandlers(logger)
+	if err != nil {
+		return nil, err
+	}
 
 	server := &Server{
 		router:   router,
@@ -57,11 +65,14 @@ func NewServer(logger *utils.Logger) *Server {
 	// Start cleanup goroutines
 	go server.startCleanupTasks()
 
-	return server
+	return server, nil
 }
 
 // setupRoutes configures all HTTP routes for the server
 func (s *Server) setupRoutes() {
+	// Trace every request, continuing the client's trace if it sent one
+	s.router.Use(tracing.GinMiddleware("mediatory-server"))
+
 	// Add custom logger middleware
 	s.router.Use(func(c *gin.Context) {
 		path := c.Request.URL.Path
@@ -69,12 +80,12 @@ func (s *Server) setupRoutes() {
 
 		start := time.Now()
 
-		s.logger.WithFields(map[string]interface{}{
-			"method": method,
-			"path":   path,
-			"client": c.ClientIP(),
-			"ua":     c.Request.UserAgent(),
-		}).Info("Request")
+		fields := tracing.LogFields(c.Request.Context())
+		fields["method"] = method
+		fields["path"] = path
+		fields["client"] = c.ClientIP()
+		fields["ua"] = c.Request.UserAgent()
+		s.logger.WithFields(fields).Info("Request")
 
 		c.Next()
 
@@ -89,39 +100,46 @@ func (s *Server) setupRoutes() {
 		}).Debug("Response")
 	})
 
+	// Record request latency for every route
+	s.router.Use(s.handlers.metrics.latency)
+
 	// Set up all routes
 	s.handlers.SetupRoutes(s.router)
 
 	// Add any server-specific routes
 	s.router.GET("/stats", s.handleStats)
+	s.router.GET("/metrics", s.handlers.Metrics().Handler())
 }
 
 // handleStats returns server statistics
 func (s *Server) handleStats(c *gin.Context) {
-	s.mu.Lock()
-	clientCount := len(s.clients)
-	activeClients := 0
-
-	for _, client := range s.clients {
-		if client.IsOnline {
-			activeClients++
-		}
-	}
-	s.mu.Unlock()
-
-	sysInfo, _ := utils.GetSystemInfo()
+	clientCount, activeClients := s.clientCounts()
 
 	c.JSON(http.StatusOK, gin.H{
 		"status": "ok",
 		"stats": gin.H{
 			"total_clients":  clientCount,
 			"active_clients": activeClients,
-			"uptime_seconds": time.Since(sysInfo.StartTime).Seconds(),
-			"version":        "0.1.0",
+			"uptime_seconds": utils.Uptime().Seconds(),
+			"version":        utils.Version,
 		},
 	})
 }
 
+// clientCounts returns the number of registered clients and how many of
+// them are online
+func (s *Server) clientCounts() (total, online int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, client := range s.clients {
+		if client.IsOnline {
+			online++
+		}
+	}
+	return len(s.clients), online
+}
+
 // Start begins the HTTP server on the specified address
 func (s *Server) Start(address string) error {
 	s.server = &http.Server{
@@ -169,9 +187,19 @@ func (s *Server) RemoveClient(id string) {
 	defer s.mu.Unlock()
 
 	delete(s.clients, id)
+	s.releaseAddress(id)
+	s.handlers.leaveGroups(id)
+	s.handlers.keys.forget(id)
 	s.logger.Infof("Client removed: %s", id)
 }
 
+// releaseAddress frees the virtual address of a client that is gone
+func (s *Server) releaseAddress(id string) {
+	if pool := s.handlers.addressPool(); pool != nil {
+		pool.Release(id)
+	}
+}
+
 // GetHandlers returns the server's handlers
 func (s *Server) GetHandlers() *Handlers {
 	return s.handlers
@@ -198,6 +226,9 @@ func (s *Server) startCleanupTasks() {
 		for id, client := range s.clients {
 			if client.LastSeen.Before(cutoff) {
 				delete(s.clients, id)
+				s.releaseAddress(id)
+				s.handlers.leaveGroups(id)
+				s.handlers.keys.forget(id)
 				clientCount++
 			}
 		}
@@ -2,14 +2,22 @@
 package stun
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"time"
 
 	"github.com/bOguzhan/NATbypass/internal/utils"
+	"github.com/bOguzhan/NATbypass/pkg/tracing"
 	"github.com/pion/stun"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracer records STUN discovery as part of a traversal trace
+var tracer = otel.Tracer("github.com/bOguzhan/NATbypass/internal/stun")
+
 // Client represents a STUN client
 type Client struct {
 	Logger  *utils.Logger
@@ -28,12 +36,35 @@ func NewClient(logger *utils.Logger, server string, timeout int, retries int) *C
 	}
 }
 
-// DiscoverPublicAddress discovers the public IP and port using STUN
+// DiscoverPublicAddress discovers the public IPv4 address and port using STUN
 func (c *Client) DiscoverPublicAddress() (*net.UDPAddr, error) {
-	c.Logger.Debugf("Discovering public address using STUN server: %s", c.Server)
+	return c.DiscoverPublicAddressContext(context.Background())
+}
+
+// DiscoverPublicAddressContext discovers the public IPv4 address, traced as
+// part of the trace in ctx
+func (c *Client) DiscoverPublicAddressContext(ctx context.Context) (*net.UDPAddr, error) {
+	return c.discover(ctx, "udp4")
+}
+
+// discover runs a binding request against the STUN server over the given network
+func (c *Client) discover(ctx context.Context, network string) (addr *net.UDPAddr, err error) {
+	ctx, span := tracer.Start(ctx, "stun.discover", trace.WithAttributes(
+		attribute.String("natbypass.stun_server", c.Server),
+		attribute.String("net.transport", network),
+	))
+	defer func() {
+		if addr != nil {
+			span.SetAttributes(attribute.String("natbypass.public_addr", addr.String()))
+		}
+		tracing.End(span, err)
+	}()
+
+	c.Logger.Debugf("Discovering public address using STUN server: %s (%s)", c.Server, network)
 
 	// Create a connection to the STUN server
-	conn, err := net.Dial("udp4", c.Server)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, c.Server)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to STUN server: %w", err)
 	}
@@ -0,0 +1,16 @@
+// internal/tracing/tracing_test.go
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bOguzhan/NATbypass/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetupDisabled(t *testing.T) {
+	shutdown, err := Setup(context.Background(), "test", config.TracingConfig{})
+	assert.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+}
@@ -9,6 +9,13 @@ import (
 	"time"
 )
 
+// Version is the NATbypass release, overridable at build time with
+// -ldflags "-X github.com/bOguzhan/NATbypass/internal/utils.Version=..."
+var Version = "0.1.0"
+
+// processStartTime is captured once when the process loads this package
+var processStartTime = time.Now()
+
 // SystemInfo holds basic information about the system environment
 type SystemInfo struct {
 	Hostname     string            `json:"hostname"`
@@ -41,11 +48,16 @@ func GetSystemInfo() (*SystemInfo, error) {
 		Architecture: runtime.GOARCH,
 		NumCPU:       runtime.NumCPU(),
 		GoVersion:    runtime.Version(),
-		StartTime:    time.Now(),
+		StartTime:    processStartTime,
 		Environment:  envVars,
 	}, nil
 }
 
+// Uptime returns how long the process has been running
+func Uptime() time.Duration {
+	return time.Since(processStartTime)
+}
+
 // String returns a human-readable representation of the system info
 func (s *SystemInfo) String() string {
 	var sb strings.Builder
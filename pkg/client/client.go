@@ -0,0 +1,443 @@
+// pkg/client/client.go
+
+// Package client is the Go SDK for NATbypass. It registers with the
+// mediatory server, exchanges connection candidates with peers through the
+// signaling API and punches a direct path between them, so applications only
+// deal with net.Conn and net.Listener.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/pkg/networking"
+	"github.com/bOguzhan/NATbypass/pkg/tracing"
+	"go.opentelemetry.io/otel"
+)
+
+// tracer records connection attempts. Spans are only exported when the
+// application installs a tracer provider, e.g. through the otel package.
+var tracer = otel.Tracer("github.com/bOguzhan/NATbypass/pkg/client")
+
+// Default timings used when the corresponding Config field is zero
+const (
+	DefaultHeartbeatInterval = 30 * time.Second
+	DefaultPollInterval      = 500 * time.Millisecond
+	DefaultPunchTimeout      = 10 * time.Second
+	DefaultListenAddress     = ":0"
+)
+
+// Errors returned by the client
+var (
+	ErrNoServerURL      = errors.New("server URL is required")
+	ErrClosed           = errors.New("client closed")
+	ErrAlreadyListening = errors.New("client is already listening")
+	ErrPunchTimeout     = errors.New("hole punching timed out")
+)
+
+// Config holds the client settings
+type Config struct {
+	// ServerURL is the mediatory server's base URL, e.g. http://host:8080
+	ServerURL string
+
+	// ClientID is the identity to register with. The server only keeps an
+	// ID it issued, and only for a client holding its session key, so it
+	// assigns a new one to a new client.
+	ClientID string
+
+	// Name is a human readable label shown by the server
+	Name string
+
+	// Properties are labels shown to the members of the client's groups,
+	// e.g. "os": "linux"
+	Properties map[string]string
+
+	// Mesh asks the server for a virtual address at registration, for
+	// joining the mesh overlay
+	Mesh bool
+
+	// OnPresence, if set, is called when a peer joins or leaves one of the
+	// client's groups
+	OnPresence func(Presence)
+
+	// ListenAddress is the local UDP address peer connections bind to.
+	// Each connection gets its own socket, so the port should normally be 0.
+	// A fixed port's private endpoints are announced at registration, so
+	// the server can hand them to peers behind the same NAT.
+	ListenAddress string
+
+	// STUNServer is used to discover the public address of each socket.
+	// Discovery is skipped when it is empty.
+	STUNServer string
+
+	// NATType is the local NAT type if known, e.g. "port-restricted-cone".
+	// It is reported as "none" when STUN sees no address translation.
+	NATType string
+
+	// PortMapping asks the gateway to forward each socket's port using PCP,
+	// NAT-PMP or UPnP IGD and advertises the mapped address to peers
+	PortMapping bool
+
+	// RendezvousServer is the application server's UDP rendezvous address,
+	// e.g. host:3478. Each connection's socket registers there before
+	// punching. Registration is skipped when it is empty.
+	RendezvousServer string
+
+	// RelayServer is the application server's relay address, e.g.
+	// host:3479. When punching fails, both peers can meet there. Relaying
+	// is skipped when it is empty.
+	RelayServer string
+
+	// Strategies are the traversal strategies to use, every built-in one
+	// if empty. UDP hole punching is tried first when listed; the others
+	// are tried when it fails, configured priorities first and the rest by
+	// estimated success for the two NAT types.
+	Strategies []networking.StrategyConfig
+
+	HeartbeatInterval time.Duration
+	PollInterval      time.Duration
+	PunchTimeout      time.Duration
+
+	// HTTPClient is used for signaling requests; http.DefaultClient if nil
+	HTTPClient *http.Client
+}
+
+// APIError is an error response from the mediatory server
+type APIError struct {
+	StatusCode int
+	Code       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.StatusCode, e.Code)
+}
+
+// Client connects to peers through a mediatory server
+type Client struct {
+	config     Config
+	httpClient *http.Client
+	traversal  *networking.Traversal
+
+	mu         sync.Mutex
+	clientID   string
+	registered bool
+	virtualIP  netip.Prefix             // Mesh address and network, if the server assigned one
+	groups     map[string]string        // Joined groups and their secrets
+	pending    map[string]pendingAnswer // Dials waiting for an answer, by connection
+	offers     chan offer               // Set while a listener is open
+	signer     *packetSigner            // Signs requests and rendezvous, relay and peer packets
+
+	stop      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// New creates a client. Nothing is sent until the first Dial or Listen, or
+// an explicit Register.
+func New(config Config) (*Client, error) {
+	if config.ServerURL == "" {
+		return nil, ErrNoServerURL
+	}
+	config.ServerURL = strings.TrimRight(config.ServerURL, "/")
+
+	if config.ListenAddress == "" {
+		config.ListenAddress = DefaultListenAddress
+	}
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultPollInterval
+	}
+	if config.PunchTimeout <= 0 {
+		config.PunchTimeout = DefaultPunchTimeout
+	}
+
+	httpClient := config.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+
+	traversal, err := networking.NewTraversal(config.Strategies)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		config:     config,
+		httpClient: httpClient,
+		traversal:  traversal,
+		clientID:   config.ClientID,
+		pending:    make(map[string]pendingAnswer),
+		groups:     make(map[string]string),
+		signer:     &packetSigner{},
+		stop:       make(chan struct{}),
+	}, nil
+}
+
+// ID returns the client ID, which is assigned by the server on registration
+func (c *Client) ID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.clientID
+}
+
+// VirtualIP returns the mesh address the server assigned on registration,
+// with the length of the virtual network, or the zero prefix if it assigned
+// none, e.g. because Config.Mesh isn't set
+func (c *Client) VirtualIP() netip.Prefix {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.virtualIP
+}
+
+// LookupVirtualIP returns the ID of the client the server assigned a mesh
+// address to
+func (c *Client) LookupVirtualIP(ctx context.Context, addr netip.Addr) (string, error) {
+	var response struct {
+		ClientID string `json:"client_id"`
+	}
+	if err := c.call(ctx, http.MethodGet, "/api/v1/virtual-ips/"+addr.String(), nil, &response); err != nil {
+		return "", err
+	}
+	return response.ClientID, nil
+}
+
+// Register registers with the server and starts sending heartbeats and
+// polling for signaling messages. Calling it again is a no-op. The lock
+// isn't held during the request; when calls race, the first to finish wins.
+func (c *Client) Register(ctx context.Context) error {
+	c.mu.Lock()
+	select {
+	case <-c.stop:
+		c.mu.Unlock()
+		return ErrClosed
+	default:
+	}
+	if c.registered {
+		c.mu.Unlock()
+		return nil
+	}
+	clientID := c.clientID
+	c.mu.Unlock()
+
+	var response registerResponse
+	err := c.call(ctx, http.MethodPost, "/api/v1/register", c.registration(clientID), &response)
+	if err != nil {
+		return fmt.Errorf("registration failed: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.stop:
+		return ErrClosed
+	default:
+	}
+	if c.registered {
+		return nil
+	}
+
+	if err := c.setSessionKey(response); err != nil {
+		return fmt.Errorf("registration failed: %w", err)
+	}
+	c.clientID = response.ClientID
+	c.registered = true
+	c.virtualIP = virtualPrefix(response.VirtualIP, response.VirtualNetwork)
+
+	c.wg.Add(2)
+	go c.heartbeatLoop()
+	go c.pollLoop()
+
+	return nil
+}
+
+// registration is the body of a registration request. Private endpoints
+// are only announced when ListenAddress has a fixed port, since otherwise
+// each connection's socket gets a port of its own; those sockets announce
+// theirs to the rendezvous server instead.
+func (c *Client) registration(clientID string) map[string]interface{} {
+	request := map[string]interface{}{
+		"client_id":  clientID,
+		"name":       c.config.Name,
+		"properties": c.config.Properties,
+	}
+	if addr, err := net.ResolveUDPAddr("udp", c.config.ListenAddress); err == nil && addr.Port != 0 {
+		request["local_addresses"] = localEndpoints(addr)
+	}
+	if c.config.Mesh {
+		request["mesh"] = true
+	}
+	return request
+}
+
+// registerResponse is the server's answer to a registration. The session
+// key signs requests, and rendezvous packets too when the server says the
+// rendezvous servers authenticate them.
+type registerResponse struct {
+	ClientID       string `json:"client_id"`
+	VirtualIP      string `json:"virtual_ip"`
+	VirtualNetwork string `json:"virtual_network"`
+	SessionKey     string `json:"session_key"`
+	KeyID          uint32 `json:"key_id"`
+	RendezvousAuth bool   `json:"rendezvous_auth"`
+}
+
+// setSessionKey starts signing with the key issued at registration
+func (c *Client) setSessionKey(response registerResponse) error {
+	if response.SessionKey == "" {
+		return nil
+	}
+	key, err := hex.DecodeString(response.SessionKey)
+	if err != nil {
+		return fmt.Errorf("invalid session key: %w", err)
+	}
+	c.signer.setKey(response.ClientID, response.KeyID, key, response.RendezvousAuth)
+	return nil
+}
+
+// virtualPrefix combines an assigned address with its network's length,
+// returning the zero prefix unless both are valid
+func virtualPrefix(address, network string) netip.Prefix {
+	addr, err := netip.ParseAddr(address)
+	if err != nil {
+		return netip.Prefix{}
+	}
+	prefix, err := netip.ParsePrefix(network)
+	if err != nil || !prefix.Contains(addr) {
+		return netip.Prefix{}
+	}
+	return netip.PrefixFrom(addr, prefix.Bits())
+}
+
+// Close stops heartbeats and polling. Connections already established stay
+// open until closed themselves.
+func (c *Client) Close() error {
+	c.closeOnce.Do(func() {
+		// Register checks stop under the lock before starting its loops
+		c.mu.Lock()
+		close(c.stop)
+		c.mu.Unlock()
+		c.wg.Wait()
+	})
+	return nil
+}
+
+// heartbeatLoop keeps the registration alive
+func (c *Client) heartbeatLoop() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.config.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), c.config.HeartbeatInterval)
+			err := c.call(ctx, http.MethodPost, "/api/v1/heartbeat", map[string]interface{}{
+				"client_id": c.ID(),
+			}, nil)
+			cancel()
+
+			// The server forgets clients and their keys after a restart, so
+			// register again
+			var apiErr *APIError
+			if errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusUnauthorized) {
+				c.reregister()
+			}
+		}
+	}
+}
+
+// reregister registers again, picking up a new session key, and rejoins
+// the client's groups. The request is signed with the current key, so the
+// client ID is kept unless the server can no longer check that key.
+func (c *Client) reregister() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.HeartbeatInterval)
+	defer cancel()
+
+	var response registerResponse
+	err := c.call(ctx, http.MethodPost, "/api/v1/register", c.registration(c.ID()), &response)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized {
+		// Our ID is in use under a key we don't hold, so take a new one
+		err = c.call(ctx, http.MethodPost, "/api/v1/register", c.registration(""), &response)
+	}
+	if err != nil || c.setSessionKey(response) != nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.clientID = response.ClientID
+	c.virtualIP = virtualPrefix(response.VirtualIP, response.VirtualNetwork)
+	groups := make(map[string]string, len(c.groups))
+	for group, secret := range c.groups {
+		groups[group] = secret
+	}
+	c.mu.Unlock()
+
+	for group, secret := range groups {
+		_ = c.joinGroup(ctx, group, secret)
+	}
+}
+
+// call sends a JSON request, signed with the session key once one is
+// issued, to the server and decodes the JSON response into out, which may
+// be nil
+func (c *Client) call(ctx context.Context, method, path string, body, out interface{}) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, c.config.ServerURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	c.signer.signRequest(request, payload)
+	tracing.InjectHeader(ctx, request.Header)
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		var failure struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal(data, &failure)
+		return &APIError{StatusCode: response.StatusCode, Code: failure.Error}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
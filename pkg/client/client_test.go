@@ -0,0 +1,640 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/internal/config"
+	"github.com/bOguzhan/NATbypass/internal/nat"
+	"github.com/bOguzhan/NATbypass/internal/signaling"
+	"github.com/bOguzhan/NATbypass/internal/utils"
+	"github.com/bOguzhan/NATbypass/pkg/networking"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+	"github.com/bOguzhan/NATbypass/pkg/tracing"
+	"github.com/gin-gonic/gin"
+	"github.com/pion/stun"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startSignalingServer runs the mediatory server's HTTP API in-process
+func startSignalingServer(t *testing.T) string {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(tracing.GinMiddleware("test"))
+	handlers, err := signaling.NewHandlers(utils.NewLogger("test", "error"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers.SetupRoutes(router)
+
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func newTestClient(t *testing.T, serverURL string) *Client {
+	client, err := New(Config{
+		ServerURL:     serverURL,
+		ListenAddress: "127.0.0.1:0",
+		PollInterval:  20 * time.Millisecond,
+		PunchTimeout:  2 * time.Second,
+	})
+	assert.NoError(t, err)
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestDialAndListen(t *testing.T) {
+	serverURL := startSignalingServer(t)
+	alice := newTestClient(t, serverURL)
+	bob := newTestClient(t, serverURL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	listener, err := alice.Listen(ctx)
+	assert.NoError(t, err)
+	defer listener.Close()
+	assert.Len(t, alice.ID(), 32)
+	assert.Equal(t, alice.ID(), listener.Addr().String())
+
+	_, err = alice.Listen(ctx)
+	assert.Equal(t, ErrAlreadyListening, err)
+
+	accepted := make(chan *Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn.(*Conn)
+		}
+	}()
+
+	conn, err := bob.Dial(ctx, alice.ID())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	var server *Conn
+	select {
+	case server = <-accepted:
+	case <-ctx.Done():
+		t.Fatal("Listener did not accept the connection")
+	}
+	defer server.Close()
+	assert.Equal(t, bob.ID(), server.PeerID())
+	assert.Equal(t, "UDP Hole Punching", Strategy(conn))
+	assert.Len(t, PeerKey(conn), peerKeySize)
+	assert.Equal(t, PeerKey(conn), PeerKey(server))
+
+	// Data flows both ways with message boundaries kept
+	_, err = conn.Write([]byte("hello"))
+	assert.NoError(t, err)
+
+	buffer := make([]byte, 64)
+	server.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := server.Read(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(buffer[:n]))
+
+	_, err = server.Write([]byte("world"))
+	assert.NoError(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err = conn.Read(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, "world", string(buffer[:n]))
+
+	// The punch measured a round trip and both datagrams were counted
+	snapshot := conn.(*Conn).Stats()
+	assert.Greater(t, snapshot.SmoothedRTT, time.Duration(0))
+	assert.GreaterOrEqual(t, snapshot.PacketsOut, uint64(2))
+	assert.GreaterOrEqual(t, snapshot.PacketsIn, uint64(2))
+
+	// Read deadlines are honoured
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = conn.Read(buffer)
+	assert.Error(t, err)
+}
+
+func TestDialWithoutListener(t *testing.T) {
+	serverURL := startSignalingServer(t)
+	alice := newTestClient(t, serverURL)
+	bob := newTestClient(t, serverURL)
+
+	assert.NoError(t, alice.Register(context.Background()))
+
+	// Nobody answers the offer, so the dial gives up with the context
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	_, err := bob.Dial(ctx, alice.ID())
+	assert.Error(t, err)
+}
+
+func TestGroups(t *testing.T) {
+	serverURL := startSignalingServer(t)
+	presences := make(chan Presence, 4)
+	alice, err := New(Config{
+		ServerURL:    serverURL,
+		PollInterval: 20 * time.Millisecond,
+		OnPresence:   func(p Presence) { presences <- p },
+	})
+	assert.NoError(t, err)
+	defer alice.Close()
+	bob := newTestClient(t, serverURL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	assert.NoError(t, alice.JoinGroup(ctx, "office", "s3cret"))
+	var apiErr *APIError
+	if assert.ErrorAs(t, bob.JoinGroup(ctx, "office", "guess"), &apiErr) {
+		assert.Equal(t, "group_access_denied", apiErr.Code)
+	}
+	assert.NoError(t, bob.JoinGroup(ctx, "office", "s3cret"))
+
+	peers, err := bob.Peers(ctx, "office")
+	assert.NoError(t, err)
+	if assert.Len(t, peers, 1) {
+		assert.Equal(t, alice.ID(), peers[0].ID)
+	}
+
+	assert.NoError(t, bob.LeaveGroup(ctx, "office"))
+	_, err = bob.Peers(ctx, "office")
+	assert.Error(t, err)
+
+	for _, joined := range []bool{true, false} {
+		select {
+		case presence := <-presences:
+			assert.Equal(t, Presence{Group: "office", PeerID: bob.ID(), Joined: joined}, presence)
+		case <-ctx.Done():
+			t.Fatal("presence not delivered")
+		}
+	}
+}
+
+var (
+	spanRecorder     *tracetest.SpanRecorder
+	spanRecorderOnce sync.Once
+)
+
+// recordSpans installs a global tracer provider recording every span. The
+// global provider only binds package tracers once, so it is shared by all
+// tests.
+func recordSpans() *tracetest.SpanRecorder {
+	spanRecorderOnce.Do(func() {
+		spanRecorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spanRecorder)))
+	})
+	return spanRecorder
+}
+
+func TestDialTrace(t *testing.T) {
+	recorder := recordSpans()
+
+	serverURL := startSignalingServer(t)
+	alice := newTestClient(t, serverURL)
+	bob := newTestClient(t, serverURL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	listener, err := alice.Listen(ctx)
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	conn, err := bob.Dial(ctx, alice.ID())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+
+	select {
+	case server := <-accepted:
+		defer server.Close()
+	case <-ctx.Done():
+		t.Fatal("Listener did not accept the connection")
+	}
+
+	// Find this dial's trace among the spans recorded so far
+	var traceID trace.TraceID
+	for _, span := range recorder.Ended() {
+		for _, attr := range span.Attributes() {
+			if span.Name() == "client.dial" && attr == attribute.String("natbypass.peer_id", alice.ID()) {
+				traceID = span.SpanContext().TraceID()
+			}
+		}
+	}
+	byName := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		if span.SpanContext().TraceID() == traceID {
+			byName[span.Name()] = append(byName[span.Name()], span)
+		}
+	}
+
+	// One trace runs from the dial through signaling to the listener
+	if !assert.Len(t, byName["client.dial"], 1) || !assert.Len(t, byName["client.accept"], 1) {
+		return
+	}
+	dial := byName["client.dial"][0]
+	assert.False(t, dial.Parent().IsValid(), "Dial starts a new trace")
+	if assert.Len(t, byName["POST /api/v1/connect"], 1) {
+		assert.Equal(t, dial.SpanContext().SpanID(), byName["POST /api/v1/connect"][0].Parent().SpanID())
+	}
+	assert.NotEmpty(t, byName["POST /api/v1/signal"], "offer and answer went through signaling")
+
+	// Both peers punched, and the dialing side's last round was acknowledged
+	var acknowledged bool
+	for _, round := range byName["client.punch_round"] {
+		for _, attr := range round.Attributes() {
+			if attr == attribute.Bool("natbypass.acknowledged", true) {
+				acknowledged = true
+			}
+		}
+	}
+	assert.True(t, acknowledged)
+	assert.Len(t, byName["client.punch"], 2)
+}
+
+// startRendezvous runs the signaling API, UDP rendezvous server and relay
+// with packet authentication enabled, sharing one authenticator like the
+// application server does
+func startRendezvous(t *testing.T, secret string) (serverURL, rendezvous, relay string, udp *nat.UDPServer) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handlers, err := signaling.NewHandlers(utils.NewLogger("test", "error"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers.SetConfig(&config.Config{Security: config.SecurityConfig{RendezvousSecret: secret}})
+	handlers.SetupRoutes(router)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	auth := nat.NewPacketAuthenticator([]byte(secret), time.Hour)
+
+	udp, err = nat.NewUDPServer("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	udp.SetAuthenticator(auth)
+	assert.NoError(t, udp.Start(ctx))
+	t.Cleanup(func() { udp.Stop() })
+
+	relayServer := nat.NewRelayServer(&config.RelayConfig{ListenHost: "127.0.0.1"}, nil)
+	relayServer.SetAuthenticator(auth)
+	assert.NoError(t, relayServer.Start(ctx))
+	t.Cleanup(func() { relayServer.Stop() })
+
+	return server.URL, udp.LocalAddr().String(), relayServer.Addr().String(), udp
+}
+
+func TestRendezvousAuthentication(t *testing.T) {
+	serverURL, rendezvous, relay, udp := startRendezvous(t, "test-rendezvous-secret")
+	newClient := func(serverURL string) *Client {
+		client, err := New(Config{
+			ServerURL:        serverURL,
+			ListenAddress:    "127.0.0.1:0",
+			RendezvousServer: rendezvous,
+			RelayServer:      relay,
+			PollInterval:     20 * time.Millisecond,
+			PunchTimeout:     2 * time.Second,
+		})
+		assert.NoError(t, err)
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	alice := newClient(serverURL)
+	bob := newClient(serverURL)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Signed registrations and punches get through
+	listener, err := alice.Listen(ctx)
+	assert.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			defer conn.Close()
+			buffer := make([]byte, 64)
+			n, _ := conn.Read(buffer)
+			conn.Write(buffer[:n])
+		}
+	}()
+
+	conn, err := bob.Dial(ctx, alice.ID())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	assert.Equal(t, "UDP Hole Punching", Strategy(conn))
+	assert.NotEmpty(t, udp.GetClientEndpoints(alice.ID()))
+	assert.NotEmpty(t, udp.GetClientEndpoints(bob.ID()))
+
+	// Both peers bind the same session at the relay with signed binds
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	results := make(chan result, 2)
+	for _, client := range []*Client{alice, bob} {
+		go func(client *Client) {
+			conn, err := client.relay(ctx, "peer", "relay-session")
+			results <- result{conn, err}
+		}(client)
+	}
+	var relayed []*Conn
+	for i := 0; i < 2; i++ {
+		r := <-results
+		if assert.NoError(t, r.err) {
+			defer r.conn.Close()
+			relayed = append(relayed, r.conn)
+		}
+	}
+	if len(relayed) != 2 {
+		return
+	}
+	assert.Equal(t, "UDP Relaying", Strategy(relayed[0]))
+
+	_, err = relayed[0].Write([]byte("relayed"))
+	assert.NoError(t, err)
+	buffer := make([]byte, 64)
+	relayed[1].SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := relayed[1].Read(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, "relayed", string(buffer[:n]))
+
+	// A client without a session key is dropped
+	carol := newClient(startSignalingServer(t))
+	assert.NoError(t, carol.Register(ctx))
+	socket, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.NoError(t, err)
+	defer socket.Close()
+	registerCtx, cancelRegister := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancelRegister()
+	_, err = carol.registerRendezvous(registerCtx, socket)
+	assert.Equal(t, ErrNoResponse, err)
+	assert.Empty(t, udp.GetClientEndpoints(carol.ID()))
+
+	// Acks that aren't signed with the session key are ignored
+	forger, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.NoError(t, err)
+	defer forger.Close()
+	go func() {
+		buffer := make([]byte, maxDatagramSize)
+		for {
+			_, addr, err := forger.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			ack, _ := (&protocol.Packet{Type: protocol.PacketTypeRegistrationAck, Payload: []byte("192.0.2.1:1")}).Serialize()
+			forger.WriteToUDP(ack, addr)
+		}
+	}()
+	alice.config.RendezvousServer = forger.LocalAddr().String()
+	registerCtx, cancelRegister = context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancelRegister()
+	_, err = alice.registerRendezvous(registerCtx, socket)
+	assert.Equal(t, ErrNoResponse, err)
+}
+
+func TestDialHonoursStrategies(t *testing.T) {
+	serverURL, rendezvous, relay, _ := startRendezvous(t, "test-rendezvous-secret")
+	newClient := func() *Client {
+		client, err := New(Config{
+			ServerURL:        serverURL,
+			ListenAddress:    "127.0.0.1:0",
+			RendezvousServer: rendezvous,
+			RelayServer:      relay,
+			Strategies:       []networking.StrategyConfig{{Type: networking.UDPRelaying}},
+			PollInterval:     20 * time.Millisecond,
+			PunchTimeout:     2 * time.Second,
+		})
+		assert.NoError(t, err)
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+	alice := newClient()
+	bob := newClient()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	listener, err := alice.Listen(ctx)
+	assert.NoError(t, err)
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+
+	// Without hole punching both peers go straight to the relay
+	conn, err := bob.Dial(ctx, alice.ID())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	assert.Equal(t, "UDP Relaying", Strategy(conn))
+
+	select {
+	case server := <-accepted:
+		defer server.Close()
+		assert.Equal(t, "UDP Relaying", Strategy(server))
+	case <-ctx.Done():
+		t.Fatal("Listener did not accept the connection")
+	}
+
+	_, err = New(Config{
+		ServerURL:  serverURL,
+		Strategies: []networking.StrategyConfig{{Type: "carrier-pigeon"}},
+	})
+	assert.ErrorIs(t, err, networking.ErrUnknownStrategy)
+}
+
+func TestAnswersOnlyFromDialedPeer(t *testing.T) {
+	client, err := New(Config{ServerURL: "http://localhost"})
+	assert.NoError(t, err)
+
+	answers := client.awaitAnswer("bob", "conn-1")
+	defer client.forgetAnswer("conn-1")
+
+	client.deliverAnswer("mallory", sessionDescription{ConnectionID: "conn-1", Candidates: []string{"10.0.0.1:1"}})
+	client.deliverAnswer("bob", sessionDescription{ConnectionID: "conn-1", Candidates: []string{"10.0.0.2:2"}})
+
+	select {
+	case answer := <-answers:
+		assert.Equal(t, []string{"10.0.0.2:2"}, answer.Candidates)
+	default:
+		t.Fatal("answer from the dialed peer was dropped")
+	}
+}
+
+func TestRegistrationAnnouncesLocalEndpoints(t *testing.T) {
+	assert.Equal(t, []string{"192.168.1.2:4000"}, localEndpoints(&net.UDPAddr{IP: net.ParseIP("192.168.1.2"), Port: 4000}))
+	assert.Empty(t, localEndpoints(&net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 4000}))
+
+	// Only a fixed listen port is announced at registration
+	client, err := New(Config{ServerURL: "http://localhost", ListenAddress: "192.168.1.2:4000"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"192.168.1.2:4000"}, client.registration("id")["local_addresses"])
+
+	client, err = New(Config{ServerURL: "http://localhost"})
+	assert.NoError(t, err)
+	assert.NotContains(t, client.registration("id"), "local_addresses")
+}
+
+// TestImportsNoInternalPackages keeps the SDK built on the same public
+// packages that applications use
+func TestImportsNoInternalPackages(t *testing.T) {
+	pkg, err := build.ImportDir(".", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range pkg.Imports {
+		assert.NotContains(t, path, "/internal/")
+	}
+}
+
+func TestConcurrentRegister(t *testing.T) {
+	var registrations int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/register" {
+			n := atomic.AddInt32(&registrations, 1)
+			<-release
+			json.NewEncoder(w).Encode(map[string]string{"client_id": fmt.Sprintf("%032d", n)})
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+	client := newTestClient(t, server.URL)
+
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { errs <- client.Register(context.Background()) }()
+	}
+
+	// Both requests are in flight at once and the client stays usable
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&registrations) == 2
+	}, 2*time.Second, 10*time.Millisecond)
+	id := make(chan string, 1)
+	go func() { id <- client.ID() }()
+	select {
+	case clientID := <-id:
+		assert.Empty(t, clientID)
+	case <-time.After(time.Second):
+		close(release)
+		t.Fatal("The client is locked during registration")
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		assert.NoError(t, <-errs)
+	}
+	assert.Contains(t, []string{fmt.Sprintf("%032d", 1), fmt.Sprintf("%032d", 2)}, client.ID())
+	assert.NoError(t, client.Register(context.Background()))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&registrations))
+}
+
+func TestNewRequiresServerURL(t *testing.T) {
+	_, err := New(Config{})
+	assert.Equal(t, ErrNoServerURL, err)
+}
+
+func TestParseCandidates(t *testing.T) {
+	addrs := parseCandidates([]string{"192.168.1.2:4000", "example.com:80", "192.168.1.2:4000", "[2001:db8::1]:5000"})
+	assert.Len(t, addrs, 2)
+	assert.Equal(t, "192.168.1.2:4000", addrs[0].String())
+	assert.Equal(t, "[2001:db8::1]:5000", addrs[1].String())
+}
+
+// startSTUNServer answers binding requests with the sender's address, its
+// port shifted by portShift to imitate a NAT
+func startSTUNServer(t *testing.T, portShift int) string {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buffer := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			request := &stun.Message{Raw: append([]byte(nil), buffer[:n]...)}
+			if request.Decode() != nil {
+				continue
+			}
+			response := stun.MustBuild(request, stun.BindingSuccess,
+				&stun.XORMappedAddress{IP: addr.IP, Port: addr.Port + portShift})
+			conn.WriteToUDP(response.Raw, addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestDiscover(t *testing.T) {
+	client := newTestClient(t, "http://127.0.0.1:1")
+	ctx := context.Background()
+
+	_, err := client.Discover(ctx)
+	assert.Equal(t, ErrNoSTUNServer, err)
+
+	// The server sees the socket's own address
+	direct := startSTUNServer(t, 0)
+	result, err := client.Discover(ctx, direct)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "none", result.NATType)
+		assert.Equal(t, result.LocalAddr.String(), result.PublicAddr.String())
+	}
+
+	// Two servers see different mappings of the same socket
+	shifted := startSTUNServer(t, 1)
+	result, err = client.Discover(ctx, startSTUNServer(t, 2), shifted)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "symmetric", result.NATType)
+		assert.Equal(t, result.LocalAddr.Port+2, result.PublicAddr.Port)
+	}
+
+	// Two servers agree on a translated address
+	result, err = client.Discover(ctx, shifted, shifted)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "port-restricted-cone", result.NATType)
+	}
+
+	result, err = client.Discover(ctx, shifted)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "unknown", result.NATType)
+	}
+}
@@ -0,0 +1,386 @@
+// pkg/client/conn.go
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/pkg/networking"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+	"github.com/bOguzhan/NATbypass/pkg/stats"
+	"github.com/bOguzhan/NATbypass/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// punchInterval is how often punch packets are resent to every candidate
+	punchInterval = 200 * time.Millisecond
+
+	// keepAliveInterval keeps NAT mappings open on idle connections
+	keepAliveInterval = 15 * time.Second
+
+	// readQueueSize bounds datagrams received but not yet read
+	readQueueSize = 256
+
+	// maxDatagramSize is the largest UDP payload we read
+	maxDatagramSize = 65535
+)
+
+// Conn is a direct UDP connection to a peer. Each Write is sent as one
+// datagram and each Read returns one, so message boundaries are kept, but
+// like UDP, delivery and ordering are not guaranteed.
+type Conn struct {
+	socket       *net.UDPConn
+	remoteAddr   *net.UDPAddr
+	peerID       string
+	peerKey      []byte // See PeerKey
+	connectionID string
+	strategy     string
+	closer       func() error // Releases resources such as port mappings
+	stats        *stats.Counters
+	signer       *packetSigner
+
+	mu       sync.Mutex
+	peers    map[string]bool // Addresses that proved they belong to the peer
+	deadline time.Time
+	wake     chan struct{} // Closed and replaced when the deadline changes
+
+	incoming chan []byte
+	closed   chan struct{}
+	once     sync.Once
+	onClose  func()
+}
+
+// newConn wraps an established socket and starts its reader
+func newConn(socket *net.UDPConn, remoteAddr *net.UDPAddr, peers map[string]bool, peerID, connectionID string, counters *stats.Counters, signer *packetSigner) *Conn {
+	conn := &Conn{
+		socket:       socket,
+		remoteAddr:   remoteAddr,
+		peerID:       peerID,
+		connectionID: connectionID,
+		strategy:     holePunchingName,
+		stats:        counters,
+		signer:       signer,
+		peers:        peers,
+		wake:         make(chan struct{}),
+		incoming:     make(chan []byte, readQueueSize),
+		closed:       make(chan struct{}),
+	}
+
+	go conn.readLoop()
+	go conn.keepAlive()
+
+	return conn
+}
+
+// PeerID returns the client ID of the peer
+func (c *Conn) PeerID() string {
+	return c.peerID
+}
+
+// PeerKey returns the secret shared with the peer, see the PeerKey function
+func (c *Conn) PeerKey() []byte {
+	return c.peerKey
+}
+
+// Strategy returns the traversal strategy that connected
+func (c *Conn) Strategy() string {
+	return c.strategy
+}
+
+// Stats returns the connection's traffic counters. The RTT and loss figures
+// come from hole punching, so they are zero for relayed connections.
+func (c *Conn) Stats() stats.Snapshot {
+	return c.stats.Snapshot()
+}
+
+// Read reads the next datagram from the peer
+func (c *Conn) Read(b []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		deadline := c.deadline
+		wake := c.wake
+		c.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return 0, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(remaining)
+			timeout = timer.C
+		}
+
+		n, done, err := c.readOnce(b, timeout, wake)
+		if timer != nil {
+			timer.Stop()
+		}
+		if done {
+			return n, err
+		}
+	}
+}
+
+// readOnce waits for a datagram, the deadline or a deadline change. done is
+// false when the deadline changed and Read has to start over.
+func (c *Conn) readOnce(b []byte, timeout <-chan time.Time, wake chan struct{}) (n int, done bool, err error) {
+	select {
+	case data := <-c.incoming:
+		return copy(b, data), true, nil
+	case <-c.closed:
+		return 0, true, net.ErrClosed
+	case <-timeout:
+		return 0, true, os.ErrDeadlineExceeded
+	case <-wake:
+		return 0, false, nil
+	}
+}
+
+// Write sends b to the peer as a single datagram
+func (c *Conn) Write(b []byte) (int, error) {
+	if err := c.send(protocol.PacketTypeData, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close closes the connection and releases its port mapping
+func (c *Conn) Close() error {
+	var err error
+	c.once.Do(func() {
+		close(c.closed)
+		err = c.socket.Close()
+		if c.closer != nil {
+			if closeErr := c.closer(); err == nil {
+				err = closeErr
+			}
+		}
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return err
+}
+
+// LocalAddr returns the local socket address
+func (c *Conn) LocalAddr() net.Addr {
+	return c.socket.LocalAddr()
+}
+
+// RemoteAddr returns the peer address the connection sends to
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// SetDeadline sets the read and write deadlines
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for Read calls
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	close(c.wake)
+	c.wake = make(chan struct{})
+	c.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for Write calls
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.socket.SetWriteDeadline(t)
+}
+
+// send writes a packet to the peer
+func (c *Conn) send(packetType protocol.PacketType, payload []byte) error {
+	data, err := c.signer.serialize(&protocol.Packet{Type: packetType, Payload: payload})
+	if err != nil {
+		return err
+	}
+	if _, err = c.socket.WriteToUDP(data, c.remoteAddr); err != nil {
+		return err
+	}
+	c.stats.RecordSent(len(data))
+	return nil
+}
+
+// readLoop answers punches that arrive late, since the peer may still be
+// waiting for our acknowledgment, and queues data for Read
+func (c *Conn) readLoop() {
+	buffer := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := c.socket.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				c.Close()
+				return
+			}
+			continue
+		}
+
+		packet, err := protocol.ParsePacket(buffer[:n])
+		if err != nil {
+			continue
+		}
+
+		switch packet.Type {
+		case protocol.PacketTypeHolePunch, protocol.PacketTypeHolePunchAck:
+			if string(packet.Payload) != c.connectionID {
+				continue
+			}
+			c.stats.RecordReceived(n)
+			c.mu.Lock()
+			c.peers[addr.String()] = true
+			c.mu.Unlock()
+			if packet.Type == protocol.PacketTypeHolePunch {
+				answerPunch(c.socket, addr, c.connectionID, c.stats, c.signer)
+			}
+
+		case protocol.PacketTypeData:
+			c.mu.Lock()
+			known := c.peers[addr.String()]
+			c.mu.Unlock()
+			if !known {
+				continue
+			}
+			c.stats.RecordReceived(n)
+
+			data := make([]byte, len(packet.Payload))
+			copy(data, packet.Payload)
+			select {
+			case c.incoming <- data:
+			default:
+				// Reader is too slow, drop like a full socket buffer would
+			}
+		}
+	}
+}
+
+// keepAlive keeps the NAT mapping open while the connection is idle
+func (c *Conn) keepAlive() {
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.closed:
+			return
+		case <-ticker.C:
+			c.send(protocol.PacketTypeKeepAlive, nil)
+		}
+	}
+}
+
+// answerPunch acknowledges a punch packet
+func answerPunch(socket *net.UDPConn, addr *net.UDPAddr, connectionID string, counters *stats.Counters, signer *packetSigner) {
+	data, err := signer.serialize(&protocol.Packet{Type: protocol.PacketTypeHolePunchAck, Payload: []byte(connectionID)})
+	if err != nil {
+		return
+	}
+	if _, err := socket.WriteToUDP(data, addr); err == nil {
+		counters.RecordSent(len(data))
+	}
+}
+
+// punch sends punch packets to every candidate until one acknowledges. Both
+// peers punch at the same time; each acknowledges the other's punches, so
+// whichever packet gets through first opens the path. It returns the first
+// address that acknowledged and every address the peer was seen on. Each
+// round of punches counts as one probe, so the RTT is measured from the
+// latest round to the acknowledgment. Each round is traced as a span under
+// ctx lasting until the next round or the acknowledgment.
+func punch(ctx context.Context, socket *net.UDPConn, connectionID string, candidates []*net.UDPAddr, counters *stats.Counters, signer *packetSigner) (*net.UDPAddr, map[string]bool, error) {
+	if len(candidates) == 0 {
+		return nil, nil, networking.ErrNoCandidates
+	}
+
+	peers := make(map[string]bool)
+	buffer := make([]byte, maxDatagramSize)
+	nextPunch := time.Now()
+	var lastRound time.Time
+
+	round, rounds := trace.Span(nil), 0
+	endRound := func(acknowledged bool, err error) {
+		if round != nil {
+			round.SetAttributes(attribute.Bool("natbypass.acknowledged", acknowledged))
+			tracing.End(round, err)
+			round = nil
+		}
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			endRound(false, ErrPunchTimeout)
+			return nil, nil, ErrPunchTimeout
+		}
+
+		if !time.Now().Before(nextPunch) {
+			endRound(false, nil)
+			rounds++
+			_, round = tracer.Start(ctx, "client.punch_round", trace.WithAttributes(
+				attribute.Int("natbypass.round", rounds),
+				attribute.Int("natbypass.candidates", len(candidates)),
+			))
+
+			for _, candidate := range candidates {
+				request, err := signer.serialize(&protocol.Packet{Type: protocol.PacketTypeHolePunch, Payload: []byte(connectionID)})
+				if err != nil {
+					endRound(false, err)
+					return nil, nil, err
+				}
+				if _, err := socket.WriteToUDP(request, candidate); err == nil {
+					counters.RecordSent(len(request))
+				}
+			}
+			if !lastRound.IsZero() {
+				counters.RecordRetransmit()
+			}
+			counters.RecordProbe()
+			lastRound = time.Now()
+			nextPunch = lastRound.Add(punchInterval)
+		}
+
+		socket.SetReadDeadline(nextPunch)
+		n, addr, err := socket.ReadFromUDP(buffer)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			if errors.Is(err, net.ErrClosed) {
+				endRound(false, err)
+				return nil, nil, err
+			}
+			// ICMP errors from unreachable candidates, keep trying the rest
+			continue
+		}
+
+		packet, err := protocol.ParsePacket(buffer[:n])
+		if err != nil || string(packet.Payload) != connectionID {
+			continue
+		}
+		counters.RecordReceived(n)
+
+		switch packet.Type {
+		case protocol.PacketTypeHolePunch:
+			peers[addr.String()] = true
+			answerPunch(socket, addr, connectionID, counters, signer)
+		case protocol.PacketTypeHolePunchAck:
+			peers[addr.String()] = true
+			counters.RecordRTT(time.Since(lastRound))
+			socket.SetReadDeadline(time.Time{})
+			round.SetAttributes(attribute.String("natbypass.remote_addr", addr.String()))
+			endRound(true, nil)
+			return addr, peers, nil
+		}
+	}
+}
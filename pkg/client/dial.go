@@ -0,0 +1,446 @@
+// pkg/client/dial.go
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/pkg/networking"
+	"github.com/bOguzhan/NATbypass/pkg/portmap"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+	"github.com/bOguzhan/NATbypass/pkg/stats"
+	"github.com/bOguzhan/NATbypass/pkg/tracing"
+	"github.com/pion/stun"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Dial connects to a peer by client ID. It registers first if needed, sends
+// the peer an offer with our candidates, waits for its answer and punches
+// a direct UDP path. When punching fails, the remaining traversal strategies
+// are tried in order of estimated success for the two NAT types.
+//
+// The attempt is traced from a span started under ctx. The trace reaches
+// the peer through the offer, so both sides' spans share one trace.
+func (c *Client) Dial(ctx context.Context, peerID string) (net.Conn, error) {
+	ctx, span := tracer.Start(ctx, "client.dial", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("natbypass.peer_id", peerID)))
+
+	conn, err := c.dial(ctx, peerID)
+	tracing.End(span, err)
+	return conn, err
+}
+
+// dial runs the offer/answer exchange and connects
+func (c *Client) dial(ctx context.Context, peerID string) (net.Conn, error) {
+	if err := c.Register(ctx); err != nil {
+		return nil, err
+	}
+
+	local, err := c.gather(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ask the server for a connection, which also tells us whether the peer
+	// is behind the same NAT and which private endpoints to try
+	var response struct {
+		ConnectionID string   `json:"connection_id"`
+		Candidates   []string `json:"candidates"`
+	}
+	request := map[string]interface{}{
+		"source_id": c.ID(),
+		"target_id": peerID,
+	}
+	if local.public != nil {
+		request["source_ip"] = local.public.IP.String()
+		request["source_port"] = local.public.Port
+	}
+	if err := c.call(ctx, http.MethodPost, "/api/v1/connect", request, &response); err != nil {
+		local.close()
+		return nil, fmt.Errorf("connection request failed: %w", err)
+	}
+	connectionID := response.ConnectionID
+	trace.SpanFromContext(ctx).SetAttributes(attribute.String("natbypass.connection_id", connectionID))
+
+	answers := c.awaitAnswer(peerID, connectionID)
+	defer c.forgetAnswer(connectionID)
+
+	key := make([]byte, peerKeySize)
+	if _, err := rand.Read(key); err != nil {
+		local.close()
+		return nil, err
+	}
+	err = c.sendSignal(ctx, protocol.TypeOffer, peerID, sessionDescription{
+		ConnectionID: connectionID,
+		Candidates:   local.candidates,
+		NATType:      local.natType,
+		Key:          hex.EncodeToString(key),
+	})
+	if err != nil {
+		local.close()
+		c.updateStatus(connectionID, "failed", err.Error())
+		return nil, fmt.Errorf("failed to send offer: %w", err)
+	}
+	c.updateStatus(connectionID, "negotiating", "")
+
+	var answer sessionDescription
+	select {
+	case answer = <-answers:
+	case <-ctx.Done():
+		local.close()
+		c.updateStatus(connectionID, "failed", "no answer from peer")
+		return nil, ctx.Err()
+	case <-c.stop:
+		local.close()
+		return nil, ErrClosed
+	}
+
+	candidates := append(append([]string(nil), response.Candidates...), answer.Candidates...)
+	return c.connect(ctx, local, peerID, connectionID, key, answer.NATType, candidates)
+}
+
+// Listen accepts connections from peers that Dial this client. Only one
+// listener can be open per client.
+func (c *Client) Listen(ctx context.Context) (net.Listener, error) {
+	if err := c.Register(ctx); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.offers != nil {
+		return nil, ErrAlreadyListening
+	}
+	c.offers = make(chan offer, offerQueueSize)
+
+	listener := &Listener{
+		client:   c,
+		offers:   c.offers,
+		accepted: make(chan net.Conn),
+		closed:   make(chan struct{}),
+	}
+	go listener.run()
+
+	return listener, nil
+}
+
+// localSocket is a bound socket and the candidates advertised for it
+type localSocket struct {
+	socket     *net.UDPConn
+	candidates []string
+	public     *net.UDPAddr // Address seen by the STUN server, if known
+	natType    string
+	lease      *portmap.Lease
+}
+
+// close releases the socket and its port mapping
+func (l *localSocket) close() error {
+	err := l.socket.Close()
+	if l.lease != nil {
+		if leaseErr := l.lease.Close(); err == nil {
+			err = leaseErr
+		}
+	}
+	return err
+}
+
+// gather binds a socket for one connection and collects its candidates:
+// host addresses, the addresses seen by the rendezvous and STUN servers and
+// a gateway port mapping. Discovery failures only mean fewer candidates.
+func (c *Client) gather(ctx context.Context) (*localSocket, error) {
+	addr, err := net.ResolveUDPAddr("udp", c.config.ListenAddress)
+	if err != nil {
+		return nil, err
+	}
+	socket, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	local := &localSocket{socket: socket, natType: c.config.NATType}
+	bound := socket.LocalAddr().(*net.UDPAddr)
+
+	if bound.IP.IsUnspecified() {
+		local.candidates, _ = networking.GetHostCandidates(bound.Port)
+	} else {
+		local.candidates = []string{bound.String()}
+	}
+
+	if c.config.RendezvousServer != "" {
+		if observed, err := c.registerRendezvous(ctx, socket); err == nil && !containsString(local.candidates, observed.String()) {
+			local.candidates = append(local.candidates, observed.String())
+		}
+	}
+
+	if c.config.STUNServer != "" {
+		if public, err := stunBinding(ctx, socket, c.config.STUNServer); err == nil {
+			local.public = public
+			if !containsString(local.candidates, public.String()) {
+				local.candidates = append(local.candidates, public.String())
+			} else if local.natType == "" {
+				local.natType = networking.NATNone
+			}
+		}
+	}
+
+	if c.config.PortMapping {
+		if mapper, err := portmap.NewDefaultMapper(); err == nil {
+			if lease, err := mapper.Lease(ctx, "udp", bound.Port, portmap.DefaultLifetime); err == nil {
+				local.lease = lease
+				mapping := lease.Mapping()
+				local.candidates = append(local.candidates, mapping.ExternalAddr().String())
+			}
+		}
+	}
+
+	return local, nil
+}
+
+// connect punches a path to the peer, falling back to the other traversal
+// strategies when punching fails or Config.Strategies leaves it out
+func (c *Client) connect(ctx context.Context, local *localSocket, peerID, connectionID string, key []byte, remoteNATType string, candidates []string) (net.Conn, error) {
+	remote := parseCandidates(candidates)
+
+	err := networking.ErrStrategyDisabled
+	if c.traversal.Enabled(networking.UDPHolePunching) {
+		var conn *Conn
+		if conn, err = c.holePunch(ctx, local, peerID, connectionID, remote); err == nil {
+			c.reportOutcome(connectionID, nil, string(networking.UDPHolePunching), local.natType, remoteNATType)
+			conn.peerKey = key
+			return conn, nil
+		}
+	}
+
+	localAddr := local.socket.LocalAddr().(*net.UDPAddr)
+	local.close()
+
+	conn, strategyType, fallbackErr := c.fallback(ctx, peerID, connectionID, localAddr, local.natType, remoteNATType, remote)
+	if fallbackErr != nil {
+		err = fmt.Errorf("%w; %v", err, fallbackErr)
+		c.reportOutcome(connectionID, err, string(strategyType), local.natType, remoteNATType)
+		return nil, err
+	}
+
+	c.reportOutcome(connectionID, nil, string(strategyType), local.natType, remoteNATType)
+	if relayed, ok := conn.(*Conn); ok {
+		relayed.onClose = func() { c.updateStatus(connectionID, "closed", "") }
+		relayed.peerKey = key
+		return relayed, nil
+	}
+	return &fallbackConn{Conn: conn, peerID: peerID, peerKey: key, strategy: c.traversal.Name(strategyType)}, nil
+}
+
+// holePunch punches a direct UDP path from the local socket to the peer
+func (c *Client) holePunch(ctx context.Context, local *localSocket, peerID, connectionID string, remote []*net.UDPAddr) (*Conn, error) {
+	counters := stats.NewCounters()
+	punchCtx, cancel := context.WithTimeout(ctx, c.config.PunchTimeout)
+	defer cancel()
+
+	punchCtx, span := tracer.Start(punchCtx, "client.punch", trace.WithAttributes(
+		attribute.String("natbypass.connection_id", connectionID),
+		attribute.Int("natbypass.candidates", len(remote)),
+	))
+	addr, peers, err := punch(punchCtx, local.socket, connectionID, networking.SortCandidates(remote), counters, c.signer)
+	if err == nil {
+		span.SetAttributes(attribute.String("natbypass.remote_addr", addr.String()))
+	}
+	tracing.End(span, err)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := newConn(local.socket, addr, peers, peerID, connectionID, counters, c.signer)
+	if local.lease != nil {
+		conn.closer = local.lease.Close
+	}
+	conn.onClose = func() { c.updateStatus(connectionID, "closed", "") }
+	return conn, nil
+}
+
+// Names reported by the strategies the client runs itself
+const (
+	holePunchingName = "UDP Hole Punching"
+	udpRelayingName  = "UDP Relaying"
+)
+
+// fallbackConn is a connection made by a fallback strategy
+type fallbackConn struct {
+	net.Conn
+	peerID   string
+	peerKey  []byte
+	strategy string
+}
+
+func (c *fallbackConn) PeerID() string {
+	return c.peerID
+}
+
+func (c *fallbackConn) PeerKey() []byte {
+	return c.peerKey
+}
+
+func (c *fallbackConn) Strategy() string {
+	return c.strategy
+}
+
+// Strategy returns the name of the traversal strategy that established a
+// connection returned by Dial or Accept, e.g. "UDP Hole Punching" or
+// "TCP Relaying". It is empty for other connections.
+func Strategy(conn net.Conn) string {
+	if s, ok := conn.(interface{ Strategy() string }); ok {
+		return s.Strategy()
+	}
+	return ""
+}
+
+// PeerKey returns the secret shared by the two peers of a connection
+// returned by Dial or Accept, or nil for other connections and peers that
+// sent none. The dialing peer picks it for each connection and sends it in
+// its offer. The server checks who sends each signal and only hands it to
+// its target, so nobody but the peers and the server knows the key.
+// Protocols on top of the connection can authenticate the peer with it.
+func PeerKey(conn net.Conn) []byte {
+	if k, ok := conn.(interface{ PeerKey() []byte }); ok {
+		return k.PeerKey()
+	}
+	return nil
+}
+
+// fallback tries the enabled traversal strategies other than UDP hole
+// punching and port mapping, which the punch already covered, in the order
+// Traversal.Rank gives. UDP relaying goes through Config.RelayServer when one
+// is set. It returns the strategy that connected, or the last one tried.
+func (c *Client) fallback(ctx context.Context, peerID, connectionID string, localAddr *net.UDPAddr, localNATType, remoteNATType string, candidates []*net.UDPAddr) (net.Conn, networking.StrategyType, error) {
+	if len(candidates) == 0 {
+		return nil, networking.UDPHolePunching, networking.ErrNoCandidates
+	}
+
+	var failures []string
+	tried := networking.UDPHolePunching
+	for _, strategyType := range c.traversal.Rank(localNATType, remoteNATType) {
+		if strategyType == networking.UDPHolePunching || strategyType == networking.PortMapping {
+			continue
+		}
+
+		tried = strategyType
+		var conn net.Conn
+		var err error
+		if strategyType == networking.UDPRelaying && c.config.RelayServer != "" {
+			conn, err = c.relay(ctx, peerID, connectionID)
+		} else {
+			conn, err = c.traversal.Connect(ctx, strategyType, localAddr, candidates)
+		}
+		if err == nil {
+			return conn, strategyType, nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", c.traversal.Name(strategyType), err))
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if len(failures) == 0 {
+		return nil, tried, errors.New("no fallback strategy available")
+	}
+	return nil, tried, fmt.Errorf("fallback failed (%s)", strings.Join(failures, "; "))
+}
+
+// stunBinding asks a STUN server for the socket's public address. It uses
+// the connection's own socket since a NAT may map each socket differently.
+func stunBinding(ctx context.Context, socket *net.UDPConn, server string) (public *net.UDPAddr, err error) {
+	_, span := tracer.Start(ctx, "stun.binding", trace.WithAttributes(
+		attribute.String("natbypass.stun_server", server),
+	))
+	defer func() {
+		if public != nil {
+			span.SetAttributes(attribute.String("natbypass.public_addr", public.String()))
+		}
+		tracing.End(span, err)
+	}()
+
+	serverAddr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		return nil, err
+	}
+
+	request := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	deadline := time.Now().Add(3 * time.Second)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	defer socket.SetReadDeadline(time.Time{})
+
+	buffer := make([]byte, 1500)
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := socket.WriteToUDP(request.Raw, serverAddr); err != nil {
+			return nil, err
+		}
+
+		retry := time.Now().Add(500 * time.Millisecond << attempt)
+		if retry.After(deadline) {
+			retry = deadline
+		}
+		socket.SetReadDeadline(retry)
+
+		for {
+			n, _, err := socket.ReadFromUDP(buffer)
+			if err != nil {
+				break
+			}
+
+			response := &stun.Message{Raw: append([]byte(nil), buffer[:n]...)}
+			if response.Decode() != nil || response.TransactionID != request.TransactionID {
+				continue
+			}
+
+			var mapped stun.XORMappedAddress
+			if err := mapped.GetFrom(response); err != nil {
+				return nil, err
+			}
+			return &net.UDPAddr{IP: mapped.IP, Port: mapped.Port}, nil
+		}
+
+		if !time.Now().Before(deadline) {
+			break
+		}
+	}
+
+	return nil, errors.New("no response from STUN server")
+}
+
+// parseCandidates converts literal host:port candidates, skipping duplicates
+// and anything that isn't an IP address
+func parseCandidates(candidates []string) []*net.UDPAddr {
+	seen := make(map[string]bool)
+	addrs := make([]*net.UDPAddr, 0, len(candidates))
+	for _, candidate := range candidates {
+		addrPort, err := netip.ParseAddrPort(candidate)
+		if err != nil || seen[addrPort.String()] {
+			continue
+		}
+		seen[addrPort.String()] = true
+		addrs = append(addrs, net.UDPAddrFromAddrPort(addrPort))
+	}
+	return addrs
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+// pkg/client/discover.go
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/bOguzhan/NATbypass/pkg/networking"
+)
+
+// ErrNoSTUNServer is returned by Discover when no STUN server is configured
+var ErrNoSTUNServer = errors.New("no STUN server configured")
+
+// Discovery describes how the local host is seen from outside its network
+type Discovery struct {
+	LocalAddr  *net.UDPAddr
+	PublicAddr *net.UDPAddr // As seen by the first STUN server that answered
+	NATType    string
+}
+
+// Discover finds the public address of a fresh socket and classifies the
+// NAT in front of it. The socket asks every server in turn, so a NAT that
+// maps each destination to a different port is detected as symmetric when
+// two or more servers answer. Filtering isn't tested, so any other NAT is
+// reported as port-restricted cone, the most conservative cone type, or
+// unknown when only one server answered. Config.STUNServer is used when no
+// servers are given.
+func (c *Client) Discover(ctx context.Context, servers ...string) (*Discovery, error) {
+	if len(servers) == 0 && c.config.STUNServer != "" {
+		servers = []string{c.config.STUNServer}
+	}
+	if len(servers) == 0 {
+		return nil, ErrNoSTUNServer
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", c.config.ListenAddress)
+	if err != nil {
+		return nil, err
+	}
+	socket, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer socket.Close()
+
+	bound := socket.LocalAddr().(*net.UDPAddr)
+	result := &Discovery{LocalAddr: bound, NATType: networking.NATUnknown}
+
+	var mapped []*net.UDPAddr
+	var lastErr error
+	for _, server := range servers {
+		public, err := stunBinding(ctx, socket, server)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		mapped = append(mapped, public)
+	}
+	if len(mapped) == 0 {
+		return nil, lastErr
+	}
+	result.PublicAddr = mapped[0]
+
+	var local []string
+	if bound.IP.IsUnspecified() {
+		local, _ = networking.GetHostCandidates(bound.Port)
+	} else {
+		local = []string{bound.String()}
+	}
+
+	switch {
+	case containsString(local, mapped[0].String()):
+		result.NATType = networking.NATNone
+	case !sameAddrs(mapped):
+		result.NATType = networking.NATSymmetric
+	case len(mapped) > 1:
+		result.NATType = networking.NATPortRestrictedCone
+	}
+	return result, nil
+}
+
+// sameAddrs reports whether every address is the same
+func sameAddrs(addrs []*net.UDPAddr) bool {
+	for _, addr := range addrs[1:] {
+		if addr.String() != addrs[0].String() {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,103 @@
+// pkg/client/groups.go
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+)
+
+// Peer is another member of a group, as listed by Peers
+type Peer struct {
+	ID         string            `json:"id"`
+	Name       string            `json:"name,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+	VirtualIP  string            `json:"virtual_ip,omitempty"`
+	LastSeen   time.Time         `json:"last_seen"`
+}
+
+// Presence reports a peer joining or leaving one of the client's groups
+type Presence struct {
+	Group  string
+	PeerID string
+	Joined bool // False when the peer left
+
+	// Name and Properties are only set when the peer joined
+	Name       string
+	Properties map[string]string
+}
+
+// JoinGroup makes the client a member of a group, creating the group if
+// nobody is in it. A group created with a secret can only be joined with the
+// same secret; a wrong one fails with an APIError coded
+// "group_access_denied". Members can list each other with Peers and are told
+// about joins and leaves through Config.OnPresence.
+func (c *Client) JoinGroup(ctx context.Context, group, secret string) error {
+	if err := c.Register(ctx); err != nil {
+		return err
+	}
+	if err := c.joinGroup(ctx, group, secret); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.groups[group] = secret
+	c.mu.Unlock()
+	return nil
+}
+
+// joinGroup asks the server to add the client to a group
+func (c *Client) joinGroup(ctx context.Context, group, secret string) error {
+	return c.call(ctx, http.MethodPost, "/api/v1/groups/join", map[string]interface{}{
+		"client_id": c.ID(),
+		"group":     group,
+		"secret":    secret,
+	}, nil)
+}
+
+// LeaveGroup removes the client from a group
+func (c *Client) LeaveGroup(ctx context.Context, group string) error {
+	c.mu.Lock()
+	delete(c.groups, group)
+	c.mu.Unlock()
+
+	return c.call(ctx, http.MethodPost, "/api/v1/groups/leave", map[string]interface{}{
+		"client_id": c.ID(),
+		"group":     group,
+	}, nil)
+}
+
+// Peers returns the other online members of a group the client belongs to
+func (c *Client) Peers(ctx context.Context, group string) ([]Peer, error) {
+	var response struct {
+		Peers []Peer `json:"peers"`
+	}
+	path := "/api/v1/groups/" + url.PathEscape(group) + "/peers?client_id=" + url.QueryEscape(c.ID())
+	if err := c.call(ctx, http.MethodGet, path, nil, &response); err != nil {
+		return nil, err
+	}
+	return response.Peers, nil
+}
+
+// deliverPresence passes a presence message to Config.OnPresence
+func (c *Client) deliverPresence(message protocol.Message) {
+	if c.config.OnPresence == nil {
+		return
+	}
+
+	var payload protocol.PresencePayload
+	if err := json.Unmarshal(message.Payload, &payload); err != nil {
+		return
+	}
+	c.config.OnPresence(Presence{
+		Group:      payload.Group,
+		PeerID:     message.ClientID,
+		Joined:     payload.Event == protocol.PresenceJoined,
+		Name:       payload.Name,
+		Properties: payload.Properties,
+	})
+}
@@ -0,0 +1,136 @@
+// pkg/client/listener.go
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"sync"
+
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+	"github.com/bOguzhan/NATbypass/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Listener accepts connections from peers dialing this client
+type Listener struct {
+	client   *Client
+	offers   chan offer
+	accepted chan net.Conn
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// Accept waits for the next peer connection
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.accepted:
+		return conn, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close stops accepting connections
+func (l *Listener) Close() error {
+	l.once.Do(func() {
+		l.client.mu.Lock()
+		if l.client.offers == l.offers {
+			l.client.offers = nil
+		}
+		l.client.mu.Unlock()
+
+		close(l.closed)
+	})
+	return nil
+}
+
+// Addr returns the client's peer address
+func (l *Listener) Addr() net.Addr {
+	return PeerAddr(l.client.ID())
+}
+
+// run answers offers until the listener or client is closed
+func (l *Listener) run() {
+	for {
+		select {
+		case <-l.closed:
+			return
+		case <-l.client.stop:
+			l.Close()
+			return
+		case o := <-l.offers:
+			go l.answer(o)
+		}
+	}
+}
+
+// answer responds to an offer and punches towards the dialing peer. Its
+// span continues the dialing peer's trace carried by the offer.
+func (l *Listener) answer(o offer) {
+	ctx, span := tracer.Start(tracing.Extract(context.Background(), o.traceContext), "client.accept",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("natbypass.peer_id", o.from),
+			attribute.String("natbypass.connection_id", o.description.ConnectionID),
+		))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-l.closed:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	conn, err := l.accept(ctx, o)
+	tracing.End(span, err)
+	if err != nil {
+		return
+	}
+
+	select {
+	case l.accepted <- conn:
+	case <-l.closed:
+		conn.Close()
+	}
+}
+
+// accept gathers candidates, answers the offer and connects
+func (l *Listener) accept(ctx context.Context, o offer) (net.Conn, error) {
+	c := l.client
+
+	local, err := c.gather(ctx)
+	if err != nil {
+		c.updateStatus(o.description.ConnectionID, "failed", err.Error())
+		return nil, err
+	}
+
+	err = c.sendSignal(ctx, protocol.TypeAnswer, o.from, sessionDescription{
+		ConnectionID: o.description.ConnectionID,
+		Candidates:   local.candidates,
+		NATType:      local.natType,
+	})
+	if err != nil {
+		local.close()
+		return nil, err
+	}
+
+	key, _ := hex.DecodeString(o.description.Key)
+	return c.connect(ctx, local, o.from, o.description.ConnectionID, key, o.description.NATType, o.description.Candidates)
+}
+
+// PeerAddr is a peer's client ID used as a network address
+type PeerAddr string
+
+// Network returns "natbypass"
+func (a PeerAddr) Network() string {
+	return "natbypass"
+}
+
+func (a PeerAddr) String() string {
+	return string(a)
+}
@@ -0,0 +1,238 @@
+// pkg/client/rendezvous.go
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/pkg/networking"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+	"github.com/bOguzhan/NATbypass/pkg/stats"
+)
+
+// relayPaired acknowledges a relay bind once both peers are bound
+const relayPaired = "paired"
+
+// ErrNoResponse is returned when the rendezvous or relay server never answered
+var ErrNoResponse = errors.New("no response from server")
+
+// packetSigner holds the session key the signaling server issued at
+// registration. Requests to the server are always signed with it. Packets
+// are signed only when the rendezvous servers check them, which is when
+// they share the rendezvous secret; they then drop packets that aren't
+// signed and sign registration acks in return.
+type packetSigner struct {
+	mu       sync.Mutex
+	clientID string
+	keyID    uint32
+	key      []byte
+	packets  bool // Whether rendezvous packets are authenticated
+	sequence uint64
+}
+
+// setKey replaces the session key, e.g. after registering again
+func (s *packetSigner) setKey(clientID string, keyID uint32, key []byte, packets bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clientID = clientID
+	s.keyID = keyID
+	s.key = key
+	s.packets = packets
+}
+
+// signRequest signs a request to the signaling server if a session key was
+// issued
+func (s *packetSigner) signRequest(request *http.Request, body []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.key != nil {
+		protocol.SignRequest(request.Header, s.clientID, s.keyID, s.key, request.Method, request.URL.RequestURI(), body, time.Now())
+	}
+}
+
+// verify reports whether a packet from the rendezvous server is signed with
+// the session key. Without authenticated packets nothing can be checked.
+func (s *packetSigner) verify(packet *protocol.Packet) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.key == nil || !s.packets {
+		return true
+	}
+	return packet.Verify(s.key) == nil &&
+		packet.Auth.ClientID == s.clientID && packet.Auth.KeyID == s.keyID
+}
+
+// serialize signs the packet if rendezvous packets are authenticated and
+// serializes it. Every packet gets the next sequence number, so
+// retransmissions must be serialized again rather than resent.
+func (s *packetSigner) serialize(packet *protocol.Packet) ([]byte, error) {
+	s.mu.Lock()
+	if s.key != nil && s.packets {
+		s.sequence++
+		err := packet.Sign(s.clientID, s.keyID, s.sequence, s.key)
+		if err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+	}
+	s.mu.Unlock()
+
+	return packet.Serialize()
+}
+
+// registerRendezvous announces the socket and its private endpoints to the
+// rendezvous server, which keeps its NAT mapping known to the server and
+// hands the endpoints to peers behind the same NAT. It returns the
+// socket's address as seen by the server. Acks that aren't signed with the
+// session key are ignored when rendezvous packets are authenticated, so a
+// forged ack can't announce a wrong address.
+func (c *Client) registerRendezvous(ctx context.Context, socket *net.UDPConn) (*net.UDPAddr, error) {
+	server, err := net.ResolveUDPAddr("udp", c.config.RendezvousServer)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := protocol.EncodeRegistration(&protocol.Registration{
+		ClientID:   c.ID(),
+		LocalAddrs: localEndpoints(socket.LocalAddr().(*net.UDPAddr)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var observed *net.UDPAddr
+	err = c.exchange(ctx, socket, server, protocol.PacketTypeRegistration, payload, nil, func(response *protocol.Packet) (bool, error) {
+		if response.Type != protocol.PacketTypeRegistrationAck || !c.signer.verify(response) {
+			return false, nil
+		}
+		addrPort, err := netip.ParseAddrPort(string(response.Payload))
+		if err != nil {
+			return false, fmt.Errorf("invalid registration ack: %w", err)
+		}
+		observed = net.UDPAddrFromAddrPort(addrPort)
+		return true, nil
+	})
+	return observed, err
+}
+
+// localEndpoints returns the private endpoints a socket bound to addr can
+// be reached on from inside its own network
+func localEndpoints(addr *net.UDPAddr) []string {
+	if addr.IP.IsUnspecified() {
+		endpoints, _ := networking.GetLocalEndpoints(addr.Port)
+		return endpoints
+	}
+
+	var endpoints []string
+	for _, addrPort := range protocol.LocalEndpoints([]string{addr.String()}) {
+		endpoints = append(endpoints, addrPort.String())
+	}
+	return endpoints
+}
+
+// relay connects to the peer through the relay server. Both peers bind the
+// connection ID agreed on through signaling and data flows once the relay
+// has paired them.
+func (c *Client) relay(ctx context.Context, peerID, connectionID string) (*Conn, error) {
+	server, err := net.ResolveUDPAddr("udp", c.config.RelayServer)
+	if err != nil {
+		return nil, err
+	}
+	addr, err := net.ResolveUDPAddr("udp", c.config.ListenAddress)
+	if err != nil {
+		return nil, err
+	}
+	socket, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	counters := stats.NewCounters()
+	bindCtx, cancel := context.WithTimeout(ctx, c.config.PunchTimeout)
+	defer cancel()
+
+	err = c.exchange(bindCtx, socket, server, protocol.PacketTypeRelayBind, []byte(connectionID), counters, func(response *protocol.Packet) (bool, error) {
+		switch response.Type {
+		case protocol.PacketTypeRelayBindAck:
+			// Binds are resent while waiting, so a lost "paired" is resent too
+			return string(response.Payload) == relayPaired, nil
+		case protocol.PacketTypeError:
+			return false, fmt.Errorf("relay refused bind: %s", response.Payload)
+		}
+		return false, nil
+	})
+	if err != nil {
+		socket.Close()
+		return nil, err
+	}
+
+	conn := newConn(socket, server, map[string]bool{server.String(): true}, peerID, connectionID, counters, c.signer)
+	conn.strategy = udpRelayingName
+	return conn, nil
+}
+
+// exchange sends a packet to a server every punchInterval until accept
+// takes a response or fails, or ctx is done. Responses from other
+// addresses are ignored; checking their signature is up to accept, since
+// the relay server doesn't sign its acks. Traffic is recorded in counters,
+// which may be nil.
+func (c *Client) exchange(ctx context.Context, socket *net.UDPConn, server *net.UDPAddr, packetType protocol.PacketType, payload []byte, counters *stats.Counters, accept func(*protocol.Packet) (bool, error)) error {
+	defer socket.SetReadDeadline(time.Time{})
+
+	buffer := make([]byte, maxDatagramSize)
+	nextSend := time.Now()
+	for {
+		if ctx.Err() != nil {
+			return ErrNoResponse
+		}
+
+		if !time.Now().Before(nextSend) {
+			data, err := c.signer.serialize(&protocol.Packet{Type: packetType, Payload: payload})
+			if err != nil {
+				return err
+			}
+			if _, err := socket.WriteToUDP(data, server); err == nil && counters != nil {
+				counters.RecordSent(len(data))
+			}
+			nextSend = time.Now().Add(punchInterval)
+		}
+
+		deadline := nextSend
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		socket.SetReadDeadline(deadline)
+
+		n, addr, err := socket.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+			// Timeouts and ICMP errors, resend
+			continue
+		}
+		if !addr.IP.Equal(server.IP) || addr.Port != server.Port {
+			continue
+		}
+
+		response, err := protocol.ParsePacket(buffer[:n])
+		if err != nil {
+			continue
+		}
+		if counters != nil {
+			counters.RecordReceived(n)
+		}
+		if done, err := accept(response); done || err != nil {
+			return err
+		}
+	}
+}
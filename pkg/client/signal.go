@@ -0,0 +1,194 @@
+// pkg/client/signal.go
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+	"github.com/bOguzhan/NATbypass/pkg/tracing"
+)
+
+const (
+	// offerQueueSize bounds offers waiting for the listener to pick them up
+	offerQueueSize = 16
+
+	// peerKeySize is the size of the key the dialer picks for a connection
+	peerKeySize = 32
+)
+
+// sessionDescription is the payload of offer and answer messages: where a
+// peer can be reached for one connection attempt
+type sessionDescription struct {
+	ConnectionID string   `json:"connection_id"`
+	Candidates   []string `json:"candidates"`
+	NATType      string   `json:"nat_type,omitempty"`
+	Key          string   `json:"key,omitempty"` // Hex encoded peer key picked by the dialer, see PeerKey
+}
+
+// offer is a connection request received from another peer
+type offer struct {
+	from         string
+	description  sessionDescription
+	traceContext map[string]string // The dialing peer's trace, if any
+}
+
+// sendSignal queues an offer or answer for a peer, carrying the trace in
+// ctx so the peer's side of the attempt joins it
+func (c *Client) sendSignal(ctx context.Context, msgType protocol.MessageType, targetID string, description sessionDescription) error {
+	message, err := protocol.NewMessage(msgType, c.ID(), description)
+	if err != nil {
+		return err
+	}
+	message.TargetID = targetID
+	message.TraceContext = tracing.Inject(ctx)
+
+	return c.call(ctx, http.MethodPost, "/api/v1/signal", message, nil)
+}
+
+// updateStatus reports the outcome of a connection attempt to the server
+func (c *Client) updateStatus(connectionID, status, errorMessage string) {
+	c.reportStatus(connectionID, status, errorMessage, nil)
+}
+
+// reportOutcome reports how a connection attempt ended, along with the
+// strategy and NAT types the server aggregates into punch metrics
+func (c *Client) reportOutcome(connectionID string, err error, strategy, natType, peerNATType string) {
+	status, errorMessage := "established", ""
+	if err != nil {
+		status, errorMessage = "failed", err.Error()
+	}
+
+	c.reportStatus(connectionID, status, errorMessage, map[string]interface{}{
+		"strategy":      strategy,
+		"nat_type":      natType,
+		"peer_nat_type": peerNATType,
+	})
+}
+
+// reportStatus sends a status update with optional extra fields
+func (c *Client) reportStatus(connectionID, status, errorMessage string, extra map[string]interface{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	body := map[string]interface{}{
+		"connection_id": connectionID,
+		"status":        status,
+		"error_message": errorMessage,
+	}
+	for key, value := range extra {
+		body[key] = value
+	}
+
+	_ = c.call(ctx, http.MethodPost, "/api/v1/connection/update", body, nil)
+}
+
+// pollLoop fetches signaling messages and routes them to waiting dials and
+// the listener
+func (c *Client) pollLoop() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.config.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+			c.poll()
+		}
+	}
+}
+
+// poll fetches and dispatches pending messages once
+func (c *Client) poll() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var response struct {
+		Messages []protocol.Message `json:"messages"`
+	}
+	if err := c.call(ctx, http.MethodGet, "/api/v1/messages/"+c.ID(), nil, &response); err != nil {
+		return
+	}
+
+	for _, message := range response.Messages {
+		if message.Type == protocol.TypePresence {
+			c.deliverPresence(message)
+			continue
+		}
+
+		var description sessionDescription
+		if err := json.Unmarshal(message.Payload, &description); err != nil || description.ConnectionID == "" {
+			continue
+		}
+
+		switch message.Type {
+		case protocol.TypeOffer:
+			c.deliverOffer(offer{from: message.ClientID, description: description, traceContext: message.TraceContext})
+		case protocol.TypeAnswer:
+			c.deliverAnswer(message.ClientID, description)
+		}
+	}
+}
+
+// deliverOffer hands an offer to the listener. Offers arriving while nobody
+// listens, or faster than they are accepted, are dropped and the dialing
+// peer times out.
+func (c *Client) deliverOffer(o offer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.offers == nil {
+		return
+	}
+
+	select {
+	case c.offers <- o:
+	default:
+	}
+}
+
+// pendingAnswer is a dial waiting for the dialed peer's answer
+type pendingAnswer struct {
+	peerID  string
+	answers chan sessionDescription
+}
+
+// deliverAnswer hands an answer to the dial waiting for it. The server
+// checks who sends a signal, so answers not from the dialed peer are dropped.
+func (c *Client) deliverAnswer(from string, description sessionDescription) {
+	c.mu.Lock()
+	pending, exists := c.pending[description.ConnectionID]
+	c.mu.Unlock()
+
+	if !exists || pending.peerID != from {
+		return
+	}
+
+	select {
+	case pending.answers <- description:
+	default:
+	}
+}
+
+// awaitAnswer registers interest in the answer from a peer for a connection
+func (c *Client) awaitAnswer(peerID, connectionID string) chan sessionDescription {
+	answers := make(chan sessionDescription, 1)
+
+	c.mu.Lock()
+	c.pending[connectionID] = pendingAnswer{peerID: peerID, answers: answers}
+	c.mu.Unlock()
+
+	return answers
+}
+
+// forgetAnswer stops waiting for the answer for a connection
+func (c *Client) forgetAnswer(connectionID string) {
+	c.mu.Lock()
+	delete(c.pending, connectionID)
+	c.mu.Unlock()
+}
@@ -0,0 +1,532 @@
+// pkg/mesh/mesh.go
+
+// Package mesh builds an overlay network on top of peer connections. The
+// mediatory server assigns every registered client a virtual IPv4 address;
+// a Node reads packets from a TUN device and sends each to the peer owning
+// its destination address, connecting to that peer with the client SDK,
+// and with it every traversal strategy, the first time it is addressed.
+// Packets are signed with the key the two clients shared when connecting,
+// see client.PeerKey, so only the peer the server vouched for can write to
+// the device.
+package mesh
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/pkg/client"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+)
+
+// Default settings used when the corresponding Config field is zero
+const (
+	DefaultMTU           = 1280
+	DefaultDialTimeout   = 30 * time.Second
+	DefaultRetryInterval = 10 * time.Second
+	DefaultIdleTimeout   = 5 * time.Minute
+	DefaultQueueSize     = 64
+
+	// replayWindowSize is the number of sequence numbers tracked behind the
+	// highest one received from a peer
+	replayWindowSize = 64
+)
+
+// Errors returned by nodes and devices
+var (
+	ErrNoVirtualIP     = errors.New("server assigned no virtual IP")
+	ErrTUNNotSupported = errors.New("TUN devices are only supported on Linux")
+	ErrNoPeerKey       = errors.New("peer shared no key to sign packets with")
+)
+
+// Device carries raw IP packets, one per Read and Write, such as a TUN
+// device
+type Device interface {
+	Read(packet []byte) (int, error)
+	Write(packet []byte) (int, error)
+	Close() error
+}
+
+// Config holds node settings
+type Config struct {
+	// MTU is the largest packet read from the device
+	MTU int
+
+	// DialTimeout limits connecting to a peer
+	DialTimeout time.Duration
+
+	// RetryInterval is how long packets to a peer that couldn't be reached
+	// are dropped before connecting is tried again
+	RetryInterval time.Duration
+
+	// IdleTimeout closes peer connections without traffic for this long;
+	// the next packet connects again
+	IdleTimeout time.Duration
+
+	// QueueSize bounds packets held for a peer while connecting to it
+	QueueSize int
+
+	// OnPeer, if set, is called when a peer connects or fails to, with a
+	// nil error on success
+	OnPeer func(addr netip.Addr, peerID string, err error)
+}
+
+func (c Config) withDefaults() Config {
+	if c.MTU <= 0 {
+		c.MTU = DefaultMTU
+	}
+	if c.DialTimeout <= 0 {
+		c.DialTimeout = DefaultDialTimeout
+	}
+	if c.RetryInterval <= 0 {
+		c.RetryInterval = DefaultRetryInterval
+	}
+	if c.IdleTimeout <= 0 {
+		c.IdleTimeout = DefaultIdleTimeout
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = DefaultQueueSize
+	}
+	return c
+}
+
+// Node routes packets between a device and the peers of a mesh
+type Node struct {
+	client *client.Client
+	device Device
+	config Config
+
+	mu    sync.Mutex
+	addr  netip.Prefix // Our address and the virtual network
+	peers map[netip.Addr]*peer
+}
+
+// peer is the connection to the owner of one virtual address
+type peer struct {
+	addr netip.Addr
+
+	mu         sync.Mutex
+	conn       *peerConn // Used for sending, nil until connected
+	dialing    bool
+	queue      [][]byte  // Packets waiting for the connection
+	retryAt    time.Time // Packets are dropped until then after a failed dial
+	lastActive int64     // Unix nanoseconds
+}
+
+func (p *peer) touch() {
+	atomic.StoreInt64(&p.lastActive, time.Now().UnixNano())
+}
+
+// peerConn is a connection to a peer whose packets are signed with the key
+// the two clients shared when connecting
+type peerConn struct {
+	net.Conn
+	localID string
+	peerID  string
+	key     []byte
+	sent    uint64 // Sequence number of the last packet sent
+
+	// Packets received, only used by the connection's reader
+	highest uint64
+	bitmap  uint64 // Bit i set means highest-i has been received
+}
+
+// newPeerConn wraps a connection from the client, which fails if the peer
+// shared no key
+func newPeerConn(conn net.Conn, localID, peerID string) (*peerConn, error) {
+	key := client.PeerKey(conn)
+	if len(key) == 0 {
+		return nil, ErrNoPeerKey
+	}
+	return &peerConn{Conn: conn, localID: localID, peerID: peerID, key: key}, nil
+}
+
+// writePacket signs one IP packet and sends it as a frame, which keeps
+// packets apart on stream connections made by fallback strategies
+func (c *peerConn) writePacket(data []byte) error {
+	packet := &protocol.Packet{Type: protocol.PacketTypeData, Payload: data}
+	if err := packet.Sign(c.localID, 0, atomic.AddUint64(&c.sent, 1), c.key); err != nil {
+		return err
+	}
+	frame, err := protocol.EncodeFrame(packet)
+	if err != nil {
+		return err
+	}
+	_, err = c.Write(frame)
+	return err
+}
+
+// accept reports whether a packet was signed by the peer and not received
+// before. Our own packets sent back to us carry our ID, so they fail too.
+func (c *peerConn) accept(packet *protocol.Packet) bool {
+	if packet.Verify(c.key) != nil || packet.Auth.ClientID != c.peerID {
+		return false
+	}
+
+	sequence := packet.Auth.Sequence
+	if sequence > c.highest {
+		if shift := sequence - c.highest; shift >= replayWindowSize {
+			c.bitmap = 1
+		} else {
+			c.bitmap = c.bitmap<<shift | 1
+		}
+		c.highest = sequence
+		return true
+	}
+
+	offset := c.highest - sequence
+	if sequence == 0 || offset >= replayWindowSize {
+		return false
+	}
+	mask := uint64(1) << offset
+	if c.bitmap&mask != 0 {
+		return false
+	}
+	c.bitmap |= mask
+	return true
+}
+
+// NewNode creates a node sending and receiving the device's packets through
+// c, which must be registered with Config.Mesh set so the server has
+// assigned its address
+func NewNode(c *client.Client, device Device, config *Config) *Node {
+	var cfg Config
+	if config != nil {
+		cfg = *config
+	}
+
+	return &Node{
+		client: c,
+		device: device,
+		config: cfg.withDefaults(),
+		peers:  make(map[netip.Addr]*peer),
+	}
+}
+
+// Run routes packets until ctx is cancelled, closing the device, or the
+// device fails. Peers connecting to this node are accepted throughout.
+func (n *Node) Run(ctx context.Context) error {
+	addr := n.client.VirtualIP()
+	if !addr.IsValid() {
+		return ErrNoVirtualIP
+	}
+	n.mu.Lock()
+	n.addr = addr
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	listener, err := n.client.Listen(ctx)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+		n.device.Close()
+	}()
+	go n.acceptLoop(listener)
+	go n.reapLoop(ctx)
+	defer n.closePeers()
+
+	buffer := make([]byte, n.config.MTU)
+	for {
+		size, err := n.device.Read(buffer)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		n.route(ctx, buffer[:size])
+	}
+}
+
+// route sends a packet from the device to the peer owning its destination,
+// connecting first if needed
+func (n *Node) route(ctx context.Context, packet []byte) {
+	_, dst, ok := addresses(packet)
+	if !ok || dst == n.addr.Addr() || !n.addr.Contains(dst) {
+		return
+	}
+
+	n.mu.Lock()
+	p, exists := n.peers[dst]
+	if !exists {
+		p = &peer{addr: dst}
+		p.touch()
+		n.peers[dst] = p
+	}
+	n.mu.Unlock()
+
+	p.mu.Lock()
+	switch {
+	case p.conn != nil:
+		conn := p.conn
+		p.mu.Unlock()
+		p.touch()
+		conn.writePacket(packet)
+	case p.dialing:
+		if len(p.queue) < n.config.QueueSize {
+			p.queue = append(p.queue, append([]byte(nil), packet...))
+		}
+		p.mu.Unlock()
+	case time.Now().Before(p.retryAt):
+		p.mu.Unlock()
+	default:
+		p.dialing = true
+		p.queue = [][]byte{append([]byte(nil), packet...)}
+		p.mu.Unlock()
+		go n.dial(ctx, p)
+	}
+}
+
+// dial connects to the owner of a peer's address
+func (n *Node) dial(ctx context.Context, p *peer) {
+	dialCtx, cancel := context.WithTimeout(ctx, n.config.DialTimeout)
+	defer cancel()
+
+	peerID, err := n.client.LookupVirtualIP(dialCtx, p.addr)
+	var conn *peerConn
+	if err == nil {
+		conn, err = n.connect(dialCtx, peerID)
+	}
+	if ctx.Err() != nil {
+		// The node is stopping
+		if conn != nil {
+			conn.Close()
+		}
+		return
+	}
+	n.notify(p.addr, peerID, err)
+
+	if err != nil {
+		p.mu.Lock()
+		p.dialing = false
+		p.queue = nil
+		p.retryAt = time.Now().Add(n.config.RetryInterval)
+		p.mu.Unlock()
+		return
+	}
+
+	n.attach(p, conn)
+	n.readLoop(conn, p.addr)
+}
+
+// connect dials a peer for a connection its packets can be signed on
+func (n *Node) connect(ctx context.Context, peerID string) (*peerConn, error) {
+	conn, err := n.client.Dial(ctx, peerID)
+	if err != nil {
+		return nil, err
+	}
+	signed, err := newPeerConn(conn, n.client.ID(), peerID)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return signed, nil
+}
+
+// attach makes conn the connection packets to p are sent on, unless it
+// already has one, and sends the packets that were waiting
+func (n *Node) attach(p *peer, conn *peerConn) {
+	p.mu.Lock()
+	if p.conn == nil {
+		p.conn = conn
+	}
+	queue := p.queue
+	p.queue = nil
+	p.dialing = false
+	sender := p.conn
+	p.mu.Unlock()
+
+	p.touch()
+	for _, packet := range queue {
+		sender.writePacket(packet)
+	}
+}
+
+// acceptLoop accepts connections from peers until the listener closes
+func (n *Node) acceptLoop(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		peerID := conn.RemoteAddr().String()
+		if identified, ok := conn.(interface{ PeerID() string }); ok {
+			peerID = identified.PeerID()
+		}
+		signed, err := newPeerConn(conn, n.client.ID(), peerID)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+		go n.readLoop(signed, netip.Addr{})
+	}
+}
+
+// readLoop writes packets signed by the peer to the device until the
+// connection fails. A connection we accepted doesn't say which address the
+// peer owns, so it is taken from the first packet's source once the server
+// confirms it belongs to the peer; packets from any other source are dropped.
+func (n *Node) readLoop(conn *peerConn, addr netip.Addr) {
+	peerID := conn.peerID
+	defer conn.Close()
+
+	var p *peer
+	if addr.IsValid() {
+		p = n.peer(addr)
+	}
+	rejected := make(map[netip.Addr]bool)
+
+	decoder := protocol.NewStreamDecoder(0)
+	buffer := make([]byte, 64*1024)
+	for {
+		size, err := conn.Read(buffer)
+		if err != nil {
+			break
+		}
+		decoder.Write(buffer[:size])
+
+		for {
+			packet, err := decoder.Next()
+			if err != nil || packet == nil {
+				break
+			}
+			if packet.Type != protocol.PacketTypeData || !conn.accept(packet) {
+				continue
+			}
+			src, _, ok := addresses(packet.Payload)
+			if !ok {
+				continue
+			}
+
+			if !addr.IsValid() && !rejected[src] {
+				if n.verify(src, peerID) {
+					addr = src
+					p = n.peer(addr)
+					n.attach(p, conn)
+					n.notify(addr, peerID, nil)
+				} else {
+					rejected[src] = true
+				}
+			}
+			if src != addr {
+				continue
+			}
+
+			p.touch()
+			n.device.Write(packet.Payload)
+		}
+	}
+
+	// Connect again on the next packet if this was the sending connection
+	if p != nil {
+		p.mu.Lock()
+		if p.conn == conn {
+			p.conn = nil
+		}
+		p.mu.Unlock()
+	}
+}
+
+// verify asks the server whether addr belongs to the peer
+func (n *Node) verify(addr netip.Addr, peerID string) bool {
+	n.mu.Lock()
+	inNetwork := n.addr.Contains(addr) && addr != n.addr.Addr()
+	n.mu.Unlock()
+	if !inNetwork {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.config.DialTimeout)
+	defer cancel()
+	owner, err := n.client.LookupVirtualIP(ctx, addr)
+	return err == nil && owner == peerID
+}
+
+// peer returns the entry for an address, creating it if needed
+func (n *Node) peer(addr netip.Addr) *peer {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	p, exists := n.peers[addr]
+	if !exists {
+		p = &peer{addr: addr}
+		p.touch()
+		n.peers[addr] = p
+	}
+	return p
+}
+
+// notify reports a peer connecting or failing to
+func (n *Node) notify(addr netip.Addr, peerID string, err error) {
+	if n.config.OnPeer != nil {
+		n.config.OnPeer(addr, peerID, err)
+	}
+}
+
+// reapLoop closes connections to idle peers until ctx is cancelled
+func (n *Node) reapLoop(ctx context.Context) {
+	ticker := time.NewTicker(n.config.IdleTimeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cutoff := time.Now().Add(-n.config.IdleTimeout).UnixNano()
+		n.mu.Lock()
+		for addr, p := range n.peers {
+			if atomic.LoadInt64(&p.lastActive) >= cutoff {
+				continue
+			}
+			p.mu.Lock()
+			if !p.dialing {
+				if p.conn != nil {
+					p.conn.Close()
+				}
+				delete(n.peers, addr)
+			}
+			p.mu.Unlock()
+		}
+		n.mu.Unlock()
+	}
+}
+
+// closePeers closes every peer connection
+func (n *Node) closePeers() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for addr, p := range n.peers {
+		p.mu.Lock()
+		if p.conn != nil {
+			p.conn.Close()
+		}
+		p.mu.Unlock()
+		delete(n.peers, addr)
+	}
+}
+
+// addresses returns the source and destination of an IPv4 packet
+func addresses(packet []byte) (src, dst netip.Addr, ok bool) {
+	if len(packet) < 20 || packet[0]>>4 != 4 {
+		return netip.Addr{}, netip.Addr{}, false
+	}
+	src = netip.AddrFrom4([4]byte{packet[12], packet[13], packet[14], packet[15]})
+	dst = netip.AddrFrom4([4]byte{packet[16], packet[17], packet[18], packet[19]})
+	return src, dst, true
+}
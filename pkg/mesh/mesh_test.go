@@ -0,0 +1,197 @@
+// pkg/mesh/mesh_test.go
+package mesh
+
+import (
+	"context"
+	"net"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/internal/signaling"
+	"github.com/bOguzhan/NATbypass/internal/utils"
+	"github.com/bOguzhan/NATbypass/pkg/client"
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+// memDevice is a Device whose packets are exchanged over channels
+type memDevice struct {
+	in     chan []byte // Packets the node reads
+	out    chan []byte // Packets the node writes
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newMemDevice() *memDevice {
+	return &memDevice{
+		in:     make(chan []byte, 16),
+		out:    make(chan []byte, 16),
+		closed: make(chan struct{}),
+	}
+}
+
+func (d *memDevice) Read(packet []byte) (int, error) {
+	select {
+	case data := <-d.in:
+		return copy(packet, data), nil
+	case <-d.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (d *memDevice) Write(packet []byte) (int, error) {
+	select {
+	case d.out <- append([]byte(nil), packet...):
+	default:
+	}
+	return len(packet), nil
+}
+
+func (d *memDevice) Close() error {
+	d.once.Do(func() { close(d.closed) })
+	return nil
+}
+
+// expect waits for the next packet the node writes to the device
+func (d *memDevice) expect(t *testing.T) []byte {
+	select {
+	case packet := <-d.out:
+		return packet
+	case <-time.After(5 * time.Second):
+		t.Fatal("no packet delivered")
+		return nil
+	}
+}
+
+// ipv4 builds an IPv4 packet with just the fields the node looks at
+func ipv4(src, dst netip.Addr, payload string) []byte {
+	packet := make([]byte, 20, 20+len(payload))
+	packet[0] = 0x45
+	copy(packet[12:16], src.AsSlice())
+	copy(packet[16:20], dst.AsSlice())
+	return append(packet, payload...)
+}
+
+// startNode registers a client and runs a node for it on a memory device
+func startNode(t *testing.T, ctx context.Context, serverURL string, config *Config) (*client.Client, *memDevice) {
+	c, err := client.New(client.Config{
+		ServerURL:     serverURL,
+		Mesh:          true,
+		ListenAddress: "127.0.0.1:0",
+		PollInterval:  20 * time.Millisecond,
+		PunchTimeout:  2 * time.Second,
+	})
+	assert.NoError(t, err)
+	t.Cleanup(func() { c.Close() })
+	assert.NoError(t, c.Register(ctx))
+
+	device := newMemDevice()
+	go NewNode(c, device, config).Run(ctx)
+	return c, device
+}
+
+func TestNodeRoutesPackets(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	handlers, err := signaling.NewHandlers(utils.NewLogger("test", "error"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handlers.SetupRoutes(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var failures []netip.Addr
+	config := &Config{
+		RetryInterval: time.Minute,
+		OnPeer: func(addr netip.Addr, peerID string, err error) {
+			if err != nil {
+				mu.Lock()
+				failures = append(failures, addr)
+				mu.Unlock()
+			}
+		},
+	}
+
+	alice, aliceDevice := startNode(t, ctx, server.URL, config)
+	bob, bobDevice := startNode(t, ctx, server.URL, config)
+	aliceIP, bobIP := alice.VirtualIP().Addr(), bob.VirtualIP().Addr()
+	assert.True(t, alice.VirtualIP().IsValid())
+	assert.NotEqual(t, aliceIP, bobIP)
+
+	// The first packet to bob connects to him
+	ping := ipv4(aliceIP, bobIP, "ping")
+	aliceDevice.in <- ping
+	assert.Equal(t, ping, bobDevice.expect(t))
+
+	// Bob answers over the connection alice made
+	pong := ipv4(bobIP, aliceIP, "pong")
+	bobDevice.in <- pong
+	assert.Equal(t, pong, aliceDevice.expect(t))
+
+	// Packets claiming another source are dropped
+	aliceDevice.in <- ipv4(netip.MustParseAddr("100.64.0.99"), bobIP, "spoofed")
+	aliceDevice.in <- ipv4(aliceIP, bobIP, "again")
+	assert.Equal(t, ipv4(aliceIP, bobIP, "again"), bobDevice.expect(t))
+
+	// Addresses nobody owns fail to connect
+	unowned := netip.MustParseAddr("100.64.0.200")
+	aliceDevice.in <- ipv4(aliceIP, unowned, "lost")
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(failures) == 1 && failures[0] == unowned
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// keyedConn is a connection with a peer key, like the client's
+type keyedConn struct {
+	net.Conn
+	key []byte
+}
+
+func (c keyedConn) PeerKey() []byte {
+	return c.key
+}
+
+func TestPeerConnAcceptsOnlySignedPackets(t *testing.T) {
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	_, err := newPeerConn(local, "bob", "alice")
+	assert.ErrorIs(t, err, ErrNoPeerKey)
+
+	key := []byte("0123456789abcdef0123456789abcdef")
+	conn, err := newPeerConn(keyedConn{Conn: local, key: key}, "bob", "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := func(from string, sequence uint64, key []byte) *protocol.Packet {
+		packet := &protocol.Packet{Type: protocol.PacketTypeData, Payload: []byte("ip packet")}
+		if err := packet.Sign(from, 0, sequence, key); err != nil {
+			t.Fatal(err)
+		}
+		return packet
+	}
+
+	assert.True(t, conn.accept(signed("alice", 1, key)))
+	assert.False(t, conn.accept(signed("alice", 1, key)), "replayed")
+	assert.False(t, conn.accept(signed("alice", 2, []byte("another key"))), "wrong key")
+	assert.False(t, conn.accept(signed("bob", 3, key)), "our own packet sent back")
+	assert.False(t, conn.accept(&protocol.Packet{Type: protocol.PacketTypeData, Payload: []byte("ip packet")}), "unsigned")
+
+	// Packets may arrive out of order within the window
+	assert.True(t, conn.accept(signed("alice", 70, key)))
+	assert.True(t, conn.accept(signed("alice", 69, key)))
+	assert.False(t, conn.accept(signed("alice", 5, key)), "too old")
+}
@@ -0,0 +1,96 @@
+//go:build linux
+
+package mesh
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// tunDevice is the clone device TUN interfaces are created from
+const tunDevice = "/dev/net/tun"
+
+// TUN is a Linux TUN interface. Each Read returns one IP packet sent by the
+// kernel and each Write delivers one to it.
+type TUN struct {
+	file *os.File
+	name string
+}
+
+// OpenTUN creates a TUN interface, or attaches to an existing persistent
+// one. An empty name lets the kernel choose one such as tun0. It needs
+// CAP_NET_ADMIN.
+func OpenTUN(name string) (*TUN, error) {
+	if len(name) >= syscall.IFNAMSIZ {
+		return nil, fmt.Errorf("interface name %q is too long", name)
+	}
+
+	fd, err := syscall.Open(tunDevice, syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", tunDevice, err)
+	}
+
+	// struct ifreq: the name, then the flags in the union that follows
+	var request [40]byte
+	copy(request[:], name)
+	*(*uint16)(unsafe.Pointer(&request[syscall.IFNAMSIZ])) = syscall.IFF_TUN | syscall.IFF_NO_PI
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TUNSETIFF, uintptr(unsafe.Pointer(&request[0]))); errno != 0 {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to create TUN interface: %w", errno)
+	}
+
+	// Non-blocking descriptors go through the runtime poller, so Close
+	// interrupts a blocked Read
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	return &TUN{
+		file: os.NewFile(uintptr(fd), tunDevice),
+		name: string(bytes.TrimRight(request[:syscall.IFNAMSIZ], "\x00")),
+	}, nil
+}
+
+// Name returns the interface name
+func (t *TUN) Name() string {
+	return t.name
+}
+
+// Configure assigns the interface its address, which also routes the rest
+// of the prefix through it, sets the MTU and brings it up. It runs ip(8).
+func (t *TUN) Configure(addr netip.Prefix, mtu int) error {
+	commands := [][]string{
+		{"addr", "replace", addr.String(), "dev", t.name},
+		{"link", "set", "dev", t.name, "mtu", strconv.Itoa(mtu), "up"},
+	}
+	for _, args := range commands {
+		if output, err := exec.Command("ip", args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("ip %s: %v: %s", strings.Join(args, " "), err, bytes.TrimSpace(output))
+		}
+	}
+	return nil
+}
+
+// Read reads one packet
+func (t *TUN) Read(packet []byte) (int, error) {
+	return t.file.Read(packet)
+}
+
+// Write writes one packet
+func (t *TUN) Write(packet []byte) (int, error) {
+	return t.file.Write(packet)
+}
+
+// Close removes the interface unless it is persistent
+func (t *TUN) Close() error {
+	return t.file.Close()
+}
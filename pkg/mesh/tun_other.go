@@ -0,0 +1,38 @@
+//go:build !linux
+
+package mesh
+
+import "net/netip"
+
+// TUN is a TUN interface, only available on Linux
+type TUN struct{}
+
+// OpenTUN returns ErrTUNNotSupported
+func OpenTUN(name string) (*TUN, error) {
+	return nil, ErrTUNNotSupported
+}
+
+// Name returns an empty name
+func (t *TUN) Name() string {
+	return ""
+}
+
+// Configure returns ErrTUNNotSupported
+func (t *TUN) Configure(addr netip.Prefix, mtu int) error {
+	return ErrTUNNotSupported
+}
+
+// Read returns ErrTUNNotSupported
+func (t *TUN) Read(packet []byte) (int, error) {
+	return 0, ErrTUNNotSupported
+}
+
+// Write returns ErrTUNNotSupported
+func (t *TUN) Write(packet []byte) (int, error) {
+	return 0, ErrTUNNotSupported
+}
+
+// Close does nothing
+func (t *TUN) Close() error {
+	return nil
+}
@@ -0,0 +1,134 @@
+package networking
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestPublicAPIAvoidsInternalPackages checks that nothing exported from the
+// packages under pkg mentions a type or value from internal packages, which
+// programs outside this module can't import
+func TestPublicAPIAvoidsInternalPackages(t *testing.T) {
+	dirs, err := filepath.Glob("../*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dir := range dirs {
+		packages, err := parser.ParseDir(token.NewFileSet(), dir, func(info fs.FileInfo) bool {
+			return !strings.HasSuffix(info.Name(), "_test.go")
+		}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, pkg := range packages {
+			for name, file := range pkg.Files {
+				internal := internalImports(file)
+				for _, node := range exportedAPI(file) {
+					ast.Inspect(node, func(n ast.Node) bool {
+						selector, ok := n.(*ast.SelectorExpr)
+						if !ok {
+							return true
+						}
+						if ident, ok := selector.X.(*ast.Ident); ok && internal[ident.Name] {
+							t.Errorf("%s: exported API uses %s.%s", name, ident.Name, selector.Sel.Name)
+						}
+						return true
+					})
+				}
+			}
+		}
+	}
+}
+
+// internalImports returns the names a file imports internal packages under
+func internalImports(file *ast.File) map[string]bool {
+	names := make(map[string]bool)
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		if !strings.Contains(path, "/internal/") {
+			continue
+		}
+		name := filepath.Base(path)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		names[name] = true
+	}
+	return names
+}
+
+// exportedAPI returns the parts of a file's exported declarations that
+// importers see: signatures, type definitions and package-level values.
+// Function bodies and unexported struct fields are left out.
+func exportedAPI(file *ast.File) []ast.Node {
+	var nodes []ast.Node
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Name.IsExported() && (decl.Recv == nil || exportedReceiver(decl.Recv)) {
+				nodes = append(nodes, decl.Type)
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					if spec.Name.IsExported() {
+						nodes = append(nodes, exportedFields(spec.Type)...)
+					}
+				case *ast.ValueSpec:
+					for i, name := range spec.Names {
+						if !name.IsExported() {
+							continue
+						}
+						if spec.Type != nil {
+							nodes = append(nodes, spec.Type)
+						}
+						if i < len(spec.Values) {
+							nodes = append(nodes, spec.Values[i])
+						}
+					}
+				}
+			}
+		}
+	}
+	return nodes
+}
+
+// exportedReceiver reports whether a method belongs to an exported type
+func exportedReceiver(recv *ast.FieldList) bool {
+	expr := recv.List[0].Type
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.IsExported()
+}
+
+// exportedFields returns a type definition without its unexported struct
+// fields
+func exportedFields(expr ast.Expr) []ast.Node {
+	structType, ok := expr.(*ast.StructType)
+	if !ok {
+		return []ast.Node{expr}
+	}
+
+	var nodes []ast.Node
+	for _, field := range structType.Fields.List {
+		exported := len(field.Names) == 0 // Embedded fields are promoted
+		for _, name := range field.Names {
+			exported = exported || name.IsExported()
+		}
+		if exported {
+			nodes = append(nodes, field.Type)
+		}
+	}
+	return nodes
+}
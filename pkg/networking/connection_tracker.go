@@ -2,10 +2,12 @@ package networking
 
 import (
 	"errors"
+	"fmt"
 	"net"
 	"sync"
 	"time"
 
+	"github.com/bOguzhan/NATbypass/pkg/stats"
 	"github.com/google/uuid"
 )
 
@@ -25,6 +27,46 @@ const (
 	ConnectionStateClosed ConnectionState = "closed"
 )
 
+// Errors returned by connection trackers
+var (
+	ErrConnectionNotFound = errors.New("connection not found")
+	ErrInvalidState       = errors.New("invalid connection state")
+	ErrInvalidTransition  = errors.New("invalid connection state transition")
+)
+
+// stateTransitions lists the states each state may move to. Failed
+// connections may be retried; closed is final.
+var stateTransitions = map[ConnectionState][]ConnectionState{
+	ConnectionStateNew:         {ConnectionStateInitiating, ConnectionStateEstablished, ConnectionStateFailed, ConnectionStateClosed},
+	ConnectionStateInitiating:  {ConnectionStateEstablished, ConnectionStateFailed, ConnectionStateClosed},
+	ConnectionStateEstablished: {ConnectionStateFailed, ConnectionStateClosed},
+	ConnectionStateFailed:      {ConnectionStateInitiating, ConnectionStateClosed},
+	ConnectionStateClosed:      {},
+}
+
+// Valid reports whether s is a known state
+func (s ConnectionState) Valid() bool {
+	_, ok := stateTransitions[s]
+	return ok
+}
+
+// CanTransitionTo reports whether a connection in state s may move to next
+func (s ConnectionState) CanTransitionTo(next ConnectionState) bool {
+	for _, allowed := range stateTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// StateTransition records a change of connection state
+type StateTransition struct {
+	From ConnectionState
+	To   ConnectionState
+	At   time.Time
+}
+
 // Connection represents a tracked connection
 type Connection struct {
 	ID            string
@@ -34,22 +76,137 @@ type Connection struct {
 	State         ConnectionState
 	CreatedAt     time.Time
 	LastUpdatedAt time.Time
+	ExpiresAt     time.Time // Zero when the tracker has no TTL
+	History       []StateTransition
 	Metadata      map[string]interface{}
+	Stats         stats.Snapshot // Traffic counters when the snapshot was taken
+
+	counters *stats.Counters
+}
+
+// snapshot returns a copy that callers can keep without racing the tracker.
+// Metadata values themselves are not copied.
+func (c *Connection) snapshot() *Connection {
+	copied := *c
+	copied.Stats = c.counters.Snapshot()
+	copied.counters = nil
+	copied.History = append([]StateTransition(nil), c.History...)
+	copied.Metadata = make(map[string]interface{}, len(c.Metadata))
+	for key, value := range c.Metadata {
+		copied.Metadata[key] = value
+	}
+	return &copied
+}
+
+// expired reports whether the connection's TTL has passed
+func (c *Connection) expired(now time.Time) bool {
+	return !c.ExpiresAt.IsZero() && now.After(c.ExpiresAt)
 }
 
+// ConnectionFilter selects connections in FindConnections. Zero fields
+// match everything.
+type ConnectionFilter struct {
+	Type          ConnectionType
+	States        []ConnectionState
+	Addr          net.Addr    // Matches either the source or the target
+	MetadataKey   string      // Requires the key to be set
+	MetadataValue interface{} // Also requires the value when not nil
+	UpdatedAfter  time.Time
+}
+
+// Matches reports whether a connection passes the filter
+func (f ConnectionFilter) Matches(conn *Connection) bool {
+	if f.Type != "" && conn.Type != f.Type {
+		return false
+	}
+
+	if len(f.States) > 0 {
+		found := false
+		for _, state := range f.States {
+			if conn.State == state {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if f.Addr != nil && !sameAddr(conn.SourceAddr, f.Addr) && !sameAddr(conn.TargetAddr, f.Addr) {
+		return false
+	}
+
+	if f.MetadataKey != "" {
+		value, exists := conn.Metadata[f.MetadataKey]
+		if !exists || (f.MetadataValue != nil && value != f.MetadataValue) {
+			return false
+		}
+	}
+
+	if !f.UpdatedAfter.IsZero() && !conn.LastUpdatedAt.After(f.UpdatedAfter) {
+		return false
+	}
+
+	return true
+}
+
+// sameAddr compares addresses by their string form
+func sameAddr(a, b net.Addr) bool {
+	return a != nil && b != nil && a.String() == b.String()
+}
+
+// ConnectionEventType identifies a change to a tracked connection
+type ConnectionEventType string
+
+const (
+	// ConnectionAdded is sent when a connection starts being tracked
+	ConnectionAdded ConnectionEventType = "added"
+	// ConnectionStateChanged is sent after a state transition
+	ConnectionStateChanged ConnectionEventType = "state_changed"
+	// ConnectionMetadataChanged is sent when metadata is set or deleted
+	ConnectionMetadataChanged ConnectionEventType = "metadata_changed"
+	// ConnectionRemoved is sent when a connection is removed
+	ConnectionRemoved ConnectionEventType = "removed"
+	// ConnectionExpired is sent when a connection outlives its TTL
+	ConnectionExpired ConnectionEventType = "expired"
+)
+
+// ConnectionChange describes a change to a tracked connection
+type ConnectionChange struct {
+	Type       ConnectionEventType
+	Connection *Connection // Snapshot after the change
+}
+
+// watcherBufferSize bounds changes queued for a slow watcher
+const watcherBufferSize = 64
+
 // BaseConnectionTracker provides a basic implementation of the ConnectionTracker interface
 type BaseConnectionTracker struct {
 	connections map[string]*Connection
+	ttl         time.Duration
+	watchers    map[chan ConnectionChange]struct{}
 	mu          sync.RWMutex
+
+	stopExpiry chan struct{}
 }
 
 // NewBaseConnectionTracker creates a new BaseConnectionTracker
 func NewBaseConnectionTracker() *BaseConnectionTracker {
 	return &BaseConnectionTracker{
 		connections: make(map[string]*Connection),
+		watchers:    make(map[chan ConnectionChange]struct{}),
 	}
 }
 
+// SetTTL makes connections expire when they haven't been updated for ttl.
+// Zero disables expiry. It applies to connections updated afterwards.
+func (t *BaseConnectionTracker) SetTTL(ttl time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.ttl = ttl
+}
+
 // AddConnection registers a new connection
 func (t *BaseConnectionTracker) AddConnection(sourceAddr net.Addr, targetAddr net.Addr, connType ConnectionType) (string, error) {
 	t.mu.Lock()
@@ -58,7 +215,7 @@ func (t *BaseConnectionTracker) AddConnection(sourceAddr net.Addr, targetAddr ne
 	id := uuid.New().String()
 	now := time.Now()
 
-	t.connections[id] = &Connection{
+	conn := &Connection{
 		ID:            id,
 		SourceAddr:    sourceAddr,
 		TargetAddr:    targetAddr,
@@ -67,50 +224,124 @@ func (t *BaseConnectionTracker) AddConnection(sourceAddr net.Addr, targetAddr ne
 		CreatedAt:     now,
 		LastUpdatedAt: now,
 		Metadata:      make(map[string]interface{}),
+		counters:      stats.NewCounters(),
 	}
+	t.touchLocked(conn, now)
+	t.connections[id] = conn
 
+	t.notifyLocked(ConnectionAdded, conn)
 	return id, nil
 }
 
-// GetConnection retrieves a connection by ID
-func (t *BaseConnectionTracker) GetConnection(connID string) (sourceAddr net.Addr, targetAddr net.Addr, connType ConnectionType, err error) {
+// GetConnection returns a snapshot of a connection
+func (t *BaseConnectionTracker) GetConnection(connID string) (*Connection, error) {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 
-	conn, exists := t.connections[connID]
-	if !exists {
-		return nil, nil, "", errors.New("connection not found")
+	conn, err := t.lookupLocked(connID)
+	if err != nil {
+		return nil, err
+	}
+	return conn.snapshot(), nil
+}
+
+// UpdateConnectionState moves a connection to a new state, recording the
+// transition. Transitions not allowed from the current state are rejected.
+func (t *BaseConnectionTracker) UpdateConnectionState(connID string, state ConnectionState) error {
+	if !state.Valid() {
+		return fmt.Errorf("%w: %q", ErrInvalidState, state)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conn, err := t.lookupLocked(connID)
+	if err != nil {
+		return err
+	}
+
+	if !conn.State.CanTransitionTo(state) {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidTransition, conn.State, state)
 	}
 
-	return conn.SourceAddr, conn.TargetAddr, conn.Type, nil
+	now := time.Now()
+	conn.History = append(conn.History, StateTransition{From: conn.State, To: state, At: now})
+	conn.State = state
+	t.touchLocked(conn, now)
+
+	t.notifyLocked(ConnectionStateChanged, conn)
+	return nil
 }
 
-// UpdateConnectionState updates the state of a connection
-func (t *BaseConnectionTracker) UpdateConnectionState(connID string, state string) error {
+// SetMetadata sets a metadata value on a connection. A nil value deletes the key.
+func (t *BaseConnectionTracker) SetMetadata(connID, key string, value interface{}) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	conn, exists := t.connections[connID]
-	if !exists {
-		return errors.New("connection not found")
+	conn, err := t.lookupLocked(connID)
+	if err != nil {
+		return err
 	}
 
-	conn.State = ConnectionState(state)
-	conn.LastUpdatedAt = time.Now()
+	if value == nil {
+		delete(conn.Metadata, key)
+	} else {
+		conn.Metadata[key] = value
+	}
+	t.touchLocked(conn, time.Now())
 
+	t.notifyLocked(ConnectionMetadataChanged, conn)
 	return nil
 }
 
+// GetMetadata returns a metadata value and whether it is set
+func (t *BaseConnectionTracker) GetMetadata(connID, key string) (interface{}, bool, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	conn, err := t.lookupLocked(connID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	value, exists := conn.Metadata[key]
+	return value, exists, nil
+}
+
+// Counters returns the live traffic counters of a connection, which the
+// connection's send and receive paths update directly
+func (t *BaseConnectionTracker) Counters(connID string) (*stats.Counters, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	conn, err := t.lookupLocked(connID)
+	if err != nil {
+		return nil, err
+	}
+	return conn.counters, nil
+}
+
+// GetStats returns a snapshot of a connection's traffic counters
+func (t *BaseConnectionTracker) GetStats(connID string) (stats.Snapshot, error) {
+	counters, err := t.Counters(connID)
+	if err != nil {
+		return stats.Snapshot{}, err
+	}
+	return counters.Snapshot(), nil
+}
+
 // RemoveConnection removes a tracked connection
 func (t *BaseConnectionTracker) RemoveConnection(connID string) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 
-	if _, exists := t.connections[connID]; !exists {
-		return errors.New("connection not found")
+	conn, exists := t.connections[connID]
+	if !exists {
+		return ErrConnectionNotFound
 	}
 
 	delete(t.connections, connID)
+	t.notifyLocked(ConnectionRemoved, conn)
 	return nil
 }
 
@@ -119,10 +350,143 @@ func (t *BaseConnectionTracker) ListConnections() ([]string, error) {
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 
+	now := time.Now()
 	ids := make([]string, 0, len(t.connections))
-	for id := range t.connections {
-		ids = append(ids, id)
+	for id, conn := range t.connections {
+		if !conn.expired(now) {
+			ids = append(ids, id)
+		}
 	}
 
 	return ids, nil
 }
+
+// FindConnections returns snapshots of the connections matching a filter
+func (t *BaseConnectionTracker) FindConnections(filter ConnectionFilter) ([]*Connection, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	now := time.Now()
+	var matches []*Connection
+	for _, conn := range t.connections {
+		if !conn.expired(now) && filter.Matches(conn) {
+			matches = append(matches, conn.snapshot())
+		}
+	}
+
+	return matches, nil
+}
+
+// Watch returns a channel of changes to tracked connections and a function
+// that stops watching. Changes are dropped while the channel is full, so a
+// slow watcher never blocks the tracker.
+func (t *BaseConnectionTracker) Watch() (<-chan ConnectionChange, func()) {
+	changes := make(chan ConnectionChange, watcherBufferSize)
+
+	t.mu.Lock()
+	t.watchers[changes] = struct{}{}
+	t.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			t.mu.Lock()
+			delete(t.watchers, changes)
+			t.mu.Unlock()
+			close(changes)
+		})
+	}
+
+	return changes, cancel
+}
+
+// ExpireConnections removes connections that outlived their TTL and
+// returns how many were removed
+func (t *BaseConnectionTracker) ExpireConnections() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	count := 0
+	for id, conn := range t.connections {
+		if conn.expired(now) {
+			delete(t.connections, id)
+			t.notifyLocked(ConnectionExpired, conn)
+			count++
+		}
+	}
+
+	return count
+}
+
+// StartExpiry removes expired connections every interval until Stop is called
+func (t *BaseConnectionTracker) StartExpiry(interval time.Duration) {
+	t.mu.Lock()
+	if t.stopExpiry != nil {
+		t.mu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	t.stopExpiry = stop
+	t.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				t.ExpireConnections()
+			}
+		}
+	}()
+}
+
+// Stop halts background expiry
+func (t *BaseConnectionTracker) Stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.stopExpiry != nil {
+		close(t.stopExpiry)
+		t.stopExpiry = nil
+	}
+}
+
+// lookupLocked finds a live connection. Expired connections count as
+// missing even before they are swept.
+func (t *BaseConnectionTracker) lookupLocked(connID string) (*Connection, error) {
+	conn, exists := t.connections[connID]
+	if !exists || conn.expired(time.Now()) {
+		return nil, ErrConnectionNotFound
+	}
+	return conn, nil
+}
+
+// touchLocked records an update and pushes back the expiry
+func (t *BaseConnectionTracker) touchLocked(conn *Connection, now time.Time) {
+	conn.LastUpdatedAt = now
+	if t.ttl > 0 {
+		conn.ExpiresAt = now.Add(t.ttl)
+	} else {
+		conn.ExpiresAt = time.Time{}
+	}
+}
+
+// notifyLocked sends a change to every watcher without blocking
+func (t *BaseConnectionTracker) notifyLocked(eventType ConnectionEventType, conn *Connection) {
+	if len(t.watchers) == 0 {
+		return
+	}
+
+	change := ConnectionChange{Type: eventType, Connection: conn.snapshot()}
+	for watcher := range t.watchers {
+		select {
+		case watcher <- change:
+		default:
+		}
+	}
+}
@@ -28,10 +28,10 @@ func TestConnectionState(t *testing.T) {
 	}
 
 	for _, state := range states {
-		err := tracker.UpdateConnectionState(connID, string(state))
+		err := tracker.UpdateConnectionState(connID, state)
 		assert.NoError(t, err)
 
-		_, _, _, err = tracker.GetConnection(connID)
+		_, err = tracker.GetConnection(connID)
 		assert.NoError(t, err)
 	}
 }
@@ -61,7 +61,7 @@ func TestConcurrentAccess(t *testing.T) {
 			}
 
 			// Update state
-			err = tracker.UpdateConnectionState(connID, string(ConnectionStateEstablished))
+			err = tracker.UpdateConnectionState(connID, ConnectionStateEstablished)
 			if err != nil {
 				t.Error("Error updating state:", err)
 				done <- true
@@ -69,7 +69,7 @@ func TestConcurrentAccess(t *testing.T) {
 			}
 
 			// Get connection
-			_, _, _, err = tracker.GetConnection(connID)
+			_, err = tracker.GetConnection(connID)
 			if err != nil {
 				t.Error("Error getting connection:", err)
 				done <- true
@@ -127,7 +127,7 @@ func TestConnectionTrackerTimeouts(t *testing.T) {
 
 	// Update state and check that LastUpdatedAt changes
 	beforeUpdate := conn.LastUpdatedAt
-	err = tracker.UpdateConnectionState(connID, string(ConnectionStateEstablished))
+	err = tracker.UpdateConnectionState(connID, ConnectionStateEstablished)
 	assert.NoError(t, err)
 
 	// Get the updated connection
@@ -138,3 +138,192 @@ func TestConnectionTrackerTimeouts(t *testing.T) {
 	assert.Equal(t, beforeUpdate, conn.CreatedAt)
 	assert.True(t, conn.LastUpdatedAt.After(beforeUpdate))
 }
+
+// TestConnectionStateTransitions tests transition validation and history
+func TestConnectionStateTransitions(t *testing.T) {
+	tracker := NewBaseConnectionTracker()
+	sourceAddr := &net.UDPAddr{IP: net.ParseIP("192.168.1.2"), Port: 12345}
+	targetAddr := &net.UDPAddr{IP: net.ParseIP("192.168.1.3"), Port: 54321}
+
+	connID, err := tracker.AddConnection(sourceAddr, targetAddr, UDP)
+	assert.NoError(t, err)
+
+	// Unknown states are rejected
+	err = tracker.UpdateConnectionState(connID, ConnectionState("bogus"))
+	assert.ErrorIs(t, err, ErrInvalidState)
+
+	// A failed attempt may be retried
+	assert.NoError(t, tracker.UpdateConnectionState(connID, ConnectionStateInitiating))
+	assert.NoError(t, tracker.UpdateConnectionState(connID, ConnectionStateFailed))
+	assert.NoError(t, tracker.UpdateConnectionState(connID, ConnectionStateInitiating))
+	assert.NoError(t, tracker.UpdateConnectionState(connID, ConnectionStateEstablished))
+
+	// An established connection can't go back to initiating
+	err = tracker.UpdateConnectionState(connID, ConnectionStateInitiating)
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+
+	// Closed is final
+	assert.NoError(t, tracker.UpdateConnectionState(connID, ConnectionStateClosed))
+	err = tracker.UpdateConnectionState(connID, ConnectionStateEstablished)
+	assert.ErrorIs(t, err, ErrInvalidTransition)
+
+	conn, err := tracker.GetConnection(connID)
+	assert.NoError(t, err)
+	assert.Equal(t, ConnectionStateClosed, conn.State)
+	assert.Len(t, conn.History, 5)
+	assert.Equal(t, ConnectionStateNew, conn.History[0].From)
+	assert.Equal(t, ConnectionStateInitiating, conn.History[0].To)
+	assert.Equal(t, ConnectionStateClosed, conn.History[4].To)
+
+	// Snapshots don't change with the tracker
+	conn.History[0].To = ConnectionStateFailed
+	again, _ := tracker.GetConnection(connID)
+	assert.Equal(t, ConnectionStateInitiating, again.History[0].To)
+}
+
+// TestConnectionMetadata tests metadata access through the tracker
+func TestConnectionMetadata(t *testing.T) {
+	tracker := NewBaseConnectionTracker()
+	connID, err := tracker.AddConnection(&net.UDPAddr{Port: 1}, &net.UDPAddr{Port: 2}, UDP)
+	assert.NoError(t, err)
+
+	assert.NoError(t, tracker.SetMetadata(connID, "peer_id", "alice"))
+
+	value, exists, err := tracker.GetMetadata(connID, "peer_id")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Equal(t, "alice", value)
+
+	// Snapshots carry metadata but changing them doesn't affect the tracker
+	conn, _ := tracker.GetConnection(connID)
+	conn.Metadata["peer_id"] = "mallory"
+	value, _, _ = tracker.GetMetadata(connID, "peer_id")
+	assert.Equal(t, "alice", value)
+
+	// Setting nil deletes the key
+	assert.NoError(t, tracker.SetMetadata(connID, "peer_id", nil))
+	_, exists, err = tracker.GetMetadata(connID, "peer_id")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	_, _, err = tracker.GetMetadata("invalid-id", "peer_id")
+	assert.Equal(t, ErrConnectionNotFound, err)
+}
+
+// TestFindConnections tests filtered queries
+func TestFindConnections(t *testing.T) {
+	tracker := NewBaseConnectionTracker()
+	peer := &net.UDPAddr{IP: net.ParseIP("192.168.1.3"), Port: 54321}
+
+	udpID, _ := tracker.AddConnection(&net.UDPAddr{IP: net.ParseIP("192.168.1.2"), Port: 1}, peer, UDP)
+	tcpID, _ := tracker.AddConnection(&net.TCPAddr{IP: net.ParseIP("192.168.1.4"), Port: 2}, &net.TCPAddr{IP: net.ParseIP("192.168.1.5"), Port: 3}, TCP)
+	tracker.UpdateConnectionState(tcpID, ConnectionStateEstablished)
+	tracker.SetMetadata(tcpID, "strategy", "tcp-simultaneous-open")
+
+	matches, err := tracker.FindConnections(ConnectionFilter{Type: UDP})
+	assert.NoError(t, err)
+	assert.Len(t, matches, 1)
+	assert.Equal(t, udpID, matches[0].ID)
+
+	matches, _ = tracker.FindConnections(ConnectionFilter{States: []ConnectionState{ConnectionStateEstablished, ConnectionStateFailed}})
+	assert.Len(t, matches, 1)
+	assert.Equal(t, tcpID, matches[0].ID)
+
+	matches, _ = tracker.FindConnections(ConnectionFilter{Addr: peer})
+	assert.Len(t, matches, 1)
+	assert.Equal(t, udpID, matches[0].ID)
+
+	matches, _ = tracker.FindConnections(ConnectionFilter{MetadataKey: "strategy", MetadataValue: "tcp-simultaneous-open"})
+	assert.Len(t, matches, 1)
+
+	matches, _ = tracker.FindConnections(ConnectionFilter{MetadataKey: "strategy", MetadataValue: "udp-hole-punching"})
+	assert.Empty(t, matches)
+
+	matches, _ = tracker.FindConnections(ConnectionFilter{})
+	assert.Len(t, matches, 2)
+}
+
+// TestConnectionExpiry tests TTL based expiry
+func TestConnectionExpiry(t *testing.T) {
+	tracker := NewBaseConnectionTracker()
+	tracker.SetTTL(50 * time.Millisecond)
+
+	changes, stop := tracker.Watch()
+	defer stop()
+
+	connID, err := tracker.AddConnection(&net.UDPAddr{Port: 1}, &net.UDPAddr{Port: 2}, UDP)
+	assert.NoError(t, err)
+
+	conn, _ := tracker.GetConnection(connID)
+	assert.False(t, conn.ExpiresAt.IsZero())
+
+	time.Sleep(80 * time.Millisecond)
+
+	// Expired connections disappear from lookups before they are swept
+	_, err = tracker.GetConnection(connID)
+	assert.Equal(t, ErrConnectionNotFound, err)
+	ids, _ := tracker.ListConnections()
+	assert.Empty(t, ids)
+
+	assert.Equal(t, 1, tracker.ExpireConnections())
+	assert.Equal(t, 0, tracker.ExpireConnections())
+
+	assert.Equal(t, ConnectionAdded, (<-changes).Type)
+	assert.Equal(t, ConnectionExpired, (<-changes).Type)
+}
+
+// TestConnectionWatch tests change notifications
+func TestConnectionWatch(t *testing.T) {
+	tracker := NewBaseConnectionTracker()
+	changes, stop := tracker.Watch()
+
+	connID, _ := tracker.AddConnection(&net.UDPAddr{Port: 1}, &net.UDPAddr{Port: 2}, UDP)
+	tracker.UpdateConnectionState(connID, ConnectionStateInitiating)
+	tracker.SetMetadata(connID, "rtt", 12)
+	tracker.RemoveConnection(connID)
+
+	expected := []ConnectionEventType{ConnectionAdded, ConnectionStateChanged, ConnectionMetadataChanged, ConnectionRemoved}
+	for _, eventType := range expected {
+		change := <-changes
+		assert.Equal(t, eventType, change.Type)
+		assert.Equal(t, connID, change.Connection.ID)
+	}
+
+	// Stopping closes the channel and further changes are not delivered
+	stop()
+	_, open := <-changes
+	assert.False(t, open)
+	_, err := tracker.AddConnection(&net.UDPAddr{Port: 1}, &net.UDPAddr{Port: 2}, UDP)
+	assert.NoError(t, err)
+}
+
+// TestConnectionStats tests traffic counters on tracked connections
+func TestConnectionStats(t *testing.T) {
+	tracker := NewBaseConnectionTracker()
+
+	connID, err := tracker.AddConnection(nil, nil, UDP)
+	assert.NoError(t, err)
+
+	counters, err := tracker.Counters(connID)
+	assert.NoError(t, err)
+	counters.RecordSent(100)
+	counters.RecordReceived(40)
+	counters.RecordRTT(20 * time.Millisecond)
+
+	snapshot, err := tracker.GetStats(connID)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(100), snapshot.BytesOut)
+	assert.Equal(t, uint64(40), snapshot.BytesIn)
+	assert.Equal(t, 20*time.Millisecond, snapshot.SmoothedRTT)
+
+	// Connection snapshots carry the counters as of the snapshot
+	conn, err := tracker.GetConnection(connID)
+	assert.NoError(t, err)
+	assert.Equal(t, snapshot, conn.Stats)
+
+	counters.RecordSent(10)
+	assert.Equal(t, uint64(100), conn.Stats.BytesOut)
+
+	_, err = tracker.GetStats("missing")
+	assert.Equal(t, ErrConnectionNotFound, err)
+}
@@ -19,6 +19,7 @@ type STUNConfig struct {
 	Server         string
 	TimeoutSeconds int // Changed from Timeout to TimeoutSeconds
 	RetryCount     int
+	Network        string // "udp4", "udp6" or "udp" (either) when empty
 }
 
 // Default settings for STUN discovery
@@ -37,7 +38,12 @@ func DiscoverPublicAddress(stunServer string) (*PublicAddress, error) {
 
 // DiscoverPublicAddressWithConfig discovers the public IP and port using a STUN server with custom config
 func DiscoverPublicAddressWithConfig(config STUNConfig) (*PublicAddress, error) {
-	c, err := stun.Dial("udp", config.Server)
+	network := config.Network
+	if network == "" {
+		network = "udp"
+	}
+
+	c, err := stun.Dial(network, config.Server)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to STUN server: %w", err)
 	}
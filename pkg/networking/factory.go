@@ -3,6 +3,9 @@ package networking
 import (
 	"fmt"
 	"sync"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/internal/nat"
 )
 
 // BaseNetworkFactory implements the NetworkFactory interface
@@ -12,12 +15,59 @@ type BaseNetworkFactory struct {
 	mu              sync.RWMutex
 }
 
-// NewNetworkFactory creates a new BaseNetworkFactory
+// NewNetworkFactory creates a new BaseNetworkFactory with the UDP and TCP
+// handlers registered
 func NewNetworkFactory() *BaseNetworkFactory {
-	return &BaseNetworkFactory{
+	factory := &BaseNetworkFactory{
 		handlers:        make(map[ConnectionType]func(map[string]interface{}) (NetworkHandler, error)),
 		punchStrategies: make(map[ConnectionType][]NATPunchStrategy),
 	}
+
+	factory.RegisterHandler(UDP, NewUDPHandler)
+	factory.RegisterHandler(TCP, NewTCPHandler)
+
+	return factory
+}
+
+// NewDefaultNetworkFactory creates a factory with the built-in handlers and
+// NAT traversal strategies registered
+func NewDefaultNetworkFactory() *BaseNetworkFactory {
+	factory := NewNetworkFactory()
+	factory.RegisterDefaultStrategies()
+	return factory
+}
+
+// RegisterDefaultStrategies registers the built-in NAT traversal strategies,
+// each under the connection type it produces
+func (f *BaseNetworkFactory) RegisterDefaultStrategies() {
+	f.RegisterStrategies(nil)
+}
+
+// RegisterStrategies registers the given built-in NAT traversal strategies,
+// or all of them when none are given. Configured priorities replace the
+// default ones.
+func (f *BaseNetworkFactory) RegisterStrategies(enabled []StrategyConfig) error {
+	strategies, err := strategyFactory(enabled)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range defaultStrategyPriorities {
+		strategyType := nat.StrategyType(entry.strategyType)
+		strategy, err := strategies.GetStrategyByType(strategyType)
+		if err != nil {
+			continue
+		}
+
+		priority := entry.priority
+		if configured, ok := strategies.Priority(strategyType); ok {
+			priority = configured
+		}
+
+		adapter := newTraversalAdapter(strategy, priority)
+		f.RegisterNATPunchStrategy(adapter.ConnectionType(), adapter)
+	}
+	return nil
 }
 
 // RegisterHandler registers a handler constructor for a specific connection type
@@ -56,10 +106,22 @@ func (f *BaseNetworkFactory) CreateHandler(connType ConnectionType, config map[s
 	return constructor(config)
 }
 
-// CreateTracker creates a new ConnectionTracker
+// CreateTracker creates a new ConnectionTracker. A "ttl_seconds" setting
+// expires connections that go that long without an update.
 func (f *BaseNetworkFactory) CreateTracker(config map[string]interface{}) (ConnectionTracker, error) {
 	// We use the base tracker implementation for all connection types
-	return NewBaseConnectionTracker(), nil
+	tracker := NewBaseConnectionTracker()
+
+	if _, set := config["ttl_seconds"]; set {
+		ttl, err := configInt(config, "ttl_seconds", 0)
+		if err != nil {
+			return nil, err
+		}
+		tracker.SetTTL(time.Duration(ttl) * time.Second)
+		tracker.StartExpiry(time.Duration(ttl) * time.Second / 2)
+	}
+
+	return tracker, nil
 }
 
 // GetNATPunchStrategy returns the appropriate NAT punch strategy
@@ -0,0 +1,174 @@
+package networking
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// receiveOne registers a callback that forwards packets to a channel
+func receiveOne(handler NetworkHandler) chan *Packet {
+	received := make(chan *Packet, 1)
+	handler.RegisterReceiveCallback(func(packet *Packet) error {
+		received <- packet
+		return nil
+	})
+	return received
+}
+
+func waitPacket(t *testing.T, received chan *Packet) *Packet {
+	select {
+	case packet := <-received:
+		return packet
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for packet")
+		return nil
+	}
+}
+
+func TestUDPHandler(t *testing.T) {
+	factory := NewNetworkFactory()
+
+	server, err := factory.CreateHandler(UDP, map[string]interface{}{"address": "127.0.0.1:12370"})
+	assert.NoError(t, err)
+	client, err := factory.CreateHandler(UDP, map[string]interface{}{"address": "127.0.0.1:12371"})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assert.NoError(t, server.Start(ctx))
+	assert.NoError(t, client.Start(ctx))
+	assert.Equal(t, ErrHandlerStarted, server.Start(ctx))
+
+	received := receiveOne(server)
+	replies := receiveOne(client)
+
+	err = client.Send(&Packet{Data: []byte("ping"), TargetAddr: server.GetListeningAddresses()[0]})
+	assert.NoError(t, err)
+
+	packet := waitPacket(t, received)
+	assert.Equal(t, "ping", string(packet.Data))
+	assert.Equal(t, UDP, packet.ConnType)
+	assert.Equal(t, "127.0.0.1:12371", packet.SourceAddr.String())
+
+	// Reply to the sender
+	assert.NoError(t, server.Send(&Packet{Data: []byte("pong"), TargetAddr: packet.SourceAddr}))
+	assert.Equal(t, "pong", string(waitPacket(t, replies).Data))
+
+	assert.NoError(t, server.Stop())
+	assert.Empty(t, server.GetListeningAddresses())
+	assert.Equal(t, ErrHandlerNotStarted, server.Send(&Packet{Data: []byte("x"), TargetAddr: packet.SourceAddr}))
+	assert.NoError(t, client.Stop())
+}
+
+func TestTCPHandler(t *testing.T) {
+	factory := NewNetworkFactory()
+
+	server, err := factory.CreateHandler(TCP, map[string]interface{}{"address": "127.0.0.1:8780"})
+	assert.NoError(t, err)
+	client, err := factory.CreateHandler(TCP, map[string]interface{}{"address": "127.0.0.1:8781"})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	assert.NoError(t, server.Start(ctx))
+	assert.NoError(t, client.Start(ctx))
+
+	received := receiveOne(server)
+	replies := receiveOne(client)
+
+	// Frames sent back to back arrive separately and in order
+	target := server.GetListeningAddresses()[0]
+	assert.NoError(t, client.Send(&Packet{Data: []byte("first"), TargetAddr: target}))
+	assert.NoError(t, client.Send(&Packet{Data: []byte("second"), TargetAddr: target}))
+
+	packet := waitPacket(t, received)
+	assert.Equal(t, "first", string(packet.Data))
+	assert.Equal(t, TCP, packet.ConnType)
+	assert.Equal(t, "second", string(waitPacket(t, received).Data))
+
+	// Replies reuse the accepted stream
+	assert.NoError(t, server.Send(&Packet{Data: []byte("reply"), TargetAddr: packet.SourceAddr}))
+	assert.Equal(t, "reply", string(waitPacket(t, replies).Data))
+
+	// Oversized frames are refused
+	limited, err := NewTCPHandler(map[string]interface{}{"max_frame_size": 4})
+	assert.NoError(t, err)
+	assert.Equal(t, ErrFrameTooLarge, limited.Send(&Packet{Data: []byte("too long"), TargetAddr: target}))
+
+	// Cancelling the context stops the handlers
+	cancel()
+	assert.Eventually(t, func() bool {
+		return len(server.GetListeningAddresses()) == 0 && len(client.GetListeningAddresses()) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestHandlerConfigValidation(t *testing.T) {
+	_, err := NewUDPHandler(map[string]interface{}{"address": 1234})
+	assert.Error(t, err)
+
+	_, err = NewTCPHandler(map[string]interface{}{"max_frame_size": -1})
+	assert.Error(t, err)
+
+	handler, err := NewUDPHandler(nil)
+	assert.NoError(t, err)
+	assert.Equal(t, ErrHandlerNotStarted, handler.Stop())
+}
+
+func TestTraversalAdapters(t *testing.T) {
+	factory := NewDefaultNetworkFactory()
+
+	// Friendly NATs use a direct strategy for each connection type
+	strategy := factory.GetNATPunchStrategy(UDP, "FullCone", "PortRestrictedCone")
+	assert.NotNil(t, strategy)
+	assert.Equal(t, "UDP Hole Punching", strategy.GetName())
+
+	strategy = factory.GetNATPunchStrategy(TCP, "full-cone", "full-cone")
+	assert.NotNil(t, strategy)
+	assert.Equal(t, "TCP Simultaneous Open", strategy.GetName())
+
+	// Hole punching declines symmetric NATs
+	strategy = factory.GetNATPunchStrategy(UDP, "symmetric", "symmetric")
+	assert.NotNil(t, strategy)
+	assert.NotEqual(t, "UDP Hole Punching", strategy.GetName())
+
+	// A strategy refuses to punch for another connection type
+	adapter := strategy.(*traversalAdapter)
+	err := adapter.InitiatePunch(context.Background(), nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}, TCP)
+	assert.Equal(t, ErrProtocolMismatch, err)
+}
+
+func TestRegisterStrategies(t *testing.T) {
+	factory := NewNetworkFactory()
+	err := factory.RegisterStrategies([]StrategyConfig{
+		{Type: TCPSimultaneousOpen},
+		{Type: UDPRelaying, Priority: 200},
+	})
+	assert.NoError(t, err)
+
+	// Only the listed strategies are registered, with configured priorities
+	strategy := factory.GetNATPunchStrategy(UDP, "full-cone", "full-cone")
+	if assert.NotNil(t, strategy) {
+		assert.Equal(t, "UDP Relaying", strategy.GetName())
+		assert.Equal(t, 200, strategy.GetPriority())
+	}
+	strategy = factory.GetNATPunchStrategy(TCP, "full-cone", "full-cone")
+	if assert.NotNil(t, strategy) {
+		assert.Equal(t, "TCP Simultaneous Open", strategy.GetName())
+	}
+
+	// Every built-in type names a strategy
+	for _, strategyType := range []StrategyType{UDPHolePunching, TCPSimultaneousOpen, UDPRelaying, TCPRelaying, PortMapping} {
+		factory := NewNetworkFactory()
+		assert.NoError(t, factory.RegisterStrategies([]StrategyConfig{{Type: strategyType}}))
+		assert.Equal(t, 1, len(factory.punchStrategies[UDP])+len(factory.punchStrategies[TCP]), strategyType)
+	}
+
+	err = NewNetworkFactory().RegisterStrategies([]StrategyConfig{{Type: "carrier-pigeon"}})
+	assert.ErrorIs(t, err, ErrUnknownStrategy)
+}
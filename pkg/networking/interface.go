@@ -1,8 +1,13 @@
+// Package networking is the public API for NAT traversal. It provides UDP and
+// TCP network handlers, connection tracking, STUN discovery and the built-in
+// traversal strategies behind the NATPunchStrategy interface.
 package networking
 
 import (
 	"context"
 	"net"
+
+	"github.com/bOguzhan/NATbypass/pkg/stats"
 )
 
 // ConnectionType represents the type of connection (TCP or UDP)
@@ -50,17 +55,36 @@ type ConnectionTracker interface {
 	// AddConnection registers a new connection
 	AddConnection(sourceAddr net.Addr, targetAddr net.Addr, connType ConnectionType) (string, error)
 
-	// GetConnection retrieves a connection by ID
-	GetConnection(connID string) (sourceAddr net.Addr, targetAddr net.Addr, connType ConnectionType, err error)
+	// GetConnection returns a snapshot of a connection by ID
+	GetConnection(connID string) (*Connection, error)
+
+	// UpdateConnectionState moves a connection to a new state if the
+	// transition is allowed
+	UpdateConnectionState(connID string, state ConnectionState) error
+
+	// SetMetadata sets a metadata value; a nil value deletes the key
+	SetMetadata(connID, key string, value interface{}) error
+
+	// GetMetadata returns a metadata value and whether it is set
+	GetMetadata(connID, key string) (interface{}, bool, error)
 
-	// UpdateConnectionState updates the state of a connection
-	UpdateConnectionState(connID string, state string) error
+	// Counters returns the live traffic counters of a connection
+	Counters(connID string) (*stats.Counters, error)
+
+	// GetStats returns a snapshot of a connection's traffic counters
+	GetStats(connID string) (stats.Snapshot, error)
 
 	// RemoveConnection removes a tracked connection
 	RemoveConnection(connID string) error
 
 	// ListConnections returns all active connections
 	ListConnections() ([]string, error)
+
+	// FindConnections returns snapshots of connections matching a filter
+	FindConnections(filter ConnectionFilter) ([]*Connection, error)
+
+	// Watch returns a channel of connection changes and a function to stop watching
+	Watch() (<-chan ConnectionChange, func())
 }
 
 // NATPunchStrategy defines the interface for NAT traversal strategies
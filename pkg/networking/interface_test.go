@@ -73,14 +73,15 @@ func TestConnectionTrackerBasicOperations(t *testing.T) {
 	assert.NotEmpty(t, connID)
 
 	// Test getting a connection
-	retrievedSource, retrievedTarget, retrievedType, err := tracker.GetConnection(connID)
+	conn, err := tracker.GetConnection(connID)
 	assert.NoError(t, err)
-	assert.Equal(t, sourceAddr, retrievedSource)
-	assert.Equal(t, targetAddr, retrievedTarget)
-	assert.Equal(t, UDP, retrievedType)
+	assert.Equal(t, sourceAddr, conn.SourceAddr)
+	assert.Equal(t, targetAddr, conn.TargetAddr)
+	assert.Equal(t, UDP, conn.Type)
+	assert.Equal(t, ConnectionStateNew, conn.State)
 
 	// Test updating connection state
-	err = tracker.UpdateConnectionState(connID, string(ConnectionStateEstablished))
+	err = tracker.UpdateConnectionState(connID, ConnectionStateEstablished)
 	assert.NoError(t, err)
 
 	// Test listing connections
@@ -94,11 +95,11 @@ func TestConnectionTrackerBasicOperations(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Verify connection is removed
-	_, _, _, err = tracker.GetConnection(connID)
-	assert.Error(t, err)
+	_, err = tracker.GetConnection(connID)
+	assert.Equal(t, ErrConnectionNotFound, err)
 
 	// Test operations on non-existent connections
-	err = tracker.UpdateConnectionState("invalid-id", string(ConnectionStateEstablished))
+	err = tracker.UpdateConnectionState("invalid-id", ConnectionStateEstablished)
 	assert.Error(t, err)
 
 	err = tracker.RemoveConnection("invalid-id")
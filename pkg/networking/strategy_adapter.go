@@ -0,0 +1,153 @@
+// pkg/networking/strategy_adapter.go
+package networking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/bOguzhan/NATbypass/internal/config"
+	"github.com/bOguzhan/NATbypass/internal/discovery"
+	"github.com/bOguzhan/NATbypass/internal/nat"
+)
+
+// StrategyType identifies a built-in NAT traversal strategy
+type StrategyType string
+
+// Built-in traversal strategies
+const (
+	UDPHolePunching     StrategyType = "udp-hole-punching"
+	TCPSimultaneousOpen StrategyType = "tcp-simultaneous-open"
+	UDPRelaying         StrategyType = "udp-relaying"
+	TCPRelaying         StrategyType = "tcp-relaying"
+	PortMapping         StrategyType = "port-mapping"
+)
+
+// StrategyConfig enables a built-in traversal strategy
+type StrategyConfig struct {
+	Type     StrategyType
+	Priority int // Higher is preferred, 0 keeps the default priority
+}
+
+// MinSuccessRate is the estimated success rate below which an adapted
+// strategy declines a NAT combination
+const MinSuccessRate = 0.5
+
+// ErrProtocolMismatch is returned when a strategy is asked to punch for a
+// connection type it doesn't speak
+var ErrProtocolMismatch = errors.New("strategy does not support connection type")
+
+// ErrUnknownStrategy is returned for a strategy type that isn't built in
+var ErrUnknownStrategy = errors.New("unknown traversal strategy")
+
+// defaultStrategyPriorities orders the built-in strategies: direct
+// connections first, relays last
+var defaultStrategyPriorities = []struct {
+	strategyType StrategyType
+	priority     int
+}{
+	{UDPHolePunching, 100},
+	{PortMapping, 90},
+	{TCPSimultaneousOpen, 80},
+	{UDPRelaying, 20},
+	{TCPRelaying, 10},
+}
+
+// traversalAdapter exposes a NAT traversal strategy as a NATPunchStrategy
+type traversalAdapter struct {
+	strategy nat.TraversalStrategy
+	priority int
+}
+
+// newTraversalAdapter adapts a traversal strategy with the given priority
+func newTraversalAdapter(strategy nat.TraversalStrategy, priority int) *traversalAdapter {
+	return &traversalAdapter{strategy: strategy, priority: priority}
+}
+
+// ConnectionType returns the connection type the strategy produces
+func (a *traversalAdapter) ConnectionType() ConnectionType {
+	return ConnectionType(a.strategy.GetProtocol())
+}
+
+// CanHandle returns true if the strategy's estimated success rate for the
+// NAT types reaches MinSuccessRate. NAT types are matched loosely, so
+// "port-restricted-cone" and "PortRestrictedCone" are the same.
+func (a *traversalAdapter) CanHandle(sourceNATType, targetNATType string) bool {
+	rate := a.strategy.EstimateSuccessRate(discovery.ParseNATType(sourceNATType), discovery.ParseNATType(targetNATType))
+	return rate >= MinSuccessRate
+}
+
+// InitiatePunch opens a path to the target and releases it again, leaving
+// the NAT mappings in place for a following connection. Use Connect to keep
+// the connection.
+func (a *traversalAdapter) InitiatePunch(ctx context.Context, sourceAddr, targetAddr net.Addr, connType ConnectionType) error {
+	if connType != a.ConnectionType() {
+		return ErrProtocolMismatch
+	}
+
+	conn, err := a.Connect(ctx, sourceAddr, targetAddr)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// Connect establishes a connection to the target using the strategy
+func (a *traversalAdapter) Connect(ctx context.Context, sourceAddr, targetAddr net.Addr) (net.Conn, error) {
+	var local *net.UDPAddr
+	if sourceAddr != nil {
+		addr, err := toUDPAddr(sourceAddr)
+		if err != nil {
+			return nil, err
+		}
+		local = addr
+	}
+
+	remote, err := toUDPAddr(targetAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.strategy.EstablishConnection(ctx, local, remote)
+}
+
+// GetName returns the name of the strategy
+func (a *traversalAdapter) GetName() string {
+	return a.strategy.GetName()
+}
+
+// GetPriority returns the priority of the strategy (higher is better)
+func (a *traversalAdapter) GetPriority() int {
+	return a.priority
+}
+
+// strategyFactory creates a traversal strategy factory with the given
+// strategies enabled, or every built-in strategy when none are given
+func strategyFactory(strategies []StrategyConfig) (*nat.StrategyFactory, error) {
+	if len(strategies) == 0 {
+		return nat.NewStrategyFactory(nil), nil
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Traversal.Strategies = config.StrategiesConfig{}
+	for _, strategy := range strategies {
+		var entry *config.StrategyConfig
+		switch strategy.Type {
+		case UDPHolePunching:
+			entry = &cfg.Traversal.Strategies.UDPHolePunching
+		case TCPSimultaneousOpen:
+			entry = &cfg.Traversal.Strategies.TCPSimultaneousOpen
+		case UDPRelaying:
+			entry = &cfg.Traversal.Strategies.UDPRelaying
+		case TCPRelaying:
+			entry = &cfg.Traversal.Strategies.TCPRelaying
+		case PortMapping:
+			entry = &cfg.Traversal.Strategies.PortMapping
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrUnknownStrategy, strategy.Type)
+		}
+		*entry = config.StrategyConfig{Enabled: true, Priority: strategy.Priority}
+	}
+	return nat.NewStrategyFactory(cfg), nil
+}
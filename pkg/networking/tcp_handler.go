@@ -0,0 +1,315 @@
+// pkg/networking/tcp_handler.go
+package networking
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// tcpFrameHeaderSize is the length prefix in front of each packet on a stream
+const tcpFrameHeaderSize = 4
+
+// ErrFrameTooLarge is returned when a TCP frame exceeds the handler's limit
+var ErrFrameTooLarge = errors.New("tcp frame too large")
+
+// TCPHandler is a NetworkHandler that carries packets over TCP streams as
+// length-prefixed frames. Accepted and dialed connections are kept open and
+// reused for later packets to the same address.
+type TCPHandler struct {
+	address      string
+	maxFrameSize int
+	dialTimeout  time.Duration
+
+	mu        sync.RWMutex
+	listener  net.Listener
+	peers     map[string]*tcpPeer
+	callbacks []func(*Packet) error
+	wg        sync.WaitGroup
+}
+
+// tcpPeer is an open stream to one remote address
+type tcpPeer struct {
+	conn    net.Conn
+	writeMu sync.Mutex
+}
+
+// NewTCPHandler creates a TCP handler from a configuration map. Recognised
+// keys are "address" (default ":0"), "max_frame_size" and
+// "dial_timeout_seconds".
+func NewTCPHandler(config map[string]interface{}) (NetworkHandler, error) {
+	handler := &TCPHandler{}
+	if err := handler.Initialize(config); err != nil {
+		return nil, err
+	}
+	return handler, nil
+}
+
+// Initialize sets up the handler with configuration
+func (h *TCPHandler) Initialize(config map[string]interface{}) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.listener != nil {
+		return ErrHandlerStarted
+	}
+
+	address, err := configString(config, "address", DefaultListenAddress)
+	if err != nil {
+		return err
+	}
+	maxFrameSize, err := configInt(config, "max_frame_size", DefaultMaxTCPFrameLen)
+	if err != nil {
+		return err
+	}
+	dialTimeout, err := configInt(config, "dial_timeout_seconds", 10)
+	if err != nil {
+		return err
+	}
+
+	h.address = address
+	h.maxFrameSize = maxFrameSize
+	h.dialTimeout = time.Duration(dialTimeout) * time.Second
+	return nil
+}
+
+// Start begins accepting connections. The handler stops when ctx is cancelled.
+func (h *TCPHandler) Start(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.listener != nil {
+		return ErrHandlerStarted
+	}
+
+	listener, err := net.Listen("tcp", h.address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on TCP: %w", err)
+	}
+	h.listener = listener
+	h.peers = make(map[string]*tcpPeer)
+
+	h.wg.Add(1)
+	go h.acceptLoop(listener)
+
+	go func() {
+		<-ctx.Done()
+		h.stopListener(listener)
+	}()
+
+	return nil
+}
+
+// Stop closes the listener and every open stream
+func (h *TCPHandler) Stop() error {
+	h.mu.RLock()
+	listener := h.listener
+	h.mu.RUnlock()
+
+	if listener == nil {
+		return ErrHandlerNotStarted
+	}
+	return h.stopListener(listener)
+}
+
+// stopListener shuts down if listener is still the handler's listener
+func (h *TCPHandler) stopListener(listener net.Listener) error {
+	h.mu.Lock()
+	if h.listener != listener {
+		h.mu.Unlock()
+		return nil
+	}
+	h.listener = nil
+	peers := h.peers
+	h.peers = nil
+	h.mu.Unlock()
+
+	err := listener.Close()
+	for _, peer := range peers {
+		peer.conn.Close()
+	}
+	h.wg.Wait()
+	return err
+}
+
+// Send writes a packet to its target, dialing a new stream if none is open
+func (h *TCPHandler) Send(packet *Packet) error {
+	if len(packet.Data) > h.maxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	peer, err := h.peerFor(packet.TargetAddr)
+	if err != nil {
+		return err
+	}
+
+	frame := make([]byte, tcpFrameHeaderSize+len(packet.Data))
+	binary.BigEndian.PutUint32(frame, uint32(len(packet.Data)))
+	copy(frame[tcpFrameHeaderSize:], packet.Data)
+
+	peer.writeMu.Lock()
+	_, err = peer.conn.Write(frame)
+	peer.writeMu.Unlock()
+
+	if err != nil {
+		h.removePeer(peer)
+		peer.conn.Close()
+	}
+	return err
+}
+
+// RegisterReceiveCallback registers a callback for received packets.
+// Callbacks for one stream run in order on that stream's read loop.
+func (h *TCPHandler) RegisterReceiveCallback(callback func(*Packet) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.callbacks = append(h.callbacks, callback)
+}
+
+// GetListeningAddresses returns the listener address
+func (h *TCPHandler) GetListeningAddresses() []net.Addr {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.listener == nil {
+		return nil
+	}
+	return []net.Addr{h.listener.Addr()}
+}
+
+// peerFor returns the open stream to an address, dialing one if needed
+func (h *TCPHandler) peerFor(addr net.Addr) (*tcpPeer, error) {
+	target, err := toTCPAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	key := target.String()
+
+	h.mu.RLock()
+	started := h.listener != nil
+	peer := h.peers[key]
+	h.mu.RUnlock()
+
+	if !started {
+		return nil, ErrHandlerNotStarted
+	}
+	if peer != nil {
+		return peer, nil
+	}
+
+	conn, err := net.DialTimeout("tcp", key, h.dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	if h.peers == nil {
+		h.mu.Unlock()
+		conn.Close()
+		return nil, ErrHandlerNotStarted
+	}
+	if existing := h.peers[key]; existing != nil {
+		// Another sender dialed first
+		h.mu.Unlock()
+		conn.Close()
+		return existing, nil
+	}
+	peer = &tcpPeer{conn: conn}
+	h.peers[key] = peer
+	h.wg.Add(1)
+	h.mu.Unlock()
+
+	go h.readLoop(peer)
+	return peer, nil
+}
+
+// acceptLoop accepts streams until the listener is closed
+func (h *TCPHandler) acceptLoop(listener net.Listener) {
+	defer h.wg.Done()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		peer := &tcpPeer{conn: conn}
+
+		h.mu.Lock()
+		if h.peers == nil {
+			h.mu.Unlock()
+			conn.Close()
+			return
+		}
+		h.peers[conn.RemoteAddr().String()] = peer
+		h.wg.Add(1)
+		h.mu.Unlock()
+
+		go h.readLoop(peer)
+	}
+}
+
+// readLoop delivers frames from a stream until it closes
+func (h *TCPHandler) readLoop(peer *tcpPeer) {
+	defer h.wg.Done()
+	defer func() {
+		h.removePeer(peer)
+		peer.conn.Close()
+	}()
+
+	header := make([]byte, tcpFrameHeaderSize)
+	for {
+		if _, err := io.ReadFull(peer.conn, header); err != nil {
+			return
+		}
+
+		length := int(binary.BigEndian.Uint32(header))
+		if length > h.maxFrameSize {
+			return
+		}
+
+		data := make([]byte, length)
+		if _, err := io.ReadFull(peer.conn, data); err != nil {
+			return
+		}
+
+		h.deliver(&Packet{
+			Data:       data,
+			SourceAddr: peer.conn.RemoteAddr(),
+			TargetAddr: peer.conn.LocalAddr(),
+			ConnType:   TCP,
+			Metadata:   make(map[string]interface{}),
+		})
+	}
+}
+
+// removePeer forgets a stream if it is still the one stored for its address
+func (h *TCPHandler) removePeer(peer *tcpPeer) {
+	key := peer.conn.RemoteAddr().String()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.peers[key] == peer {
+		delete(h.peers, key)
+	}
+}
+
+// deliver hands a packet to every callback. Callback errors only affect
+// that packet, so they are ignored.
+func (h *TCPHandler) deliver(packet *Packet) {
+	h.mu.RLock()
+	callbacks := h.callbacks
+	h.mu.RUnlock()
+
+	for _, callback := range callbacks {
+		_ = callback(packet)
+	}
+}
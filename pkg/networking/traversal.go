@@ -0,0 +1,119 @@
+// pkg/networking/traversal.go
+package networking
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+
+	"github.com/bOguzhan/NATbypass/internal/discovery"
+	"github.com/bOguzhan/NATbypass/internal/nat"
+)
+
+// NAT type names, as reported by STUN discovery and exchanged with peers
+const (
+	NATUnknown               = "unknown"
+	NATNone                  = "none"
+	NATFullCone              = "full-cone"
+	NATAddressRestrictedCone = "address-restricted-cone"
+	NATPortRestrictedCone    = "port-restricted-cone"
+	NATSymmetric             = "symmetric"
+)
+
+// Errors returned by Traversal
+var (
+	ErrNoCandidates     = errors.New("no candidate endpoints")
+	ErrStrategyDisabled = errors.New("traversal strategy not enabled")
+)
+
+// Traversal connects to peers with a configured set of the built-in NAT
+// traversal strategies
+type Traversal struct {
+	strategies *nat.StrategyFactory
+}
+
+// NewTraversal enables the given strategies, or every built-in strategy
+// when none are given
+func NewTraversal(strategies []StrategyConfig) (*Traversal, error) {
+	factory, err := strategyFactory(strategies)
+	if err != nil {
+		return nil, err
+	}
+	return &Traversal{strategies: factory}, nil
+}
+
+// Enabled reports whether a strategy is enabled
+func (t *Traversal) Enabled(strategyType StrategyType) bool {
+	_, err := t.strategies.GetStrategyByType(nat.StrategyType(strategyType))
+	return err == nil
+}
+
+// Rank returns the enabled strategies that may connect the two NAT types,
+// strategies with a configured priority first and the rest by estimated
+// success. NAT type names are matched loosely, as by CanHandle.
+func (t *Traversal) Rank(localNATType, remoteNATType string) []StrategyType {
+	localType := discovery.ParseNATType(localNATType)
+	remoteType := discovery.ParseNATType(remoteNATType)
+
+	type ranked struct {
+		strategyType StrategyType
+		priority     int
+		successRate  float64
+	}
+	var candidates []ranked
+	for _, entry := range defaultStrategyPriorities {
+		strategy, err := t.strategies.GetStrategyByType(nat.StrategyType(entry.strategyType))
+		if err != nil {
+			continue
+		}
+		successRate := strategy.EstimateSuccessRate(localType, remoteType)
+		if successRate <= 0 {
+			continue
+		}
+		priority, _ := t.strategies.Priority(nat.StrategyType(entry.strategyType))
+		candidates = append(candidates, ranked{entry.strategyType, priority, successRate})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].priority != candidates[j].priority {
+			return candidates[i].priority > candidates[j].priority
+		}
+		return candidates[i].successRate > candidates[j].successRate
+	})
+
+	ranking := make([]StrategyType, len(candidates))
+	for i, candidate := range candidates {
+		ranking[i] = candidate.strategyType
+	}
+	return ranking
+}
+
+// Name returns the display name of a strategy, e.g. "UDP Hole Punching"
+func (t *Traversal) Name(strategyType StrategyType) string {
+	strategy, err := t.strategies.GetStrategyByType(nat.StrategyType(strategyType))
+	if err != nil {
+		return string(strategyType)
+	}
+	return strategy.GetName()
+}
+
+// Connect connects to a peer with one strategy. Direct IPv6 candidates are
+// tried first and the rest are raced Happy Eyeballs style.
+func (t *Traversal) Connect(ctx context.Context, strategyType StrategyType, localAddr *net.UDPAddr, candidates []*net.UDPAddr) (net.Conn, error) {
+	strategy, err := t.strategies.GetStrategyByType(nat.StrategyType(strategyType))
+	if err != nil {
+		return nil, ErrStrategyDisabled
+	}
+	conn, err := t.strategies.EstablishConnection(ctx, strategy, localAddr, candidates)
+	if errors.Is(err, nat.ErrNoCandidates) {
+		return nil, ErrNoCandidates
+	}
+	return conn, err
+}
+
+// SortCandidates orders remote endpoints for connection attempts: global
+// IPv6 addresses first, then the rest alternating between address families
+func SortCandidates(candidates []*net.UDPAddr) []*net.UDPAddr {
+	return nat.SortCandidates(candidates)
+}
@@ -0,0 +1,73 @@
+package networking
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/internal/discovery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNATTypeNames(t *testing.T) {
+	// Peers exchange the names discovery reports
+	assert.Equal(t, string(discovery.NATUnknown), NATUnknown)
+	assert.Equal(t, string(discovery.NATNone), NATNone)
+	assert.Equal(t, string(discovery.NATFullCone), NATFullCone)
+	assert.Equal(t, string(discovery.NATAddressRestrictedCone), NATAddressRestrictedCone)
+	assert.Equal(t, string(discovery.NATPortRestrictedCone), NATPortRestrictedCone)
+	assert.Equal(t, string(discovery.NATSymmetric), NATSymmetric)
+}
+
+func TestTraversalRank(t *testing.T) {
+	traversal, err := NewTraversal(nil)
+	assert.NoError(t, err)
+	ranking := traversal.Rank("full-cone", "full-cone")
+	assert.Len(t, ranking, 5)
+	assert.Equal(t, "UDP Hole Punching", traversal.Name(ranking[0]))
+
+	// Only enabled strategies are ranked, configured priorities first
+	traversal, err = NewTraversal([]StrategyConfig{
+		{Type: UDPHolePunching},
+		{Type: TCPRelaying, Priority: 10},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []StrategyType{TCPRelaying, UDPHolePunching}, traversal.Rank("FullCone", "full_cone"))
+	assert.True(t, traversal.Enabled(UDPHolePunching))
+	assert.False(t, traversal.Enabled(UDPRelaying))
+
+	_, err = NewTraversal([]StrategyConfig{{Type: "carrier-pigeon"}})
+	assert.ErrorIs(t, err, ErrUnknownStrategy)
+}
+
+func TestTraversalConnect(t *testing.T) {
+	peer, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+	go func() {
+		if conn, err := peer.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+
+	traversal, err := NewTraversal([]StrategyConfig{{Type: TCPSimultaneousOpen}})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	peerAddr := peer.Addr().(*net.TCPAddr)
+	candidates := []*net.UDPAddr{{IP: peerAddr.IP, Port: peerAddr.Port}}
+	conn, err := traversal.Connect(ctx, TCPSimultaneousOpen, nil, candidates)
+	if assert.NoError(t, err) {
+		conn.Close()
+	}
+
+	_, err = traversal.Connect(ctx, TCPSimultaneousOpen, nil, nil)
+	assert.Equal(t, ErrNoCandidates, err)
+	_, err = traversal.Connect(ctx, UDPRelaying, nil, candidates)
+	assert.Equal(t, ErrStrategyDisabled, err)
+}
@@ -0,0 +1,270 @@
+// pkg/networking/udp_handler.go
+package networking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Default settings for the built-in handlers
+const (
+	DefaultListenAddress  = ":0"
+	DefaultUDPBufferSize  = 65535
+	DefaultMaxTCPFrameLen = 1 << 20
+)
+
+// Errors returned by the built-in handlers
+var (
+	ErrHandlerNotStarted = errors.New("network handler not started")
+	ErrHandlerStarted    = errors.New("network handler already started")
+	ErrInvalidAddress    = errors.New("invalid address for connection type")
+)
+
+// UDPHandler is a NetworkHandler that sends and receives datagrams on a
+// single UDP socket
+type UDPHandler struct {
+	address    string
+	bufferSize int
+
+	mu        sync.RWMutex
+	conn      *net.UDPConn
+	callbacks []func(*Packet) error
+	wg        sync.WaitGroup
+}
+
+// NewUDPHandler creates a UDP handler from a configuration map. Recognised
+// keys are "address" (default ":0") and "buffer_size".
+func NewUDPHandler(config map[string]interface{}) (NetworkHandler, error) {
+	handler := &UDPHandler{}
+	if err := handler.Initialize(config); err != nil {
+		return nil, err
+	}
+	return handler, nil
+}
+
+// Initialize sets up the handler with configuration
+func (h *UDPHandler) Initialize(config map[string]interface{}) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.conn != nil {
+		return ErrHandlerStarted
+	}
+
+	address, err := configString(config, "address", DefaultListenAddress)
+	if err != nil {
+		return err
+	}
+	bufferSize, err := configInt(config, "buffer_size", DefaultUDPBufferSize)
+	if err != nil {
+		return err
+	}
+
+	h.address = address
+	h.bufferSize = bufferSize
+	return nil
+}
+
+// Start binds the socket and begins delivering received datagrams to the
+// registered callbacks. The handler stops when ctx is cancelled.
+func (h *UDPHandler) Start(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.conn != nil {
+		return ErrHandlerStarted
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", h.address)
+	if err != nil {
+		return fmt.Errorf("failed to resolve UDP address: %w", err)
+	}
+
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on UDP: %w", err)
+	}
+	h.conn = conn
+
+	h.wg.Add(1)
+	go h.readLoop(conn)
+
+	go func() {
+		<-ctx.Done()
+		h.stopConn(conn)
+	}()
+
+	return nil
+}
+
+// Stop closes the socket and waits for the read loop to exit
+func (h *UDPHandler) Stop() error {
+	h.mu.RLock()
+	conn := h.conn
+	h.mu.RUnlock()
+
+	if conn == nil {
+		return ErrHandlerNotStarted
+	}
+	return h.stopConn(conn)
+}
+
+// stopConn closes conn if it is still the handler's socket
+func (h *UDPHandler) stopConn(conn *net.UDPConn) error {
+	h.mu.Lock()
+	if h.conn != conn {
+		h.mu.Unlock()
+		return nil
+	}
+	h.conn = nil
+	h.mu.Unlock()
+
+	err := conn.Close()
+	h.wg.Wait()
+	return err
+}
+
+// Send transmits a packet's data to its target address
+func (h *UDPHandler) Send(packet *Packet) error {
+	h.mu.RLock()
+	conn := h.conn
+	h.mu.RUnlock()
+
+	if conn == nil {
+		return ErrHandlerNotStarted
+	}
+
+	target, err := toUDPAddr(packet.TargetAddr)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.WriteToUDP(packet.Data, target)
+	return err
+}
+
+// RegisterReceiveCallback registers a callback for received packets.
+// Callbacks run on the read loop, so slow callbacks delay later packets.
+func (h *UDPHandler) RegisterReceiveCallback(callback func(*Packet) error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.callbacks = append(h.callbacks, callback)
+}
+
+// GetListeningAddresses returns the bound socket address
+func (h *UDPHandler) GetListeningAddresses() []net.Addr {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.conn == nil {
+		return nil
+	}
+	return []net.Addr{h.conn.LocalAddr()}
+}
+
+// readLoop delivers datagrams until the socket is closed
+func (h *UDPHandler) readLoop(conn *net.UDPConn) {
+	defer h.wg.Done()
+
+	buffer := make([]byte, h.bufferSize)
+	for {
+		n, addr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			continue
+		}
+
+		data := make([]byte, n)
+		copy(data, buffer[:n])
+
+		h.deliver(&Packet{
+			Data:       data,
+			SourceAddr: addr,
+			TargetAddr: conn.LocalAddr(),
+			ConnType:   UDP,
+			Metadata:   make(map[string]interface{}),
+		})
+	}
+}
+
+// deliver hands a packet to every callback. Callback errors only affect
+// that packet, so they are ignored.
+func (h *UDPHandler) deliver(packet *Packet) {
+	h.mu.RLock()
+	callbacks := h.callbacks
+	h.mu.RUnlock()
+
+	for _, callback := range callbacks {
+		_ = callback(packet)
+	}
+}
+
+// toUDPAddr converts any IP address to a UDP address
+func toUDPAddr(addr net.Addr) (*net.UDPAddr, error) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a, nil
+	case *net.TCPAddr:
+		return &net.UDPAddr{IP: a.IP, Port: a.Port, Zone: a.Zone}, nil
+	case nil:
+		return nil, ErrInvalidAddress
+	default:
+		return net.ResolveUDPAddr("udp", addr.String())
+	}
+}
+
+// toTCPAddr converts any IP address to a TCP address
+func toTCPAddr(addr net.Addr) (*net.TCPAddr, error) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a, nil
+	case *net.UDPAddr:
+		return &net.TCPAddr{IP: a.IP, Port: a.Port, Zone: a.Zone}, nil
+	case nil:
+		return nil, ErrInvalidAddress
+	default:
+		return net.ResolveTCPAddr("tcp", addr.String())
+	}
+}
+
+// configString reads an optional string setting
+func configString(config map[string]interface{}, key, fallback string) (string, error) {
+	value, ok := config[key]
+	if !ok {
+		return fallback, nil
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("config %q must be a string", key)
+	}
+	return s, nil
+}
+
+// configInt reads an optional positive integer setting
+func configInt(config map[string]interface{}, key string, fallback int) (int, error) {
+	value, ok := config[key]
+	if !ok {
+		return fallback, nil
+	}
+
+	var n int
+	switch v := value.(type) {
+	case int:
+		n = v
+	case int64:
+		n = int(v)
+	case float64:
+		n = int(v)
+	default:
+		return 0, fmt.Errorf("config %q must be a number", key)
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("config %q must be positive", key)
+	}
+	return n, nil
+}
@@ -4,26 +4,93 @@ package networking
 import (
 	"fmt"
 	"net"
+	"strconv"
 	"time"
+
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
 )
 
-// GetLocalIP returns the non-loopback local IP of the host
+// GetLocalIP returns the non-loopback local IP of the host, preferring IPv4
+// and falling back to a global IPv6 address on IPv6-only hosts
 func GetLocalIP() (net.IP, error) {
+	ips, err := GetLocalIPs()
+	if err != nil {
+		return nil, err
+	}
+
+	// GetLocalIPs lists IPv4 addresses first
+	return ips[0], nil
+}
+
+// GetLocalIPs returns the host's usable unicast addresses, IPv4 first. Loopback
+// and link-local addresses are skipped since peers can't reach them without
+// knowing the interface.
+func GetLocalIPs() ([]net.IP, error) {
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get interface addresses: %w", err)
 	}
 
+	var ipv4, ipv6 []net.IP
 	for _, addr := range addrs {
-		// Check if the address is IP network
-		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-			if ipnet.IP.To4() != nil {
-				return ipnet.IP, nil
-			}
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.IsLoopback() || ipnet.IP.IsLinkLocalUnicast() {
+			continue
 		}
+
+		if ipnet.IP.To4() != nil {
+			ipv4 = append(ipv4, ipnet.IP)
+		} else {
+			ipv6 = append(ipv6, ipnet.IP)
+		}
+	}
+
+	if len(ipv4) == 0 && len(ipv6) == 0 {
+		return nil, fmt.Errorf("no suitable local IP address found")
 	}
 
-	return nil, fmt.Errorf("no suitable local IP address found")
+	return append(ipv4, ipv6...), nil
+}
+
+// GetHostCandidates returns host:port candidates for every usable local
+// address, IPv4 and IPv6. Global IPv6 candidates can usually be reached
+// directly, without NAT traversal.
+func GetHostCandidates(port int) ([]string, error) {
+	ips, err := GetLocalIPs()
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		candidates = append(candidates, net.JoinHostPort(ip.String(), strconv.Itoa(port)))
+	}
+	return candidates, nil
+}
+
+// GetLocalEndpoints returns host:port endpoints for the private addresses of
+// the host, as many as servers accept. Clients announce these when
+// registering so peers behind the same NAT can reach them directly instead
+// of relying on hairpinning.
+func GetLocalEndpoints(port int) ([]string, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get interface addresses: %w", err)
+	}
+
+	var candidates []string
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.IsPrivate() {
+			candidates = append(candidates, net.JoinHostPort(ipnet.IP.String(), strconv.Itoa(port)))
+		}
+	}
+
+	// Filtered the way servers filter announcements
+	var endpoints []string
+	for _, addrPort := range protocol.LocalEndpoints(candidates) {
+		endpoints = append(endpoints, addrPort.String())
+	}
+	return endpoints, nil
 }
 
 // CheckUDPPort tests if a UDP port is available for listening
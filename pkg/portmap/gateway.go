@@ -0,0 +1,55 @@
+// pkg/portmap/gateway.go
+package portmap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"os"
+	"strings"
+)
+
+// routeTable is the kernel routing table on Linux
+const routeTable = "/proc/net/route"
+
+// DefaultGateway returns the IPv4 address of the host's default gateway. It
+// reads the Linux routing table and returns ErrNoGateway elsewhere.
+func DefaultGateway() (net.IP, error) {
+	file, err := os.Open(routeTable)
+	if err != nil {
+		return nil, ErrNoGateway
+	}
+	defer file.Close()
+
+	return parseRouteTable(bufio.NewScanner(file))
+}
+
+// parseRouteTable finds the default route in /proc/net/route. Addresses are
+// hex encoded in host byte order, which is little endian on every platform
+// we run on.
+func parseRouteTable(scanner *bufio.Scanner) (net.IP, error) {
+	// Skip the header line
+	scanner.Scan()
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+
+		gateway := make(net.IP, 4)
+		binary.BigEndian.PutUint32(gateway, binary.LittleEndian.Uint32(raw))
+		if gateway.IsUnspecified() {
+			continue
+		}
+		return gateway, nil
+	}
+
+	return nil, ErrNoGateway
+}
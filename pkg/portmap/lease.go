@@ -0,0 +1,126 @@
+// pkg/portmap/lease.go
+package portmap
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// minRenewInterval keeps renewals from spinning on very short leases
+	minRenewInterval = 5 * time.Second
+
+	// retryRenewInterval is used after a failed renewal
+	retryRenewInterval = 30 * time.Second
+
+	// unmapTimeout bounds deleting the mapping on close
+	unmapTimeout = 3 * time.Second
+)
+
+// Lease keeps a mapping alive by renewing it halfway through each lifetime,
+// and deletes it when closed
+type Lease struct {
+	mapper *Mapper
+
+	mu      sync.Mutex
+	mapping Mapping
+	lastErr error
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// Lease requests a mapping and keeps it alive until the lease is closed
+func (m *Mapper) Lease(ctx context.Context, protocol string, internalPort int, lifetime time.Duration) (*Lease, error) {
+	mapping, err := m.Map(ctx, protocol, internalPort, lifetime)
+	if err != nil {
+		return nil, err
+	}
+
+	lease := &Lease{
+		mapper:  m,
+		mapping: *mapping,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go lease.renewLoop()
+
+	return lease, nil
+}
+
+// Mapping returns the current mapping. The external address may change when
+// a renewal is granted a different port.
+func (l *Lease) Mapping() Mapping {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.mapping
+}
+
+// Err returns the error from the last renewal, if it failed
+func (l *Lease) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.lastErr
+}
+
+// Close stops renewing and deletes the mapping from the gateway
+func (l *Lease) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.stop)
+		<-l.done
+
+		ctx, cancel := context.WithTimeout(context.Background(), unmapTimeout)
+		defer cancel()
+
+		mapping := l.Mapping()
+		err = l.mapper.Unmap(ctx, &mapping)
+	})
+	return err
+}
+
+// renewLoop renews the mapping until the lease is closed
+func (l *Lease) renewLoop() {
+	defer close(l.done)
+
+	interval := renewInterval(l.Mapping().Lifetime)
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-timer.C:
+		}
+
+		mapping := l.Mapping()
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		err := l.mapper.Renew(ctx, &mapping)
+		cancel()
+
+		l.mu.Lock()
+		l.lastErr = err
+		if err == nil {
+			l.mapping = mapping
+		}
+		l.mu.Unlock()
+
+		if err != nil {
+			timer.Reset(retryRenewInterval)
+		} else {
+			interval = renewInterval(mapping.Lifetime)
+			timer.Reset(interval)
+		}
+	}
+}
+
+// renewInterval returns when to renew a mapping with the given lifetime
+func renewInterval(lifetime time.Duration) time.Duration {
+	if interval := lifetime / 2; interval > minRenewInterval {
+		return interval
+	}
+	return minRenewInterval
+}
@@ -0,0 +1,138 @@
+// pkg/portmap/natpmp.go
+package portmap
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// NAT-PMP opcodes and sizes (RFC 6886)
+const (
+	natpmpVersion          = 0
+	natpmpOpExternalAddr   = 0
+	natpmpOpMapUDP         = 1
+	natpmpOpMapTCP         = 2
+	natpmpResponseBit      = 128
+	natpmpMapRequestSize   = 12
+	natpmpMapResponseSize  = 16
+	natpmpAddrResponseSize = 12
+)
+
+// NATPMPClient requests mappings using NAT-PMP
+type NATPMPClient struct {
+	gateway *net.UDPAddr
+}
+
+// NewNATPMPClient creates a NAT-PMP client for a gateway, usually on GatewayPort
+func NewNATPMPClient(gateway *net.UDPAddr) *NATPMPClient {
+	return &NATPMPClient{gateway: gateway}
+}
+
+// Method returns MethodNATPMP
+func (c *NATPMPClient) Method() Method {
+	return MethodNATPMP
+}
+
+// Map creates or renews a mapping. NAT-PMP map responses don't carry the
+// external address, so it is requested separately.
+func (c *NATPMPClient) Map(ctx context.Context, mapping *Mapping) error {
+	lifetime, port, err := c.request(ctx, mapping, uint32(mapping.Lifetime/time.Second), mapping.ExternalPort)
+	if err != nil {
+		return err
+	}
+
+	externalIP, err := c.externalAddress(ctx)
+	if err != nil {
+		return err
+	}
+
+	mapping.ExternalIP = externalIP
+	mapping.ExternalPort = port
+	mapping.Lifetime = lifetime
+	mapping.Expires = time.Now().Add(lifetime)
+	return nil
+}
+
+// Unmap deletes a mapping by requesting it with a zero lifetime
+func (c *NATPMPClient) Unmap(ctx context.Context, mapping *Mapping) error {
+	_, _, err := c.request(ctx, mapping, 0, 0)
+	return err
+}
+
+// request sends a mapping request and returns the granted lifetime and port
+func (c *NATPMPClient) request(ctx context.Context, mapping *Mapping, lifetime uint32, externalPort int) (time.Duration, int, error) {
+	opcode := byte(natpmpOpMapUDP)
+	if mapping.Protocol == "tcp" {
+		opcode = natpmpOpMapTCP
+	}
+
+	response, err := exchange(ctx, c.gateway,
+		func(*net.UDPAddr) []byte {
+			request := make([]byte, natpmpMapRequestSize)
+			request[0] = natpmpVersion
+			request[1] = opcode
+			binary.BigEndian.PutUint16(request[4:6], uint16(mapping.InternalPort))
+			binary.BigEndian.PutUint16(request[6:8], uint16(externalPort))
+			binary.BigEndian.PutUint32(request[8:12], lifetime)
+			return request
+		},
+		func(response []byte) bool {
+			return len(response) >= 4 && response[1] == natpmpResponseBit+opcode
+		})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := natpmpResult(response); err != nil {
+		return 0, 0, err
+	}
+	if len(response) < natpmpMapResponseSize ||
+		int(binary.BigEndian.Uint16(response[8:10])) != mapping.InternalPort {
+		return 0, 0, ErrInvalidResponse
+	}
+
+	port := int(binary.BigEndian.Uint16(response[10:12]))
+	granted := time.Duration(binary.BigEndian.Uint32(response[12:16])) * time.Second
+	return granted, port, nil
+}
+
+// externalAddress asks the gateway for its public IPv4 address
+func (c *NATPMPClient) externalAddress(ctx context.Context) (net.IP, error) {
+	response, err := exchange(ctx, c.gateway,
+		func(*net.UDPAddr) []byte {
+			return []byte{natpmpVersion, natpmpOpExternalAddr}
+		},
+		func(response []byte) bool {
+			return len(response) >= 4 && response[1] == natpmpResponseBit+natpmpOpExternalAddr
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := natpmpResult(response); err != nil {
+		return nil, err
+	}
+	if len(response) < natpmpAddrResponseSize {
+		return nil, ErrInvalidResponse
+	}
+
+	return net.IPv4(response[8], response[9], response[10], response[11]), nil
+}
+
+// natpmpResult turns a response's result code into an error
+func natpmpResult(response []byte) error {
+	if response[0] != natpmpVersion {
+		return ErrUnsupported
+	}
+
+	switch code := binary.BigEndian.Uint16(response[2:4]); code {
+	case 0:
+		return nil
+	case 1:
+		return ErrUnsupported
+	default:
+		return &ResultError{Method: MethodNATPMP, Code: int(code)}
+	}
+}
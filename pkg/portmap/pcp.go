@@ -0,0 +1,125 @@
+// pkg/portmap/pcp.go
+package portmap
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"time"
+)
+
+// PCP constants (RFC 6887)
+const (
+	pcpVersion        = 2
+	pcpOpMap          = 1
+	pcpResponseBit    = 0x80
+	pcpHeaderSize     = 24
+	pcpMapPayloadSize = 36
+	pcpMessageSize    = pcpHeaderSize + pcpMapPayloadSize
+
+	pcpResultSuccess     = 0
+	pcpResultUnsuppVers  = 1
+	pcpProtocolUDP       = 17
+	pcpProtocolTCP       = 6
+	pcpPayloadNonceEnd   = pcpHeaderSize + 12
+	pcpPayloadPortOffset = pcpHeaderSize + 16
+)
+
+// PCPClient requests mappings using the Port Control Protocol
+type PCPClient struct {
+	gateway *net.UDPAddr
+}
+
+// NewPCPClient creates a PCP client for a gateway, usually on GatewayPort
+func NewPCPClient(gateway *net.UDPAddr) *PCPClient {
+	return &PCPClient{gateway: gateway}
+}
+
+// Method returns MethodPCP
+func (c *PCPClient) Method() Method {
+	return MethodPCP
+}
+
+// Map creates or renews a mapping. Renewals reuse the mapping's nonce, which
+// the gateway uses to recognise the mapping.
+func (c *PCPClient) Map(ctx context.Context, mapping *Mapping) error {
+	if mapping.nonce == [12]byte{} {
+		if _, err := rand.Read(mapping.nonce[:]); err != nil {
+			return err
+		}
+	}
+
+	response, err := c.request(ctx, mapping, uint32(mapping.Lifetime/time.Second), mapping.ExternalPort)
+	if err != nil {
+		return err
+	}
+
+	lifetime := time.Duration(binary.BigEndian.Uint32(response[4:8])) * time.Second
+	mapping.ExternalPort = int(binary.BigEndian.Uint16(response[pcpPayloadPortOffset+2 : pcpPayloadPortOffset+4]))
+	mapping.ExternalIP = net.IP(append([]byte(nil), response[pcpPayloadPortOffset+4:pcpMessageSize]...))
+	if ip4 := mapping.ExternalIP.To4(); ip4 != nil {
+		mapping.ExternalIP = ip4
+	}
+	mapping.Lifetime = lifetime
+	mapping.Expires = time.Now().Add(lifetime)
+	return nil
+}
+
+// Unmap deletes a mapping by requesting it with a zero lifetime
+func (c *PCPClient) Unmap(ctx context.Context, mapping *Mapping) error {
+	_, err := c.request(ctx, mapping, 0, 0)
+	return err
+}
+
+// request sends a MAP request and returns the successful response
+func (c *PCPClient) request(ctx context.Context, mapping *Mapping, lifetime uint32, externalPort int) ([]byte, error) {
+	protocol := byte(pcpProtocolUDP)
+	if mapping.Protocol == "tcp" {
+		protocol = pcpProtocolTCP
+	}
+
+	response, err := exchange(ctx, c.gateway,
+		func(localAddr *net.UDPAddr) []byte {
+			request := make([]byte, pcpMessageSize)
+			request[0] = pcpVersion
+			request[1] = pcpOpMap
+			binary.BigEndian.PutUint32(request[4:8], lifetime)
+			copy(request[8:24], localAddr.IP.To16())
+
+			payload := request[pcpHeaderSize:]
+			copy(payload[0:12], mapping.nonce[:])
+			payload[12] = protocol
+			binary.BigEndian.PutUint16(payload[16:18], uint16(mapping.InternalPort))
+			binary.BigEndian.PutUint16(payload[18:20], uint16(externalPort))
+			// No preference for the external address: ::ffff:0.0.0.0
+			copy(payload[20:36], net.IPv4zero.To16())
+			return request
+		},
+		func(response []byte) bool {
+			// A NAT-PMP only gateway answers with version 0
+			if len(response) >= 4 && response[0] != pcpVersion {
+				return true
+			}
+			return len(response) >= pcpMessageSize &&
+				response[1] == pcpResponseBit|pcpOpMap &&
+				bytes.Equal(response[pcpHeaderSize:pcpPayloadNonceEnd], mapping.nonce[:])
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	if response[0] != pcpVersion {
+		return nil, ErrUnsupported
+	}
+
+	switch code := response[3]; code {
+	case pcpResultSuccess:
+		return response, nil
+	case pcpResultUnsuppVers:
+		return nil, ErrUnsupported
+	default:
+		return nil, &ResultError{Method: MethodPCP, Code: int(code)}
+	}
+}
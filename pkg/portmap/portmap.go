@@ -0,0 +1,240 @@
+// pkg/portmap/portmap.go
+
+// Package portmap asks home gateways to forward a port using PCP (RFC 6887),
+// NAT-PMP (RFC 6886) or UPnP IGD, so peers can reach us without hole punching.
+package portmap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Method identifies a port mapping protocol
+type Method string
+
+const (
+	// MethodPCP is the Port Control Protocol, the successor of NAT-PMP
+	MethodPCP Method = "pcp"
+
+	// MethodNATPMP is the NAT Port Mapping Protocol
+	MethodNATPMP Method = "nat-pmp"
+
+	// MethodUPnP is UPnP Internet Gateway Device port forwarding
+	MethodUPnP Method = "upnp"
+)
+
+const (
+	// DefaultLifetime is the lease requested for new mappings
+	DefaultLifetime = 2 * time.Hour
+
+	// GatewayPort is the port PCP and NAT-PMP servers listen on
+	GatewayPort = 5351
+
+	// initialRetransmit is the first retransmission timeout for PCP and
+	// NAT-PMP requests; it doubles with every attempt
+	initialRetransmit = 250 * time.Millisecond
+
+	// maxAttempts bounds retransmissions so the next method is tried quickly
+	maxAttempts = 3
+)
+
+// Errors returned while requesting mappings
+var (
+	ErrNoGateway       = errors.New("no default gateway found")
+	ErrUnsupported     = errors.New("port mapping protocol not supported by gateway")
+	ErrNoResponse      = errors.New("gateway did not respond")
+	ErrUnknownMethod   = errors.New("no client for mapping method")
+	ErrInvalidResponse = errors.New("invalid response from gateway")
+)
+
+// ResultError is a failure result code returned by a gateway
+type ResultError struct {
+	Method Method
+	Code   int
+}
+
+func (e *ResultError) Error() string {
+	return fmt.Sprintf("%s request failed with result code %d", e.Method, e.Code)
+}
+
+// Mapping is a port forwarded by the gateway from ExternalIP:ExternalPort to
+// InternalPort on this host
+type Mapping struct {
+	Method       Method
+	Protocol     string // "udp" or "tcp"
+	InternalPort int
+	ExternalIP   net.IP
+	ExternalPort int
+	Lifetime     time.Duration
+	Expires      time.Time
+
+	nonce [12]byte // PCP mapping nonce, reused when renewing or deleting
+}
+
+// ExternalAddr returns the address peers can reach the mapping on
+func (m Mapping) ExternalAddr() *net.UDPAddr {
+	return &net.UDPAddr{IP: m.ExternalIP, Port: m.ExternalPort}
+}
+
+// Client requests mappings from a gateway using a single protocol
+type Client interface {
+	// Method returns the protocol this client speaks
+	Method() Method
+
+	// Map creates or renews a mapping. Protocol, InternalPort and Lifetime
+	// must be set; ExternalPort is a suggestion. On success the external
+	// address, granted lifetime and expiry are filled in.
+	Map(ctx context.Context, mapping *Mapping) error
+
+	// Unmap deletes a mapping
+	Unmap(ctx context.Context, mapping *Mapping) error
+}
+
+// Mapper tries several port mapping protocols in order and uses the first
+// one the gateway supports
+type Mapper struct {
+	clients []Client
+}
+
+// NewMapper creates a mapper that tries clients in the given order
+func NewMapper(clients ...Client) *Mapper {
+	return &Mapper{clients: clients}
+}
+
+// NewGatewayMapper creates a mapper for a gateway, trying PCP, then NAT-PMP,
+// then UPnP IGD
+func NewGatewayMapper(gateway net.IP) *Mapper {
+	addr := &net.UDPAddr{IP: gateway, Port: GatewayPort}
+	return NewMapper(NewPCPClient(addr), NewNATPMPClient(addr), NewUPnPClient())
+}
+
+// NewDefaultMapper creates a mapper for the host's default gateway
+func NewDefaultMapper() (*Mapper, error) {
+	gateway, err := DefaultGateway()
+	if err != nil {
+		return nil, err
+	}
+	return NewGatewayMapper(gateway), nil
+}
+
+// Map requests a mapping for a local port, trying each protocol in turn
+func (m *Mapper) Map(ctx context.Context, protocol string, internalPort int, lifetime time.Duration) (*Mapping, error) {
+	if lifetime <= 0 {
+		lifetime = DefaultLifetime
+	}
+
+	var failures []string
+	for _, client := range m.clients {
+		mapping := &Mapping{
+			Method:       client.Method(),
+			Protocol:     protocol,
+			InternalPort: internalPort,
+			ExternalPort: internalPort,
+			Lifetime:     lifetime,
+		}
+
+		err := client.Map(ctx, mapping)
+		if err == nil {
+			return mapping, nil
+		}
+		failures = append(failures, fmt.Sprintf("%s: %v", client.Method(), err))
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if len(failures) == 0 {
+		return nil, ErrUnsupported
+	}
+	return nil, fmt.Errorf("port mapping failed (%s)", strings.Join(failures, "; "))
+}
+
+// Renew extends a mapping with the protocol that created it
+func (m *Mapper) Renew(ctx context.Context, mapping *Mapping) error {
+	client, err := m.client(mapping.Method)
+	if err != nil {
+		return err
+	}
+	return client.Map(ctx, mapping)
+}
+
+// Unmap deletes a mapping with the protocol that created it
+func (m *Mapper) Unmap(ctx context.Context, mapping *Mapping) error {
+	client, err := m.client(mapping.Method)
+	if err != nil {
+		return err
+	}
+	return client.Unmap(ctx, mapping)
+}
+
+// client returns the client for a method
+func (m *Mapper) client(method Method) (Client, error) {
+	for _, client := range m.clients {
+		if client.Method() == method {
+			return client, nil
+		}
+	}
+	return nil, ErrUnknownMethod
+}
+
+// exchange sends a request to a PCP or NAT-PMP server and waits for a
+// response accepted by match, retransmitting with exponential backoff. The
+// request is built once the socket exists so it can carry the local address.
+func exchange(
+	ctx context.Context,
+	gateway *net.UDPAddr,
+	build func(localAddr *net.UDPAddr) []byte,
+	match func(response []byte) bool,
+) ([]byte, error) {
+	conn, err := net.DialUDP("udp", nil, gateway)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	request := build(conn.LocalAddr().(*net.UDPAddr))
+	buffer := make([]byte, 1100) // PCP messages are at most 1100 bytes
+	timeout := initialRetransmit
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		if _, err := conn.Write(request); err != nil {
+			return nil, err
+		}
+
+		deadline := time.Now().Add(timeout)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		conn.SetReadDeadline(deadline)
+
+		for {
+			n, err := conn.Read(buffer)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				// ICMP port unreachable: nothing listens on the gateway
+				return nil, ErrUnsupported
+			}
+
+			if match(buffer[:n]) {
+				response := make([]byte, n)
+				copy(response, buffer[:n])
+				return response, nil
+			}
+		}
+
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		timeout *= 2
+	}
+
+	return nil, ErrNoResponse
+}
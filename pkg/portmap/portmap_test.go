@@ -0,0 +1,338 @@
+// pkg/portmap/portmap_test.go
+package portmap
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeGateway answers PCP or NAT-PMP requests on a local port
+type fakeGateway struct {
+	conn     *net.UDPConn
+	pcp      bool
+	external net.IP
+
+	mu       sync.Mutex
+	mappings map[int]uint32 // Internal port to granted lifetime
+	requests int
+}
+
+func newFakeGateway(t *testing.T, port int, pcp bool) *fakeGateway {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: port})
+	if err != nil {
+		t.Fatalf("Failed to start fake gateway: %v", err)
+	}
+
+	gateway := &fakeGateway{
+		conn:     conn,
+		pcp:      pcp,
+		external: net.IPv4(203, 0, 113, 7).To4(),
+		mappings: make(map[int]uint32),
+	}
+	go gateway.serve()
+	t.Cleanup(func() { conn.Close() })
+	return gateway
+}
+
+func (g *fakeGateway) addr() *net.UDPAddr {
+	return g.conn.LocalAddr().(*net.UDPAddr)
+}
+
+func (g *fakeGateway) lifetime(port int) (uint32, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	lifetime, ok := g.mappings[port]
+	return lifetime, ok
+}
+
+func (g *fakeGateway) requestCount() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.requests
+}
+
+func (g *fakeGateway) serve() {
+	buffer := make([]byte, 1100)
+	for {
+		n, addr, err := g.conn.ReadFromUDP(buffer)
+		if err != nil {
+			return
+		}
+
+		g.mu.Lock()
+		g.requests++
+		g.mu.Unlock()
+
+		var response []byte
+		if g.pcp {
+			response = g.answerPCP(buffer[:n])
+		} else {
+			response = g.answerNATPMP(buffer[:n])
+		}
+		if response != nil {
+			g.conn.WriteToUDP(response, addr)
+		}
+	}
+}
+
+func (g *fakeGateway) answerPCP(request []byte) []byte {
+	if len(request) < pcpMessageSize || request[0] != pcpVersion {
+		// Answer like a NAT-PMP only gateway would
+		return []byte{natpmpVersion, request[1] | natpmpResponseBit, 0, 1}
+	}
+
+	response := make([]byte, pcpMessageSize)
+	copy(response, request)
+	response[1] = pcpResponseBit | pcpOpMap
+	response[3] = pcpResultSuccess
+
+	internalPort := int(binary.BigEndian.Uint16(request[pcpHeaderSize+16 : pcpHeaderSize+18]))
+	lifetime := binary.BigEndian.Uint32(request[4:8])
+	g.record(internalPort, lifetime)
+
+	binary.BigEndian.PutUint16(response[pcpPayloadPortOffset+2:pcpPayloadPortOffset+4], uint16(internalPort+10000))
+	copy(response[pcpPayloadPortOffset+4:], g.external.To16())
+	return response
+}
+
+func (g *fakeGateway) answerNATPMP(request []byte) []byte {
+	if len(request) < 2 || request[0] != natpmpVersion {
+		// Unsupported version
+		return []byte{natpmpVersion, request[1] | natpmpResponseBit, 0, 1}
+	}
+
+	switch request[1] {
+	case natpmpOpExternalAddr:
+		response := make([]byte, natpmpAddrResponseSize)
+		response[1] = natpmpResponseBit + natpmpOpExternalAddr
+		copy(response[8:12], g.external)
+		return response
+	case natpmpOpMapUDP, natpmpOpMapTCP:
+		internalPort := binary.BigEndian.Uint16(request[4:6])
+		lifetime := binary.BigEndian.Uint32(request[8:12])
+		g.record(int(internalPort), lifetime)
+
+		response := make([]byte, natpmpMapResponseSize)
+		response[1] = natpmpResponseBit + request[1]
+		binary.BigEndian.PutUint16(response[8:10], internalPort)
+		binary.BigEndian.PutUint16(response[10:12], internalPort+10000)
+		binary.BigEndian.PutUint32(response[12:16], lifetime)
+		return response
+	}
+	return nil
+}
+
+func (g *fakeGateway) record(port int, lifetime uint32) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if lifetime == 0 {
+		delete(g.mappings, port)
+	} else {
+		g.mappings[port] = lifetime
+	}
+}
+
+func TestPCPMapping(t *testing.T) {
+	gateway := newFakeGateway(t, 15351, true)
+	client := NewPCPClient(gateway.addr())
+
+	mapping := &Mapping{Method: MethodPCP, Protocol: "udp", InternalPort: 4000, Lifetime: time.Hour}
+	err := client.Map(context.Background(), mapping)
+	assert.NoError(t, err)
+	assert.Equal(t, "203.0.113.7:14000", mapping.ExternalAddr().String())
+	assert.Equal(t, time.Hour, mapping.Lifetime)
+
+	lifetime, ok := gateway.lifetime(4000)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(3600), lifetime)
+
+	assert.NoError(t, client.Unmap(context.Background(), mapping))
+	_, ok = gateway.lifetime(4000)
+	assert.False(t, ok)
+}
+
+func TestMapperFallsBackToNATPMP(t *testing.T) {
+	gateway := newFakeGateway(t, 15352, false)
+	mapper := NewMapper(NewPCPClient(gateway.addr()), NewNATPMPClient(gateway.addr()))
+
+	mapping, err := mapper.Map(context.Background(), "udp", 4001, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, MethodNATPMP, mapping.Method)
+	assert.Equal(t, "203.0.113.7:14001", mapping.ExternalAddr().String())
+
+	assert.NoError(t, mapper.Unmap(context.Background(), mapping))
+	_, ok := gateway.lifetime(4001)
+	assert.False(t, ok)
+}
+
+func TestMapperNoGateway(t *testing.T) {
+	// Nothing listens here, so requests fail fast with port unreachable
+	addr := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 15353}
+	mapper := NewMapper(NewPCPClient(addr), NewNATPMPClient(addr))
+
+	_, err := mapper.Map(context.Background(), "udp", 4002, time.Hour)
+	assert.Error(t, err)
+}
+
+func TestLeaseRenewsAndDeletes(t *testing.T) {
+	gateway := newFakeGateway(t, 15354, true)
+	mapper := NewMapper(NewPCPClient(gateway.addr()))
+
+	lease, err := mapper.Lease(context.Background(), "udp", 4003, time.Second)
+	assert.NoError(t, err)
+	assert.Equal(t, "203.0.113.7:14003", lease.Mapping().ExternalAddr().String())
+	assert.Equal(t, 1, gateway.requestCount())
+
+	assert.NoError(t, lease.Close())
+	_, ok := gateway.lifetime(4003)
+	assert.False(t, ok)
+
+	// Closing twice is harmless
+	assert.NoError(t, lease.Close())
+}
+
+func TestRenewInterval(t *testing.T) {
+	assert.Equal(t, time.Hour, renewInterval(2*time.Hour))
+	assert.Equal(t, minRenewInterval, renewInterval(time.Second))
+}
+
+func TestParseRouteTable(t *testing.T) {
+	table := "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\n" +
+		"eth0\t0000A8C0\t00000000\t0001\t0\t0\t0\t00FFFFFF\n" +
+		"eth0\t00000000\t0100A8C0\t0003\t0\t0\t0\t00000000\n"
+
+	gateway, err := parseRouteTable(bufio.NewScanner(strings.NewReader(table)))
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.0.1", gateway.String())
+
+	_, err = parseRouteTable(bufio.NewScanner(strings.NewReader("Iface\tDestination\tGateway\n")))
+	assert.Equal(t, ErrNoGateway, err)
+}
+
+// fakeIGD is a UPnP gateway: an SSDP responder plus an HTTP control endpoint
+type fakeIGD struct {
+	mu       sync.Mutex
+	mappings map[string]string // External port to lease duration
+}
+
+func newFakeIGD(t *testing.T, ssdpPort int) (*fakeIGD, *net.UDPAddr) {
+	igd := &fakeIGD{mappings: make(map[string]string)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rootDesc.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+        <serviceList>
+          <service>
+            <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+            <controlURL>/ctl/IPConn</controlURL>
+          </service>
+        </serviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`)
+	})
+	mux.HandleFunc("/ctl/IPConn", igd.control)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: ssdpPort})
+	if err != nil {
+		t.Fatalf("Failed to start fake SSDP responder: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buffer := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFromUDP(buffer)
+			if err != nil {
+				return
+			}
+			if !strings.HasPrefix(string(buffer[:n]), "M-SEARCH") {
+				continue
+			}
+			response := "HTTP/1.1 200 OK\r\n" +
+				"ST: " + ssdpSearchTarget + "\r\n" +
+				"LOCATION: " + server.URL + "/rootDesc.xml\r\n\r\n"
+			conn.WriteToUDP([]byte(response), addr)
+		}
+	}()
+
+	return igd, conn.LocalAddr().(*net.UDPAddr)
+}
+
+func (igd *fakeIGD) control(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	action := r.Header.Get("SOAPAction")
+	args, _ := parseSOAPResponse(body)
+
+	igd.mu.Lock()
+	defer igd.mu.Unlock()
+
+	var response string
+	switch {
+	case strings.HasSuffix(action, `#AddPortMapping"`):
+		if args["NewLeaseDuration"] != "0" {
+			// Only permanent leases are supported, like many real routers
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body><s:Fault><detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>725</errorCode><errorDescription>OnlyPermanentLeasesSupported</errorDescription></UPnPError></detail></s:Fault></s:Body></s:Envelope>`)
+			return
+		}
+		igd.mappings[args["NewExternalPort"]] = args["NewInternalClient"]
+		response = `<u:AddPortMappingResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1"/>`
+	case strings.HasSuffix(action, `#GetExternalIPAddress"`):
+		response = `<u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1"><NewExternalIPAddress>198.51.100.4</NewExternalIPAddress></u:GetExternalIPAddressResponse>`
+	case strings.HasSuffix(action, `#DeletePortMapping"`):
+		delete(igd.mappings, args["NewExternalPort"])
+		response = `<u:DeletePortMappingResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1"/>`
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	fmt.Fprintf(w, `<?xml version="1.0"?><s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>%s</s:Body></s:Envelope>`, response)
+}
+
+func (igd *fakeIGD) client(port string) (string, bool) {
+	igd.mu.Lock()
+	defer igd.mu.Unlock()
+	client, ok := igd.mappings[port]
+	return client, ok
+}
+
+func TestUPnPMapping(t *testing.T) {
+	igd, ssdpAddr := newFakeIGD(t, 15355)
+	client := NewUPnPClientWithSSDP(ssdpAddr)
+
+	mapping := &Mapping{Method: MethodUPnP, Protocol: "udp", InternalPort: 4004, Lifetime: time.Hour}
+	err := client.Map(context.Background(), mapping)
+	assert.NoError(t, err)
+	assert.Equal(t, "198.51.100.4:4004", mapping.ExternalAddr().String())
+
+	internalClient, ok := igd.client("4004")
+	assert.True(t, ok)
+	assert.Equal(t, "127.0.0.1", internalClient)
+
+	assert.NoError(t, client.Unmap(context.Background(), mapping))
+	_, ok = igd.client("4004")
+	assert.False(t, ok)
+}
@@ -0,0 +1,411 @@
+// pkg/portmap/upnp.go
+package portmap
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ssdpSearchTarget is the device type searched for during discovery
+	ssdpSearchTarget = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+
+	// ssdpWait is how long to collect discovery responses
+	ssdpWait = 2 * time.Second
+
+	// upnpDescription labels mappings in the gateway's admin interface
+	upnpDescription = "NATbypass"
+
+	// upnpErrOnlyPermanentLeases is returned by gateways that reject leases
+	upnpErrOnlyPermanentLeases = 725
+)
+
+// SSDPAddr is the multicast address UPnP devices are discovered on
+var SSDPAddr = &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250), Port: 1900}
+
+// upnpServiceTypes are the WAN connection services able to forward ports, in
+// order of preference
+var upnpServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:2",
+	"urn:schemas-upnp-org:service:WANIPConnection:1",
+	"urn:schemas-upnp-org:service:WANPPPConnection:1",
+}
+
+// UPnPClient requests mappings from a UPnP Internet Gateway Device
+type UPnPClient struct {
+	ssdpAddr   *net.UDPAddr
+	httpClient *http.Client
+
+	mu      sync.Mutex
+	service *upnpService // Discovered lazily and cached
+}
+
+// upnpService is a gateway's WAN connection service
+type upnpService struct {
+	controlURL  string
+	serviceType string
+	localIP     net.IP // Our address as seen from the gateway
+}
+
+// upnpDevice is the subset of a UPnP device description we need
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+// NewUPnPClient creates a UPnP client that discovers the gateway over SSDP
+func NewUPnPClient() *UPnPClient {
+	return NewUPnPClientWithSSDP(SSDPAddr)
+}
+
+// NewUPnPClientWithSSDP creates a UPnP client that sends discovery requests
+// to the given address instead of the SSDP multicast group
+func NewUPnPClientWithSSDP(ssdpAddr *net.UDPAddr) *UPnPClient {
+	return &UPnPClient{
+		ssdpAddr:   ssdpAddr,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Method returns MethodUPnP
+func (c *UPnPClient) Method() Method {
+	return MethodUPnP
+}
+
+// Map creates or renews a port forwarding. Gateways that only accept
+// permanent leases get one; it is deleted on Unmap like any other.
+func (c *UPnPClient) Map(ctx context.Context, mapping *Mapping) error {
+	service, err := c.discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	if mapping.ExternalPort == 0 {
+		mapping.ExternalPort = mapping.InternalPort
+	}
+
+	lease := int(mapping.Lifetime / time.Second)
+	err = c.addPortMapping(ctx, service, mapping, lease)
+
+	var upnpErr *upnpError
+	if errors.As(err, &upnpErr) && upnpErr.code == upnpErrOnlyPermanentLeases {
+		err = c.addPortMapping(ctx, service, mapping, 0)
+	}
+	if err != nil {
+		return err
+	}
+
+	response, err := c.soap(ctx, service, "GetExternalIPAddress", nil)
+	if err != nil {
+		return err
+	}
+	externalIP := net.ParseIP(response["NewExternalIPAddress"])
+	if externalIP == nil {
+		return ErrInvalidResponse
+	}
+
+	mapping.ExternalIP = externalIP
+	mapping.Expires = time.Now().Add(mapping.Lifetime)
+	return nil
+}
+
+// Unmap deletes a port forwarding
+func (c *UPnPClient) Unmap(ctx context.Context, mapping *Mapping) error {
+	service, err := c.discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.soap(ctx, service, "DeletePortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(mapping.ExternalPort)},
+		{"NewProtocol", strings.ToUpper(mapping.Protocol)},
+	})
+	return err
+}
+
+// addPortMapping sends an AddPortMapping request
+func (c *UPnPClient) addPortMapping(ctx context.Context, service *upnpService, mapping *Mapping, lease int) error {
+	_, err := c.soap(ctx, service, "AddPortMapping", [][2]string{
+		{"NewRemoteHost", ""},
+		{"NewExternalPort", strconv.Itoa(mapping.ExternalPort)},
+		{"NewProtocol", strings.ToUpper(mapping.Protocol)},
+		{"NewInternalPort", strconv.Itoa(mapping.InternalPort)},
+		{"NewInternalClient", service.localIP.String()},
+		{"NewEnabled", "1"},
+		{"NewPortMappingDescription", upnpDescription},
+		{"NewLeaseDuration", strconv.Itoa(lease)},
+	})
+	return err
+}
+
+// discover finds the gateway's WAN connection service, caching the result
+func (c *UPnPClient) discover(ctx context.Context) (*upnpService, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.service != nil {
+		return c.service, nil
+	}
+
+	locations, err := c.search(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, location := range locations {
+		service, err := c.describe(ctx, location)
+		if err == nil {
+			c.service = service
+			return service, nil
+		}
+	}
+
+	return nil, ErrUnsupported
+}
+
+// search sends an SSDP M-SEARCH and collects device description locations
+func (c *UPnPClient) search(ctx context.Context) ([]string, error) {
+	conn, err := net.ListenUDP("udp4", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	request := fmt.Sprintf("M-SEARCH * HTTP/1.1\r\n"+
+		"HOST: %s\r\n"+
+		"MAN: \"ssdp:discover\"\r\n"+
+		"MX: 2\r\n"+
+		"ST: %s\r\n\r\n", SSDPAddr.String(), ssdpSearchTarget)
+	if _, err := conn.WriteToUDP([]byte(request), c.ssdpAddr); err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(ssdpWait)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetReadDeadline(deadline)
+
+	var locations []string
+	buffer := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			break
+		}
+
+		response, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buffer[:n])), nil)
+		if err != nil {
+			continue
+		}
+		response.Body.Close()
+
+		if location := response.Header.Get("Location"); location != "" {
+			locations = append(locations, location)
+			// The first gateway is good enough, stop waiting for others
+			break
+		}
+	}
+
+	if len(locations) == 0 {
+		return nil, ErrUnsupported
+	}
+	return locations, nil
+}
+
+// describe fetches a device description and picks its WAN connection service
+func (c *UPnPClient) describe(ctx context.Context, location string) (*upnpService, error) {
+	base, err := url.Parse(location)
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var root struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(response.Body).Decode(&root); err != nil {
+		return nil, err
+	}
+	if root.URLBase != "" {
+		if urlBase, err := url.Parse(root.URLBase); err == nil {
+			base = urlBase
+		}
+	}
+
+	for _, serviceType := range upnpServiceTypes {
+		controlURL, found := findService(&root.Device, serviceType)
+		if !found {
+			continue
+		}
+
+		resolved, err := base.Parse(controlURL)
+		if err != nil {
+			return nil, err
+		}
+
+		localIP, err := localIPFor(resolved.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		return &upnpService{
+			controlURL:  resolved.String(),
+			serviceType: serviceType,
+			localIP:     localIP,
+		}, nil
+	}
+
+	return nil, ErrUnsupported
+}
+
+// findService searches a device tree for a service type
+func findService(device *upnpDevice, serviceType string) (string, bool) {
+	for _, service := range device.Services {
+		if service.ServiceType == serviceType {
+			return service.ControlURL, true
+		}
+	}
+
+	for i := range device.Devices {
+		if controlURL, found := findService(&device.Devices[i], serviceType); found {
+			return controlURL, true
+		}
+	}
+
+	return "", false
+}
+
+// localIPFor returns the local address used to reach a host
+func localIPFor(hostport string) (net.IP, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host, port = hostport, "80"
+	}
+
+	conn, err := net.Dial("udp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// upnpError is a UPnP fault returned by a SOAP action
+type upnpError struct {
+	action string
+	code   int
+	desc   string
+}
+
+func (e *upnpError) Error() string {
+	return fmt.Sprintf("UPnP %s failed: %d %s", e.action, e.code, e.desc)
+}
+
+// soap invokes an action on the service and returns the response arguments
+func (c *UPnPClient) soap(ctx context.Context, service *upnpService, action string, args [][2]string) (map[string]string, error) {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body>`)
+	fmt.Fprintf(&body, `<u:%s xmlns:u="%s">`, action, service.serviceType)
+	for _, arg := range args {
+		fmt.Fprintf(&body, "<%s>", arg[0])
+		xml.EscapeText(&body, []byte(arg[1]))
+		fmt.Fprintf(&body, "</%s>", arg[0])
+	}
+	fmt.Fprintf(&body, `</u:%s></s:Body></s:Envelope>`, action)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, service.controlURL, &body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	request.Header.Set("SOAPAction", fmt.Sprintf(`"%s#%s"`, service.serviceType, action))
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK {
+		var fault struct {
+			Code int    `xml:"Body>Fault>detail>UPnPError>errorCode"`
+			Desc string `xml:"Body>Fault>detail>UPnPError>errorDescription"`
+		}
+		if xml.Unmarshal(data, &fault) == nil && fault.Code != 0 {
+			return nil, &upnpError{action: action, code: fault.Code, desc: fault.Desc}
+		}
+		return nil, fmt.Errorf("UPnP %s failed: HTTP %d", action, response.StatusCode)
+	}
+
+	return parseSOAPResponse(data)
+}
+
+// parseSOAPResponse returns the output arguments of a SOAP action response
+func parseSOAPResponse(data []byte) (map[string]string, error) {
+	values := make(map[string]string)
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+
+	// Arguments are the leaf elements inside the action response element,
+	// which sits three levels deep: Envelope > Body > ActionResponse
+	depth := 0
+	var name string
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return values, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			name = t.Name.Local
+			text.Reset()
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if depth == 4 && t.Name.Local == name {
+				values[name] = strings.TrimSpace(text.String())
+			}
+			depth--
+		}
+	}
+}
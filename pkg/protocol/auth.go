@@ -0,0 +1,149 @@
+package protocol
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	// authTrailerMarker identifies an authentication trailer following the payload
+	authTrailerMarker byte = 0xA5
+
+	// MACSize is the size of the HMAC-SHA256 tag carried by authenticated packets
+	MACSize = sha256.Size
+
+	// MaxAuthClientIDLength is the longest client ID an auth trailer can carry
+	MaxAuthClientIDLength = 255
+)
+
+// Errors related to packet authentication
+var (
+	ErrNotAuthenticated   = errors.New("packet is not authenticated")
+	ErrInvalidMAC         = errors.New("packet MAC verification failed")
+	ErrInvalidAuthTrailer = errors.New("invalid authentication trailer")
+)
+
+// AuthTrailer carries the per-packet authentication data. It is serialized
+// after the payload, so peers that don't know about it still parse the packet.
+type AuthTrailer struct {
+	ClientID string
+	KeyID    uint32 // Identifies which issued key signed the packet
+	Sequence uint64 // Monotonic per-sender counter used for replay protection
+	MAC      []byte
+}
+
+// DeriveClientKey derives the per-client packet key from the rendezvous master secret.
+// The signaling server hands this key to the client at registration and the
+// rendezvous servers re-derive it from the trailer, so no key state is shared.
+func DeriveClientKey(masterKey []byte, clientID string, keyID uint32) []byte {
+	mac := hmac.New(sha256.New, masterKey)
+	mac.Write([]byte(clientID))
+
+	var id [4]byte
+	binary.BigEndian.PutUint32(id[:], keyID)
+	mac.Write(id[:])
+
+	return mac.Sum(nil)
+}
+
+// Sign attaches an authentication trailer to the packet using the given key
+func (p *Packet) Sign(clientID string, keyID uint32, sequence uint64, key []byte) error {
+	if len(clientID) == 0 || len(clientID) > MaxAuthClientIDLength {
+		return errors.New("invalid client ID length for authentication")
+	}
+
+	p.Auth = &AuthTrailer{
+		ClientID: clientID,
+		KeyID:    keyID,
+		Sequence: sequence,
+	}
+
+	data, err := p.authenticatedBytes()
+	if err != nil {
+		p.Auth = nil
+		return err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	p.Auth.MAC = mac.Sum(nil)
+
+	return nil
+}
+
+// Verify checks the packet's MAC against the given key
+func (p *Packet) Verify(key []byte) error {
+	if p.Auth == nil {
+		return ErrNotAuthenticated
+	}
+
+	data, err := p.authenticatedBytes()
+	if err != nil {
+		return err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	if !hmac.Equal(mac.Sum(nil), p.Auth.MAC) {
+		return ErrInvalidMAC
+	}
+
+	return nil
+}
+
+// authenticatedBytes returns the bytes covered by the MAC: the packet header,
+// the payload and every trailer field except the MAC itself
+func (p *Packet) authenticatedBytes() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := p.writeHeaderAndPayload(buf); err != nil {
+		return nil, err
+	}
+	p.Auth.writeFields(buf)
+	return buf.Bytes(), nil
+}
+
+// writeFields writes the trailer fields that precede the MAC
+func (a *AuthTrailer) writeFields(buf *bytes.Buffer) {
+	buf.WriteByte(authTrailerMarker)
+	buf.WriteByte(byte(len(a.ClientID)))
+	buf.WriteString(a.ClientID)
+	binary.Write(buf, binary.BigEndian, a.KeyID)
+	binary.Write(buf, binary.BigEndian, a.Sequence)
+}
+
+// parseAuthTrailer decodes an authentication trailer, returning nil if the
+// data doesn't start with a trailer marker
+func parseAuthTrailer(data []byte) (*AuthTrailer, error) {
+	if len(data) == 0 || data[0] != authTrailerMarker {
+		return nil, nil
+	}
+
+	if len(data) < 2 {
+		return nil, ErrInvalidAuthTrailer
+	}
+
+	idLen := int(data[1])
+	expected := 2 + idLen + 4 + 8 + MACSize
+	if idLen == 0 || len(data) < expected {
+		return nil, ErrInvalidAuthTrailer
+	}
+
+	offset := 2
+	trailer := &AuthTrailer{
+		ClientID: string(data[offset : offset+idLen]),
+	}
+	offset += idLen
+
+	trailer.KeyID = binary.BigEndian.Uint32(data[offset : offset+4])
+	offset += 4
+
+	trailer.Sequence = binary.BigEndian.Uint64(data[offset : offset+8])
+	offset += 8
+
+	trailer.MAC = data[offset : offset+MACSize]
+
+	return trailer, nil
+}
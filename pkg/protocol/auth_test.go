@@ -0,0 +1,55 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignedPacketRoundTrip(t *testing.T) {
+	key := DeriveClientKey([]byte("master-secret"), "client-1", 1700000000)
+
+	packet := &Packet{
+		Type:    PacketTypeRegistration,
+		Payload: []byte("client-1"),
+	}
+	assert.NoError(t, packet.Sign("client-1", 1700000000, 42, key))
+
+	data, err := packet.Serialize()
+	assert.NoError(t, err)
+	assert.Equal(t, len(data), packet.Size())
+
+	parsed, err := ParsePacket(data)
+	assert.NoError(t, err)
+	assert.NotNil(t, parsed.Auth)
+	assert.Equal(t, len(data), parsed.Size())
+	assert.Equal(t, "client-1", parsed.Auth.ClientID)
+	assert.Equal(t, uint32(1700000000), parsed.Auth.KeyID)
+	assert.Equal(t, uint64(42), parsed.Auth.Sequence)
+	assert.NoError(t, parsed.Verify(key))
+
+	// A different key must not verify
+	otherKey := DeriveClientKey([]byte("master-secret"), "client-2", 1700000000)
+	assert.Equal(t, ErrInvalidMAC, parsed.Verify(otherKey))
+
+	// Tampering with the payload must break the MAC
+	data[HeaderSize] ^= 0xFF
+	tampered, err := ParsePacket(data)
+	assert.NoError(t, err)
+	assert.Equal(t, ErrInvalidMAC, tampered.Verify(key))
+}
+
+func TestUnsignedPacketHasNoAuth(t *testing.T) {
+	packet := &Packet{Type: PacketTypeKeepAlive, Payload: []byte{}}
+	data, err := packet.Serialize()
+	assert.NoError(t, err)
+
+	parsed, err := ParsePacket(data)
+	assert.NoError(t, err)
+	assert.Nil(t, parsed.Auth)
+	assert.Equal(t, ErrNotAuthenticated, parsed.Verify([]byte("key")))
+
+	// A truncated trailer is rejected
+	_, err = ParsePacket(append(data, authTrailerMarker, 4, 'a'))
+	assert.Equal(t, ErrInvalidAuthTrailer, err)
+}
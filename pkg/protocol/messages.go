@@ -24,8 +24,27 @@ const (
 
 	// TypeKeepAlive is used to maintain NAT mappings
 	TypeKeepAlive MessageType = "keep-alive"
+
+	// TypePresence tells the members of a group that a client joined or
+	// left it
+	TypePresence MessageType = "presence"
+)
+
+// Presence events
+const (
+	PresenceJoined = "joined"
+	PresenceLeft   = "left"
 )
 
+// PresencePayload is the payload of presence messages. The message's
+// ClientID is the client that joined or left.
+type PresencePayload struct {
+	Group      string            `json:"group"`
+	Event      string            `json:"event"`
+	Name       string            `json:"name,omitempty"`
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
 // Message represents the base structure for all protocol messages
 type Message struct {
 	Type      MessageType     `json:"type"`
@@ -33,6 +52,10 @@ type Message struct {
 	TargetID  string          `json:"target_id,omitempty"`
 	Timestamp time.Time       `json:"timestamp"`
 	Payload   json.RawMessage `json:"payload"`
+
+	// TraceContext carries the sender's W3C trace context so the receiver's
+	// spans join the same trace
+	TraceContext map[string]string `json:"trace_context,omitempty"`
 }
 
 // NewMessage creates a new message of the specified type
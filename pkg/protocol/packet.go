@@ -3,8 +3,10 @@ package protocol
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"net"
+	"net/netip"
 )
 
 const (
@@ -20,21 +22,24 @@ type PacketType byte
 
 const (
 	PacketTypeUnknown           PacketType = 0
-	PacketTypeRegistration      PacketType = 1
-	PacketTypeRegistrationAck   PacketType = 2
+	PacketTypeRegistration      PacketType = 1 // Payload is a Registration
+	PacketTypeRegistrationAck   PacketType = 2 // Payload is the client's address as seen by the server
 	PacketTypeHolePunch         PacketType = 3
 	PacketTypeHolePunchResponse PacketType = 4
 	PacketTypeHolePunchAck      PacketType = 5
 	PacketTypeData              PacketType = 6
-	PacketTypeKeepAlive         PacketType = 7
+	PacketTypeKeepAlive         PacketType = 7 // Refreshes the sender's session, never answered
 	PacketTypeError             PacketType = 8
+	PacketTypeRelayBind         PacketType = 9  // Payload is the relay session ID
+	PacketTypeRelayBindAck      PacketType = 10 // Payload is "waiting" or "paired"
 )
 
 // Packet represents a protocol packet
 type Packet struct {
 	Type       PacketType
 	Payload    []byte
-	SourceAddr net.Addr // Not serialized, used internally
+	Auth       *AuthTrailer // Optional, serialized after the payload when present
+	SourceAddr net.Addr     // Not serialized, used internally
 }
 
 // ParsePacket parses a byte slice into a Packet
@@ -57,35 +62,165 @@ func ParsePacket(data []byte) (*Packet, error) {
 		Payload: data[HeaderSize : HeaderSize+payloadLength],
 	}
 
+	// Anything after the payload may be an authentication trailer
+	auth, err := parseAuthTrailer(data[HeaderSize+payloadLength:])
+	if err != nil {
+		return nil, err
+	}
+	packet.Auth = auth
+
 	return packet, nil
 }
 
 // Serialize converts a Packet into a byte slice
 func (p *Packet) Serialize() ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	if err := p.writeHeaderAndPayload(buf); err != nil {
+		return nil, err
+	}
+
+	// Write authentication trailer if the packet was signed
+	if p.Auth != nil {
+		p.Auth.writeFields(buf)
+		buf.Write(p.Auth.MAC)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Size returns the length of the serialized packet
+func (p *Packet) Size() int {
+	size := HeaderSize + len(p.Payload)
+	if p.Auth != nil {
+		size += 2 + len(p.Auth.ClientID) + 4 + 8 + len(p.Auth.MAC)
+	}
+	return size
+}
+
+// writeHeaderAndPayload writes the packet type, payload length and payload
+func (p *Packet) writeHeaderAndPayload(buf *bytes.Buffer) error {
 	payloadLen := len(p.Payload)
 	if payloadLen > MaxPacketSize-HeaderSize {
-		return nil, errors.New("payload too large")
+		return errors.New("payload too large")
 	}
 
-	buf := new(bytes.Buffer)
-
 	// Write packet type (1 byte)
 	err := buf.WriteByte(byte(p.Type))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// Write payload length (4 bytes)
 	err = binary.Write(buf, binary.BigEndian, uint32(payloadLen))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	// Write payload
 	_, err = buf.Write(p.Payload)
-	if err != nil {
+	return err
+}
+
+// PunchInstruction tells a peer which endpoint to open a connection towards and
+// how long to wait before doing so, so both sides start at roughly the same time
+type PunchInstruction struct {
+	PeerID      string   `json:"peer_id"`
+	PeerAddr    string   `json:"peer_addr"`
+	DelayMillis int64    `json:"delay_ms"`
+	Candidates  []string `json:"candidates,omitempty"` // Endpoints to try in order, private ones first when peers share a NAT
+}
+
+// EncodePunchInstruction serializes a punch instruction into a packet payload
+func EncodePunchInstruction(instruction *PunchInstruction) ([]byte, error) {
+	return json.Marshal(instruction)
+}
+
+// DecodePunchInstruction parses a punch instruction from a packet payload
+func DecodePunchInstruction(payload []byte) (*PunchInstruction, error) {
+	var instruction PunchInstruction
+	if err := json.Unmarshal(payload, &instruction); err != nil {
 		return nil, err
 	}
+	return &instruction, nil
+}
 
-	return buf.Bytes(), nil
+// PunchCandidates returns the endpoints to try from a hole punch payload, in
+// order. The payload is either a bare address or an encoded PunchInstruction.
+func PunchCandidates(payload []byte) []string {
+	if len(payload) == 0 || payload[0] != '{' {
+		return []string{string(payload)}
+	}
+
+	instruction, err := DecodePunchInstruction(payload)
+	if err != nil {
+		return nil
+	}
+	if len(instruction.Candidates) > 0 {
+		return instruction.Candidates
+	}
+	return []string{instruction.PeerAddr}
+}
+
+// MaxLocalAddrs is the most private endpoints a client may announce.
+// Servers ignore the rest.
+const MaxLocalAddrs = 8
+
+// LocalEndpoints keeps the announced addresses that are valid private
+// endpoints: literal private or link-local IP:port pairs with a port.
+// Public addresses are dropped so a client can't point peers at arbitrary
+// hosts, and loopback ones since they would point peers at themselves.
+// Duplicates are dropped and at most MaxLocalAddrs are kept.
+func LocalEndpoints(addrs []string) []netip.AddrPort {
+	var endpoints []netip.AddrPort
+	seen := make(map[netip.AddrPort]bool)
+	for _, addr := range addrs {
+		if len(endpoints) == MaxLocalAddrs {
+			break
+		}
+
+		// Only literal addresses are accepted, announcements never trigger lookups
+		addrPort, err := netip.ParseAddrPort(addr)
+		if err != nil || addrPort.Port() == 0 {
+			continue
+		}
+		addrPort = netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port())
+
+		ip := addrPort.Addr()
+		if !(ip.IsPrivate() || ip.IsLinkLocalUnicast()) || seen[addrPort] {
+			continue
+		}
+		seen[addrPort] = true
+		endpoints = append(endpoints, addrPort)
+	}
+	return endpoints
+}
+
+// Registration announces a client to a rendezvous server along with the
+// private endpoints it can be reached on inside its own network
+type Registration struct {
+	ClientID   string   `json:"client_id"`
+	LocalAddrs []string `json:"local_addrs,omitempty"`
+}
+
+// EncodeRegistration serializes a registration into a packet payload. Without
+// local addresses the payload is the bare client ID, as older servers expect.
+func EncodeRegistration(registration *Registration) ([]byte, error) {
+	if len(registration.LocalAddrs) == 0 {
+		return []byte(registration.ClientID), nil
+	}
+	return json.Marshal(registration)
+}
+
+// DecodeRegistration parses a registration payload in either encoding
+func DecodeRegistration(payload []byte) (*Registration, error) {
+	if len(payload) == 0 || payload[0] != '{' {
+		return &Registration{ClientID: string(payload)}, nil
+	}
+
+	var registration Registration
+	if err := json.Unmarshal(payload, &registration); err != nil {
+		return nil, err
+	}
+	return &registration, nil
 }
@@ -0,0 +1,78 @@
+package protocol
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistrationEncoding(t *testing.T) {
+	// Without local addresses the payload stays a bare client ID
+	payload, err := EncodeRegistration(&Registration{ClientID: "client-a"})
+	assert.NoError(t, err)
+	assert.Equal(t, "client-a", string(payload))
+
+	registration, err := DecodeRegistration(payload)
+	assert.NoError(t, err)
+	assert.Equal(t, "client-a", registration.ClientID)
+	assert.Empty(t, registration.LocalAddrs)
+
+	payload, err = EncodeRegistration(&Registration{
+		ClientID:   "client-a",
+		LocalAddrs: []string{"192.168.1.20:4000"},
+	})
+	assert.NoError(t, err)
+
+	registration, err = DecodeRegistration(payload)
+	assert.NoError(t, err)
+	assert.Equal(t, "client-a", registration.ClientID)
+	assert.Equal(t, []string{"192.168.1.20:4000"}, registration.LocalAddrs)
+
+	_, err = DecodeRegistration([]byte("{broken"))
+	assert.Error(t, err)
+}
+
+func TestPunchCandidates(t *testing.T) {
+	assert.Equal(t, []string{"203.0.113.7:4000"}, PunchCandidates([]byte("203.0.113.7:4000")))
+
+	payload, err := EncodePunchInstruction(&PunchInstruction{PeerID: "peer", PeerAddr: "203.0.113.7:4000"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"203.0.113.7:4000"}, PunchCandidates(payload))
+
+	payload, err = EncodePunchInstruction(&PunchInstruction{
+		PeerID:     "peer",
+		PeerAddr:   "203.0.113.7:4000",
+		Candidates: []string{"192.168.1.20:4000", "203.0.113.7:4000"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"192.168.1.20:4000", "203.0.113.7:4000"}, PunchCandidates(payload))
+}
+
+func TestLocalEndpoints(t *testing.T) {
+	endpoints := LocalEndpoints([]string{
+		"192.168.1.20:4000",
+		"203.0.113.7:4000",       // Public
+		"example.com:4000",       // Not a literal address
+		"10.0.0.5:0",             // No port
+		"127.0.0.1:4000",         // Loopback
+		"[::1]:4000",             // Loopback
+		"[::ffff:10.0.0.6]:4000", // IPv4-mapped, unmapped
+		"192.168.1.20:4000",      // Duplicate
+		"[fe80::1%eth0]:4000",    // Link-local
+		"[fd00::5]:4000",
+	})
+
+	var got []string
+	for _, endpoint := range endpoints {
+		got = append(got, endpoint.String())
+	}
+	assert.Equal(t, []string{"192.168.1.20:4000", "10.0.0.6:4000", "[fe80::1%eth0]:4000", "[fd00::5]:4000"}, got)
+
+	// At most MaxLocalAddrs are kept
+	var many []string
+	for i := 1; i <= 2*MaxLocalAddrs; i++ {
+		many = append(many, fmt.Sprintf("10.0.0.%d:4000", i))
+	}
+	assert.Len(t, LocalEndpoints(many), MaxLocalAddrs)
+}
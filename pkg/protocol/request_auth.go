@@ -0,0 +1,100 @@
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Headers carrying the signature of a signaling API request. Registered
+// clients sign their requests with the session key issued at registration.
+const (
+	HeaderClientID  = "X-Natbypass-Client-Id"
+	HeaderKeyID     = "X-Natbypass-Key-Id"
+	HeaderTimestamp = "X-Natbypass-Timestamp"
+	HeaderSignature = "X-Natbypass-Signature"
+)
+
+// MaxRequestSkew is how far a signed request's timestamp may be from the
+// server's clock
+const MaxRequestSkew = 5 * time.Minute
+
+// Errors related to request authentication
+var (
+	ErrRequestNotSigned        = errors.New("request is not signed")
+	ErrInvalidRequestSignature = errors.New("request signature verification failed")
+	ErrStaleRequest            = errors.New("request timestamp is too far from the server's clock")
+)
+
+// RequestAuth is the signature of a request, as carried in its headers
+type RequestAuth struct {
+	ClientID  string
+	KeyID     uint32
+	Timestamp int64 // Unix nanoseconds
+	Signature []byte
+}
+
+// RequestSignature returns the MAC of a request: its method, URI (path and
+// query), timestamp and the hash of its body
+func RequestSignature(key []byte, method, uri string, timestamp int64, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(method + "\n" + uri + "\n" + strconv.FormatInt(timestamp, 10) + "\n"))
+	mac.Write([]byte(hex.EncodeToString(bodyHash[:])))
+	return mac.Sum(nil)
+}
+
+// SignRequest sets the headers authenticating a request sent at now
+func SignRequest(header http.Header, clientID string, keyID uint32, key []byte, method, uri string, body []byte, now time.Time) {
+	timestamp := now.UnixNano()
+	header.Set(HeaderClientID, clientID)
+	header.Set(HeaderKeyID, strconv.FormatUint(uint64(keyID), 10))
+	header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	header.Set(HeaderSignature, hex.EncodeToString(RequestSignature(key, method, uri, timestamp, body)))
+}
+
+// ParseRequestAuth reads the signature headers of a request, returning
+// ErrRequestNotSigned if there are none
+func ParseRequestAuth(header http.Header) (*RequestAuth, error) {
+	if header.Get(HeaderSignature) == "" {
+		return nil, ErrRequestNotSigned
+	}
+
+	keyID, err := strconv.ParseUint(header.Get(HeaderKeyID), 10, 32)
+	if err != nil {
+		return nil, ErrInvalidRequestSignature
+	}
+	timestamp, err := strconv.ParseInt(header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return nil, ErrInvalidRequestSignature
+	}
+	signature, err := hex.DecodeString(header.Get(HeaderSignature))
+	if err != nil {
+		return nil, ErrInvalidRequestSignature
+	}
+
+	return &RequestAuth{
+		ClientID:  header.Get(HeaderClientID),
+		KeyID:     uint32(keyID),
+		Timestamp: timestamp,
+		Signature: signature,
+	}, nil
+}
+
+// Verify checks the signature against the given key and the request's
+// timestamp against now
+func (a *RequestAuth) Verify(key []byte, method, uri string, body []byte, now time.Time) error {
+	skew := now.Sub(time.Unix(0, a.Timestamp))
+	if skew > MaxRequestSkew || skew < -MaxRequestSkew {
+		return ErrStaleRequest
+	}
+	if !hmac.Equal(RequestSignature(key, method, uri, a.Timestamp, body), a.Signature) {
+		return ErrInvalidRequestSignature
+	}
+	return nil
+}
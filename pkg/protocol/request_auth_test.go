@@ -0,0 +1,41 @@
+package protocol
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignedRequest(t *testing.T) {
+	key := DeriveClientKey([]byte("master-secret"), "client-1", 1700000000)
+	now := time.Now()
+	body := []byte(`{"client_id":"client-1"}`)
+
+	header := make(http.Header)
+	SignRequest(header, "client-1", 1700000000, key, http.MethodPost, "/api/v1/heartbeat", body, now)
+
+	auth, err := ParseRequestAuth(header)
+	assert.NoError(t, err)
+	assert.Equal(t, "client-1", auth.ClientID)
+	assert.Equal(t, uint32(1700000000), auth.KeyID)
+	assert.NoError(t, auth.Verify(key, http.MethodPost, "/api/v1/heartbeat", body, now))
+
+	// Any change to the request breaks the signature
+	assert.ErrorIs(t, auth.Verify(key, http.MethodPost, "/api/v1/heartbeat", []byte(`{}`), now), ErrInvalidRequestSignature)
+	assert.ErrorIs(t, auth.Verify(key, http.MethodPost, "/api/v1/signal", body, now), ErrInvalidRequestSignature)
+	assert.ErrorIs(t, auth.Verify(key, http.MethodGet, "/api/v1/heartbeat", body, now), ErrInvalidRequestSignature)
+	other := DeriveClientKey([]byte("master-secret"), "client-2", 1700000000)
+	assert.ErrorIs(t, auth.Verify(other, http.MethodPost, "/api/v1/heartbeat", body, now), ErrInvalidRequestSignature)
+
+	// Old requests can't be replayed later
+	later := now.Add(MaxRequestSkew + time.Second)
+	assert.ErrorIs(t, auth.Verify(key, http.MethodPost, "/api/v1/heartbeat", body, later), ErrStaleRequest)
+
+	_, err = ParseRequestAuth(make(http.Header))
+	assert.ErrorIs(t, err, ErrRequestNotSigned)
+	header.Set(HeaderKeyID, "not-a-number")
+	_, err = ParseRequestAuth(header)
+	assert.ErrorIs(t, err, ErrInvalidRequestSignature)
+}
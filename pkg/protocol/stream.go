@@ -0,0 +1,130 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	// FrameHeaderSize is the size of the length prefix in front of each packet on a stream
+	FrameHeaderSize = 4
+
+	// MaxFrameSize is the largest serialized packet accepted on a stream,
+	// including an authentication trailer
+	MaxFrameSize = MaxPacketSize + 2 + MaxAuthClientIDLength + 4 + 8 + MACSize
+)
+
+// ErrFrameTooLarge is returned when a stream frame exceeds the decoder's limit
+var ErrFrameTooLarge = errors.New("stream frame too large")
+
+// EncodeFrame serializes a packet with a length prefix for stream transports such as TCP
+func EncodeFrame(p *Packet) ([]byte, error) {
+	data, err := p.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	frame := make([]byte, FrameHeaderSize+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[FrameHeaderSize:], data)
+
+	return frame, nil
+}
+
+// WriteFrame writes a length-prefixed packet to a stream
+func WriteFrame(w io.Writer, p *Packet) error {
+	frame, err := EncodeFrame(p)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(frame)
+	return err
+}
+
+// StreamDecoder reassembles length-prefixed packets from a byte stream.
+// Bytes are fed in as they arrive, so a read that ends mid-frame (or times
+// out) loses nothing; the frame completes on a later write.
+type StreamDecoder struct {
+	buf          []byte
+	maxFrameSize int
+}
+
+// NewStreamDecoder creates a decoder that rejects frames larger than maxFrameSize.
+// A non-positive limit defaults to MaxFrameSize.
+func NewStreamDecoder(maxFrameSize int) *StreamDecoder {
+	if maxFrameSize <= 0 || maxFrameSize > MaxFrameSize {
+		maxFrameSize = MaxFrameSize
+	}
+
+	return &StreamDecoder{
+		maxFrameSize: maxFrameSize,
+	}
+}
+
+// Write appends stream data to the decoder
+func (d *StreamDecoder) Write(data []byte) (int, error) {
+	d.buf = append(d.buf, data...)
+	return len(data), nil
+}
+
+// Next returns the next complete packet, or nil if more data is needed.
+// An error means the stream is corrupt and should be closed.
+func (d *StreamDecoder) Next() (*Packet, error) {
+	if len(d.buf) < FrameHeaderSize {
+		return nil, nil
+	}
+
+	frameLen := int(binary.BigEndian.Uint32(d.buf))
+	if frameLen > d.maxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+
+	if len(d.buf) < FrameHeaderSize+frameLen {
+		return nil, nil
+	}
+
+	// Copy the frame out so the packet doesn't alias the decoder's buffer
+	frame := make([]byte, frameLen)
+	copy(frame, d.buf[FrameHeaderSize:FrameHeaderSize+frameLen])
+
+	remaining := copy(d.buf, d.buf[FrameHeaderSize+frameLen:])
+	d.buf = d.buf[:remaining]
+
+	return ParsePacket(frame)
+}
+
+// Reset drops any buffered data, e.g. the rest of a corrupt datagram
+func (d *StreamDecoder) Reset() {
+	d.buf = d.buf[:0]
+}
+
+// Buffered returns the number of bytes waiting for a complete frame
+func (d *StreamDecoder) Buffered() int {
+	return len(d.buf)
+}
+
+// ReadFrame reads a single length-prefixed packet from a blocking stream
+func ReadFrame(r io.Reader, maxFrameSize int) (*Packet, error) {
+	if maxFrameSize <= 0 || maxFrameSize > MaxFrameSize {
+		maxFrameSize = MaxFrameSize
+	}
+
+	var header [FrameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	frameLen := int(binary.BigEndian.Uint32(header[:]))
+	if frameLen > maxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+
+	frame := make([]byte, frameLen)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+
+	return ParsePacket(frame)
+}
@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamDecoderReassemblesFrames(t *testing.T) {
+	first, err := EncodeFrame(&Packet{Type: PacketTypeRegistration, Payload: []byte("client-1")})
+	assert.NoError(t, err)
+	second, err := EncodeFrame(&Packet{Type: PacketTypeKeepAlive})
+	assert.NoError(t, err)
+
+	stream := append(first, second...)
+	decoder := NewStreamDecoder(0)
+
+	// Feed one byte at a time; each packet should appear exactly once
+	var packets []*Packet
+	for _, b := range stream {
+		decoder.Write([]byte{b})
+		packet, err := decoder.Next()
+		assert.NoError(t, err)
+		if packet != nil {
+			packets = append(packets, packet)
+		}
+	}
+
+	assert.Len(t, packets, 2)
+	assert.Equal(t, PacketTypeRegistration, packets[0].Type)
+	assert.Equal(t, []byte("client-1"), packets[0].Payload)
+	assert.Equal(t, PacketTypeKeepAlive, packets[1].Type)
+	assert.Equal(t, 0, decoder.Buffered())
+}
+
+func TestStreamDecoderRejectsOversizedFrame(t *testing.T) {
+	decoder := NewStreamDecoder(16)
+
+	var header [FrameHeaderSize]byte
+	binary.BigEndian.PutUint32(header[:], 17)
+	decoder.Write(header[:])
+
+	_, err := decoder.Next()
+	assert.Equal(t, ErrFrameTooLarge, err)
+}
+
+func TestReadWriteFrame(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, WriteFrame(&buf, &Packet{Type: PacketTypeData, Payload: []byte("hello")}))
+
+	packet, err := ReadFrame(&buf, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, PacketTypeData, packet.Type)
+	assert.Equal(t, []byte("hello"), packet.Payload)
+}
@@ -0,0 +1,153 @@
+// pkg/stats/stats.go
+
+// Package stats keeps per-connection traffic counters and round trip time
+// estimates. Counters are safe for concurrent use, so the send and receive
+// paths of a connection can update them without extra locking.
+package stats
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Counters tracks traffic and latency for one connection. A nil *Counters
+// ignores updates and reports zeros, so optional counters need no checks.
+type Counters struct {
+	bytesIn     uint64
+	bytesOut    uint64
+	packetsIn   uint64
+	packetsOut  uint64
+	retransmits uint64
+	probesSent  uint64
+	probesAcked uint64
+
+	mu        sync.Mutex
+	lastRTT   time.Duration
+	smoothRTT time.Duration
+	rttVar    time.Duration
+	lastSeen  time.Time
+}
+
+// Snapshot is a point-in-time copy of a connection's counters
+type Snapshot struct {
+	BytesIn     uint64        `json:"bytes_in"`
+	BytesOut    uint64        `json:"bytes_out"`
+	PacketsIn   uint64        `json:"packets_in"`
+	PacketsOut  uint64        `json:"packets_out"`
+	Retransmits uint64        `json:"retransmits"`
+	LastRTT     time.Duration `json:"last_rtt_ns"`
+	SmoothedRTT time.Duration `json:"smoothed_rtt_ns"`
+	RTTVariance time.Duration `json:"rtt_variance_ns"`
+	Loss        float64       `json:"loss"`
+	LastSeen    time.Time     `json:"last_seen,omitempty"`
+}
+
+// NewCounters creates zeroed counters
+func NewCounters() *Counters {
+	return &Counters{}
+}
+
+// RecordSent counts one outgoing packet of n bytes
+func (c *Counters) RecordSent(n int) {
+	if c == nil {
+		return
+	}
+	atomic.AddUint64(&c.packetsOut, 1)
+	atomic.AddUint64(&c.bytesOut, uint64(n))
+}
+
+// RecordReceived counts one incoming packet of n bytes
+func (c *Counters) RecordReceived(n int) {
+	if c == nil {
+		return
+	}
+	atomic.AddUint64(&c.packetsIn, 1)
+	atomic.AddUint64(&c.bytesIn, uint64(n))
+
+	c.mu.Lock()
+	c.lastSeen = time.Now()
+	c.mu.Unlock()
+}
+
+// RecordRetransmit counts a packet sent again because the first copy was
+// not acknowledged
+func (c *Counters) RecordRetransmit() {
+	if c == nil {
+		return
+	}
+	atomic.AddUint64(&c.retransmits, 1)
+}
+
+// RecordProbe counts a packet that expects an answer, such as a punch or
+// keep-alive. Probes without an answer make up the loss estimate.
+func (c *Counters) RecordProbe() {
+	if c == nil {
+		return
+	}
+	atomic.AddUint64(&c.probesSent, 1)
+}
+
+// RecordRTT records the round trip time of an answered probe. The smoothed
+// RTT and its variance follow RFC 6298.
+func (c *Counters) RecordRTT(rtt time.Duration) {
+	if c == nil || rtt < 0 {
+		return
+	}
+	atomic.AddUint64(&c.probesAcked, 1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lastRTT = rtt
+	if c.smoothRTT == 0 {
+		c.smoothRTT = rtt
+		c.rttVar = rtt / 2
+		return
+	}
+
+	delta := c.smoothRTT - rtt
+	if delta < 0 {
+		delta = -delta
+	}
+	c.rttVar = (3*c.rttVar + delta) / 4
+	c.smoothRTT = (7*c.smoothRTT + rtt) / 8
+}
+
+// Loss estimates the fraction of probes that went unanswered
+func (c *Counters) Loss() float64 {
+	if c == nil {
+		return 0
+	}
+	sent := atomic.LoadUint64(&c.probesSent)
+	acked := atomic.LoadUint64(&c.probesAcked)
+	if sent == 0 || acked >= sent {
+		return 0
+	}
+	return float64(sent-acked) / float64(sent)
+}
+
+// Snapshot returns a copy of the current counters
+func (c *Counters) Snapshot() Snapshot {
+	if c == nil {
+		return Snapshot{}
+	}
+
+	snapshot := Snapshot{
+		BytesIn:     atomic.LoadUint64(&c.bytesIn),
+		BytesOut:    atomic.LoadUint64(&c.bytesOut),
+		PacketsIn:   atomic.LoadUint64(&c.packetsIn),
+		PacketsOut:  atomic.LoadUint64(&c.packetsOut),
+		Retransmits: atomic.LoadUint64(&c.retransmits),
+		Loss:        c.Loss(),
+	}
+
+	c.mu.Lock()
+	snapshot.LastRTT = c.lastRTT
+	snapshot.SmoothedRTT = c.smoothRTT
+	snapshot.RTTVariance = c.rttVar
+	snapshot.LastSeen = c.lastSeen
+	c.mu.Unlock()
+
+	return snapshot
+}
@@ -0,0 +1,72 @@
+// pkg/stats/stats_test.go
+package stats
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountersTraffic(t *testing.T) {
+	counters := NewCounters()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			counters.RecordSent(100)
+			counters.RecordReceived(50)
+		}()
+	}
+	wg.Wait()
+	counters.RecordRetransmit()
+
+	snapshot := counters.Snapshot()
+	assert.Equal(t, uint64(10), snapshot.PacketsOut)
+	assert.Equal(t, uint64(1000), snapshot.BytesOut)
+	assert.Equal(t, uint64(10), snapshot.PacketsIn)
+	assert.Equal(t, uint64(500), snapshot.BytesIn)
+	assert.Equal(t, uint64(1), snapshot.Retransmits)
+	assert.False(t, snapshot.LastSeen.IsZero())
+}
+
+func TestCountersRTT(t *testing.T) {
+	counters := NewCounters()
+
+	counters.RecordRTT(100 * time.Millisecond)
+	snapshot := counters.Snapshot()
+	assert.Equal(t, 100*time.Millisecond, snapshot.LastRTT)
+	assert.Equal(t, 100*time.Millisecond, snapshot.SmoothedRTT)
+	assert.Equal(t, 50*time.Millisecond, snapshot.RTTVariance)
+
+	counters.RecordRTT(180 * time.Millisecond)
+	snapshot = counters.Snapshot()
+	assert.Equal(t, 180*time.Millisecond, snapshot.LastRTT)
+	assert.Equal(t, 110*time.Millisecond, snapshot.SmoothedRTT)
+	assert.Equal(t, 57500*time.Microsecond, snapshot.RTTVariance)
+}
+
+func TestCountersLoss(t *testing.T) {
+	counters := NewCounters()
+	assert.Equal(t, 0.0, counters.Loss())
+
+	for i := 0; i < 4; i++ {
+		counters.RecordProbe()
+	}
+	counters.RecordRTT(10 * time.Millisecond)
+	assert.Equal(t, 0.75, counters.Loss())
+
+	counters.RecordRTT(10 * time.Millisecond)
+	assert.Equal(t, 0.5, counters.Loss())
+}
+
+func TestNilCounters(t *testing.T) {
+	var counters *Counters
+	counters.RecordSent(10)
+	counters.RecordReceived(10)
+	counters.RecordRTT(time.Millisecond)
+	assert.Equal(t, Snapshot{}, counters.Snapshot())
+}
@@ -0,0 +1,77 @@
+// pkg/tracing/tracing.go
+
+// Package tracing carries OpenTelemetry trace context across the hops of a
+// traversal attempt: HTTP calls to the signaling API, signaling messages
+// queued for the peer and the punching code on both ends.
+package tracing
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// propagator is the wire format of trace context in HTTP headers and
+// signaling messages. It is fixed rather than taken from the global
+// propagator since both peers and the server must agree on it.
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Propagator returns the wire format of trace context, for installing as
+// the global propagator
+func Propagator() propagation.TextMapPropagator {
+	return propagator
+}
+
+// Inject returns the trace context of ctx as key/value pairs for embedding
+// in a signaling message, or nil when ctx carries no trace
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// Extract returns ctx continuing the trace in carrier, as produced by Inject
+func Extract(ctx context.Context, carrier map[string]string) context.Context {
+	if len(carrier) == 0 {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier(carrier))
+}
+
+// InjectHeader adds the trace context of ctx to outgoing HTTP headers
+func InjectHeader(ctx context.Context, header http.Header) {
+	propagator.Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// ExtractHeader returns ctx continuing the trace in incoming HTTP headers
+func ExtractHeader(ctx context.Context, header http.Header) context.Context {
+	return propagator.Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// End records err on span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// LogFields returns the trace and span IDs of ctx as log fields, so log
+// lines can be matched to traces. It is empty when ctx carries no trace.
+func LogFields(ctx context.Context) map[string]interface{} {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"trace_id": spanContext.TraceID().String(),
+		"span_id":  spanContext.SpanID().String(),
+	}
+}
@@ -0,0 +1,457 @@
+// pkg/tunnel/forward.go
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// dialTimeout limits connecting to an exposed service
+const dialTimeout = 10 * time.Second
+
+// Reset reasons the peer maps back to errors
+const (
+	reasonUnknownService = "service not exposed"     // The stream names a service that isn't exposed
+	reasonNotAllowed     = "destination not allowed" // The exit refuses the stream's address
+)
+
+// errNotAllowed fails dials the exit refuses
+var errNotAllowed = errors.New(reasonNotAllowed)
+
+// specialNetworks aren't reachable on the internet although the standard
+// library counts them as global unicast
+var specialNetworks = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // Carrier-grade NAT, and the mesh's virtual network
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+}
+
+// Service is a TCP or UDP address, written network:address, for example
+// tcp:localhost:22 or udp:127.0.0.1:53
+type Service struct {
+	Network string
+	Address string
+}
+
+// ParseService parses a network:address service
+func ParseService(s string) (Service, error) {
+	network, address, found := strings.Cut(s, ":")
+	if !found || (network != "tcp" && network != "udp") {
+		return Service{}, fmt.Errorf("service %q must start with tcp: or udp:", s)
+	}
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		return Service{}, fmt.Errorf("service %q: %w", s, err)
+	}
+	return Service{Network: network, Address: address}, nil
+}
+
+func (s Service) String() string {
+	return s.Network + ":" + s.Address
+}
+
+// Addr is the address of a stream, the service it connects to
+type Addr string
+
+// Network returns "tunnel"
+func (a Addr) Network() string {
+	return "tunnel"
+}
+
+func (a Addr) String() string {
+	return string(a)
+}
+
+// resetError converts a reset reason from the peer into an error
+func resetError(reason []byte) error {
+	switch string(reason) {
+	case reasonUnknownService:
+		return ErrUnknownService
+	case reasonNotAllowed:
+		return ErrNotAllowed
+	}
+	return fmt.Errorf("%w: %s", ErrStreamReset, reason)
+}
+
+// Serve connects each stream the peer opens to the local service it names,
+// until the session ends. Streams naming a service not in services are
+// reset, so the peer can only reach what was exposed.
+func Serve(session *Session, services []Service) error {
+	exposed := make(map[Service]bool, len(services))
+	for _, service := range services {
+		exposed[service] = true
+	}
+	return serve(session, &net.Dialer{Timeout: dialTimeout}, func(service Service) bool {
+		return exposed[service]
+	})
+}
+
+// ServeExit connects each stream the peer opens to whatever address it
+// names, acting as the exit for the peer's ServeSOCKS proxy, until the
+// session ends. Streams are reset when allow, if not nil, rejects their
+// service, or when their address resolves to a loopback, private or other
+// address that isn't public and not in Config.ExitNetworks, so the peer
+// can't reach the exit host itself or its LAN unless it is meant to.
+func ServeExit(session *Session, allow func(Service) bool) error {
+	return serve(session, exitDialer(session.config.ExitNetworks), allow)
+}
+
+// serve connects the streams allow accepts, every one if it is nil, with
+// dialer until the session ends
+func serve(session *Session, dialer *net.Dialer, allow func(Service) bool) error {
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			return err
+		}
+		if allow != nil && !allow(stream.Service()) {
+			go stream.reset(reasonUnknownService)
+			continue
+		}
+		go serveStream(stream, dialer, session.config.UDPIdleTimeout)
+	}
+}
+
+// exitDialer returns a dialer refusing addresses that aren't public or in
+// networks. It checks each address a name resolves to as it is dialed, so
+// a name can't lead somewhere an address would be refused.
+func exitDialer(networks []netip.Prefix) *net.Dialer {
+	return &net.Dialer{
+		Timeout: dialTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return err
+			}
+			addr := addrPort.Addr().Unmap()
+			if isPublic(addr) {
+				return nil
+			}
+			for _, prefix := range networks {
+				if prefix.Contains(addr) {
+					return nil
+				}
+			}
+			return errNotAllowed
+		},
+	}
+}
+
+// isPublic reports whether addr is a unicast address reachable on the
+// internet
+func isPublic(addr netip.Addr) bool {
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return false
+	}
+	for _, prefix := range specialNetworks {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// serveStream connects a stream to its service and tells the peer once it
+// is reached
+func serveStream(stream *Stream, dialer *net.Dialer, udpIdleTimeout time.Duration) {
+	service := stream.Service()
+	conn, err := dialer.Dial(service.Network, service.Address)
+	if errors.Is(err, errNotAllowed) {
+		stream.reset(reasonNotAllowed)
+		return
+	}
+	if err != nil {
+		stream.reset("dial failed: " + err.Error())
+		return
+	}
+	if err := stream.session.sendFrame(frameReady, 0, stream.id, nil); err != nil {
+		conn.Close()
+		return
+	}
+
+	if service.Network == "udp" {
+		joinPacket(conn, stream, udpIdleTimeout)
+		return
+	}
+	join(conn, stream)
+}
+
+// Forward accepts connections on listener and carries each to the peer's
+// TCP service over a new stream. It returns when the listener fails; the
+// listener is closed when the session ends.
+func Forward(session *Session, listener net.Listener, service Service) error {
+	go func() {
+		<-session.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if session.Err() != nil {
+				return session.Err()
+			}
+			return err
+		}
+
+		stream, err := session.Open(service)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		go join(conn, stream)
+	}
+}
+
+// join copies between a connection and a stream in both directions,
+// passing on half closes, until both directions finish or either fails
+func join(conn net.Conn, stream *Stream) {
+	errs := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(stream, conn)
+		if err == nil {
+			err = stream.CloseWrite()
+		}
+		errs <- err
+	}()
+	go func() {
+		_, err := io.Copy(conn, stream)
+		if err == nil {
+			err = closeWrite(conn)
+		}
+		errs <- err
+	}()
+
+	// A failure in one direction ends the other, which may be blocked
+	if err := <-errs; err != nil {
+		conn.Close()
+		stream.Close()
+	}
+	<-errs
+
+	conn.Close()
+	stream.Close()
+}
+
+// closeWrite half closes conn if it supports it, or closes it
+func closeWrite(conn net.Conn) error {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		return c.CloseWrite()
+	}
+	return conn.Close()
+}
+
+// ForwardPacket forwards datagrams arriving on conn to the peer's UDP
+// service. Each source address gets its own stream, ended after
+// Config.UDPIdleTimeout without traffic. It returns when conn fails; conn is
+// closed when the session ends.
+func ForwardPacket(session *Session, conn net.PacketConn, service Service) error {
+	relay := newPacketRelay(session, conn)
+	defer relay.close()
+
+	buffer := make([]byte, 64*1024)
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if err != nil {
+			if session.Err() != nil {
+				return session.Err()
+			}
+			return err
+		}
+		if err := relay.send(addr.String(), service, buffer[:n], addr, nil); err != nil {
+			return err
+		}
+	}
+}
+
+// packetRelay carries datagrams from a local socket to the peer, each flow
+// over its own stream, and writes the replies back to the socket
+type packetRelay struct {
+	session *Session
+	conn    net.PacketConn
+	stop    chan struct{}
+
+	mu    sync.Mutex
+	flows map[string]*packetFlow
+}
+
+// newPacketRelay starts a relay for conn, which is closed when the session
+// ends
+func newPacketRelay(session *Session, conn net.PacketConn) *packetRelay {
+	r := &packetRelay{
+		session: session,
+		conn:    conn,
+		stop:    make(chan struct{}),
+		flows:   make(map[string]*packetFlow),
+	}
+
+	go func() {
+		select {
+		case <-session.Done():
+			conn.Close()
+		case <-r.stop:
+		}
+	}()
+	go r.reap(session.config.UDPIdleTimeout)
+
+	return r
+}
+
+// send forwards a datagram on the flow named key, opening a stream to
+// service for a new flow. Replies on that stream are passed through wrap,
+// if not nil, and written to addr.
+func (r *packetRelay) send(key string, service Service, data []byte, addr net.Addr, wrap func([]byte) []byte) error {
+	r.mu.Lock()
+	flow, exists := r.flows[key]
+	if !exists {
+		stream, err := r.session.Open(service)
+		if err != nil {
+			r.mu.Unlock()
+			return err
+		}
+		flow = &packetFlow{stream: stream}
+		r.flows[key] = flow
+
+		go func() {
+			flow.replies(r.conn, addr, wrap)
+			r.mu.Lock()
+			if r.flows[key] == flow {
+				delete(r.flows, key)
+			}
+			r.mu.Unlock()
+		}()
+	}
+	r.mu.Unlock()
+
+	flow.touch()
+	if _, err := flow.stream.Write(data); err != nil {
+		flow.stream.Close()
+	}
+	return nil
+}
+
+// reap ends flows idle for longer than timeout
+func (r *packetRelay) reap(timeout time.Duration) {
+	ticker := time.NewTicker(timeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+
+		r.mu.Lock()
+		for key, flow := range r.flows {
+			if flow.idle(timeout) {
+				flow.stream.Close()
+				delete(r.flows, key)
+			}
+		}
+		r.mu.Unlock()
+	}
+}
+
+// close ends every flow
+func (r *packetRelay) close() {
+	close(r.stop)
+
+	r.mu.Lock()
+	for key, flow := range r.flows {
+		flow.stream.Close()
+		delete(r.flows, key)
+	}
+	r.mu.Unlock()
+}
+
+// packetFlow is the stream carrying one flow's datagrams
+type packetFlow struct {
+	stream     *Stream
+	lastActive int64 // Unix nanoseconds
+}
+
+func (f *packetFlow) touch() {
+	atomic.StoreInt64(&f.lastActive, time.Now().UnixNano())
+}
+
+func (f *packetFlow) idle(timeout time.Duration) bool {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&f.lastActive))) > timeout
+}
+
+// replies writes datagrams from the stream to addr, passed through wrap if
+// it is not nil
+func (f *packetFlow) replies(conn net.PacketConn, addr net.Addr, wrap func([]byte) []byte) {
+	buffer := make([]byte, 64*1024)
+	for {
+		n, err := f.stream.Read(buffer)
+		if err != nil {
+			return
+		}
+		f.touch()
+
+		datagram := buffer[:n]
+		if wrap != nil {
+			datagram = wrap(datagram)
+		}
+		if _, err := conn.WriteTo(datagram, addr); err != nil && errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+// joinPacket relays datagrams between a connected UDP socket and a stream
+// until either fails or both directions are idle for idleTimeout
+func joinPacket(conn net.Conn, stream *Stream, idleTimeout time.Duration) {
+	flow := &packetFlow{stream: stream}
+	flow.touch()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		flow.replies(&connectedPacketConn{conn}, nil, nil)
+	}()
+
+	buffer := make([]byte, 64*1024)
+	for {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		n, err := conn.Read(buffer)
+		if isTimeout(err) && !flow.idle(idleTimeout) {
+			continue
+		}
+		if err != nil {
+			break
+		}
+		flow.touch()
+		if _, err := stream.Write(buffer[:n]); err != nil {
+			break
+		}
+	}
+
+	stream.Close()
+	conn.Close()
+	<-done
+}
+
+// connectedPacketConn writes to a connected socket whatever the address
+type connectedPacketConn struct {
+	net.Conn
+}
+
+func (c *connectedPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, err := c.Read(b)
+	return n, c.RemoteAddr(), err
+}
+
+func (c *connectedPacketConn) WriteTo(b []byte, _ net.Addr) (int, error) {
+	return c.Write(b)
+}
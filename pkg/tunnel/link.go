@@ -0,0 +1,426 @@
+// pkg/tunnel/link.go
+package tunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/pkg/protocol"
+	"github.com/bOguzhan/NATbypass/pkg/stats"
+)
+
+// Link messages, each the payload of one data packet
+const (
+	linkData  byte = 1 // Sequence number, then a session frame
+	linkAck   byte = 2 // Next sequence number expected, then a bitmap of the 64 after it
+	linkClose byte = 3 // The peer closed the session
+)
+
+const (
+	linkHeaderSize = 5  // Message kind and sequence number
+	sackBits       = 64 // Sequence numbers past the cumulative ack reported per ack
+	tickInterval   = 10 * time.Millisecond
+	minRTO         = 100 * time.Millisecond
+	maxRTO         = 2 * time.Second
+	initialRTO     = 500 * time.Millisecond
+	closeCopies    = 3
+)
+
+// outbound is a message sent but not yet acknowledged
+type outbound struct {
+	data        []byte // Encoded frame, ready to resend
+	sentAt      time.Time
+	retransmits int
+}
+
+// link delivers messages reliably and in order over a connection that may
+// lose, duplicate or reorder them, such as a hole punched UDP path. Each
+// message is one length-prefixed frame, so the link also runs over stream
+// connections made by fallback strategies, where it costs only the acks.
+//
+// The receiver acknowledges every message with the next sequence number it
+// expects and a bitmap of the ones it already holds beyond that. Messages
+// are resent when unacknowledged for the retransmission timeout, or sooner
+// when later messages were acknowledged around them.
+type link struct {
+	conn    net.Conn
+	config  Config
+	deliver func([]byte) // Called with each message in order, from the read loop
+	stats   *stats.Counters
+	stream  bool // Whether conn is a byte stream rather than datagrams
+
+	mu         sync.Mutex
+	nextSeq    uint32
+	inflight   map[uint32]*outbound
+	space      *sync.Cond // Signalled when inflight shrinks or the link closes
+	rto        time.Duration
+	lastSent   time.Time
+	expected   uint32
+	received   map[uint32][]byte // Out of order messages
+	lastHeard  time.Time
+	closeError error
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// newLink starts a link over conn. deliver must not block.
+func newLink(conn net.Conn, config Config, deliver func([]byte)) *link {
+	l := &link{
+		conn:      conn,
+		config:    config,
+		deliver:   deliver,
+		stats:     stats.NewCounters(),
+		stream:    !isPacketNetwork(conn.LocalAddr().Network()),
+		inflight:  make(map[uint32]*outbound),
+		rto:       initialRTO,
+		received:  make(map[uint32][]byte),
+		lastHeard: time.Now(),
+		lastSent:  time.Now(),
+		closed:    make(chan struct{}),
+	}
+	l.space = sync.NewCond(&l.mu)
+
+	go l.readLoop()
+	go l.timerLoop()
+
+	return l
+}
+
+// send queues a message, blocking while the send window is full
+func (l *link) send(message []byte) error {
+	data, err := encodeLinkMessage(linkData, 0, message)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	for len(l.inflight) >= l.config.SendWindow && l.closeError == nil {
+		l.space.Wait()
+	}
+	if l.closeError != nil {
+		err := l.closeError
+		l.mu.Unlock()
+		return err
+	}
+
+	seq := l.nextSeq
+	l.nextSeq++
+	binary.BigEndian.PutUint32(data[protocol.FrameHeaderSize+protocol.HeaderSize+1:], seq)
+	l.inflight[seq] = &outbound{data: data, sentAt: time.Now()}
+	l.lastSent = time.Now()
+	l.mu.Unlock()
+
+	l.stats.RecordProbe()
+	return l.write(data)
+}
+
+// write sends an encoded message. A failed write on a stream connection may
+// have left part of a frame behind, after which the peer can't find message
+// boundaries, so the link is closed. Datagrams that fail to go out are
+// resent like lost ones, unless the connection itself is closed.
+func (l *link) write(data []byte) error {
+	err := l.transmit(data)
+	if err != nil && (l.stream || errors.Is(err, net.ErrClosed)) {
+		l.close(err)
+	}
+	return err
+}
+
+// transmit writes an encoded message to the connection
+func (l *link) transmit(data []byte) error {
+	if _, err := l.conn.Write(data); err != nil {
+		return err
+	}
+	l.stats.RecordSent(len(data))
+	return nil
+}
+
+// readLoop decodes messages until the connection fails
+func (l *link) readLoop() {
+	decoder := protocol.NewStreamDecoder(0)
+	buffer := make([]byte, 64*1024)
+
+	for {
+		n, err := l.conn.Read(buffer)
+		if err != nil {
+			l.close(err)
+			return
+		}
+		l.stats.RecordReceived(n)
+
+		// A datagram holds whole frames, so one that is corrupt or cut short
+		// is dropped on its own, while a corrupt stream can't be resynced
+		if !l.stream {
+			decoder.Reset()
+		}
+		decoder.Write(buffer[:n])
+
+		for {
+			packet, err := decoder.Next()
+			if err != nil && l.stream {
+				l.close(err)
+				return
+			}
+			if err != nil || packet == nil {
+				break
+			}
+			if packet.Type == protocol.PacketTypeData && len(packet.Payload) > 0 {
+				l.handle(packet.Payload)
+			}
+		}
+	}
+}
+
+// handle processes one link message
+func (l *link) handle(message []byte) {
+	l.mu.Lock()
+	l.lastHeard = time.Now()
+	l.mu.Unlock()
+
+	switch message[0] {
+	case linkData:
+		if len(message) < linkHeaderSize {
+			return
+		}
+		l.receive(binary.BigEndian.Uint32(message[1:]), message[linkHeaderSize:])
+	case linkAck:
+		if len(message) < linkHeaderSize+8 {
+			return
+		}
+		l.acknowledge(binary.BigEndian.Uint32(message[1:]), binary.BigEndian.Uint64(message[linkHeaderSize:]))
+	case linkClose:
+		l.close(io.EOF)
+	}
+}
+
+// receive stores a message, delivers any now in order and acknowledges
+func (l *link) receive(seq uint32, message []byte) {
+	var ready [][]byte
+
+	l.mu.Lock()
+	// Messages already delivered or too far ahead are only acknowledged
+	if seq >= l.expected && seq-l.expected < uint32(l.config.SendWindow)*2 {
+		if _, exists := l.received[seq]; !exists {
+			l.received[seq] = append([]byte(nil), message...)
+		}
+		for {
+			next, exists := l.received[l.expected]
+			if !exists {
+				break
+			}
+			delete(l.received, l.expected)
+			ready = append(ready, next)
+			l.expected++
+		}
+	}
+	ack := l.ackMessage()
+	l.mu.Unlock()
+
+	for _, message := range ready {
+		l.deliver(message)
+	}
+	l.write(ack) // Closes the link if the ack can't be sent on a stream
+}
+
+// ackMessage encodes an ack for the current receive state
+func (l *link) ackMessage() []byte {
+	var sack uint64
+	for i := uint32(0); i < sackBits; i++ {
+		if _, exists := l.received[l.expected+1+i]; exists {
+			sack |= 1 << i
+		}
+	}
+
+	body := make([]byte, 8)
+	binary.BigEndian.PutUint64(body, sack)
+	data, _ := encodeLinkMessage(linkAck, l.expected, body)
+	return data
+}
+
+// acknowledge drops acknowledged messages and resends those the peer
+// skipped over
+func (l *link) acknowledge(ack uint32, sack uint64) {
+	now := time.Now()
+	var resend [][]byte
+
+	l.mu.Lock()
+	highest := ack
+	for seq, out := range l.inflight {
+		acked := seq < ack
+		if !acked && seq > ack && seq-ack <= sackBits && sack&(1<<(seq-ack-1)) != 0 {
+			acked = true
+		}
+		if !acked {
+			continue
+		}
+		if seq+1 > highest {
+			highest = seq + 1
+		}
+		if out.retransmits == 0 {
+			// Only first transmissions give unambiguous samples
+			l.stats.RecordRTT(now.Sub(out.sentAt))
+		}
+		delete(l.inflight, seq)
+	}
+	l.updateRTO()
+
+	// Anything unacknowledged below a later acknowledged message was most
+	// likely lost; resend it once it has had a round trip to arrive
+	snapshot := l.stats.Snapshot()
+	for seq, out := range l.inflight {
+		if seq < highest && now.Sub(out.sentAt) > snapshot.SmoothedRTT+tickInterval {
+			out.sentAt = now
+			out.retransmits++
+			resend = append(resend, out.data)
+		}
+	}
+	l.space.Broadcast()
+	l.mu.Unlock()
+
+	for _, data := range resend {
+		l.stats.RecordRetransmit()
+		if err := l.write(data); err != nil {
+			return
+		}
+	}
+}
+
+// updateRTO derives the retransmission timeout from the RTT estimate as in
+// RFC 6298
+func (l *link) updateRTO() {
+	snapshot := l.stats.Snapshot()
+	if snapshot.SmoothedRTT == 0 {
+		return
+	}
+	rto := snapshot.SmoothedRTT + 4*snapshot.RTTVariance
+	if rto < minRTO {
+		rto = minRTO
+	}
+	if rto > maxRTO {
+		rto = maxRTO
+	}
+	l.rto = rto
+}
+
+// timerLoop resends timed out messages, keeps the path alive and notices
+// a peer that went away
+func (l *link) timerLoop() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.closed:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		var resend [][]byte
+
+		l.mu.Lock()
+		if now.Sub(l.lastHeard) > l.config.IdleTimeout {
+			l.mu.Unlock()
+			l.close(ErrPeerTimeout)
+			return
+		}
+
+		backedOff := false
+		for _, out := range l.inflight {
+			if now.Sub(out.sentAt) > l.rto {
+				out.sentAt = now
+				out.retransmits++
+				resend = append(resend, out.data)
+				backedOff = true
+			}
+		}
+		if backedOff {
+			// Back off until new acks bring a fresh estimate
+			l.rto *= 2
+			if l.rto > maxRTO {
+				l.rto = maxRTO
+			}
+		}
+
+		keepAlive := len(resend) == 0 && now.Sub(l.lastSent) > l.config.KeepAliveInterval
+		var ack []byte
+		if keepAlive {
+			l.lastSent = now
+			ack = l.ackMessage()
+		}
+		l.mu.Unlock()
+
+		var err error
+		for _, data := range resend {
+			l.stats.RecordRetransmit()
+			if err = l.write(data); err != nil {
+				break
+			}
+		}
+		if keepAlive && err == nil {
+			l.write(ack)
+		}
+	}
+}
+
+// close tells the peer, when closing locally, and fails pending sends
+func (l *link) close(reason error) {
+	l.closeOnce.Do(func() {
+		if reason == nil {
+			// The close isn't acknowledged, so send copies in case some are
+			// lost; a peer that misses them all times out instead
+			if data, err := encodeLinkMessage(linkClose, 0, nil); err == nil {
+				for i := 0; i < closeCopies; i++ {
+					if l.transmit(data) != nil {
+						break
+					}
+				}
+			}
+			reason = ErrSessionClosed
+		}
+
+		l.mu.Lock()
+		l.closeError = reason
+		l.space.Broadcast()
+		l.mu.Unlock()
+
+		close(l.closed)
+		l.conn.Close()
+	})
+}
+
+// err returns why the link closed, nil while open
+func (l *link) err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.closeError
+}
+
+// encodeLinkMessage frames a link message. The sequence number may be
+// filled in later at a fixed offset.
+func encodeLinkMessage(kind byte, seq uint32, body []byte) ([]byte, error) {
+	payload := make([]byte, linkHeaderSize+len(body))
+	payload[0] = kind
+	binary.BigEndian.PutUint32(payload[1:], seq)
+	copy(payload[linkHeaderSize:], body)
+
+	return protocol.EncodeFrame(&protocol.Packet{Type: protocol.PacketTypeData, Payload: payload})
+}
+
+// isPacketNetwork reports whether a network name such as "udp4" carries
+// datagrams
+func isPacketNetwork(network string) bool {
+	return strings.HasPrefix(network, "udp") || strings.HasPrefix(network, "ip") || network == "unixgram"
+}
+
+// isTimeout reports whether err is a deadline error
+func isTimeout(err error) bool {
+	return errors.Is(err, os.ErrDeadlineExceeded)
+}
@@ -0,0 +1,296 @@
+// pkg/tunnel/session.go
+
+// Package tunnel forwards TCP and UDP services between two peers over one
+// traversed connection. A Session multiplexes any number of streams over the
+// connection, resending what the path loses, so a single punched UDP path
+// can carry many TCP connections. Serve exposes local services to the peer
+// and Forward and ForwardPacket give local clients a way to reach the
+// peer's services.
+package tunnel
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/bOguzhan/NATbypass/pkg/stats"
+)
+
+// Default settings used when the corresponding Config field is zero
+const (
+	DefaultSendWindow        = 128
+	DefaultKeepAliveInterval = 5 * time.Second
+	DefaultIdleTimeout       = 30 * time.Second
+	DefaultAcceptBacklog     = 64
+	DefaultUDPIdleTimeout    = 2 * time.Minute
+)
+
+// Errors returned by sessions and streams
+var (
+	ErrSessionClosed  = errors.New("tunnel session closed")
+	ErrPeerTimeout    = errors.New("tunnel peer stopped responding")
+	ErrStreamReset    = errors.New("tunnel stream reset by peer")
+	ErrUnknownService = errors.New("service not exposed by peer")
+	ErrNotAllowed     = errors.New("destination not allowed by peer")
+)
+
+// Config holds session settings
+type Config struct {
+	// SendWindow is the number of messages sent ahead of the peer's
+	// acknowledgements
+	SendWindow int
+
+	// KeepAliveInterval is how often an idle session shows it's alive
+	KeepAliveInterval time.Duration
+
+	// IdleTimeout closes the session when nothing is heard for this long
+	IdleTimeout time.Duration
+
+	// AcceptBacklog bounds streams opened by the peer but not yet accepted
+	AcceptBacklog int
+
+	// UDPIdleTimeout ends forwarded UDP flows without traffic for this long
+	UDPIdleTimeout time.Duration
+
+	// ExitNetworks are networks ServeExit lets the peer reach although
+	// they aren't public, e.g. the exit host's LAN. Loopback, link-local,
+	// private and other special-purpose addresses are refused otherwise.
+	ExitNetworks []netip.Prefix
+}
+
+func (c Config) withDefaults() Config {
+	if c.SendWindow <= 0 {
+		c.SendWindow = DefaultSendWindow
+	}
+	if c.KeepAliveInterval <= 0 {
+		c.KeepAliveInterval = DefaultKeepAliveInterval
+	}
+	if c.IdleTimeout <= 0 {
+		c.IdleTimeout = DefaultIdleTimeout
+	}
+	if c.AcceptBacklog <= 0 {
+		c.AcceptBacklog = DefaultAcceptBacklog
+	}
+	if c.UDPIdleTimeout <= 0 {
+		c.UDPIdleTimeout = DefaultUDPIdleTimeout
+	}
+	return c
+}
+
+// Session frames, carried one per link message
+const (
+	frameOpen   byte = 1 // Body is the service name
+	frameData   byte = 2 // Body is stream data
+	frameWindow byte = 3 // Body is the number of bytes the reader consumed
+	frameClose  byte = 4 // The sender won't write any more
+	frameReset  byte = 5 // Body is the reason
+	frameReady  byte = 6 // The opened service was reached
+)
+
+const (
+	// flagMore marks a data frame whose message continues in the next one,
+	// so datagrams larger than a frame keep their boundaries
+	flagMore byte = 1
+
+	frameHeaderSize = 6 // Type, flags and stream ID
+
+	// maxFramePayload keeps each link message within a typical path MTU
+	maxFramePayload = 1100
+
+	// streamWindow is how many bytes may be sent on a stream ahead of the
+	// reader. Both peers use the same value.
+	streamWindow = 256 * 1024
+)
+
+// Session multiplexes streams over one connection to a peer
+type Session struct {
+	config Config
+	link   *link
+
+	mu      sync.Mutex
+	streams map[uint32]*Stream
+	nextID  uint32
+	accept  chan *Stream
+}
+
+// Client starts a session on the side that dialed the connection
+func Client(conn net.Conn, config *Config) *Session {
+	return newSession(conn, config, 1)
+}
+
+// Server starts a session on the side that accepted the connection
+func Server(conn net.Conn, config *Config) *Session {
+	return newSession(conn, config, 2)
+}
+
+// newSession starts a session. The two sides open streams with odd and even
+// IDs so they never collide.
+func newSession(conn net.Conn, config *Config, firstID uint32) *Session {
+	var cfg Config
+	if config != nil {
+		cfg = *config
+	}
+	cfg = cfg.withDefaults()
+
+	s := &Session{
+		config:  cfg,
+		streams: make(map[uint32]*Stream),
+		nextID:  firstID,
+		accept:  make(chan *Stream, cfg.AcceptBacklog),
+	}
+	s.link = newLink(conn, cfg, s.handle)
+
+	go func() {
+		<-s.link.closed
+		s.closeStreams()
+	}()
+
+	return s
+}
+
+// Open opens a stream to one of the peer's exposed services
+func (s *Session) Open(service Service) (*Stream, error) {
+	s.mu.Lock()
+	id := s.nextID
+	s.nextID += 2
+	stream := newStream(s, id, service)
+	s.streams[id] = stream
+	s.mu.Unlock()
+
+	if err := s.sendFrame(frameOpen, 0, id, []byte(service.String())); err != nil {
+		s.remove(id)
+		return nil, err
+	}
+	return stream, nil
+}
+
+// Accept waits for the next stream opened by the peer
+func (s *Session) Accept() (*Stream, error) {
+	select {
+	case stream := <-s.accept:
+		return stream, nil
+	case <-s.link.closed:
+		return nil, s.link.err()
+	}
+}
+
+// Close closes the session and every stream
+func (s *Session) Close() error {
+	s.link.close(nil)
+	return nil
+}
+
+// Done is closed when the session ends
+func (s *Session) Done() <-chan struct{} {
+	return s.link.closed
+}
+
+// Err returns why the session ended, nil while it is open
+func (s *Session) Err() error {
+	return s.link.err()
+}
+
+// Stats returns the traffic counters of the underlying connection
+func (s *Session) Stats() stats.Snapshot {
+	return s.link.stats.Snapshot()
+}
+
+// sendFrame sends one frame over the link
+func (s *Session) sendFrame(frameType, flags byte, id uint32, body []byte) error {
+	frame := make([]byte, frameHeaderSize+len(body))
+	frame[0] = frameType
+	frame[1] = flags
+	binary.BigEndian.PutUint32(frame[2:], id)
+	copy(frame[frameHeaderSize:], body)
+	return s.link.send(frame)
+}
+
+// handle dispatches a frame from the link. It runs on the link's read
+// loop, so anything that sends is done in a goroutine.
+func (s *Session) handle(frame []byte) {
+	if len(frame) < frameHeaderSize {
+		return
+	}
+	frameType, flags := frame[0], frame[1]
+	id := binary.BigEndian.Uint32(frame[2:])
+	body := frame[frameHeaderSize:]
+
+	if frameType == frameOpen {
+		s.handleOpen(id, body)
+		return
+	}
+
+	s.mu.Lock()
+	stream, exists := s.streams[id]
+	s.mu.Unlock()
+
+	if !exists {
+		if frameType != frameReset {
+			go s.sendFrame(frameReset, 0, id, []byte("unknown stream"))
+		}
+		return
+	}
+
+	switch frameType {
+	case frameData:
+		if !stream.receive(body, flags&flagMore != 0) {
+			go stream.reset("window exceeded")
+		}
+	case frameWindow:
+		if len(body) >= 4 {
+			stream.grant(int(binary.BigEndian.Uint32(body)))
+		}
+	case frameReady:
+		stream.receiveReady()
+	case frameClose:
+		stream.receiveClose()
+	case frameReset:
+		stream.receiveReset(resetError(body))
+	}
+}
+
+// handleOpen queues a stream opened by the peer
+func (s *Session) handleOpen(id uint32, body []byte) {
+	service, err := ParseService(string(body))
+	if err != nil {
+		go s.sendFrame(frameReset, 0, id, []byte(err.Error()))
+		return
+	}
+
+	s.mu.Lock()
+	if _, exists := s.streams[id]; exists {
+		s.mu.Unlock()
+		return
+	}
+	stream := newStream(s, id, service)
+	s.streams[id] = stream
+	s.mu.Unlock()
+
+	select {
+	case s.accept <- stream:
+	default:
+		go stream.reset("accept backlog full")
+	}
+}
+
+// remove forgets a finished stream
+func (s *Session) remove(id uint32) {
+	s.mu.Lock()
+	delete(s.streams, id)
+	s.mu.Unlock()
+}
+
+// closeStreams fails every stream once the link is gone
+func (s *Session) closeStreams() {
+	s.mu.Lock()
+	streams := s.streams
+	s.streams = make(map[uint32]*Stream)
+	s.mu.Unlock()
+
+	for _, stream := range streams {
+		stream.receiveReset(s.link.err())
+	}
+}
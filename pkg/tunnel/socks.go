@@ -0,0 +1,294 @@
+// pkg/tunnel/socks.go
+package tunnel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// SOCKS5 protocol values, RFC 1928
+const (
+	socksVersion      = 5
+	socksNoAuth       = 0x00
+	socksNoAcceptable = 0xff
+
+	socksConnect      = 1
+	socksUDPAssociate = 3
+
+	socksIPv4   = 1
+	socksDomain = 3
+	socksIPv6   = 4
+
+	socksSucceeded           = 0
+	socksGeneralFailure      = 1
+	socksNotAllowed          = 2
+	socksHostUnreachable     = 4
+	socksCommandNotSupported = 7
+	socksAddressNotSupported = 8
+)
+
+// socksHandshakeTimeout limits how long a SOCKS client takes to send its
+// request
+const socksHandshakeTimeout = 10 * time.Second
+
+// errSOCKSAddressType reports an address type other than IPv4, IPv6 or a
+// domain name
+var errSOCKSAddressType = errors.New("unsupported SOCKS address type")
+
+// ServeSOCKS runs a SOCKS5 proxy on listener whose CONNECT and UDP
+// ASSOCIATE requests leave through the peer, which must be running
+// ServeExit. Clients aren't authenticated, so listener should only be
+// reachable by trusted hosts. It returns when the listener fails; the
+// listener is closed when the session ends.
+func ServeSOCKS(session *Session, listener net.Listener) error {
+	go func() {
+		<-session.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if session.Err() != nil {
+				return session.Err()
+			}
+			return err
+		}
+		go serveSOCKSConn(session, conn)
+	}
+}
+
+// serveSOCKSConn handles one SOCKS client connection
+func serveSOCKSConn(session *Session, conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(socksHandshakeTimeout))
+
+	if err := socksGreeting(conn); err != nil {
+		conn.Close()
+		return
+	}
+
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(conn, header); err != nil || header[0] != socksVersion {
+		conn.Close()
+		return
+	}
+	address, err := readSOCKSAddress(conn)
+	if err != nil {
+		if errors.Is(err, errSOCKSAddressType) {
+			writeSOCKSReply(conn, socksAddressNotSupported, nil)
+		}
+		conn.Close()
+		return
+	}
+
+	switch header[1] {
+	case socksConnect:
+		socksConnectStream(session, conn, address)
+	case socksUDPAssociate:
+		socksAssociate(session, conn)
+	default:
+		writeSOCKSReply(conn, socksCommandNotSupported, nil)
+		conn.Close()
+	}
+}
+
+// socksGreeting agrees on no authentication, the only method supported
+func socksGreeting(conn net.Conn) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[0] != socksVersion {
+		return errors.New("not a SOCKS5 client")
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return err
+	}
+
+	if bytes.IndexByte(methods, socksNoAuth) < 0 {
+		conn.Write([]byte{socksVersion, socksNoAcceptable})
+		return errors.New("SOCKS client requires authentication")
+	}
+	_, err := conn.Write([]byte{socksVersion, socksNoAuth})
+	return err
+}
+
+// socksConnectStream connects a client to a TCP address through the peer
+func socksConnectStream(session *Session, conn net.Conn, address string) {
+	stream, err := session.Open(Service{Network: "tcp", Address: address})
+	if err != nil {
+		writeSOCKSReply(conn, socksGeneralFailure, nil)
+		conn.Close()
+		return
+	}
+
+	// Only report success once the peer has reached the address
+	if err := stream.waitReady(time.Now().Add(dialTimeout + socksHandshakeTimeout)); err != nil {
+		writeSOCKSReply(conn, socksReplyCode(err), nil)
+		stream.Close()
+		conn.Close()
+		return
+	}
+	if err := writeSOCKSReply(conn, socksSucceeded, nil); err != nil {
+		stream.Close()
+		conn.Close()
+		return
+	}
+
+	conn.SetDeadline(time.Time{})
+	join(conn, stream)
+}
+
+// socksReplyCode maps why a stream failed to a SOCKS reply
+func socksReplyCode(err error) byte {
+	switch {
+	case errors.Is(err, ErrUnknownService), errors.Is(err, ErrNotAllowed):
+		return socksNotAllowed
+	case errors.Is(err, ErrStreamReset):
+		return socksHostUnreachable
+	}
+	return socksGeneralFailure
+}
+
+// socksAssociate relays a client's UDP datagrams through the peer until
+// its control connection closes. Each destination gets its own stream.
+func socksAssociate(session *Session, conn net.Conn) {
+	defer conn.Close()
+
+	// Datagrams arrive on the address the client reached the proxy on
+	host, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+	packetConn, err := net.ListenPacket("udp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		writeSOCKSReply(conn, socksGeneralFailure, nil)
+		return
+	}
+	defer packetConn.Close()
+
+	if err := writeSOCKSReply(conn, socksSucceeded, packetConn.LocalAddr()); err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	// The association lasts as long as the control connection
+	go func() {
+		io.Copy(io.Discard, conn)
+		packetConn.Close()
+	}()
+
+	relay := newPacketRelay(session, packetConn)
+	defer relay.close()
+
+	clientHost, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	clientIP := net.ParseIP(clientHost)
+	buffer := make([]byte, 64*1024)
+	for {
+		n, addr, err := packetConn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		from, ok := addr.(*net.UDPAddr)
+		if !ok || !from.IP.Equal(clientIP) {
+			continue
+		}
+
+		// Fragments aren't supported and are dropped, as RFC 1928 allows
+		reader := bytes.NewReader(buffer[:n])
+		header := make([]byte, 3)
+		if _, err := io.ReadFull(reader, header); err != nil || header[2] != 0 {
+			continue
+		}
+		destination, err := readSOCKSAddress(reader)
+		if err != nil {
+			continue
+		}
+		payload := buffer[n-reader.Len() : n]
+
+		prefix := append([]byte{0, 0, 0}, encodeSOCKSAddress(destination)...)
+		wrap := func(data []byte) []byte {
+			return append(append([]byte(nil), prefix...), data...)
+		}
+		service := Service{Network: "udp", Address: destination}
+		if err := relay.send(destination, service, payload, from, wrap); err != nil {
+			return
+		}
+	}
+}
+
+// readSOCKSAddress reads an address type, address and port as host:port
+func readSOCKSAddress(r io.Reader) (string, error) {
+	kind := make([]byte, 1)
+	if _, err := io.ReadFull(r, kind); err != nil {
+		return "", err
+	}
+
+	var host string
+	switch kind[0] {
+	case socksIPv4, socksIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if kind[0] == socksIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", errSOCKSAddressType
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// encodeSOCKSAddress encodes host:port as an address type, address and
+// port
+func encodeSOCKSAddress(address string) []byte {
+	host, portText, err := net.SplitHostPort(address)
+	if err != nil {
+		return []byte{socksIPv4, 0, 0, 0, 0, 0, 0}
+	}
+	port, _ := strconv.Atoi(portText)
+
+	var encoded []byte
+	ip := net.ParseIP(host)
+	switch {
+	case ip.To4() != nil:
+		encoded = append([]byte{socksIPv4}, ip.To4()...)
+	case ip != nil:
+		encoded = append([]byte{socksIPv6}, ip.To16()...)
+	default:
+		encoded = append([]byte{socksDomain, byte(len(host))}, host...)
+	}
+	return append(encoded, byte(port>>8), byte(port))
+}
+
+// writeSOCKSReply sends a reply with the bound address, or an empty IPv4
+// address if bound is nil
+func writeSOCKSReply(conn net.Conn, code byte, bound net.Addr) error {
+	address := "0.0.0.0:0"
+	if bound != nil {
+		address = bound.String()
+	}
+	reply := append([]byte{socksVersion, code, 0}, encodeSOCKSAddress(address)...)
+	_, err := conn.Write(reply)
+	return err
+}
@@ -0,0 +1,172 @@
+// pkg/tunnel/socks_test.go
+package tunnel
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// socksRequest greets a SOCKS5 proxy and sends a request, returning the
+// reply code and bound address
+func socksRequest(t *testing.T, conn net.Conn, command byte, address string) (byte, string) {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+
+	_, err := conn.Write([]byte{socksVersion, 1, socksNoAuth})
+	assert.NoError(t, err)
+	method := make([]byte, 2)
+	_, err = io.ReadFull(conn, method)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{socksVersion, socksNoAuth}, method)
+
+	request := append([]byte{socksVersion, command, 0}, encodeSOCKSAddress(address)...)
+	_, err = conn.Write(request)
+	assert.NoError(t, err)
+
+	header := make([]byte, 3)
+	_, err = io.ReadFull(conn, header)
+	assert.NoError(t, err)
+	bound, err := readSOCKSAddress(conn)
+	assert.NoError(t, err)
+	return header[1], bound
+}
+
+// loopback lets the exit reach the test's services
+var loopback = []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+
+func TestSOCKSConnect(t *testing.T) {
+	client, server := sessionPair(t, 12412, 12413, &Config{ExitNetworks: loopback})
+	echo(t, "127.0.0.1:12414")
+	go ServeExit(server, func(service Service) bool {
+		return service.Address != "127.0.0.1:12499"
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:12415")
+	assert.NoError(t, err)
+	go ServeSOCKS(client, listener)
+
+	conn, err := net.Dial("tcp", "127.0.0.1:12415")
+	assert.NoError(t, err)
+	defer conn.Close()
+	code, _ := socksRequest(t, conn, socksConnect, "127.0.0.1:12414")
+	assert.Equal(t, byte(socksSucceeded), code)
+
+	data := bytes.Repeat([]byte("natbypass"), 10000)
+	go conn.Write(data)
+	echoed := make([]byte, len(data))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(conn, echoed)
+	assert.NoError(t, err)
+	assert.Equal(t, data, echoed)
+
+	// Failures are reported before any data flows
+	refused, err := net.Dial("tcp", "127.0.0.1:12415")
+	assert.NoError(t, err)
+	defer refused.Close()
+	code, _ = socksRequest(t, refused, socksConnect, "127.0.0.1:12416")
+	assert.Equal(t, byte(socksHostUnreachable), code)
+
+	denied, err := net.Dial("tcp", "127.0.0.1:12415")
+	assert.NoError(t, err)
+	defer denied.Close()
+	code, _ = socksRequest(t, denied, socksConnect, "127.0.0.1:12499")
+	assert.Equal(t, byte(socksNotAllowed), code)
+}
+
+func TestSOCKSUDPAssociate(t *testing.T) {
+	client, server := sessionPair(t, 12417, 12418, &Config{ExitNetworks: loopback})
+	go ServeExit(server, nil)
+
+	echoConn, err := net.ListenPacket("udp", "127.0.0.1:12419")
+	assert.NoError(t, err)
+	defer echoConn.Close()
+	go func() {
+		buffer := make([]byte, 64*1024)
+		for {
+			n, addr, err := echoConn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			echoConn.WriteTo(buffer[:n], addr)
+		}
+	}()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:12420")
+	assert.NoError(t, err)
+	go ServeSOCKS(client, listener)
+
+	control, err := net.Dial("tcp", "127.0.0.1:12420")
+	assert.NoError(t, err)
+	defer control.Close()
+	code, bound := socksRequest(t, control, socksUDPAssociate, "0.0.0.0:0")
+	assert.Equal(t, byte(socksSucceeded), code)
+
+	conn, err := net.Dial("udp", bound)
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	header := append([]byte{0, 0, 0}, encodeSOCKSAddress("127.0.0.1:12419")...)
+	_, err = conn.Write(append(header, "ping"...))
+	assert.NoError(t, err)
+
+	buffer := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, err := conn.Read(buffer)
+	assert.NoError(t, err)
+	assert.Equal(t, append(header, "ping"...), buffer[:n])
+}
+
+func TestSOCKSAddressEncoding(t *testing.T) {
+	for _, address := range []string{"192.0.2.1:80", "[2001:db8::1]:443", "example.com:53"} {
+		decoded, err := readSOCKSAddress(bytes.NewReader(encodeSOCKSAddress(address)))
+		assert.NoError(t, err)
+		assert.Equal(t, address, decoded)
+	}
+
+	_, err := readSOCKSAddress(bytes.NewReader([]byte{9, 0, 0}))
+	assert.ErrorIs(t, err, errSOCKSAddressType)
+}
+
+func TestExitRefusesPrivateDestinations(t *testing.T) {
+	client, server := sessionPair(t, 12421, 12422, nil)
+	echo(t, "127.0.0.1:12423")
+	go ServeExit(server, nil)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:12424")
+	assert.NoError(t, err)
+	go ServeSOCKS(client, listener)
+
+	// The exit host itself, by address or by name, is off limits
+	for _, address := range []string{"127.0.0.1:12423", "localhost:12423"} {
+		conn, err := net.Dial("tcp", "127.0.0.1:12424")
+		assert.NoError(t, err)
+		defer conn.Close()
+		code, _ := socksRequest(t, conn, socksConnect, address)
+		assert.Equal(t, byte(socksNotAllowed), code, address)
+	}
+
+	for address, public := range map[string]bool{
+		"1.1.1.1":         true,
+		"2606:4700::1":    true,
+		"127.0.0.1":       false,
+		"::1":             false,
+		"10.1.2.3":        false,
+		"172.16.0.1":      false,
+		"192.168.1.1":     false,
+		"169.254.169.254": false,
+		"fe80::1":         false,
+		"fd00::1":         false,
+		"100.64.0.1":      false,
+		"0.0.0.0":         false,
+		"224.0.0.1":       false,
+		"::ffff:10.0.0.1": false,
+	} {
+		assert.Equal(t, public, isPublic(netip.MustParseAddr(address).Unmap()), address)
+	}
+}
@@ -0,0 +1,360 @@
+// pkg/tunnel/stream.go
+package tunnel
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Stream is one connection to a service, multiplexed over a session. TCP
+// streams behave like a TCP connection. On UDP streams each Write is
+// delivered as one Read, keeping datagram boundaries, and nothing is lost.
+type Stream struct {
+	session *Session
+	id      uint32
+	service Service
+
+	writeMu sync.Mutex // Keeps each Write's frames together
+
+	mu            sync.Mutex
+	wake          chan struct{} // Closed and replaced whenever the state changes
+	chunks        [][]byte      // Received messages not yet read
+	partial       []byte        // Message still being received
+	buffered      int           // Bytes received but not read, at most streamWindow
+	consumed      int           // Bytes read since the last window update
+	credit        int           // Bytes that may be sent before the peer reads
+	ready         bool          // The peer reached the service
+	readClosed    bool          // The peer won't write any more
+	writeClosed   bool
+	closed        bool
+	err           error // Set when the stream was reset
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func newStream(session *Session, id uint32, service Service) *Stream {
+	return &Stream{
+		session: session,
+		id:      id,
+		service: service,
+		wake:    make(chan struct{}),
+		credit:  streamWindow,
+	}
+}
+
+// Service returns the service the stream connects to
+func (st *Stream) Service() Service {
+	return st.service
+}
+
+// Read reads received data. On UDP streams a Read never spans datagrams.
+func (st *Stream) Read(b []byte) (int, error) {
+	st.mu.Lock()
+	for len(st.chunks) == 0 {
+		switch {
+		case st.err != nil:
+			err := st.err
+			st.mu.Unlock()
+			return 0, err
+		case st.readClosed:
+			st.mu.Unlock()
+			return 0, io.EOF
+		case st.closed:
+			st.mu.Unlock()
+			return 0, net.ErrClosed
+		}
+		if err := st.waitLocked(st.readDeadline); err != nil {
+			st.mu.Unlock()
+			return 0, err
+		}
+	}
+
+	n := copy(b, st.chunks[0])
+	if n == len(st.chunks[0]) {
+		st.chunks = st.chunks[1:]
+	} else {
+		st.chunks[0] = st.chunks[0][n:]
+	}
+	st.buffered -= n
+	st.consumed += n
+
+	// Return credit in batches rather than for every read
+	var update int
+	if st.consumed >= streamWindow/4 {
+		update, st.consumed = st.consumed, 0
+	}
+	st.mu.Unlock()
+
+	if update > 0 {
+		body := make([]byte, 4)
+		binary.BigEndian.PutUint32(body, uint32(update))
+		st.session.sendFrame(frameWindow, 0, st.id, body)
+	}
+	return n, nil
+}
+
+// Write sends b, waiting while the peer's window is full
+func (st *Stream) Write(b []byte) (int, error) {
+	st.writeMu.Lock()
+	defer st.writeMu.Unlock()
+
+	message := st.service.Network == "udp"
+	written := 0
+	for written < len(b) {
+		st.mu.Lock()
+		for st.credit == 0 && st.err == nil && !st.writeClosed {
+			if err := st.waitLocked(st.writeDeadline); err != nil {
+				st.mu.Unlock()
+				return written, err
+			}
+		}
+		switch {
+		case st.err != nil:
+			err := st.err
+			st.mu.Unlock()
+			return written, err
+		case st.writeClosed:
+			st.mu.Unlock()
+			return written, net.ErrClosed
+		}
+
+		n := len(b) - written
+		if n > maxFramePayload {
+			n = maxFramePayload
+		}
+		if n > st.credit {
+			n = st.credit
+		}
+		st.credit -= n
+		st.mu.Unlock()
+
+		var flags byte
+		if message && written+n < len(b) {
+			flags = flagMore
+		}
+		if err := st.session.sendFrame(frameData, flags, st.id, b[written:written+n]); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// CloseWrite tells the peer no more data follows; it reads EOF once it has
+// read everything sent before
+func (st *Stream) CloseWrite() error {
+	st.mu.Lock()
+	if st.writeClosed || st.err != nil {
+		st.mu.Unlock()
+		return nil
+	}
+	st.writeClosed = true
+	finished := st.readClosed
+	st.notifyLocked()
+	st.mu.Unlock()
+
+	err := st.session.sendFrame(frameClose, 0, st.id, nil)
+	if finished {
+		st.session.remove(st.id)
+	}
+	return err
+}
+
+// Close closes the stream. If the peer is still writing, the stream is
+// reset so it stops.
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.closed = true
+	peerDone := st.readClosed || st.err != nil
+	st.notifyLocked()
+	st.mu.Unlock()
+
+	if peerDone {
+		return st.CloseWrite()
+	}
+	st.reset("closed")
+	return nil
+}
+
+// reset aborts the stream in both directions
+func (st *Stream) reset(reason string) {
+	st.mu.Lock()
+	if st.err == nil {
+		st.err = fmt.Errorf("tunnel stream reset: %s", reason)
+	}
+	st.notifyLocked()
+	st.mu.Unlock()
+
+	st.session.remove(st.id)
+	st.session.sendFrame(frameReset, 0, st.id, []byte(reason))
+}
+
+// receive queues data from the peer. It returns false if the peer sent
+// more than the window allows.
+func (st *Stream) receive(data []byte, more bool) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.closed || st.err != nil {
+		return true
+	}
+	if st.buffered+len(data) > streamWindow {
+		return false
+	}
+
+	st.buffered += len(data)
+	if more {
+		st.partial = append(st.partial, data...)
+		return true
+	}
+	if st.partial != nil {
+		data = append(st.partial, data...)
+		st.partial = nil
+	}
+	if len(data) > 0 {
+		st.chunks = append(st.chunks, append([]byte(nil), data...))
+	}
+	st.notifyLocked()
+	return true
+}
+
+// grant adds send credit returned by the peer
+func (st *Stream) grant(n int) {
+	st.mu.Lock()
+	st.credit += n
+	st.notifyLocked()
+	st.mu.Unlock()
+}
+
+// receiveReady records that the peer reached the service
+func (st *Stream) receiveReady() {
+	st.mu.Lock()
+	st.ready = true
+	st.notifyLocked()
+	st.mu.Unlock()
+}
+
+// waitReady waits until the peer has reached the service, returning the
+// reset error if it couldn't
+func (st *Stream) waitReady(deadline time.Time) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for !st.ready {
+		switch {
+		case st.err != nil:
+			return st.err
+		case st.closed:
+			return net.ErrClosed
+		}
+		if err := st.waitLocked(deadline); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// receiveClose records that the peer finished writing
+func (st *Stream) receiveClose() {
+	st.mu.Lock()
+	st.readClosed = true
+	finished := st.writeClosed
+	st.notifyLocked()
+	st.mu.Unlock()
+
+	if finished {
+		st.session.remove(st.id)
+	}
+}
+
+// receiveReset fails the stream
+func (st *Stream) receiveReset(err error) {
+	st.mu.Lock()
+	if st.err == nil {
+		st.err = err
+	}
+	st.notifyLocked()
+	st.mu.Unlock()
+
+	st.session.remove(st.id)
+}
+
+// notifyLocked wakes everything waiting on the stream
+func (st *Stream) notifyLocked() {
+	close(st.wake)
+	st.wake = make(chan struct{})
+}
+
+// waitLocked releases the lock until the stream changes or the deadline
+// passes
+func (st *Stream) waitLocked(deadline time.Time) error {
+	wake := st.wake
+	st.mu.Unlock()
+	defer st.mu.Lock()
+
+	if deadline.IsZero() {
+		<-wake
+		return nil
+	}
+
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return os.ErrDeadlineExceeded
+	}
+	timer := time.NewTimer(remaining)
+	defer timer.Stop()
+
+	select {
+	case <-wake:
+		return nil
+	case <-timer.C:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// LocalAddr returns the service address
+func (st *Stream) LocalAddr() net.Addr {
+	return Addr(st.service.String())
+}
+
+// RemoteAddr returns the service address
+func (st *Stream) RemoteAddr() net.Addr {
+	return Addr(st.service.String())
+}
+
+// SetDeadline sets the read and write deadlines
+func (st *Stream) SetDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.readDeadline = t
+	st.writeDeadline = t
+	st.notifyLocked()
+	st.mu.Unlock()
+	return nil
+}
+
+// SetReadDeadline sets the deadline for Read calls
+func (st *Stream) SetReadDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.readDeadline = t
+	st.notifyLocked()
+	st.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for Write calls waiting for window
+func (st *Stream) SetWriteDeadline(t time.Time) error {
+	st.mu.Lock()
+	st.writeDeadline = t
+	st.notifyLocked()
+	st.mu.Unlock()
+	return nil
+}
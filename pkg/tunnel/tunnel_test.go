@@ -0,0 +1,281 @@
+// pkg/tunnel/tunnel_test.go
+package tunnel
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// udpPair returns two UDP sockets connected to each other
+func udpPair(t *testing.T, portA, portB int) (net.Conn, net.Conn) {
+	addrA := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: portA}
+	addrB := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: portB}
+
+	a, err := net.DialUDP("udp", addrA, addrB)
+	assert.NoError(t, err)
+	b, err := net.DialUDP("udp", addrB, addrA)
+	assert.NoError(t, err)
+
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+// lossyConn drops every nth write
+type lossyConn struct {
+	net.Conn
+	n      uint64
+	writes uint64
+}
+
+func (c *lossyConn) Write(b []byte) (int, error) {
+	if atomic.AddUint64(&c.writes, 1)%c.n == 0 {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+// sessionPair starts a client and server session over a lossy UDP pair
+func sessionPair(t *testing.T, portA, portB int, serverConfig *Config) (*Session, *Session) {
+	a, b := udpPair(t, portA, portB)
+	client := Client(&lossyConn{Conn: a, n: 9}, nil)
+	server := Server(&lossyConn{Conn: b, n: 7}, serverConfig)
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+// echo serves TCP connections by writing back what they send
+func echo(t *testing.T, address string) {
+	listener, err := net.Listen("tcp", address)
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+}
+
+func TestParseService(t *testing.T) {
+	service, err := ParseService("tcp:localhost:22")
+	assert.NoError(t, err)
+	assert.Equal(t, Service{Network: "tcp", Address: "localhost:22"}, service)
+	assert.Equal(t, "tcp:localhost:22", service.String())
+
+	_, err = ParseService("sctp:localhost:22")
+	assert.Error(t, err)
+	_, err = ParseService("udp:localhost")
+	assert.Error(t, err)
+}
+
+func TestForward(t *testing.T) {
+	client, server := sessionPair(t, 12400, 12401, nil)
+	echo(t, "127.0.0.1:12402")
+
+	service := Service{Network: "tcp", Address: "127.0.0.1:12402"}
+	go Serve(server, []Service{service})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:12403")
+	assert.NoError(t, err)
+	go Forward(client, listener, service)
+
+	// Many connections share the one path
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			conn, err := net.Dial("tcp", "127.0.0.1:12403")
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer conn.Close()
+
+			data := make([]byte, 64*1024)
+			rand.Read(data)
+			go func() {
+				conn.Write(data)
+				conn.(*net.TCPConn).CloseWrite()
+			}()
+
+			conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+			echoed, err := io.ReadAll(conn)
+			assert.NoError(t, err)
+			assert.True(t, bytes.Equal(data, echoed))
+		}()
+	}
+	wg.Wait()
+
+	snapshot := client.Stats()
+	assert.Greater(t, snapshot.PacketsOut, uint64(0))
+}
+
+func TestForwardPacket(t *testing.T) {
+	client, server := sessionPair(t, 12404, 12405, nil)
+
+	echoConn, err := net.ListenPacket("udp", "127.0.0.1:12406")
+	assert.NoError(t, err)
+	defer echoConn.Close()
+	go func() {
+		buffer := make([]byte, 64*1024)
+		for {
+			n, addr, err := echoConn.ReadFrom(buffer)
+			if err != nil {
+				return
+			}
+			echoConn.WriteTo(buffer[:n], addr)
+		}
+	}()
+
+	service := Service{Network: "udp", Address: "127.0.0.1:12406"}
+	go Serve(server, []Service{service})
+
+	forwarder, err := net.ListenPacket("udp", "127.0.0.1:12407")
+	assert.NoError(t, err)
+	go ForwardPacket(client, forwarder, service)
+
+	conn, err := net.Dial("udp", "127.0.0.1:12407")
+	assert.NoError(t, err)
+	defer conn.Close()
+
+	// Datagrams keep their boundaries, even those larger than a frame
+	buffer := make([]byte, 64*1024)
+	for _, size := range []int{1, 1500, 5000} {
+		data := make([]byte, size)
+		rand.Read(data)
+		_, err := conn.Write(data)
+		assert.NoError(t, err)
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := conn.Read(buffer)
+		assert.NoError(t, err)
+		assert.True(t, bytes.Equal(data, buffer[:n]))
+	}
+}
+
+func TestServeRejectsUnexposedServices(t *testing.T) {
+	client, server := sessionPair(t, 12408, 12409, nil)
+	go Serve(server, []Service{{Network: "tcp", Address: "127.0.0.1:22"}})
+
+	stream, err := client.Open(Service{Network: "tcp", Address: "127.0.0.1:25"})
+	assert.NoError(t, err)
+
+	stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = stream.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, ErrUnknownService)
+}
+
+func TestSessionClose(t *testing.T) {
+	client, server := sessionPair(t, 12410, 12411, nil)
+
+	stream, err := client.Open(Service{Network: "tcp", Address: "127.0.0.1:22"})
+	assert.NoError(t, err)
+	accepted, err := server.Accept()
+	assert.NoError(t, err)
+	assert.Equal(t, "tcp:127.0.0.1:22", accepted.RemoteAddr().String())
+
+	client.Close()
+	select {
+	case <-server.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("server session still open")
+	}
+	assert.ErrorIs(t, server.Err(), io.EOF)
+
+	_, err = stream.Write([]byte("late"))
+	assert.ErrorIs(t, err, ErrSessionClosed)
+	_, err = accepted.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+// brokenConn fails every write, like a stream whose peer reset it
+type brokenConn struct {
+	net.Conn
+}
+
+var errBroken = errors.New("broken pipe")
+
+func (c *brokenConn) Write(b []byte) (int, error) {
+	return 0, errBroken
+}
+
+func TestStreamWriteFailureClosesSession(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	defer listener.Close()
+
+	a, err := net.Dial("tcp", listener.Addr().String())
+	assert.NoError(t, err)
+	b, err := listener.Accept()
+	assert.NoError(t, err)
+
+	client := Client(&brokenConn{Conn: a}, nil)
+	server := Server(b, nil)
+	defer client.Close()
+	defer server.Close()
+
+	// The client can read the open but not acknowledge it
+	_, err = server.Open(Service{Network: "tcp", Address: "127.0.0.1:22"})
+	assert.NoError(t, err)
+
+	select {
+	case <-client.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("client session still open after a failed write")
+	}
+	assert.ErrorIs(t, client.Err(), errBroken)
+}
+
+func TestPacketLinkDropsCorruptDatagrams(t *testing.T) {
+	a, b := udpPair(t, 12425, 12426)
+	client := Client(a, nil)
+	server := Server(b, nil)
+	defer client.Close()
+	defer server.Close()
+
+	echo(t, "127.0.0.1:12427")
+	service := Service{Network: "tcp", Address: "127.0.0.1:12427"}
+	go Serve(server, []Service{service})
+
+	// A frame too large, one cut short and one that isn't a packet
+	for _, garbage := range [][]byte{{0xff, 0xff, 0xff, 0xff}, {0, 0, 0, 64, 1}, {0, 0, 0, 1, 0}} {
+		_, err := a.Write(garbage)
+		assert.NoError(t, err)
+	}
+
+	stream, err := client.Open(service)
+	assert.NoError(t, err)
+	defer stream.Close()
+	_, err = stream.Write([]byte("still here"))
+	assert.NoError(t, err)
+
+	echoed := make([]byte, len("still here"))
+	stream.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = io.ReadFull(stream, echoed)
+	assert.NoError(t, err)
+	assert.Equal(t, "still here", string(echoed))
+	assert.NoError(t, server.Err())
+}
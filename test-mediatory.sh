@@ -4,6 +4,25 @@ set -e  # Exit on error
 echo "=== Testing Mediatory Server Implementation ==="
 echo ""
 
+# signed_curl sends a request signed with a client's session key, as the
+# SDK does: an HMAC-SHA256 over the method, URI, timestamp and body hash.
+# Usage: signed_curl METHOD URI BODY CLIENT_ID KEY_ID KEY
+signed_curl() {
+    local method=$1 uri=$2 body=$3 client_id=$4 key_id=$5 key=$6
+    local timestamp body_hash signature
+    timestamp=$(date +%s%N)
+    body_hash=$(printf '%s' "$body" | sha256sum | cut -d' ' -f1)
+    signature=$(printf '%s\n%s\n%s\n%s' "$method" "$uri" "$timestamp" "$body_hash" \
+      | openssl dgst -sha256 -mac HMAC -macopt "hexkey:$key" | awk '{print $NF}')
+    curl -s -X "$method" "http://localhost:8080$uri" \
+      -H "Content-Type: application/json" \
+      -H "X-Natbypass-Client-Id: $client_id" \
+      -H "X-Natbypass-Key-Id: $key_id" \
+      -H "X-Natbypass-Timestamp: $timestamp" \
+      -H "X-Natbypass-Signature: $signature" \
+      ${body:+-d "$body"}
+}
+
 # Build the mediatory server
 echo "Building mediatory server..."
 go build -o bin/mediatory-server cmd/mediatory-server/main.go
@@ -55,6 +74,8 @@ if [[ $REGISTER_RESPONSE == *"\"status\":\"registered\""* ]]; then
     echo "✓ Client registration passed"
     # Extract client_id from response
     CLIENT_ID=$(echo $REGISTER_RESPONSE | grep -o '"client_id":"[^"]*"' | cut -d'"' -f4)
+    CLIENT_KEY=$(echo $REGISTER_RESPONSE | grep -o '"session_key":"[^"]*"' | cut -d'"' -f4)
+    CLIENT_KEY_ID=$(echo $REGISTER_RESPONSE | grep -o '"key_id":[0-9]*' | cut -d':' -f2)
     echo "  Client ID: $CLIENT_ID"
 else
     echo "✗ Client registration failed"
@@ -78,9 +99,8 @@ echo ""
 # Test heartbeat endpoint (if we got a client_id)
 if [ ! -z "$CLIENT_ID" ]; then
     echo "Testing heartbeat endpoint..."
-    HEARTBEAT_RESPONSE=$(curl -s -X POST http://localhost:8080/api/v1/heartbeat \
-      -H "Content-Type: application/json" \
-      -d "{\"client_id\":\"$CLIENT_ID\"}")
+    HEARTBEAT_RESPONSE=$(signed_curl POST /api/v1/heartbeat "{\"client_id\":\"$CLIENT_ID\"}" \
+      "$CLIENT_ID" "$CLIENT_KEY_ID" "$CLIENT_KEY")
     
     if [[ $HEARTBEAT_RESPONSE == *"\"status\":\"ok\""* ]]; then
         echo "✓ Heartbeat endpoint passed"
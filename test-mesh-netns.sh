@@ -0,0 +1,72 @@
+#!/bin/bash
+# File: test-mesh-netns.sh
+#
+# Runs two mesh peers in their own network namespaces, routed through the
+# host where the mediatory server runs, and pings one from the other over
+# their TUN interfaces. Needs root on Linux.
+
+set -e  # Exit on error
+
+PORT=${PORT:-18081}
+NETWORK=${NETWORK:-100.64.0.0/24}
+LOGS=$(mktemp -d)
+
+echo "=== Testing the mesh VPN in network namespaces ==="
+echo ""
+
+cleanup() {
+    kill $(jobs -p) 2>/dev/null || true
+    ip netns pids nb-mesh-a 2>/dev/null | xargs -r kill 2>/dev/null || true
+    ip netns pids nb-mesh-b 2>/dev/null | xargs -r kill 2>/dev/null || true
+    ip netns del nb-mesh-a 2>/dev/null || true
+    ip netns del nb-mesh-b 2>/dev/null || true
+    echo "Logs are in $LOGS"
+}
+trap cleanup EXIT
+
+echo "Building binaries..."
+make build-mediatory build-natbypass >/dev/null
+echo "✓ Built"
+
+# Each namespace reaches the host, and the other namespace through it
+echo "Creating namespaces..."
+sysctl -qw net.ipv4.ip_forward=1
+for side in a b; do
+    subnet=$([ $side = a ] && echo 1 || echo 2)
+    ip netns add nb-mesh-$side
+    ip link add nb-$side type veth peer name eth0 netns nb-mesh-$side
+    ip addr add 10.201.$subnet.1/24 dev nb-$side
+    ip link set nb-$side up
+    ip -n nb-mesh-$side addr add 10.201.$subnet.2/24 dev eth0
+    ip -n nb-mesh-$side link set eth0 up
+    ip -n nb-mesh-$side link set lo up
+    ip -n nb-mesh-$side route add default via 10.201.$subnet.1
+done
+echo "✓ Namespaces nb-mesh-a and nb-mesh-b created"
+
+echo "Starting mediatory server on port $PORT..."
+bin/mediatory-server -set servers.mediatory.port=$PORT -set signaling.virtual_network=$NETWORK >"$LOGS/mediatory.log" 2>&1 &
+sleep 2
+
+for side in a b; do
+    subnet=$([ $side = a ] && echo 1 || echo 2)
+    ip netns exec nb-mesh-$side bin/natbypass -server http://10.201.$subnet.1:$PORT -stun= mesh nbmesh0 >"$LOGS/peer-$side.log" 2>&1 &
+done
+sleep 3
+
+IP_B=$(grep -o 'mesh as [0-9.]*' "$LOGS/peer-b.log" | awk '{print $3}')
+if [ -z "$IP_B" ]; then
+    echo "✗ Peer b did not join the mesh"
+    cat "$LOGS/peer-b.log"
+    exit 1
+fi
+echo "✓ Peer b joined as $IP_B"
+
+echo "Pinging $IP_B from peer a..."
+if ip netns exec nb-mesh-a ping -c 3 -W 10 "$IP_B"; then
+    echo "✓ Mesh ping succeeded"
+else
+    echo "✗ Mesh ping failed"
+    cat "$LOGS/peer-a.log" "$LOGS/peer-b.log"
+    exit 1
+fi
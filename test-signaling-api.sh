@@ -4,6 +4,25 @@ set -e  # Exit on error
 echo "=== Testing Signaling API Endpoints ==="
 echo ""
 
+# signed_curl sends a request signed with a client's session key, as the
+# SDK does: an HMAC-SHA256 over the method, URI, timestamp and body hash.
+# Usage: signed_curl METHOD URI BODY CLIENT_ID KEY_ID KEY
+signed_curl() {
+    local method=$1 uri=$2 body=$3 client_id=$4 key_id=$5 key=$6
+    local timestamp body_hash signature
+    timestamp=$(date +%s%N)
+    body_hash=$(printf '%s' "$body" | sha256sum | cut -d' ' -f1)
+    signature=$(printf '%s\n%s\n%s\n%s' "$method" "$uri" "$timestamp" "$body_hash" \
+      | openssl dgst -sha256 -mac HMAC -macopt "hexkey:$key" | awk '{print $NF}')
+    curl -s -X "$method" "http://localhost:8080$uri" \
+      -H "Content-Type: application/json" \
+      -H "X-Natbypass-Client-Id: $client_id" \
+      -H "X-Natbypass-Key-Id: $key_id" \
+      -H "X-Natbypass-Timestamp: $timestamp" \
+      -H "X-Natbypass-Signature: $signature" \
+      ${body:+-d "$body"}
+}
+
 # Build the mediatory server if needed
 echo "Building mediatory server..."
 make build-mediatory
@@ -40,6 +59,8 @@ CLIENT1_RESPONSE=$(curl -s -X POST http://localhost:8080/api/v1/register \
 
 if [[ $CLIENT1_RESPONSE == *"\"status\":\"registered\""* ]]; then
     CLIENT1_ID=$(echo $CLIENT1_RESPONSE | grep -o '"client_id":"[^"]*"' | cut -d'"' -f4)
+    CLIENT1_KEY=$(echo $CLIENT1_RESPONSE | grep -o '"session_key":"[^"]*"' | cut -d'"' -f4)
+    CLIENT1_KEY_ID=$(echo $CLIENT1_RESPONSE | grep -o '"key_id":[0-9]*' | cut -d':' -f2)
     echo "✓ Client 1 registered with ID: $CLIENT1_ID"
 else
     echo "✗ Client 1 registration failed"
@@ -55,6 +76,8 @@ CLIENT2_RESPONSE=$(curl -s -X POST http://localhost:8080/api/v1/register \
 
 if [[ $CLIENT2_RESPONSE == *"\"status\":\"registered\""* ]]; then
     CLIENT2_ID=$(echo $CLIENT2_RESPONSE | grep -o '"client_id":"[^"]*"' | cut -d'"' -f4)
+    CLIENT2_KEY=$(echo $CLIENT2_RESPONSE | grep -o '"session_key":"[^"]*"' | cut -d'"' -f4)
+    CLIENT2_KEY_ID=$(echo $CLIENT2_RESPONSE | grep -o '"key_id":[0-9]*' | cut -d':' -f2)
     echo "✓ Client 2 registered with ID: $CLIENT2_ID"
 else
     echo "✗ Client 2 registration failed"
@@ -66,9 +89,9 @@ echo ""
 
 # Test connection registration endpoint
 echo "Creating connection request from client 1 to client 2..."
-CONNECT_RESPONSE=$(curl -s -X POST http://localhost:8080/api/v1/connect \
-  -H "Content-Type: application/json" \
-  -d "{\"source_id\":\"$CLIENT1_ID\",\"target_id\":\"$CLIENT2_ID\",\"source_ip\":\"192.168.1.2\",\"source_port\":12345}")
+CONNECT_RESPONSE=$(signed_curl POST /api/v1/connect \
+  "{\"source_id\":\"$CLIENT1_ID\",\"target_id\":\"$CLIENT2_ID\",\"source_ip\":\"192.168.1.2\",\"source_port\":12345}" \
+  "$CLIENT1_ID" "$CLIENT1_KEY_ID" "$CLIENT1_KEY")
 
 if [[ $CONNECT_RESPONSE == *"\"status\":\"connection_registered\""* ]]; then
     CONN_ID=$(echo $CONNECT_RESPONSE | grep -o '"connection_id":"[^"]*"' | cut -d'"' -f4)
@@ -83,7 +106,7 @@ echo ""
 
 # Test getting active connections for a client
 echo "Retrieving active connections for client 1..."
-CONNECTIONS_RESPONSE=$(curl -s http://localhost:8080/api/v1/connections/$CLIENT1_ID)
+CONNECTIONS_RESPONSE=$(signed_curl GET /api/v1/connections/$CLIENT1_ID "" "$CLIENT1_ID" "$CLIENT1_KEY_ID" "$CLIENT1_KEY")
 
 if [[ $CONNECTIONS_RESPONSE == *"\"status\":\"success\""* ]]; then
     echo "✓ Retrieved connections successfully"
@@ -95,9 +118,9 @@ echo ""
 
 # Test sending signaling messages
 echo "Sending test signal message from client 1 to client 2..."
-SIGNAL_RESPONSE=$(curl -s -X POST http://localhost:8080/api/v1/signal \
-  -H "Content-Type: application/json" \
-  -d "{\"type\":\"offer\",\"client_id\":\"$CLIENT1_ID\",\"target_id\":\"$CLIENT2_ID\",\"payload\":{\"sdp\":\"test-sdp-offer\"}}")
+SIGNAL_RESPONSE=$(signed_curl POST /api/v1/signal \
+  "{\"type\":\"offer\",\"client_id\":\"$CLIENT1_ID\",\"target_id\":\"$CLIENT2_ID\",\"payload\":{\"sdp\":\"test-sdp-offer\"}}" \
+  "$CLIENT1_ID" "$CLIENT1_KEY_ID" "$CLIENT1_KEY")
 
 if [[ $SIGNAL_RESPONSE == *"\"status\":\"message_queued\""* ]]; then
     echo "✓ Signal message queued successfully"
@@ -109,7 +132,7 @@ echo ""
 
 # Test retrieving messages for a client
 echo "Retrieving queued messages for client 2..."
-MESSAGES_RESPONSE=$(curl -s http://localhost:8080/api/v1/messages/$CLIENT2_ID)
+MESSAGES_RESPONSE=$(signed_curl GET /api/v1/messages/$CLIENT2_ID "" "$CLIENT2_ID" "$CLIENT2_KEY_ID" "$CLIENT2_KEY")
 
 if [[ $MESSAGES_RESPONSE == *"\"status\":\"success\""* ]]; then
     COUNT=$(echo $MESSAGES_RESPONSE | grep -o '"count":[0-9]*' | cut -d':' -f2)
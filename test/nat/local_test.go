@@ -14,7 +14,7 @@ import (
 )
 
 func TestNATTraversalStrategiesLocalPair(t *testing.T) {
-    factory := nat.NewStrategyFactory()
+    factory := nat.NewStrategyFactory(nil)
 
     // Test cases with different strategy types
     testCases := []struct {
@@ -56,7 +56,7 @@ func TestNATTraversalStrategiesLocalPair(t *testing.T) {
 }
 
 func TestNATTraversalStrategyMatrixOnLocalhost(t *testing.T) {
-    factory := nat.NewStrategyFactory()
+    factory := nat.NewStrategyFactory(nil)
     
     // Test strategy selection for different NAT type combinations
     natTypes := []discovery.NATType{
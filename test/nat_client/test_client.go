@@ -88,7 +88,7 @@ func main() {
 	}
 
 	// Create NAT traversal factory
-	factory := nat.NewStrategyFactory()
+	factory := nat.NewStrategyFactory(nil)
 
 	// Simulate remote peer's NAT type (in real scenario would come via signaling)
 	var remoteNATType discovery.NATType
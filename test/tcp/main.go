@@ -23,9 +23,9 @@ func main() {
 
 	// Create TCP server configuration
 	tcpConfig := &config.TCPServerConfig{
-		Host:              "0.0.0.0",
-		Port:              5555,
-		ConnectionTimeout: 300,
+		ListenHost:        "0.0.0.0",
+		ListenPort:        5555,
+		ConnectionTimeout: 300 * time.Second,
 		MaxConnections:    1000,
 		BufferSize:        4096,
 	}
@@ -37,14 +37,14 @@ func main() {
 				var port int
 				_, err := fmt.Sscanf(os.Args[i+1], "%d", &port)
 				if err == nil && port > 0 && port < 65536 {
-					tcpConfig.Port = port
+					tcpConfig.ListenPort = port
 				}
 				i++
 			}
 		}
 	}
 
-	logger.Infof("Starting TCP server on port %d", tcpConfig.Port)
+	logger.Infof("Starting TCP server on port %d", tcpConfig.ListenPort)
 
 	// Create and start the server
 	server := nat.NewTCPServer(tcpConfig, logger)
@@ -60,7 +60,7 @@ func main() {
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
 	// Print server info and instructions
-	logger.Infof("TCP server is running on %s:%d", tcpConfig.Host, tcpConfig.Port)
+	logger.Infof("TCP server is running on %s:%d", tcpConfig.ListenHost, tcpConfig.ListenPort)
 	logger.Info("Press Ctrl+C to stop the server")
 
 	// Stats reporting